@@ -2,17 +2,64 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
 	"time"
 
 	"CacheFlow/internal/client"
+	"CacheFlow/internal/tlsutil"
 )
 
+// kvClient is the set of operations the CLI needs, implemented by
+// client.Client, client.ClusterClient and client.SentinelClient
+type kvClient interface {
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Get(ctx context.Context, key string) (string, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Close() error
+}
+
 func main() {
-	c, err := client.New("localhost:6379")
+	addr := flag.String("addr", "localhost:6379", "server address, or unix:///path/to/socket")
+	clusterMode := flag.Bool("cluster", false, "treat -addr as a seed node of a sharded cluster")
+	sentinels := flag.String("sentinel", "", "comma separated sentinel addresses to discover the primary from (ignores -addr)")
+	name := flag.String("name", "cacheflow", "primary name to ask the sentinels for")
+	timeout := flag.Duration("timeout", 5*time.Second, "time limit for each command")
+	user := flag.String("user", "", "user to log in as (default user if empty)")
+	password := flag.String("password", "", "password to log in with")
+	clientName := flag.String("client-name", "", "name of the connection in CLIENT LIST")
+	db := flag.Int("db", 0, "database to SELECT")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	tlsCert := flag.String("tls-cert", "", "client certificate to present")
+	tlsKey := flag.String("tls-key", "", "private key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "CA file the server certificate is verified against (default system pool)")
+	flag.Parse()
+
+	opts := client.Options{Username: *user, Password: *password, ClientName: *clientName, DB: *db}
+	if *useTLS {
+		tlsConfig, err := tlsutil.ClientConfig(tlsutil.Files{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA})
+		if err != nil {
+			log.Fatalf("Invalid TLS settings: %v", err)
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	var c kvClient
+	var err error
+	switch {
+	case *sentinels != "":
+		c, err = client.NewSentinelClientWithOptions(*name, opts, strings.Split(*sentinels, ",")...)
+	case *clusterMode:
+		c, err = client.NewClusterWithOptions(opts, *addr)
+	default:
+		c, err = client.NewWithOptions(*addr, opts)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -20,7 +67,7 @@ func main() {
 
 	scanner := bufio.NewScanner(os.Stdin)
 	fmt.Println("CacheFlow CLI Client")
-	fmt.Println("Enter commands (SET/GET/DELETE/EXISTS) or 'exit' to quit")
+	fmt.Println("Enter commands (SET/GET/DELETE/EXISTS/INFO/MONITOR) or 'exit' to quit")
 
 	for {
 		fmt.Print("> ")
@@ -33,11 +80,13 @@ func main() {
 			break
 		}
 
-		handleCommand(c, cmd)
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		handleCommand(ctx, c, cmd)
+		cancel()
 	}
 }
 
-func handleCommand(c *client.Client, cmd string) {
+func handleCommand(ctx context.Context, c kvClient, cmd string) {
 	parts := strings.Fields(cmd)
 	if len(parts) == 0 {
 		fmt.Println("Error: Empty command")
@@ -59,7 +108,7 @@ func handleCommand(c *client.Client, cmd string) {
 				return
 			}
 		}
-		err := c.Set(parts[1], parts[2], ttl)
+		err := c.Set(ctx, parts[1], parts[2], ttl)
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 		} else {
@@ -71,7 +120,7 @@ func handleCommand(c *client.Client, cmd string) {
 			fmt.Println("Usage: GET key")
 			return
 		}
-		value, err := c.Get(parts[1])
+		value, err := c.Get(ctx, parts[1])
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 		} else if value == "" {
@@ -85,7 +134,7 @@ func handleCommand(c *client.Client, cmd string) {
 			fmt.Println("Usage: DELETE key")
 			return
 		}
-		err := c.Delete(parts[1])
+		err := c.Delete(ctx, parts[1])
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 		} else {
@@ -97,14 +146,69 @@ func handleCommand(c *client.Client, cmd string) {
 			fmt.Println("Usage: EXISTS key")
 			return
 		}
-		exists, err := c.Exists(parts[1])
+		exists, err := c.Exists(ctx, parts[1])
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 		} else {
 			fmt.Println(exists)
 		}
 
+	case "INFO":
+		ic, ok := c.(interface {
+			Info(ctx context.Context, sections ...string) (client.Info, error)
+		})
+		if !ok {
+			fmt.Println("Error: INFO needs a connection to a single server")
+			return
+		}
+		info, err := ic.Info(ctx, parts[1:]...)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		printInfo(info)
+
+	case "MONITOR":
+		mc, ok := c.(interface {
+			Monitor(ctx context.Context) (<-chan string, error)
+		})
+		if !ok {
+			fmt.Println("Error: MONITOR needs a connection to a single server")
+			return
+		}
+		// The stream outlives the command timeout and stops on Ctrl-C
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		lines, err := mc.Monitor(ctx)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Println("OK (press Ctrl-C to stop)")
+		for line := range lines {
+			fmt.Println(line)
+		}
+
 	default:
-		fmt.Println("Unknown command. Available commands: SET, GET, DELETE, EXISTS")
+		fmt.Println("Unknown command. Available commands: SET, GET, DELETE, EXISTS, INFO, MONITOR")
+	}
+}
+
+// printInfo prints the sections of INFO in the server's order, one field per line
+func printInfo(info client.Info) {
+	for _, section := range []string{"server", "clients", "memory", "persistence", "stats", "replication", "commandstats", "keyspace", "namespaces"} {
+		fields, ok := info[section]
+		if !ok {
+			continue
+		}
+		fmt.Printf("# %s\n", strings.ToUpper(section[:1])+section[1:])
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s:%s\n", name, fields[name])
+		}
 	}
 }
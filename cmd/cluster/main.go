@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"CacheFlow/internal/client"
+	"CacheFlow/internal/cluster"
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/tlsutil"
+)
+
+const usage = `Usage: cluster [flags] command args...
+
+Commands:
+  cluster create host:port host:port ...   introduce the nodes to each other and split the slots evenly
+  cluster nodes host:port                  show the nodes known to a node and their slots
+  cluster slots host:port                  show the slot map of a node
+  cluster add-node new existing            introduce an empty node to every member of an existing cluster
+  cluster migrate source target range      move a range of slots and their keys from source to target
+  cluster rebalance host:port              move slots between the cluster's nodes until each owns an equal share`
+
+// options are used for every connection to a node
+var options client.Options
+
+func main() {
+	flag.StringVar(&options.Username, "user", "", "user to log in to the nodes as (default user if empty)")
+	flag.StringVar(&options.Password, "password", "", "password to log in to the nodes with")
+	useTLS := flag.Bool("tls", false, "connect to the nodes over TLS")
+	tlsCert := flag.String("tls-cert", "", "client certificate presented to the nodes")
+	tlsKey := flag.String("tls-key", "", "private key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "CA file the nodes' certificates are verified against (default system pool)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *useTLS {
+		tlsConfig, err := tlsutil.ClientConfig(tlsutil.Files{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA})
+		if err != nil {
+			log.Fatalf("Invalid TLS settings: %v", err)
+		}
+		options.TLSConfig = tlsConfig
+	}
+
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "create":
+		err = create(args[1:])
+	case "nodes":
+		err = show(args[1], "CLUSTER NODES")
+	case "slots":
+		err = show(args[1], "CLUSTER SLOTS")
+	case "add-node":
+		if len(args) != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = addNode(args[1], args[2])
+	case "migrate":
+		if len(args) != 4 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = migrate(args[1], args[2], args[3])
+	case "rebalance":
+		err = rebalance(args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migrateBatch is the number of keys moved by a single MIGRATE command
+const migrateBatch = 100
+
+// node is a connection to one cluster member
+type node struct {
+	addr  string
+	id    string
+	conn  *client.Client
+	slots []int // slots the node owns, filled in by discover
+}
+
+// do sends a command to the node and treats ERROR replies as failures
+func (n *node) do(cmd string) (string, error) {
+	response, err := n.conn.Do(context.Background(), cmd)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", n.addr, err)
+	}
+	if protocol.IsError(response) {
+		return "", fmt.Errorf("%s: %s: %s", n.addr, cmd, response)
+	}
+	return response, nil
+}
+
+// connect opens connections to every address and fetches their node IDs
+func connect(addrs []string) ([]*node, error) {
+	var nodes []*node
+	for _, addr := range addrs {
+		conn, err := client.NewWithOptions(addr, options)
+		if err != nil {
+			return nil, err
+		}
+		n := &node{addr: addr, conn: conn}
+		if n.id, err = n.do("CLUSTER MYID"); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// create makes every node aware of the others and assigns each a contiguous share of the slots
+func create(addrs []string) error {
+	nodes, err := connect(addrs)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, n := range nodes {
+			n.conn.Close()
+		}
+	}()
+
+	for _, n := range nodes {
+		for _, other := range nodes {
+			if other == n {
+				continue
+			}
+			if err := n.meet(other); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, owner := range nodes {
+		r := cluster.SlotRange{
+			Start: i * cluster.SlotCount / len(nodes),
+			End:   (i+1)*cluster.SlotCount/len(nodes) - 1,
+		}
+		for _, n := range nodes {
+			if _, err := n.do(fmt.Sprintf("CLUSTER SETSLOT %s NODE %s", r, owner.id)); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%s (%s): slots %s\n", owner.addr, owner.id, r)
+	}
+	return nil
+}
+
+// show prints the reply to an informational command, one entry per line
+func show(addr, cmd string) error {
+	conn, err := client.NewWithOptions(addr, options)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	n := &node{addr: addr, conn: conn}
+	response, err := n.do(cmd)
+	if err != nil {
+		return err
+	}
+	for _, entry := range strings.Split(response, ", ") {
+		fmt.Println(entry)
+	}
+	return nil
+}
+
+// discover connects to every node known to the node at addr and loads the slots each owns
+func discover(addr string) ([]*node, error) {
+	seed, err := connect([]string{addr})
+	if err != nil {
+		return nil, err
+	}
+	response, err := seed[0].do("CLUSTER NODES")
+	seed[0].conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*node
+	for _, entry := range strings.Split(response, ", ") {
+		fields := strings.Fields(entry)
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := connect([]string{fields[1]})
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields[2:] {
+			if field == "myself" {
+				continue
+			}
+			r, err := cluster.ParseSlotRange(field)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fields[1], err)
+			}
+			for slot := r.Start; slot <= r.End; slot++ {
+				n[0].slots = append(n[0].slots, slot)
+			}
+		}
+		nodes = append(nodes, n[0])
+	}
+	return nodes, nil
+}
+
+// meet introduces other to n
+func (n *node) meet(other *node) error {
+	host, port, err := net.SplitHostPort(other.addr)
+	if err != nil {
+		return err
+	}
+	_, err = n.do(fmt.Sprintf("CLUSTER MEET %s %s", host, port))
+	return err
+}
+
+// addNode makes a new node and every member of the cluster aware of each other
+func addNode(newAddr, existingAddr string) error {
+	nodes, err := discover(existingAddr)
+	if err != nil {
+		return err
+	}
+	defer closeAll(nodes)
+
+	added, err := connect([]string{newAddr})
+	if err != nil {
+		return err
+	}
+	defer closeAll(added)
+
+	for _, n := range nodes {
+		if err := n.meet(added[0]); err != nil {
+			return err
+		}
+		if err := added[0].meet(n); err != nil {
+			return err
+		}
+	}
+	// Give the new node the current slot map so it can redirect clients
+	for _, n := range nodes {
+		for _, r := range slotRanges(n.slots) {
+			if _, err := added[0].do(fmt.Sprintf("CLUSTER SETSLOT %s NODE %s", r, n.id)); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("Added %s (%s) to the cluster; run rebalance to give it slots\n", added[0].addr, added[0].id)
+	return nil
+}
+
+// slotRanges groups sorted slots into contiguous ranges
+func slotRanges(slots []int) []cluster.SlotRange {
+	var ranges []cluster.SlotRange
+	for _, slot := range slots {
+		if n := len(ranges); n > 0 && ranges[n-1].End == slot-1 {
+			ranges[n-1].End = slot
+			continue
+		}
+		ranges = append(ranges, cluster.SlotRange{Start: slot, End: slot})
+	}
+	return ranges
+}
+
+// closeAll closes the connections to every node
+func closeAll(nodes []*node) {
+	for _, n := range nodes {
+		n.conn.Close()
+	}
+}
+
+// findNode returns the node with the given address
+func findNode(nodes []*node, addr string) (*node, error) {
+	for _, n := range nodes {
+		if n.addr == addr {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a member of the cluster", addr)
+}
+
+// migrate moves a range of slots from source to target
+func migrate(sourceAddr, targetAddr, slots string) error {
+	r, err := cluster.ParseSlotRange(slots)
+	if err != nil {
+		return err
+	}
+	nodes, err := discover(sourceAddr)
+	if err != nil {
+		return err
+	}
+	defer closeAll(nodes)
+
+	source, err := findNode(nodes, sourceAddr)
+	if err != nil {
+		return err
+	}
+	target, err := findNode(nodes, targetAddr)
+	if err != nil {
+		return err
+	}
+	return moveSlots(nodes, source, target, r)
+}
+
+// moveSlots migrates each slot in r from source to target while both keep
+// serving traffic, then tells the remaining nodes about the new owner
+func moveSlots(nodes []*node, source, target *node, r cluster.SlotRange) error {
+	host, port, err := net.SplitHostPort(target.addr)
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for slot := r.Start; slot <= r.End; slot++ {
+		if _, err := target.do(fmt.Sprintf("CLUSTER SETSLOT %d IMPORTING %s", slot, source.id)); err != nil {
+			return err
+		}
+		if _, err := source.do(fmt.Sprintf("CLUSTER SETSLOT %d MIGRATING %s", slot, target.id)); err != nil {
+			return err
+		}
+
+		for {
+			keys, err := source.do(fmt.Sprintf("CLUSTER GETKEYSINSLOT %d %d", slot, migrateBatch))
+			if err != nil {
+				return err
+			}
+			if keys == "" {
+				break
+			}
+			if _, err := source.do(fmt.Sprintf("MIGRATE %s %s %s", host, port, keys)); err != nil {
+				return err
+			}
+			moved += len(strings.Fields(keys))
+		}
+
+		// The target must own the slot before the source stops answering with ASK
+		for _, n := range []*node{target, source} {
+			if _, err := n.do(fmt.Sprintf("CLUSTER SETSLOT %d NODE %s", slot, target.id)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		if n == source || n == target {
+			continue
+		}
+		if _, err := n.do(fmt.Sprintf("CLUSTER SETSLOT %s NODE %s", r, target.id)); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Moved slots %s (%d keys) from %s to %s\n", r, moved, source.addr, target.addr)
+	return nil
+}
+
+// rebalance moves slots from nodes owning more than an equal share to nodes owning less
+func rebalance(addr string) error {
+	nodes, err := discover(addr)
+	if err != nil {
+		return err
+	}
+	defer closeAll(nodes)
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].addr < nodes[j].addr })
+	want := make(map[*node]int)
+	for i, n := range nodes {
+		want[n] = cluster.SlotCount / len(nodes)
+		if i < cluster.SlotCount%len(nodes) {
+			want[n]++
+		}
+	}
+
+	for _, receiver := range nodes {
+		for _, donor := range nodes {
+			for len(receiver.slots) < want[receiver] && len(donor.slots) > want[donor] {
+				// Give away a contiguous run from the end of the donor's slots
+				n := len(donor.slots) - want[donor]
+				if need := want[receiver] - len(receiver.slots); n > need {
+					n = need
+				}
+				run := donor.slots[len(donor.slots)-n:]
+				start := len(run) - 1
+				for start > 0 && run[start-1] == run[start]-1 {
+					start--
+				}
+				run = run[start:]
+
+				r := cluster.SlotRange{Start: run[0], End: run[len(run)-1]}
+				if err := moveSlots(nodes, donor, receiver, r); err != nil {
+					return err
+				}
+				donor.slots = donor.slots[:len(donor.slots)-len(run)]
+				receiver.slots = append(receiver.slots, run...)
+			}
+		}
+	}
+	fmt.Println("Cluster is balanced.")
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"CacheFlow/internal/sentinel"
+	"CacheFlow/internal/tlsutil"
+)
+
+func main() {
+	cfg := sentinel.DefaultConfig(":26379", "cacheflow", "")
+	flag.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	flag.StringVar(&cfg.Name, "name", cfg.Name, "name clients use to ask for the primary")
+	flag.StringVar(&cfg.PrimaryAddr, "primary", "", "address of the primary to monitor")
+	flag.IntVar(&cfg.Quorum, "quorum", 0, "sentinels that must agree the primary is down (default majority)")
+	flag.DurationVar(&cfg.DownAfter, "down-after", cfg.DownAfter, "time without replies before the primary is considered down")
+	flag.DurationVar(&cfg.FailoverTimeout, "failover-timeout", cfg.FailoverTimeout, "minimum time between failover attempts")
+	flag.StringVar(&cfg.StateFile, "state-file", "sentinel.json", "file holding the current primary and epochs (empty disables it)")
+	flag.StringVar(&cfg.AuthUser, "auth-user", "", "user to log in to the monitored servers as")
+	flag.StringVar(&cfg.AuthPassword, "auth-password", "", "password to log in to the monitored servers with")
+	peers := flag.String("peers", "", "comma separated addresses of the other sentinels")
+	useTLS := flag.Bool("tls", false, "connect to the monitored servers over TLS")
+	tlsCert := flag.String("tls-cert", "", "client certificate presented to the monitored servers")
+	tlsKey := flag.String("tls-key", "", "private key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "CA file the servers' certificates are verified against (default system pool)")
+	flag.Parse()
+
+	if *useTLS {
+		tlsConfig, err := tlsutil.ClientConfig(tlsutil.Files{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA})
+		if err != nil {
+			log.Fatalf("Invalid TLS settings: %v", err)
+		}
+		cfg.TLSConfig = tlsConfig
+	}
+
+	if *peers != "" {
+		for _, peer := range strings.Split(*peers, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				cfg.Peers = append(cfg.Peers, peer)
+			}
+		}
+	}
+
+	s, err := sentinel.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize sentinel: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		log.Fatalf("Sentinel error: %v", err)
+	}
+}
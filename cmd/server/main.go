@@ -1,17 +1,127 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
 
+	"CacheFlow/internal/logging"
 	"CacheFlow/internal/server"
 )
 
 func main() {
-	srv, err := server.New(":6379")
+	cfg := server.DefaultConfig(":6379")
+	flag.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on (empty serves only -unixsocket)")
+	flag.StringVar(&cfg.UnixSocket, "unixsocket", "", "path of a Unix socket to listen on as well")
+	unixSocketPerm := flag.String("unixsocketperm", "", "octal file mode of the Unix socket, such as 770")
+	flag.StringVar(&cfg.AnnounceAddr, "announce-addr", "", "address advertised to other nodes and redirected clients (defaults to -addr)")
+	flag.StringVar(&cfg.AOFFilename, "aof", cfg.AOFFilename, "append-only file for persistence (empty disables it; must be empty with -raft-id)")
+	flag.IntVar(&cfg.Databases, "databases", cfg.Databases, "number of databases clients can SELECT")
+	flag.StringVar(&cfg.ReplicaOf, "replicaof", "", "address of a primary to replicate from")
+	flag.IntVar(&cfg.ReplBacklogSize, "repl-backlog-size", cfg.ReplBacklogSize, "replication backlog size in bytes")
+	flag.StringVar(&cfg.RaftID, "raft-id", "", "node ID; enables raft mode")
+	flag.StringVar(&cfg.RaftAddr, "raft-addr", "", "address for raft traffic between nodes")
+	flag.StringVar(&cfg.RaftDir, "raft-dir", "", "directory for the raft log (default raft-<id>)")
+	flag.BoolVar(&cfg.ClusterEnabled, "cluster-enabled", false, "enable hash-slot sharding")
+	flag.StringVar(&cfg.ClusterConfigFile, "cluster-config-file", cfg.ClusterConfigFile, "file holding the node ID and slot map")
+	flag.StringVar(&cfg.ACLFile, "aclfile", "", "file holding the users (empty keeps them in memory)")
+	flag.StringVar(&cfg.RequirePass, "requirepass", "", "password of the default user")
+	flag.StringVar(&cfg.AuthUser, "auth-user", "", "user to log in to the primary, MIGRATE targets and CLUSTER MEET peers as")
+	flag.StringVar(&cfg.AuthPassword, "auth-password", "", "password to log in to the primary, MIGRATE targets and CLUSTER MEET peers with")
+	flag.StringVar(&cfg.TLSCertFile, "tls-cert", "", "certificate to serve TLS with (enables TLS together with -tls-key)")
+	flag.StringVar(&cfg.TLSKeyFile, "tls-key", "", "private key of -tls-cert")
+	flag.StringVar(&cfg.TLSCAFile, "tls-ca", "", "CA file client certificates and other nodes are verified against")
+	flag.StringVar(&cfg.TLSAuthClients, "tls-auth-clients", "yes", "require client certificates when -tls-ca is set: yes, optional or no")
+	flag.BoolVar(&cfg.TLSCertUsers, "tls-cert-users", false, "log clients in as the ACL user named by their certificate's Common Name")
+	flag.BoolVar(&cfg.TLSReplication, "tls-replication", false, "use TLS to connect to the primary and MIGRATE targets")
+	flag.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", "", "file of keys encrypting the AOF and Raft files, current key first (defaults to $CACHEFLOW_ENCRYPTION_KEYS)")
+	flag.IntVar(&cfg.MaxClients, "maxclients", cfg.MaxClients, "maximum number of connected clients (0 is unlimited)")
+	flag.DurationVar(&cfg.IdleTimeout, "timeout", 0, "close connections idle for this long (0 keeps them open)")
+	flag.IntVar(&cfg.MaxRequestSize, "max-request-size", cfg.MaxRequestSize, "longest command line in bytes (0 is unlimited, only without persistence)")
+	flag.IntVar(&cfg.MaxArgSize, "max-arg-size", 0, "longest single argument in bytes (0 is unlimited)")
+	flag.IntVar(&cfg.OutputBufferLimit, "output-buffer-limit", cfg.OutputBufferLimit, "largest reply in bytes before the client is disconnected (0 is unlimited)")
+	flag.DurationVar(&cfg.OutputTimeout, "output-timeout", cfg.OutputTimeout, "disconnect clients that take longer than this to read a reply (0 waits forever)")
+	flag.DurationVar(&cfg.SlowlogThreshold, "slowlog-threshold", cfg.SlowlogThreshold, "log commands that take at least this long in the slow log (0 logs every command, negative disables it)")
+	flag.IntVar(&cfg.SlowlogMaxLen, "slowlog-max-len", cfg.SlowlogMaxLen, "number of entries the slow log keeps")
+	flag.DurationVar(&cfg.LatencyThreshold, "latency-threshold", cfg.LatencyThreshold, "record commands and internal events that take at least this long for LATENCY (0 disables it)")
+	flag.DurationVar(&cfg.ScriptTimeout, "script-timeout", cfg.ScriptTimeout, "stop scripts run by EVAL after this long (0 is unlimited)")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "address of an HTTP listener exporting Prometheus metrics at /metrics (empty disables it)")
+	logLevel := flag.String("log-level", "info", "least severe log level written: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	logFile := flag.String("log-file", "", "file to write logs to (empty writes to standard error)")
+	logMaxSize := flag.Int64("log-max-size", 100<<20, "rotate -log-file once it would grow past this many bytes (0 never rotates)")
+	logMaxBackups := flag.Int("log-max-backups", 5, "number of rotated log files to keep")
+	logSampleFirst := flag.Int("log-sample-first", 10, "per-connection events logged each second before sampling starts")
+	logSampleEvery := flag.Int("log-sample-every", 100, "after -log-sample-first, log one in this many per-connection events (0 logs all of them)")
+	raftPeers := flag.String("raft-peers", "", "initial raft group as id=addr,id=addr,... including this node")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Invalid -log-level: %v", err)
+	}
+	logger, logCloser, err := logging.New(logging.Config{
+		Level:      level,
+		Format:     *logFormat,
+		File:       *logFile,
+		MaxSize:    *logMaxSize,
+		MaxBackups: *logMaxBackups,
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize server: %v", err)
+		log.Fatalf("Invalid log settings: %v", err)
+	}
+	defer logCloser.Close()
+	// The store, the AOF and the packages still using the log package write through it too
+	slog.SetDefault(logger)
+	cfg.Logger = logger
+	cfg.ConnLogger = logging.Sampled(logger, *logSampleFirst, *logSampleEvery)
+
+	if *raftPeers != "" {
+		peers, err := parsePeers(*raftPeers)
+		if err != nil {
+			log.Fatalf("Invalid -raft-peers: %v", err)
+		}
+		cfg.RaftPeers = peers
+	}
+
+	if *unixSocketPerm != "" {
+		perm, err := strconv.ParseUint(*unixSocketPerm, 8, 32)
+		if err != nil || perm > 0777 {
+			log.Fatalf("Invalid -unixsocketperm %q: expected an octal mode such as 770", *unixSocketPerm)
+		}
+		cfg.UnixSocketPerm = os.FileMode(perm)
+	}
+
+	if cfg.EncryptionKeyFile == "" {
+		cfg.EncryptionKeys = os.Getenv("CACHEFLOW_ENCRYPTION_KEYS")
+	}
+
+	srv, err := server.NewWithConfig(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize server", "err", err)
+		logCloser.Close()
+		os.Exit(1)
 	}
 	if err := srv.Start(); err != nil {
-		log.Fatalf("Server error: %v", err)
+		logger.Error("Server error", "err", err)
+		logCloser.Close()
+		os.Exit(1)
+	}
+}
+
+// parsePeers parses a comma separated list of id=addr pairs
+func parsePeers(s string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("expected id=addr, got %q", pair)
+		}
+		peers[id] = addr
 	}
+	return peers, nil
 }
@@ -0,0 +1,464 @@
+// Package acl implements the users a server accepts and what each may do:
+// its passwords, the commands it may run, individually or by category, and
+// the keys it may touch. Users are configured with Redis-style rules such as
+// "on >secret ~cache:* +@read -@dangerous".
+package acl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultUser is the user new connections are logged in as when it needs no password
+const DefaultUser = "default"
+
+var (
+	// ErrWrongPass is returned for an unknown user, a wrong password or a disabled user
+	ErrWrongPass = errors.New("invalid username-password pair or user is disabled")
+	// ErrNoPermCommand is returned for a command the user may not run
+	ErrNoPermCommand = errors.New("no permission to run command")
+	// ErrNoPermKey is returned for a key the user may not access
+	ErrNoPermKey = errors.New("no permission to access key")
+)
+
+// User is an account and its permissions
+type User struct {
+	name      string
+	enabled   bool
+	noPass    bool
+	passwords map[string]bool // SHA-256 digests in hex
+	commands  []string        // +/- rules in the order given; later ones win
+	keys      []string        // glob patterns of accessible keys
+}
+
+// newUser creates a disabled user without passwords or permissions
+func newUser(name string) *User {
+	return &User{name: name, passwords: make(map[string]bool)}
+}
+
+// ACL holds the users of a server. It is safe for concurrent use.
+type ACL struct {
+	mu       sync.RWMutex
+	users    map[string]*User
+	commands *commandTable // the commands and categories rules may name
+}
+
+// New creates an ACL with only the default user, which needs no password and may do anything
+func New() *ACL {
+	a := &ACL{users: make(map[string]*User), commands: newCommandTable()}
+	a.users[DefaultUser] = defaultUser()
+	return a
+}
+
+// defaultUser returns the unrestricted user a server starts with
+func defaultUser() *User {
+	u := newUser(DefaultUser)
+	u.enabled = true
+	u.noPass = true
+	u.commands = []string{"+@all"}
+	u.keys = []string{"*"}
+	return u
+}
+
+// SetUser creates or modifies a user by applying rules in order. A new user
+// starts disabled, without passwords and without permissions. Either every
+// rule is applied or, if one is invalid, none.
+func (a *ACL) SetUser(name string, rules ...string) error {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, ok := a.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.apply(a.commands, rule); err != nil {
+			return err
+		}
+	}
+	a.users[name] = u
+	return nil
+}
+
+// DelUser removes users and returns how many existed. The default user cannot be removed.
+func (a *ACL) DelUser(names ...string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, fmt.Errorf("the %s user cannot be removed", DefaultUser)
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Authenticate checks a user's password
+func (a *ACL) Authenticate(name, password string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok || !u.enabled {
+		return ErrWrongPass
+	}
+	if u.noPass || u.passwords[hashPassword(password)] {
+		return nil
+	}
+	return ErrWrongPass
+}
+
+// Enabled reports whether a user exists and is enabled, so that a connection
+// authenticated by other means, such as a client certificate, may log in as it
+func (a *ACL) Enabled(name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	return ok && u.enabled
+}
+
+// AutoLogin reports whether new connections are logged in as the default user
+// without authenticating, which is the case while it is enabled and needs no password
+func (a *ACL) AutoLogin() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u := a.users[DefaultUser]
+	return u != nil && u.enabled && u.noPass
+}
+
+// Check reports whether a user may run command on keys. Permissions are
+// looked up on every call, so changes apply to connections already logged in.
+func (a *ACL) Check(name, command string, keys []string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok || !u.enabled {
+		return fmt.Errorf("%w: user %s no longer exists or is disabled", ErrNoPermCommand, name)
+	}
+	if !u.canRun(a.commands, command) {
+		return fmt.Errorf("%w: user %s has no permissions to run the '%s' command", ErrNoPermCommand, name, strings.ToLower(command))
+	}
+	for _, key := range keys {
+		if !u.canAccess(key) {
+			return fmt.Errorf("%w: user %s has no permissions to access the '%s' key", ErrNoPermKey, name, key)
+		}
+	}
+	return nil
+}
+
+// Users returns the names of every user, sorted
+func (a *ACL) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns a user as a rule line that recreates it, as in an ACL file
+func (a *ACL) Describe(name string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok {
+		return "", false
+	}
+	return "user " + name + " " + strings.Join(u.rules(), " "), true
+}
+
+// List describes every user, sorted by name
+func (a *ACL) List() []string {
+	var lines []string
+	for _, name := range a.Users() {
+		if line, ok := a.Describe(name); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Load replaces every user with those in an ACL file: one "user <name> <rules...>"
+// line per user, with blank lines and lines starting with # ignored. A file
+// without a default user gets the unrestricted one. On error nothing changes.
+func (a *ACL) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loaded := &ACL{users: make(map[string]*User), commands: a.commands}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected user <name> <rules...>", path, n)
+		}
+		if _, ok := loaded.users[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user %s", path, n, fields[1])
+		}
+		if err := loaded.SetUser(fields[1], fields[2:]...); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := loaded.users[DefaultUser]; !ok {
+		loaded.users[DefaultUser] = defaultUser()
+	}
+
+	a.mu.Lock()
+	a.users = loaded.users
+	a.mu.Unlock()
+	return nil
+}
+
+// Save writes every user to an ACL file, replacing it atomically. Passwords
+// are stored as digests only.
+func (a *ACL) Save(path string) error {
+	var b strings.Builder
+	for _, line := range a.List() {
+		b.WriteString(line + "\n")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// clone returns a copy of u that can be modified without affecting u
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = make(map[string]bool, len(u.passwords))
+	for digest := range u.passwords {
+		c.passwords[digest] = true
+	}
+	c.commands = append([]string(nil), u.commands...)
+	c.keys = append([]string(nil), u.keys...)
+	return &c
+}
+
+// apply changes the user according to a single rule
+func (u *User) apply(commands *commandTable, rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.noPass = true
+		u.passwords = make(map[string]bool)
+		return nil
+	case "resetpass":
+		u.noPass = false
+		u.passwords = make(map[string]bool)
+		return nil
+	case "allkeys":
+		u.keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allcommands":
+		u.commands = []string{"+@all"}
+		return nil
+	case "nocommands":
+		u.commands = nil
+		return nil
+	case "reset":
+		*u = *newUser(u.name)
+		return nil
+	}
+
+	if rule == "" {
+		return fmt.Errorf("empty rule")
+	}
+	arg := rule[1:]
+	switch rule[0] {
+	case '>':
+		u.passwords[hashPassword(arg)] = true
+		u.noPass = false
+	case '<':
+		delete(u.passwords, hashPassword(arg))
+	case '#':
+		if !isDigest(arg) {
+			return fmt.Errorf("invalid password digest %q", arg)
+		}
+		u.passwords[strings.ToLower(arg)] = true
+		u.noPass = false
+	case '!':
+		delete(u.passwords, strings.ToLower(arg))
+	case '~':
+		if arg == "" {
+			return fmt.Errorf("empty key pattern")
+		}
+		u.keys = append(u.keys, arg)
+	case '+', '-':
+		return u.applyCommandRule(commands, rule[0], arg)
+	default:
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+	return nil
+}
+
+// applyCommandRule adds a +command, -command, +@category or -@category rule
+// naming commands and categories in commands
+func (u *User) applyCommandRule(commands *commandTable, sign byte, target string) error {
+	if category, ok := strings.CutPrefix(target, "@"); ok {
+		category = strings.ToLower(category)
+		if category != "all" && !commands.knownCategory(category) {
+			return fmt.Errorf("unknown command category %q", category)
+		}
+		if category == "all" {
+			// Everything before is overridden, so it need not be kept
+			u.commands = nil
+		}
+		u.commands = append(u.commands, string(sign)+"@"+category)
+		return nil
+	}
+
+	command := strings.ToUpper(target)
+	if !commands.known(command) {
+		return fmt.Errorf("unknown command %q", target)
+	}
+	u.commands = append(u.commands, string(sign)+strings.ToLower(command))
+	return nil
+}
+
+// canRun evaluates the command rules; the last rule matching command decides
+func (u *User) canRun(commands *commandTable, command string) bool {
+	allowed := false
+	for _, rule := range u.commands {
+		target := rule[1:]
+		var matches bool
+		if category, ok := strings.CutPrefix(target, "@"); ok {
+			matches = category == "all" || commands.inCategory(command, category)
+		} else {
+			matches = strings.ToUpper(target) == command
+		}
+		if matches {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// canAccess reports whether key matches one of the user's key patterns
+func (u *User) canAccess(key string) bool {
+	for _, pattern := range u.keys {
+		if matchGlob(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// rules returns the rules that recreate the user from scratch
+func (u *User) rules() []string {
+	rules := []string{"off"}
+	if u.enabled {
+		rules[0] = "on"
+	}
+	if u.noPass {
+		rules = append(rules, "nopass")
+	}
+	digests := make([]string, 0, len(u.passwords))
+	for digest := range u.passwords {
+		digests = append(digests, "#"+digest)
+	}
+	sort.Strings(digests)
+	rules = append(rules, digests...)
+	for _, pattern := range u.keys {
+		rules = append(rules, "~"+pattern)
+	}
+	if len(u.commands) == 0 {
+		return append(rules, "-@all")
+	}
+	return append(rules, u.commands...)
+}
+
+// hashPassword returns the hex SHA-256 digest stored for a password
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// isDigest reports whether s looks like a hex SHA-256 digest
+func isDigest(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// matchGlob matches key against a pattern where * matches any run of
+// characters, ? any single character, and \ escapes the next character
+func matchGlob(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if matchGlob(pattern, key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if key == "" {
+				return false
+			}
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if key == "" || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return key == ""
+}
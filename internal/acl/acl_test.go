@@ -0,0 +1,167 @@
+package acl
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDefaultUser(t *testing.T) {
+	a := New()
+	if !a.AutoLogin() {
+		t.Error("Expected connections to be logged in as the default user")
+	}
+	if err := a.Check(DefaultUser, "SET", []string{"any"}); err != nil {
+		t.Errorf("Expected the default user to be unrestricted, got %v", err)
+	}
+
+	if err := a.SetUser(DefaultUser, ">secret"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+	if a.AutoLogin() {
+		t.Error("Expected a password on the default user to require AUTH")
+	}
+	if err := a.Authenticate(DefaultUser, "wrong"); !errors.Is(err, ErrWrongPass) {
+		t.Errorf("Expected ErrWrongPass, got %v", err)
+	}
+	if err := a.Authenticate(DefaultUser, "secret"); err != nil {
+		t.Errorf("Expected the password to be accepted, got %v", err)
+	}
+	if _, err := a.DelUser(DefaultUser); err == nil {
+		t.Error("Expected the default user to be undeletable")
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	a := New()
+	if err := a.SetUser("reader", "on", ">pw", "~cache:*", "+@read", "+ping"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		keys    []string
+		err     error
+	}{
+		{"GET", []string{"cache:1"}, nil},
+		{"EXISTS", []string{"cache:1"}, nil},
+		{"PING", nil, nil},
+		{"GET", []string{"other"}, ErrNoPermKey},
+		{"SET", []string{"cache:1"}, ErrNoPermCommand},
+		{"ACL", nil, ErrNoPermCommand},
+	}
+	for _, tt := range tests {
+		err := a.Check("reader", tt.command, tt.keys)
+		if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("Check(%s %v): expected %v, got %v", tt.command, tt.keys, tt.err, err)
+		}
+	}
+
+	// Later rules override earlier ones
+	if err := a.SetUser("reader", "+@all", "-@dangerous"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+	if err := a.Check("reader", "SET", []string{"cache:1"}); err != nil {
+		t.Errorf("Expected SET to be allowed after +@all, got %v", err)
+	}
+	if err := a.Check("reader", "REPLICAOF", nil); !errors.Is(err, ErrNoPermCommand) {
+		t.Errorf("Expected REPLICAOF to stay denied by -@dangerous, got %v", err)
+	}
+
+	if err := a.SetUser("reader", "off"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+	if err := a.Authenticate("reader", "pw"); !errors.Is(err, ErrWrongPass) {
+		t.Errorf("Expected a disabled user to be refused, got %v", err)
+	}
+	if err := a.Check("reader", "GET", []string{"cache:1"}); err == nil {
+		t.Error("Expected a disabled user to lose its permissions")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	a := New()
+	for _, rule := range []string{"+@nosuchcategory", "+nosuchcommand", "#nothex", "bogus", "~"} {
+		if err := a.SetUser("u", "on", rule); err == nil {
+			t.Errorf("Expected rule %q to be rejected", rule)
+		}
+	}
+	if users := a.Users(); len(users) != 1 {
+		t.Errorf("Expected failed SetUser calls to create no user, got %v", users)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	a := New()
+	if err := a.SetUser("app", "on", ">pw", "~app:*", "+@read", "+@write", "-delete"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+	if err := a.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded := New()
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want, _ := a.Describe("app")
+	if got, ok := loaded.Describe("app"); !ok || got != want {
+		t.Errorf("Expected %q after loading, got %q", want, got)
+	}
+	if err := loaded.Authenticate("app", "pw"); err != nil {
+		t.Errorf("Expected the saved password digest to work, got %v", err)
+	}
+	if err := loaded.Check("app", "DELETE", []string{"app:1"}); !errors.Is(err, ErrNoPermCommand) {
+		t.Errorf("Expected DELETE to stay denied, got %v", err)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"*", "anything", true},
+		{"cache:*", "cache:1", true},
+		{"cache:*", "cach", false},
+		{"user:?", "user:1", true},
+		{"user:?", "user:12", false},
+		{"*:session", "a:b:session", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"exact", "exact", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.key); got != tt.match {
+			t.Errorf("matchGlob(%q, %q): expected %v, got %v", tt.pattern, tt.key, tt.match, got)
+		}
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	a, other := New(), New()
+	a.RegisterCommand("INCRBY", []string{"write", "counter"})
+
+	if err := a.SetUser("app", "on", "nopass", "allkeys", "+@counter"); err != nil {
+		t.Fatalf("Expected the registered category to be known, got %v", err)
+	}
+	if err := a.Check("app", "INCRBY", []string{"key"}); err != nil {
+		t.Errorf("Expected app to run the registered command, got %v", err)
+	}
+	if commands, _ := a.CategoryCommands("write"); !a.KnownCommand("INCRBY") || !slices.Contains(commands, "INCRBY") {
+		t.Errorf("Expected INCRBY to be known in @write, got categories %v", a.CommandCategories("INCRBY"))
+	}
+
+	// Another ACL, as another server has, is unaffected
+	if other.KnownCommand("INCRBY") {
+		t.Error("Expected a command registered on one ACL not to be known to another")
+	}
+	if err := other.SetUser("app", "+incrby"); err == nil {
+		t.Error("Expected another ACL to reject the unregistered command")
+	}
+	if _, ok := other.CategoryCommands("counter"); ok {
+		t.Error("Expected another ACL not to have the new category")
+	}
+}
@@ -0,0 +1,158 @@
+package acl
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+// builtinCategories assigns every built-in server command to the categories +@category rules refer to
+var builtinCategories = map[string][]string{
+	"SET":        {"write", "keyspace", "string"},
+	"GET":        {"read", "string", "fast"},
+	"DELETE":     {"write", "keyspace", "fast"},
+	"EXISTS":     {"read", "keyspace", "fast"},
+	"SELECT":     {"connection", "fast"},
+	"SWAPDB":     {"write", "keyspace", "dangerous", "fast"},
+	"FLUSHDB":    {"write", "keyspace", "dangerous"},
+	"FLUSHALL":   {"write", "keyspace", "dangerous"},
+	"NAMESPACE":  {"admin", "keyspace", "dangerous"},
+	"XADD":       {"write", "stream", "fast"},
+	"XTRIM":      {"write", "stream"},
+	"XSETID":     {"write", "stream", "fast"},
+	"XLEN":       {"read", "stream", "fast"},
+	"XRANGE":     {"read", "stream"},
+	"XREVRANGE":  {"read", "stream"},
+	"XREAD":      {"read", "stream", "blocking"},
+	"XREADGROUP": {"write", "stream", "blocking"},
+	"XGROUP":     {"write", "stream"},
+	"XACK":       {"write", "stream", "fast"},
+	"XCLAIM":     {"write", "stream", "fast"},
+	"XAUTOCLAIM": {"write", "stream", "fast"},
+	"XPENDING":   {"read", "stream"},
+	"EVAL":       {"scripting"},
+	"EVALSHA":    {"scripting"},
+	"SCRIPT":     {"scripting"},
+	"COMMAND":    {"connection"},
+	"PING":       {"connection", "fast"},
+	"AUTH":       {"connection", "fast"},
+	"ACL":        {"admin", "dangerous"},
+	"REPLICAOF":  {"admin", "dangerous", "replication"},
+	"PSYNC":      {"admin", "dangerous", "replication"},
+	"ROLE":       {"admin", "fast", "replication"},
+	"REPLICAS":   {"admin", "replication"},
+	"CLUSTER":    {"admin", "cluster"},
+	"ASKING":     {"connection", "cluster", "fast"},
+	"MIGRATE":    {"write", "keyspace", "dangerous", "cluster"},
+	"RESTORE":    {"write", "keyspace", "dangerous", "cluster"},
+	"RAFT":       {"admin", "dangerous"},
+	"INFO":       {"admin", "dangerous"},
+	"SLOWLOG":    {"admin", "dangerous"},
+	"LATENCY":    {"admin", "dangerous"},
+	"MONITOR":    {"admin", "dangerous"},
+	"CLIENT":     {"admin", "connection", "dangerous"},
+}
+
+// commandTable holds the commands known to an ACL and their categories. It
+// starts with the built-in commands and grows as a server registers more.
+type commandTable struct {
+	mu         sync.RWMutex
+	commands   map[string][]string        // command to its categories
+	categories map[string]map[string]bool // category to the set of its commands
+}
+
+// newCommandTable returns a table holding the built-in commands
+func newCommandTable() *commandTable {
+	t := &commandTable{commands: make(map[string][]string), categories: make(map[string]map[string]bool)}
+	for command, cats := range builtinCategories {
+		t.register(command, cats)
+	}
+	return t
+}
+
+// register adds command to cats. The caller must hold t.mu or own t.
+func (t *commandTable) register(command string, cats []string) {
+	if _, ok := t.commands[command]; !ok {
+		t.commands[command] = nil
+	}
+	for _, category := range cats {
+		if !slices.Contains(t.commands[command], category) {
+			t.commands[command] = append(t.commands[command], category)
+		}
+		if t.categories[category] == nil {
+			t.categories[category] = make(map[string]bool)
+		}
+		t.categories[category][command] = true
+	}
+}
+
+// known reports whether command is in the table
+func (t *commandTable) known(command string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.commands[command]
+	return ok
+}
+
+// inCategory reports whether command belongs to category
+func (t *commandTable) inCategory(command, category string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.categories[category][command]
+}
+
+// knownCategory reports whether category exists
+func (t *commandTable) knownCategory(category string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.categories[category] != nil
+}
+
+// RegisterCommand makes command, in upper case, known to the ACL's rules and
+// adds it to categories, which may be new ones. It is how commands added to
+// a server at run time become subject to ACLs.
+func (a *ACL) RegisterCommand(command string, cats []string) {
+	a.commands.mu.Lock()
+	defer a.commands.mu.Unlock()
+	a.commands.register(command, cats)
+}
+
+// KnownCommand reports whether command, in upper case, is a server command
+func (a *ACL) KnownCommand(command string) bool {
+	return a.commands.known(command)
+}
+
+// CommandCategories returns the categories of command, in upper case
+func (a *ACL) CommandCategories(command string) []string {
+	a.commands.mu.RLock()
+	defer a.commands.mu.RUnlock()
+	return slices.Clone(a.commands.commands[command])
+}
+
+// Categories returns the names of every command category, sorted
+func (a *ACL) Categories() []string {
+	a.commands.mu.RLock()
+	defer a.commands.mu.RUnlock()
+	names := make([]string, 0, len(a.commands.categories))
+	for category := range a.commands.categories {
+		names = append(names, category)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CategoryCommands returns the commands of a category, sorted, and whether it exists
+func (a *ACL) CategoryCommands(category string) ([]string, bool) {
+	a.commands.mu.RLock()
+	defer a.commands.mu.RUnlock()
+	commands, ok := a.commands.categories[category]
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, 0, len(commands))
+	for command := range commands {
+		names = append(names, command)
+	}
+	sort.Strings(names)
+	return names, true
+}
@@ -2,97 +2,436 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Options tune a Client; zero values select the defaults
+type Options struct {
+	// DialTimeout bounds connecting to the server (default 5s)
+	DialTimeout time.Duration
+	// ReadTimeout bounds waiting for a reply, on top of the context's deadline (default 30s)
+	ReadTimeout time.Duration
+	// WriteTimeout bounds sending a command, on top of the context's deadline (default 30s)
+	WriteTimeout time.Duration
+	// MaxRetries is how many times a command failing with a network error is
+	// retried on a new connection (default 3, negative disables retries)
+	MaxRetries int
+	// MinRetryBackoff and MaxRetryBackoff bound the exponential delay between retries (default 10ms and 1s)
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	// Username and Password log every connection in with AUTH; an empty
+	// Password skips it, and an empty Username selects the default user
+	Username string
+	Password string
+	// ClientName names every connection with CLIENT SETNAME, so it can be
+	// told apart in CLIENT LIST; empty leaves connections unnamed
+	ClientName string
+	// DB selects a database with SELECT on every connection; zero uses database 0
+	DB int
+	// TLSConfig enables TLS; a config without ServerName verifies the host of the address
+	TLSConfig *tls.Config
+}
+
+// withDefaults fills in the zero fields of o
+func (o Options) withDefaults() Options {
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = 30 * time.Second
+	}
+	if o.WriteTimeout <= 0 {
+		o.WriteTimeout = 30 * time.Second
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	}
+	if o.MinRetryBackoff <= 0 {
+		o.MinRetryBackoff = 10 * time.Millisecond
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = time.Second
+	}
+	if o.MaxRetryBackoff < o.MinRetryBackoff {
+		o.MaxRetryBackoff = o.MinRetryBackoff
+	}
+	return o
+}
+
+// Client is a connection to a single server. It is safe for concurrent use;
+// commands from different goroutines are sent one at a time. A connection
+// that fails is re-established on the next command.
 type Client struct {
-	conn   net.Conn
+	addr string
+	opts Options
+
+	mu     sync.Mutex // serializes exchanges on the connection
 	reader *bufio.Reader
 	writer *bufio.Writer
+	broken bool
+
+	connMu sync.Mutex // guards conn, so Close can interrupt an exchange
+	conn   net.Conn
+	closed atomic.Bool
 }
 
+// New connects to a server with the default Options. The address is
+// host:port, or unix:///path/to/socket for a Unix domain socket.
 func New(address string) (*Client, error) {
-	conn, err := net.Dial("tcp", address)
+	return NewWithOptions(address, Options{})
+}
+
+// NewWithOptions connects to a server
+func NewWithOptions(address string, opts Options) (*Client, error) {
+	return newWithContext(context.Background(), address, opts)
+}
+
+// newWithContext connects to a server, giving up when ctx ends
+func newWithContext(ctx context.Context, address string, opts Options) (*Client, error) {
+	c := &Client{addr: address, opts: opts.withDefaults()}
+	if err := c.dial(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial opens a new connection, replacing the current one, and logs it in.
+// The caller must hold c.mu.
+func (c *Client) dial(ctx context.Context) error {
+	var conn net.Conn
+	var err error
+	network, addr := splitAddress(c.addr)
+	dialer := &net.Dialer{Timeout: c.opts.DialTimeout}
+	if c.opts.TLSConfig != nil {
+		tlsDialer := tls.Dialer{NetDialer: dialer, Config: c.opts.TLSConfig}
+		conn, err = tlsDialer.DialContext(ctx, network, addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return c.networkError(ctx, "dial", err)
+	}
+
+	c.connMu.Lock()
+	if c.closed.Load() {
+		c.connMu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.writer = bufio.NewWriter(conn)
+	c.broken = false
+	c.connMu.Unlock()
+
+	if err := c.auth(ctx); err != nil {
+		return err
+	}
+	if err := c.setName(ctx); err != nil {
+		return err
+	}
+	return c.selectDB(ctx)
+}
+
+// splitAddress returns the network and address to dial: a Unix socket for
+// unix:///path/to/socket, TCP for host:port
+func splitAddress(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		return "unix", path
+	}
+	return "tcp", address
+}
+
+// auth sends AUTH on a new connection if a password is set. The caller must hold c.mu.
+func (c *Client) auth(ctx context.Context) error {
+	if c.opts.Password == "" {
+		return nil
+	}
+	cmd := "AUTH " + c.opts.Password
+	if c.opts.Username != "" {
+		cmd = fmt.Sprintf("AUTH %s %s", c.opts.Username, c.opts.Password)
+	}
+	responses, _, err := c.roundTrip(ctx, []string{cmd})
+	if err != nil {
+		return err
+	}
+	if responses[0] != "OK" {
+		// Retrying would not help, and the connection must not be used logged out
+		c.broken = true
+		return unexpected(responses[0])
+	}
+	return nil
+}
+
+// setName sends CLIENT SETNAME on a new connection if a name is set. The caller must hold c.mu.
+func (c *Client) setName(ctx context.Context) error {
+	if c.opts.ClientName == "" {
+		return nil
+	}
+	responses, _, err := c.roundTrip(ctx, []string{"CLIENT SETNAME " + c.opts.ClientName})
+	if err != nil {
+		return err
+	}
+	if responses[0] != "OK" {
+		c.broken = true
+		return unexpected(responses[0])
+	}
+	return nil
+}
+
+// selectDB sends SELECT on a new connection if a database other than 0 is
+// set. The caller must hold c.mu.
+func (c *Client) selectDB(ctx context.Context) error {
+	if c.opts.DB == 0 {
+		return nil
+	}
+	responses, _, err := c.roundTrip(ctx, []string{fmt.Sprintf("SELECT %d", c.opts.DB)})
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server: %w", err)
+		return err
 	}
-	return &Client{
-		conn:   conn,
-		reader: bufio.NewReader(conn),
-		writer: bufio.NewWriter(conn),
-	}, nil
+	if responses[0] != "OK" {
+		c.broken = true
+		return unexpected(responses[0])
+	}
+	return nil
 }
 
+// Close closes the connection, interrupting a command in progress
 func (c *Client) Close() error {
+	c.closed.Store(true)
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
 	return c.conn.Close()
 }
 
-func (c *Client) Set(key, value string, ttl time.Duration) error {
+// Addr returns the address of the server
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Set stores a value with an optional TTL
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
 	cmd := fmt.Sprintf("SET %s %s", key, value)
 	if ttl > 0 {
 		cmd += fmt.Sprintf(" %s", ttl)
 	}
-	response, err := c.executeCommand(cmd)
+	response, err := c.executeCommand(ctx, cmd)
 	if err != nil {
 		return err
 	}
 
 	if response != "OK" {
-		return fmt.Errorf("unexpected response: %s", response)
+		return unexpected(response)
 	}
 	return nil
 }
 
-func (c *Client) Get(key string) (string, error) {
-    response, err := c.executeCommand(fmt.Sprintf("GET %s", key))
-    if err != nil {
-        return "", err
-    }
-    
-    if response == "NIL" {
-        return "", nil
-    }
-    return response, nil
+// Get retrieves a value; a missing key yields an empty value
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	response, err := c.executeCommand(ctx, fmt.Sprintf("GET %s", key))
+	if err != nil {
+		return "", err
+	}
+
+	return parseValue(response)
 }
 
-func (c *Client) Delete(key string) error {
-	response, err := c.executeCommand(fmt.Sprintf("DELETE %s", key))
+// Delete removes a key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	response, err := c.executeCommand(ctx, fmt.Sprintf("DELETE %s", key))
 	if err != nil {
 		return err
 	}
 
 	if response != "OK" {
-		return fmt.Errorf("unexptected response %s", response)
+		return unexpected(response)
 	}
 	return nil
 }
 
-func (c *Client) Exists(key string) (bool, error) {
-    response, err := c.executeCommand(fmt.Sprintf("EXISTS %s", key))
-    if err != nil {
-        return false, err
-    }
-    
-    return response == "1", nil
+// Exists checks whether a key exists
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	response, err := c.executeCommand(ctx, fmt.Sprintf("EXISTS %s", key))
+	if err != nil {
+		return false, err
+	}
+
+	if response != "0" && response != "1" {
+		return false, unexpected(response)
+	}
+	return response == "1", nil
 }
 
-func (c *Client) executeCommand(cmd string) (string, error) {
-	_, err := c.writer.WriteString(cmd + "\n")
+// Ping checks that the server is reachable and responding
+func (c *Client) Ping(ctx context.Context) error {
+	response, err := c.executeCommand(ctx, "PING")
 	if err != nil {
-		return "", fmt.Errorf("failed to send command: %w", err)
+		return err
 	}
 
-	if err := c.writer.Flush(); err != nil {
-		return "", fmt.Errorf("failed to flush command: %w", err)
+	if response != "PONG" {
+		return unexpected(response)
 	}
+	return nil
+}
 
-	response, err := c.reader.ReadString('\n')
+// Do sends a raw command line and returns the reply line, including ERROR
+// replies. Since the command may not be safe to repeat, it is only retried
+// if it could not be sent at all.
+func (c *Client) Do(ctx context.Context, cmd string) (string, error) {
+	responses, err := c.exchange(ctx, []string{cmd}, false)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", err
 	}
+	return responses[0], nil
+}
+
+// executeCommand sends a command that is safe to repeat after a network error
+func (c *Client) executeCommand(ctx context.Context, cmd string) (string, error) {
+	responses, err := c.exchange(ctx, []string{cmd}, true)
+	if err != nil {
+		return "", err
+	}
+	return responses[0], nil
+}
+
+// exchange sends commands in a single write and reads one reply per command,
+// reconnecting and retrying with backoff after network errors. Commands that
+// are not retryable are only retried if they were never sent.
+func (c *Client) exchange(ctx context.Context, cmds []string, retryable bool) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if c.closed.Load() {
+			return nil, ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, &NetworkError{Op: "write", Err: err}
+		}
+
+		var err error
+		sent := false
+		if c.broken || c.reader == nil {
+			err = c.dial(ctx)
+		}
+		if err == nil {
+			var responses []string
+			if responses, sent, err = c.roundTrip(ctx, cmds); err == nil {
+				return responses, nil
+			}
+		}
+
+		if err == ErrClosed || errors.Is(err, ErrServer) || (sent && !retryable) || attempt >= c.opts.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		if !c.backoff(ctx, attempt) {
+			return nil, err
+		}
+	}
+}
+
+// backoff waits before retry number attempt+1, returning false if ctx ends first
+func (c *Client) backoff(ctx context.Context, attempt int) bool {
+	delay := c.opts.MinRetryBackoff << attempt
+	if delay <= 0 || delay > c.opts.MaxRetryBackoff {
+		delay = c.opts.MaxRetryBackoff
+	}
+	// Jitter keeps clients that failed together from retrying together
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// roundTrip writes commands and reads their replies on the current
+// connection. sent reports whether the commands may have reached the server.
+// After a network error the replies can no longer be matched to commands, so
+// the connection is marked broken and replaced on the next exchange.
+func (c *Client) roundTrip(ctx context.Context, cmds []string) (responses []string, sent bool, err error) {
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+
+	// Canceling the context interrupts a blocked read or write
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	conn.SetWriteDeadline(deadline(ctx, c.opts.WriteTimeout))
+	for _, cmd := range cmds {
+		if _, err := c.writer.WriteString(cmd + "\n"); err != nil {
+			c.broken = true
+			return nil, true, c.networkError(ctx, "write", err)
+		}
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.broken = true
+		return nil, true, c.networkError(ctx, "write", err)
+	}
+
+	conn.SetReadDeadline(deadline(ctx, c.opts.ReadTimeout))
+	responses = make([]string, len(cmds))
+	for i := range cmds {
+		response, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.broken = true
+			return nil, true, c.networkError(ctx, "read", err)
+		}
+		responses[i] = strings.TrimSpace(response)
+	}
+	return responses, true, nil
+}
+
+// deadline returns the earlier of ctx's deadline and now plus timeout
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	d := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
+	}
+	return d
+}
+
+// networkError wraps a connection failure, reporting the context's error if it caused it
+func (c *Client) networkError(ctx context.Context, op string, err error) error {
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	} else if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) && errors.Is(err, os.ErrDeadlineExceeded) {
+		// The socket deadline copied from ctx can expire before ctx's own timer fires
+		err = context.DeadlineExceeded
+	}
+	return &NetworkError{Op: op, Err: err}
+}
 
-	return strings.TrimSpace(response), nil
+// isBroken reports whether the last exchange failed
+func (c *Client) isBroken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.broken
 }
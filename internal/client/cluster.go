@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"CacheFlow/internal/cluster"
+	"CacheFlow/internal/protocol"
+)
+
+// maxRedirects bounds how many MOVED/ASK replies a single command follows
+const maxRedirects = 5
+
+// ClusterClient talks to a sharded CacheFlow cluster. It learns which node
+// owns each hash slot and sends every command straight to that node,
+// following MOVED and ASK redirects when the slot map changes. It is safe for
+// concurrent use.
+type ClusterClient struct {
+	mu    sync.Mutex
+	seeds []string
+	opts  Options
+	slots [cluster.SlotCount]string // slot to node address; empty if unknown
+	conns map[string]*Client
+}
+
+// NewCluster connects to a cluster through any of the given seed nodes and loads its slot map
+func NewCluster(seeds ...string) (*ClusterClient, error) {
+	return NewClusterWithOptions(Options{}, seeds...)
+}
+
+// NewClusterWithOptions is NewCluster with Options for the connection to each node
+func NewClusterWithOptions(opts Options, seeds ...string) (*ClusterClient, error) {
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("at least one seed address is required")
+	}
+	c := &ClusterClient{
+		seeds: seeds,
+		opts:  opts,
+		conns: make(map[string]*Client),
+	}
+	if err := c.Refresh(context.Background()); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the connections to every node
+func (c *ClusterClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for addr, conn := range c.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.conns, addr)
+	}
+	return firstErr
+}
+
+// Refresh reloads the slot map from the first node that answers CLUSTER SLOTS
+func (c *ClusterClient) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	candidates := append([]string(nil), c.seeds...)
+	for addr := range c.conns {
+		candidates = append(candidates, addr)
+	}
+	c.mu.Unlock()
+
+	var lastErr error
+	for _, addr := range candidates {
+		conn, err := c.conn(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		response, err := conn.executeCommand(ctx, "CLUSTER SLOTS")
+		if err != nil {
+			c.drop(addr)
+			lastErr = err
+			continue
+		}
+		slots, err := parseSlotMap(response)
+		if err != nil {
+			lastErr = fmt.Errorf("node %s: %w", addr, err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("failed to load cluster slot map: %w", lastErr)
+}
+
+// parseSlotMap parses a CLUSTER SLOTS reply of the form "0-5460 host:port, 5461-16383 host:port"
+func parseSlotMap(response string) ([cluster.SlotCount]string, error) {
+	var slots [cluster.SlotCount]string
+	if err := replyError(response); err != nil {
+		return slots, err
+	}
+	for _, entry := range strings.Split(response, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return slots, fmt.Errorf("malformed slot map entry %q", entry)
+		}
+		r, err := cluster.ParseSlotRange(fields[0])
+		if err != nil {
+			return slots, err
+		}
+		for slot := r.Start; slot <= r.End; slot++ {
+			slots[slot] = fields[1]
+		}
+	}
+	return slots, nil
+}
+
+// conn returns the connection to addr, dialing it on first use
+func (c *ClusterClient) conn(addr string) (*Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := NewWithOptions(addr, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// drop closes and forgets a connection after a network error
+func (c *ClusterClient) drop(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[addr]; ok {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+}
+
+// nodeFor returns the address of the node believed to own key
+func (c *ClusterClient) nodeFor(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if addr := c.slots[cluster.KeySlot(key)]; addr != "" {
+		return addr
+	}
+	return c.seeds[0]
+}
+
+// parseRedirect recognizes "ERROR: MOVED <slot> <addr>" and "ERROR: ASK <slot> <addr>" replies
+func parseRedirect(response string) (kind protocol.Code, slot int, addr string, ok bool) {
+	code, message, isError := protocol.ParseError(response)
+	fields := strings.Fields(message)
+	if !isError || (code != protocol.CodeMoved && code != protocol.CodeAsk) || len(fields) != 2 {
+		return "", 0, "", false
+	}
+	slot, err := strconv.Atoi(fields[0])
+	if err != nil || slot < 0 || slot >= cluster.SlotCount {
+		return "", 0, "", false
+	}
+	return code, slot, fields[1], true
+}
+
+// executeCommand sends a command for key to the node that owns it. A MOVED
+// reply updates the slot map and retries on the new owner; an ASK reply
+// retries once on the target node, preceded by ASKING, without updating it.
+func (c *ClusterClient) executeCommand(ctx context.Context, key, cmd string) (string, error) {
+	addr := c.nodeFor(key)
+	asking := false
+
+	for i := 0; i <= maxRedirects; i++ {
+		conn, err := c.conn(addr)
+		if err != nil {
+			return "", err
+		}
+		if asking {
+			if _, err := conn.executeCommand(ctx, "ASKING"); err != nil {
+				c.drop(addr)
+				return "", err
+			}
+		}
+		response, err := conn.executeCommand(ctx, cmd)
+		if err != nil {
+			c.drop(addr)
+			return "", err
+		}
+
+		kind, slot, target, ok := parseRedirect(response)
+		if !ok {
+			return response, nil
+		}
+		if kind == protocol.CodeMoved {
+			c.mu.Lock()
+			c.slots[slot] = target
+			c.mu.Unlock()
+			asking = false
+		} else {
+			asking = true
+		}
+		addr = target
+	}
+	return "", fmt.Errorf("too many cluster redirects for key %s", key)
+}
+
+// Set stores a value on the node owning key
+func (c *ClusterClient) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	cmd := fmt.Sprintf("SET %s %s", key, value)
+	if ttl > 0 {
+		cmd += fmt.Sprintf(" %s", ttl)
+	}
+	response, err := c.executeCommand(ctx, key, cmd)
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Get retrieves a value from the node owning key
+func (c *ClusterClient) Get(ctx context.Context, key string) (string, error) {
+	response, err := c.executeCommand(ctx, key, fmt.Sprintf("GET %s", key))
+	if err != nil {
+		return "", err
+	}
+
+	return parseValue(response)
+}
+
+// Delete removes a key from the node owning it
+func (c *ClusterClient) Delete(ctx context.Context, key string) error {
+	response, err := c.executeCommand(ctx, key, fmt.Sprintf("DELETE %s", key))
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Exists checks whether a key exists on the node owning it
+func (c *ClusterClient) Exists(ctx context.Context, key string) (bool, error) {
+	response, err := c.executeCommand(ctx, key, fmt.Sprintf("EXISTS %s", key))
+	if err != nil {
+		return false, err
+	}
+
+	if response != "0" && response != "1" {
+		return false, unexpected(response)
+	}
+	return response == "1", nil
+}
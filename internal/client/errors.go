@@ -0,0 +1,126 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"CacheFlow/internal/protocol"
+)
+
+var (
+	// ErrNetwork matches every error caused by the connection rather than the server's reply
+	ErrNetwork = errors.New("network error")
+	// ErrServer matches every ERROR reply from the server
+	ErrServer = errors.New("server error")
+	// ErrClosed is returned by a Client after Close
+	ErrClosed = errors.New("client is closed")
+)
+
+// Errors matching ERROR replies with a specific code
+var (
+	ErrSyntax         = errors.New("syntax error")
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrWrongType      = errors.New("wrong type")
+	ErrNoAuth         = errors.New("authentication required")
+	ErrWrongPass      = errors.New("wrong password")
+	ErrNoPerm         = errors.New("permission denied")
+	ErrOOM            = errors.New("out of memory")
+	ErrReadOnly       = errors.New("read only replica")
+	ErrMoved          = errors.New("moved")
+	ErrAsk            = errors.New("ask")
+	ErrClusterDown    = errors.New("cluster down")
+	ErrNotLeader      = errors.New("not leader")
+	ErrIOErr          = errors.New("I/O error")
+	ErrNoScript       = errors.New("no such script")
+	ErrBusy           = errors.New("busy")
+	ErrNoGroup        = errors.New("no such consumer group")
+)
+
+// codeErrors maps reply codes to the errors a ServerError with that code matches
+var codeErrors = map[protocol.Code]error{
+	protocol.CodeSyntax:         ErrSyntax,
+	protocol.CodeUnknownCommand: ErrUnknownCommand,
+	protocol.CodeWrongType:      ErrWrongType,
+	protocol.CodeNoAuth:         ErrNoAuth,
+	protocol.CodeWrongPass:      ErrWrongPass,
+	protocol.CodeNoPerm:         ErrNoPerm,
+	protocol.CodeOOM:            ErrOOM,
+	protocol.CodeReadOnly:       ErrReadOnly,
+	protocol.CodeMoved:          ErrMoved,
+	protocol.CodeAsk:            ErrAsk,
+	protocol.CodeClusterDown:    ErrClusterDown,
+	protocol.CodeNotLeader:      ErrNotLeader,
+	protocol.CodeIOErr:          ErrIOErr,
+	protocol.CodeNoScript:       ErrNoScript,
+	protocol.CodeBusy:           ErrBusy,
+	protocol.CodeNoGroup:        ErrNoGroup,
+}
+
+// NetworkError is a failure to reach the server or exchange data with it. It
+// matches ErrNetwork, and unwraps to the underlying error, which is the
+// context's error when the command was canceled or ran past its deadline.
+type NetworkError struct {
+	Op  string // "dial", "write" or "read"
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.Op, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrNetwork) hold for every NetworkError
+func (e *NetworkError) Is(target error) bool {
+	return target == ErrNetwork
+}
+
+// ServerError is an ERROR reply from the server. It matches ErrServer, and
+// the error of its code, such as ErrWrongType for WRONGTYPE.
+type ServerError struct {
+	Code    protocol.Code
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	if e.Message == "" {
+		return "server replied: " + string(e.Code)
+	}
+	return "server replied: " + string(e.Code) + " " + e.Message
+}
+
+// Is makes errors.Is(err, ErrServer) hold for every ServerError, and
+// errors.Is(err, ErrReadOnly) and the like for those with that code
+func (e *ServerError) Is(target error) bool {
+	return target == ErrServer || (target != nil && codeErrors[e.Code] == target)
+}
+
+// replyError returns the ServerError carried by a reply, or nil if it is not an error
+func replyError(response string) error {
+	if code, message, ok := protocol.ParseError(response); ok {
+		return &ServerError{Code: code, Message: message}
+	}
+	return nil
+}
+
+// unexpected reports a reply that is neither an error nor what the command returns
+func unexpected(response string) error {
+	if err := replyError(response); err != nil {
+		return err
+	}
+	return fmt.Errorf("unexpected response: %s", response)
+}
+
+// parseValue interprets the reply of a command returning a stored value; a
+// missing key yields an empty value
+func parseValue(response string) (string, error) {
+	if err := replyError(response); err != nil {
+		return "", err
+	}
+	if response == protocol.Nil {
+		return "", nil
+	}
+	return protocol.UnescapeValue(response), nil
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// Info holds the reply of INFO: the fields of each section, keyed by the
+// lowercase section name and then by field name
+type Info map[string]map[string]string
+
+// Get returns a field of a section, or "" if there is none
+func (i Info) Get(section, field string) string {
+	return i[section][field]
+}
+
+// Int returns a numeric field of a section, or 0 if it is missing or not a number
+func (i Info) Int(section, field string) int64 {
+	n, _ := strconv.ParseInt(i.Get(section, field), 10, 64)
+	return n
+}
+
+// Info returns the named sections of the server's INFO, or every section if none is named
+func (c *Client) Info(ctx context.Context, sections ...string) (Info, error) {
+	cmd := "INFO"
+	if len(sections) > 0 {
+		cmd += " " + strings.Join(sections, " ")
+	}
+	response, err := c.executeCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := replyError(response); err != nil {
+		return nil, err
+	}
+	return parseInfo(response), nil
+}
+
+// parseInfo parses an INFO reply: "# Section" entries followed by "field:value" entries
+func parseInfo(response string) Info {
+	info := make(Info)
+	var section map[string]string
+	for _, entry := range strings.Split(response, ", ") {
+		if name, ok := strings.CutPrefix(entry, "# "); ok {
+			section = make(map[string]string)
+			info[strings.ToLower(name)] = section
+			continue
+		}
+		field, value, ok := strings.Cut(entry, ":")
+		if !ok || section == nil {
+			continue
+		}
+		section[field] = value
+	}
+	return info
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Monitor opens a second connection to the server in MONITOR mode and
+// returns the commands the server processes, one line each, until ctx is
+// canceled or the server ends the stream. The channel is then closed.
+func (c *Client) Monitor(ctx context.Context) (<-chan string, error) {
+	mc, err := NewWithOptions(c.addr, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := mc.Do(ctx, "MONITOR")
+	if err != nil {
+		mc.Close()
+		return nil, err
+	}
+	if reply != "OK" {
+		mc.Close()
+		return nil, unexpected(reply)
+	}
+
+	// The connection now only carries the stream; nothing else uses it
+	mc.conn.SetReadDeadline(time.Time{})
+	lines := make(chan string)
+	stop := context.AfterFunc(ctx, func() { mc.Close() })
+	go func() {
+		defer close(lines)
+		defer stop()
+		defer mc.Close()
+		for {
+			line, err := mc.reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			select {
+			case lines <- strings.TrimSpace(line):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines, nil
+}
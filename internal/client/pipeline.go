@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reply is the result of a pipelined command, available once the pipeline has been executed
+type Reply struct {
+	cmd   string
+	parse func(response string) (string, error)
+	value string
+	err   error
+	done  bool
+}
+
+// Result returns the value of the command, or the error it failed with
+func (r *Reply) Result() (string, error) {
+	if !r.done {
+		return "", fmt.Errorf("pipeline has not been executed")
+	}
+	return r.value, r.err
+}
+
+// Bool interprets the reply of EXISTS
+func (r *Reply) Bool() (bool, error) {
+	value, err := r.Result()
+	return value == "1", err
+}
+
+// Pipeline queues commands and sends them in a single write. Replies are
+// read back in order and delivered to the Reply returned by each call.
+// A Pipeline must not be used from several goroutines at once.
+type Pipeline struct {
+	exec    func(ctx context.Context, cmds []string) ([]string, error)
+	replies []*Reply
+}
+
+// Pipeline starts a new pipeline on the connection. A pipeline is retried
+// after a network error only if it could not be sent at all.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{exec: func(ctx context.Context, cmds []string) ([]string, error) {
+		return c.exchange(ctx, cmds, false)
+	}}
+}
+
+// queue adds a command to the pipeline
+func (p *Pipeline) queue(cmd string, parse func(string) (string, error)) *Reply {
+	r := &Reply{cmd: cmd, parse: parse}
+	p.replies = append(p.replies, r)
+	return r
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.replies)
+}
+
+// Do queues a raw command line; its reply is returned unchanged
+func (p *Pipeline) Do(cmd string) *Reply {
+	return p.queue(cmd, nil)
+}
+
+// Set queues a SET
+func (p *Pipeline) Set(key, value string, ttl time.Duration) *Reply {
+	cmd := fmt.Sprintf("SET %s %s", key, value)
+	if ttl > 0 {
+		cmd += fmt.Sprintf(" %s", ttl)
+	}
+	return p.queue(cmd, expectOK)
+}
+
+// Get queues a GET; a missing key yields an empty value
+func (p *Pipeline) Get(key string) *Reply {
+	return p.queue(fmt.Sprintf("GET %s", key), parseValue)
+}
+
+// Delete queues a DELETE
+func (p *Pipeline) Delete(key string) *Reply {
+	return p.queue(fmt.Sprintf("DELETE %s", key), expectOK)
+}
+
+// Exists queues an EXISTS; use Reply.Bool to read the result
+func (p *Pipeline) Exists(key string) *Reply {
+	return p.queue(fmt.Sprintf("EXISTS %s", key), func(response string) (string, error) {
+		if response != "0" && response != "1" {
+			return "", unexpected(response)
+		}
+		return response, nil
+	})
+}
+
+// expectOK accepts only an OK reply
+func expectOK(response string) (string, error) {
+	if response != "OK" {
+		return "", unexpected(response)
+	}
+	return response, nil
+}
+
+// Exec sends every queued command and fills in their replies. The returned
+// error reports a failed exchange, in which case every reply carries it;
+// commands the server rejected only fail their own Reply. The pipeline is
+// empty afterwards and can be reused.
+func (p *Pipeline) Exec(ctx context.Context) error {
+	replies := p.replies
+	p.replies = nil
+	if len(replies) == 0 {
+		return nil
+	}
+
+	cmds := make([]string, len(replies))
+	for i, r := range replies {
+		cmds[i] = r.cmd
+	}
+	responses, err := p.exec(ctx, cmds)
+	for i, r := range replies {
+		r.done = true
+		switch {
+		case err != nil:
+			r.err = err
+		case r.parse != nil:
+			r.value, r.err = r.parse(responses[i])
+		default:
+			r.value = responses[i]
+		}
+	}
+	return err
+}
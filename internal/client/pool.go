@@ -0,0 +1,396 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by a Pool after Close
+var ErrPoolClosed = errors.New("connection pool is closed")
+
+// PoolOptions tune a Pool; zero values select the defaults
+type PoolOptions struct {
+	// MinIdle is the number of idle connections kept open even when unused (default 0)
+	MinIdle int
+	// MaxIdle is the number of idle connections kept for reuse; others are closed (default 8)
+	MaxIdle int
+	// MaxOpen limits the number of open connections; callers wait for a free
+	// one when it is reached (default 0, no limit)
+	MaxOpen int
+	// IdleTimeout closes connections idle for longer than this, down to MinIdle (default 5m)
+	IdleTimeout time.Duration
+	// HealthCheckInterval is how often idle connections are pinged (default 30s)
+	HealthCheckInterval time.Duration
+	// Client configures each connection
+	Client Options
+}
+
+// PoolStats describes the connections of a Pool
+type PoolStats struct {
+	Open   int   // connections currently open, idle or in use
+	Idle   int   // connections waiting for reuse
+	Hits   int64 // requests served by an idle connection
+	Misses int64 // requests that had to dial a new connection
+}
+
+// idleConn is a connection waiting in the pool
+type idleConn struct {
+	c     *Client
+	since time.Time
+}
+
+// Pool is a goroutine-safe client keeping a set of connections to one
+// server. Each command borrows a connection, so concurrent callers don't
+// wait for each other's round trips.
+type Pool struct {
+	addr string
+	opts PoolOptions
+
+	mu     sync.Mutex
+	idle   []idleConn // most recently used last
+	open   int
+	hits   int64
+	misses int64
+	closed bool
+	cond   *sync.Cond // signaled when a connection is returned or closed
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewPool creates a pool of connections to addr and opens MinIdle of them
+func NewPool(addr string, opts PoolOptions) (*Pool, error) {
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = 8
+	}
+	if opts.MinIdle > opts.MaxIdle {
+		opts.MaxIdle = opts.MinIdle
+	}
+	if opts.MaxOpen > 0 && opts.MinIdle > opts.MaxOpen {
+		return nil, fmt.Errorf("MinIdle %d exceeds MaxOpen %d", opts.MinIdle, opts.MaxOpen)
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 5 * time.Minute
+	}
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = 30 * time.Second
+	}
+
+	p := &Pool{
+		addr: addr,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	if err := p.fill(); err != nil {
+		close(p.done)
+		p.Close()
+		return nil, err
+	}
+
+	go p.healthCheckLoop()
+	return p, nil
+}
+
+// Close closes every idle connection; connections in use are closed when returned
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	p.mu.Unlock()
+	p.cond.Broadcast()
+
+	close(p.stop)
+	<-p.done
+
+	var firstErr error
+	for _, ic := range idle {
+		if err := ic.c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the current connection counts
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{Open: p.open, Idle: len(p.idle), Hits: p.hits, Misses: p.misses}
+}
+
+// get borrows a connection, reusing the most recently returned idle one if
+// possible. When MaxOpen connections are open it waits for one to be
+// returned, or for ctx to end.
+func (p *Pool) get(ctx context.Context) (*Client, error) {
+	stop := context.AfterFunc(ctx, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.cond.Broadcast()
+	})
+	defer stop()
+
+	p.mu.Lock()
+	counted := false
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if err := ctx.Err(); err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1].c
+			p.idle = p.idle[:n-1]
+			if !counted {
+				p.hits++
+			}
+			p.mu.Unlock()
+			return c, nil
+		}
+		if !counted {
+			p.misses++
+			counted = true
+		}
+		if p.opts.MaxOpen <= 0 || p.open < p.opts.MaxOpen {
+			break
+		}
+		p.cond.Wait()
+	}
+	p.open++
+	p.mu.Unlock()
+
+	c, err := newWithContext(ctx, p.addr, p.opts.Client)
+	if err != nil {
+		p.discard()
+		return nil, err
+	}
+	return c, nil
+}
+
+// discard accounts for a connection that was closed or failed to open
+func (p *Pool) discard() {
+	p.mu.Lock()
+	p.open--
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// put returns a borrowed connection. Broken connections, and those beyond
+// MaxIdle or returned after Close, are closed instead of kept.
+func (p *Pool) put(c *Client) {
+	broken := c.isBroken()
+
+	p.mu.Lock()
+	if !broken && !p.closed && len(p.idle) < p.opts.MaxIdle {
+		p.idle = append(p.idle, idleConn{c: c, since: time.Now()})
+		p.mu.Unlock()
+		p.cond.Signal()
+		return
+	}
+	p.mu.Unlock()
+
+	c.Close()
+	p.discard()
+}
+
+// fill opens connections until MinIdle are idle, within MaxOpen
+func (p *Pool) fill() error {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle) >= p.opts.MinIdle || (p.opts.MaxOpen > 0 && p.open >= p.opts.MaxOpen) {
+			p.mu.Unlock()
+			return nil
+		}
+		p.open++
+		p.mu.Unlock()
+
+		c, err := NewWithOptions(p.addr, p.opts.Client)
+		if err != nil {
+			p.discard()
+			return err
+		}
+		p.put(c)
+	}
+}
+
+// healthCheckLoop pings idle connections, closes those idle for too long and keeps MinIdle open
+func (p *Pool) healthCheckLoop() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		p.checkIdle()
+		if err := p.fill(); err != nil {
+			log.Printf("Failed to open idle connections to %s: %v", p.addr, err)
+		}
+	}
+}
+
+// checkIdle takes the idle connections out of the pool, drops the expired
+// and unhealthy ones, and returns the rest
+func (p *Pool) checkIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	kept := 0
+	for i, ic := range idle {
+		// The oldest connections come first; keep the newest MinIdle regardless of age
+		expired := time.Since(ic.since) > p.opts.IdleTimeout && len(idle)-i > p.opts.MinIdle
+		if expired || p.ping(ic.c) != nil {
+			ic.c.Close()
+			p.discard()
+			continue
+		}
+		idle[kept] = ic
+		kept++
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.open -= kept
+		p.mu.Unlock()
+		for _, ic := range idle[:kept] {
+			ic.c.Close()
+		}
+		return
+	}
+	// Connections returned during the check are more recent than the checked ones
+	p.idle = append(idle[:kept], p.idle...)
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// ping checks an idle connection without retrying
+func (p *Pool) ping(c *Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.HealthCheckInterval)
+	defer cancel()
+	responses, err := c.exchange(ctx, []string{"PING"}, false)
+	if err != nil {
+		return err
+	}
+	if responses[0] != "PONG" {
+		return unexpected(responses[0])
+	}
+	return nil
+}
+
+// Pipeline starts a pipeline that runs on a single pooled connection
+func (p *Pool) Pipeline() *Pipeline {
+	return &Pipeline{exec: func(ctx context.Context, cmds []string) ([]string, error) {
+		c, err := p.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer p.put(c)
+		return c.exchange(ctx, cmds, false)
+	}}
+}
+
+// executeCommand sends a command on a borrowed connection
+func (p *Pool) executeCommand(ctx context.Context, cmd string) (string, error) {
+	c, err := p.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer p.put(c)
+	return c.executeCommand(ctx, cmd)
+}
+
+// Do sends a raw command line on a borrowed connection and returns the reply line
+func (p *Pool) Do(ctx context.Context, cmd string) (string, error) {
+	c, err := p.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer p.put(c)
+	return c.Do(ctx, cmd)
+}
+
+// Ping checks that the server is reachable and responding
+func (p *Pool) Ping(ctx context.Context) error {
+	response, err := p.executeCommand(ctx, "PING")
+	if err != nil {
+		return err
+	}
+
+	if response != "PONG" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Set stores a value
+func (p *Pool) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	cmd := fmt.Sprintf("SET %s %s", key, value)
+	if ttl > 0 {
+		cmd += fmt.Sprintf(" %s", ttl)
+	}
+	response, err := p.executeCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Get retrieves a value
+func (p *Pool) Get(ctx context.Context, key string) (string, error) {
+	response, err := p.executeCommand(ctx, fmt.Sprintf("GET %s", key))
+	if err != nil {
+		return "", err
+	}
+
+	return parseValue(response)
+}
+
+// Delete removes a key
+func (p *Pool) Delete(ctx context.Context, key string) error {
+	response, err := p.executeCommand(ctx, fmt.Sprintf("DELETE %s", key))
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Exists checks whether a key exists
+func (p *Pool) Exists(ctx context.Context, key string) (bool, error) {
+	response, err := p.executeCommand(ctx, fmt.Sprintf("EXISTS %s", key))
+	if err != nil {
+		return false, err
+	}
+
+	if response != "0" && response != "1" {
+		return false, unexpected(response)
+	}
+	return response == "1", nil
+}
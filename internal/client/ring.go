@@ -0,0 +1,64 @@
+package client
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ringPoint is one virtual node on the hash ring
+type ringPoint struct {
+	hash uint64
+	addr string
+}
+
+// hashRing maps keys to nodes with consistent hashing. Each node is placed on
+// the ring many times (virtual nodes) in proportion to its weight, so load is
+// spread evenly and removing a node only remaps the keys it owned.
+type hashRing struct {
+	points []ringPoint
+}
+
+// newHashRing builds a ring from node weights, placing vnodes points per unit of weight
+func newHashRing(weights map[string]int, vnodes int) *hashRing {
+	r := &hashRing{}
+	for addr, weight := range weights {
+		for i := 0; i < weight*vnodes; i++ {
+			r.points = append(r.points, ringPoint{hash: hashKey(addr + "#" + strconv.Itoa(i)), addr: addr})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].addr < r.points[j].addr
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// hashKey hashes a string onto the ring
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV alone clusters similar inputs; a final mix spreads them around the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// lookup returns the node owning key: the first point clockwise from its hash
+func (r *hashRing) lookup(key string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].addr, true
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashRingWeights(t *testing.T) {
+	ring := newHashRing(map[string]int{"a": 1, "b": 1, "c": 2}, 160)
+
+	counts := make(map[string]int)
+	const keys = 100000
+	for i := 0; i < keys; i++ {
+		addr, ok := ring.lookup("key:" + strconv.Itoa(i))
+		if !ok {
+			t.Fatal("Expected a node for every key")
+		}
+		counts[addr]++
+	}
+
+	// c has twice the weight, so it should get about half of the keys
+	expected := map[string]float64{"a": 0.25, "b": 0.25, "c": 0.5}
+	for addr, share := range expected {
+		got := float64(counts[addr]) / keys
+		if got < share-0.05 || got > share+0.05 {
+			t.Errorf("Expected node %s to get about %.2f of the keys, got %.3f", addr, share, got)
+		}
+	}
+}
+
+func TestHashRingRemoveNode(t *testing.T) {
+	before := newHashRing(map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}, 160)
+	after := newHashRing(map[string]int{"a": 1, "b": 1, "c": 1}, 160)
+
+	moved := 0
+	const keys = 10000
+	for i := 0; i < keys; i++ {
+		key := "key:" + strconv.Itoa(i)
+		old, _ := before.lookup(key)
+		cur, _ := after.lookup(key)
+		if old != "d" && old != cur {
+			t.Fatalf("Expected key %s to stay on %s, got %s", key, old, cur)
+		}
+		if old != cur {
+			moved++
+		}
+	}
+	if moved == 0 || moved > keys/3 {
+		t.Errorf("Expected about a quarter of the keys to move, got %d of %d", moved, keys)
+	}
+}
+
+func TestHashRingEmpty(t *testing.T) {
+	ring := newHashRing(nil, 160)
+	if _, ok := ring.lookup("key"); ok {
+		t.Error("Expected no node on an empty ring")
+	}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Eval runs a script atomically on the server with KEYS and ARGV set to keys
+// and args, and returns its reply; a nil or false result yields an empty
+// value. Scripts must fit on one line, and keys and args must not contain
+// spaces. Since a script may write, it is only retried if it could not be sent.
+func (c *Client) Eval(ctx context.Context, script string, keys, args []string) (string, error) {
+	parts := append([]string{"EVAL", fmt.Sprint(len(keys))}, keys...)
+	parts = append(append(parts, fmt.Sprint(len(args))), args...)
+	return c.evalCommand(ctx, strings.Join(append(parts, script), " "))
+}
+
+// EvalSHA runs a script loaded with ScriptLoad or a previous Eval. It fails
+// with an error matching ErrNoScript if the server does not know the script.
+func (c *Client) EvalSHA(ctx context.Context, sha string, keys, args []string) (string, error) {
+	parts := append([]string{"EVALSHA", sha, fmt.Sprint(len(keys))}, keys...)
+	return c.evalCommand(ctx, strings.Join(append(parts, args...), " "))
+}
+
+// ScriptLoad caches a script on the server without running it and returns
+// the SHA1 EvalSHA refers to it by
+func (c *Client) ScriptLoad(ctx context.Context, script string) (string, error) {
+	response, err := c.executeCommand(ctx, "SCRIPT LOAD "+script)
+	if err != nil {
+		return "", err
+	}
+	if err := replyError(response); err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// evalCommand sends EVAL or EVALSHA and parses the reply as a value
+func (c *Client) evalCommand(ctx context.Context, cmd string) (string, error) {
+	responses, err := c.exchange(ctx, []string{cmd}, false)
+	if err != nil {
+		return "", err
+	}
+	return parseValue(responses[0])
+}
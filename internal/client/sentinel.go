@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sentinelTimeout bounds a query to a single sentinel
+const sentinelTimeout = 2 * time.Second
+
+// DiscoverPrimary asks the sentinels in turn for the address of the primary
+// of the named group and returns the first answer
+func DiscoverPrimary(ctx context.Context, name string, sentinels ...string) (string, error) {
+	if len(sentinels) == 0 {
+		return "", fmt.Errorf("at least one sentinel address is required")
+	}
+
+	var lastErr error
+	for _, addr := range sentinels {
+		primary, err := askSentinel(ctx, addr, name)
+		if err == nil {
+			return primary, nil
+		}
+		lastErr = fmt.Errorf("sentinel %s: %w", addr, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", fmt.Errorf("failed to discover primary %s: %w", name, lastErr)
+}
+
+// askSentinel sends "SENTINEL PRIMARY <name>" to one sentinel
+func askSentinel(ctx context.Context, addr, name string) (string, error) {
+	c, err := NewWithOptions(addr, Options{DialTimeout: sentinelTimeout, MaxRetries: -1})
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, sentinelTimeout)
+	defer cancel()
+	response, err := c.Do(ctx, "SENTINEL PRIMARY "+name)
+	if err != nil {
+		return "", err
+	}
+	if err := replyError(response); err != nil {
+		return "", err
+	}
+	if response == "" {
+		return "", fmt.Errorf("unexpected response: %s", response)
+	}
+	return response, nil
+}
+
+// NewFromSentinel connects to the primary of the named group as reported by
+// the sentinels, checking that the server it reaches really is a primary
+func NewFromSentinel(name string, sentinels ...string) (*Client, error) {
+	return connectPrimary(context.Background(), name, Options{}, sentinels)
+}
+
+// connectPrimary discovers the primary of the named group and connects to it
+func connectPrimary(ctx context.Context, name string, opts Options, sentinels []string) (*Client, error) {
+	addr, err := DiscoverPrimary(ctx, name, sentinels...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewWithOptions(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	role, err := c.Do(ctx, "ROLE")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(role, "primary ") {
+		c.Close()
+		return nil, fmt.Errorf("%s is not a primary: %s", addr, role)
+	}
+	return c, nil
+}
+
+// SentinelClient talks to the primary of a group monitored by sentinels. When
+// the connection fails or the server turns out to be a read-only replica, it
+// asks the sentinels for the primary again and retries the command once. It
+// is safe for concurrent use.
+type SentinelClient struct {
+	name      string
+	sentinels []string
+	opts      Options
+
+	mu   sync.Mutex
+	conn *Client
+}
+
+// NewSentinelClient creates a client for the primary of the named group
+func NewSentinelClient(name string, sentinels ...string) (*SentinelClient, error) {
+	return NewSentinelClientWithOptions(name, Options{}, sentinels...)
+}
+
+// NewSentinelClientWithOptions is NewSentinelClient with Options for the connection to the primary
+func NewSentinelClientWithOptions(name string, opts Options, sentinels ...string) (*SentinelClient, error) {
+	conn, err := connectPrimary(context.Background(), name, opts, sentinels)
+	if err != nil {
+		return nil, err
+	}
+	return &SentinelClient{name: name, sentinels: sentinels, opts: opts, conn: conn}, nil
+}
+
+// Close closes the connection to the primary
+func (c *SentinelClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// executeCommand sends a command to the primary, rediscovering it once if needed
+func (c *SentinelClient) executeCommand(ctx context.Context, cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			conn, err := connectPrimary(ctx, c.name, c.opts, c.sentinels)
+			if err != nil {
+				return "", err
+			}
+			c.conn = conn
+		}
+
+		response, err := c.conn.executeCommand(ctx, cmd)
+		if err == nil {
+			// A READONLY reply means the server was demoted to a replica since it was discovered
+			if err = replyError(response); !errors.Is(err, ErrReadOnly) {
+				return response, nil
+			}
+		}
+		lastErr = err
+		c.conn.Close()
+		c.conn = nil
+	}
+	return "", lastErr
+}
+
+// Set stores a value on the primary
+func (c *SentinelClient) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	cmd := fmt.Sprintf("SET %s %s", key, value)
+	if ttl > 0 {
+		cmd += fmt.Sprintf(" %s", ttl)
+	}
+	response, err := c.executeCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Get retrieves a value from the primary
+func (c *SentinelClient) Get(ctx context.Context, key string) (string, error) {
+	response, err := c.executeCommand(ctx, fmt.Sprintf("GET %s", key))
+	if err != nil {
+		return "", err
+	}
+
+	return parseValue(response)
+}
+
+// Delete removes a key on the primary
+func (c *SentinelClient) Delete(ctx context.Context, key string) error {
+	response, err := c.executeCommand(ctx, fmt.Sprintf("DELETE %s", key))
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Exists checks whether a key exists on the primary
+func (c *SentinelClient) Exists(ctx context.Context, key string) (bool, error) {
+	response, err := c.executeCommand(ctx, fmt.Sprintf("EXISTS %s", key))
+	if err != nil {
+		return false, err
+	}
+
+	if response != "0" && response != "1" {
+		return false, unexpected(response)
+	}
+	return response == "1", nil
+}
@@ -0,0 +1,345 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoHealthyNodes is returned when every node of a ShardedClient has been ejected
+var ErrNoHealthyNodes = errors.New("no healthy nodes available")
+
+// ShardNode is a server taking part in client-side sharding
+type ShardNode struct {
+	Addr string
+	// Weight scales the share of keys the node receives; 0 means 1
+	Weight int
+}
+
+// ShardOptions tune a ShardedClient; zero values select the defaults
+type ShardOptions struct {
+	// VirtualNodes is the number of ring points per unit of weight (default 160)
+	VirtualNodes int
+	// HealthCheckInterval is how often every node is pinged (default 1s)
+	HealthCheckInterval time.Duration
+	// FailureThreshold is the number of consecutive failures that eject a node (default 3)
+	FailureThreshold int
+	// Client configures the connection to each node
+	Client Options
+}
+
+// ShardStatus describes a node of a ShardedClient
+type ShardStatus struct {
+	Addr     string
+	Weight   int
+	Healthy  bool
+	Failures int
+}
+
+// shard is the client-side state of one node
+type shard struct {
+	addr     string
+	weight   int
+	conn     *Client
+	healthy  bool
+	failures int
+}
+
+// ShardedClient spreads keys over independent CacheFlow servers with a
+// consistent-hash ring. Nodes are pinged in the background; a node failing
+// FailureThreshold times in a row is ejected from the ring, moving its keys
+// to the remaining nodes, and is added back once it answers again. It is
+// safe for concurrent use.
+type ShardedClient struct {
+	opts ShardOptions
+
+	mu     sync.Mutex
+	shards map[string]*shard
+	ring   *hashRing
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSharded creates a client for the given nodes. Nodes that cannot be
+// reached yet start out ejected and join the ring once a health check succeeds.
+func NewSharded(nodes []ShardNode, opts ShardOptions) (*ShardedClient, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("at least one node is required")
+	}
+	if opts.VirtualNodes <= 0 {
+		opts.VirtualNodes = 160
+	}
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = time.Second
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 3
+	}
+
+	c := &ShardedClient{
+		opts:   opts,
+		shards: make(map[string]*shard),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, node := range nodes {
+		if _, ok := c.shards[node.Addr]; ok {
+			return nil, fmt.Errorf("duplicate node %s", node.Addr)
+		}
+		weight := node.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		s := &shard{addr: node.Addr, weight: weight, healthy: true}
+		if conn, err := NewWithOptions(node.Addr, opts.Client); err == nil {
+			s.conn = conn
+		} else {
+			log.Printf("Shard %s unreachable, starting ejected: %v", node.Addr, err)
+			s.healthy = false
+			s.failures = opts.FailureThreshold
+		}
+		c.shards[node.Addr] = s
+	}
+	c.rebuildRing()
+
+	go c.healthCheckLoop()
+	return c, nil
+}
+
+// Close stops health checking and closes every connection. It is safe to call more than once.
+func (c *ShardedClient) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for _, s := range c.shards {
+		if s.conn != nil {
+			if err := s.conn.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			s.conn = nil
+		}
+	}
+	return firstErr
+}
+
+// Nodes returns the state of every node, sorted by address
+func (c *ShardedClient) Nodes() []ShardStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	statuses := make([]ShardStatus, 0, len(c.shards))
+	for _, s := range c.shards {
+		statuses = append(statuses, ShardStatus{Addr: s.addr, Weight: s.weight, Healthy: s.healthy, Failures: s.failures})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Addr < statuses[j].Addr })
+	return statuses
+}
+
+// NodeFor returns the address of the node key is currently routed to
+func (c *ShardedClient) NodeFor(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addr, ok := c.ring.lookup(key)
+	if !ok {
+		return "", ErrNoHealthyNodes
+	}
+	return addr, nil
+}
+
+// rebuildRing places every healthy node on a new ring. The caller must hold c.mu.
+func (c *ShardedClient) rebuildRing() {
+	weights := make(map[string]int)
+	for addr, s := range c.shards {
+		if s.healthy {
+			weights[addr] = s.weight
+		}
+	}
+	c.ring = newHashRing(weights, c.opts.VirtualNodes)
+}
+
+// recordResult updates a node's failure count and ejects or restores it. The caller must hold c.mu.
+func (c *ShardedClient) recordResult(s *shard, err error) {
+	if err == nil {
+		s.failures = 0
+		if !s.healthy {
+			log.Printf("Shard %s is healthy again, adding it back to the ring", s.addr)
+			s.healthy = true
+			c.rebuildRing()
+		}
+		return
+	}
+
+	s.failures++
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.healthy && s.failures >= c.opts.FailureThreshold {
+		log.Printf("Ejecting shard %s after %d failures: %v", s.addr, s.failures, err)
+		s.healthy = false
+		c.rebuildRing()
+	}
+}
+
+// healthCheckLoop pings every node on each interval
+func (c *ShardedClient) healthCheckLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		addrs := make([]string, 0, len(c.shards))
+		for addr := range c.shards {
+			addrs = append(addrs, addr)
+		}
+		c.mu.Unlock()
+
+		for _, addr := range addrs {
+			err := ping(addr, c.opts.Client, c.opts.HealthCheckInterval)
+			c.mu.Lock()
+			c.recordResult(c.shards[addr], err)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// ping checks a node over a dedicated short-lived connection, so health
+// checks never interleave with commands on the shared one
+func ping(addr string, opts Options, timeout time.Duration) error {
+	opts.DialTimeout = timeout
+	opts.MaxRetries = -1
+	conn, err := NewWithOptions(addr, opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return conn.Ping(ctx)
+}
+
+// executeCommand sends a command to the node owning key
+func (c *ShardedClient) executeCommand(ctx context.Context, key, cmd string) (string, error) {
+	c.mu.Lock()
+	addr, ok := c.ring.lookup(key)
+	if !ok {
+		c.mu.Unlock()
+		return "", ErrNoHealthyNodes
+	}
+	s := c.shards[addr]
+	conn := s.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		// Dial without the lock, so a slow node does not hold up the others
+		dialed, err := NewWithOptions(addr, c.opts.Client)
+		c.mu.Lock()
+		if err != nil {
+			c.recordResult(s, err)
+			c.mu.Unlock()
+			return "", err
+		}
+		select {
+		case <-c.stop:
+			c.mu.Unlock()
+			dialed.Close()
+			return "", ErrClosed
+		default:
+		}
+		if s.conn == nil {
+			s.conn = dialed
+		} else {
+			// Another command connected first
+			dialed.Close()
+		}
+		conn = s.conn
+		c.mu.Unlock()
+	}
+
+	response, err := conn.executeCommand(ctx, cmd)
+	if err != nil {
+		// A canceled or expired request says nothing about the node's health
+		if ctx.Err() == nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			c.mu.Lock()
+			if s.conn == conn {
+				c.recordResult(s, err)
+			}
+			c.mu.Unlock()
+		}
+		return "", fmt.Errorf("node %s: %w", addr, err)
+	}
+	return response, nil
+}
+
+// Set stores a value on the node owning key
+func (c *ShardedClient) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	cmd := fmt.Sprintf("SET %s %s", key, value)
+	if ttl > 0 {
+		cmd += fmt.Sprintf(" %s", ttl)
+	}
+	response, err := c.executeCommand(ctx, key, cmd)
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Get retrieves a value from the node owning key
+func (c *ShardedClient) Get(ctx context.Context, key string) (string, error) {
+	response, err := c.executeCommand(ctx, key, fmt.Sprintf("GET %s", key))
+	if err != nil {
+		return "", err
+	}
+
+	return parseValue(response)
+}
+
+// Delete removes a key from the node owning it
+func (c *ShardedClient) Delete(ctx context.Context, key string) error {
+	response, err := c.executeCommand(ctx, key, fmt.Sprintf("DELETE %s", key))
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return unexpected(response)
+	}
+	return nil
+}
+
+// Exists checks whether a key exists on the node owning it
+func (c *ShardedClient) Exists(ctx context.Context, key string) (bool, error) {
+	response, err := c.executeCommand(ctx, key, fmt.Sprintf("EXISTS %s", key))
+	if err != nil {
+		return false, err
+	}
+
+	if response != "0" && response != "1" {
+		return false, unexpected(response)
+	}
+	return response == "1", nil
+}
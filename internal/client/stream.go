@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"CacheFlow/internal/protocol"
+)
+
+// StreamEntry is an entry of a stream
+type StreamEntry struct {
+	ID string
+	// Fields alternate between field names and values
+	Fields []string
+}
+
+// XAdd appends an entry made of field-value pairs to the stream at key and
+// returns its ID. Fields and values must not contain spaces or commas. Since
+// sending it twice would add the entry twice, it is only retried if it could
+// not be sent.
+func (c *Client) XAdd(ctx context.Context, key string, fields ...string) (string, error) {
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return "", fmt.Errorf("XAdd requires field-value pairs")
+	}
+	response, err := c.Do(ctx, "XADD "+key+" * "+strings.Join(fields, " "))
+	if err != nil {
+		return "", err
+	}
+	if err := replyError(response); err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// XRange returns the entries of the stream at key with IDs from start to
+// end, where "-" and "+" stand for the smallest and greatest ID. A positive
+// count limits how many are returned.
+func (c *Client) XRange(ctx context.Context, key, start, end string, count int) ([]StreamEntry, error) {
+	cmd := fmt.Sprintf("XRANGE %s %s %s", key, start, end)
+	if count > 0 {
+		cmd += " COUNT " + strconv.Itoa(count)
+	}
+	response, err := c.executeCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseEntries(response, false)
+}
+
+// XReadGroup reads up to count entries of the stream at key never delivered
+// to group, as consumer of it. With a positive block it waits that long for
+// entries if there are none; block must be shorter than Options.ReadTimeout.
+// The entries stay pending until they are acknowledged with XAck. Since
+// reading delivers the entries, it is only retried if it could not be sent.
+func (c *Client) XReadGroup(ctx context.Context, group, consumer, key string, count int, block time.Duration) ([]StreamEntry, error) {
+	cmd := fmt.Sprintf("XREADGROUP GROUP %s %s", group, consumer)
+	if count > 0 {
+		cmd += " COUNT " + strconv.Itoa(count)
+	}
+	if block > 0 {
+		cmd += " BLOCK " + strconv.FormatInt(block.Milliseconds(), 10)
+	}
+	response, err := c.Do(ctx, cmd+" STREAMS "+key+" >")
+	if err != nil {
+		return nil, err
+	}
+	return parseEntries(response, true)
+}
+
+// XAck acknowledges entries of group read with XReadGroup and returns how
+// many of them were pending
+func (c *Client) XAck(ctx context.Context, key, group string, ids ...string) (int, error) {
+	response, err := c.executeCommand(ctx, fmt.Sprintf("XACK %s %s %s", key, group, strings.Join(ids, " ")))
+	if err != nil {
+		return 0, err
+	}
+	n, convErr := strconv.Atoi(response)
+	if convErr != nil {
+		return 0, unexpected(response)
+	}
+	return n, nil
+}
+
+// parseEntries parses a list of stream entries, whose words are preceded by
+// the stream key if withKey is set; NIL is an empty list
+func parseEntries(response string, withKey bool) ([]StreamEntry, error) {
+	if err := replyError(response); err != nil {
+		return nil, err
+	}
+	if response == protocol.Nil {
+		return nil, nil
+	}
+	var entries []StreamEntry
+	for _, line := range strings.Split(response, ", ") {
+		words := strings.Fields(line)
+		if withKey && len(words) > 0 {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			return nil, unexpected(response)
+		}
+		entries = append(entries, StreamEntry{ID: words[0], Fields: words[1:]})
+	}
+	return entries, nil
+}
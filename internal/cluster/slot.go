@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SlotCount is the number of hash slots the keyspace is divided into
+const SlotCount = 16384
+
+// KeySlot returns the hash slot of a key. If the key contains a non-empty
+// hash tag ("{...}"), only the tag is hashed, so related keys can be forced
+// into the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16([]byte(key)) % SlotCount)
+}
+
+// crc16 computes the CRC16-CCITT (XMODEM) checksum of data
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// SlotRange is an inclusive range of slots
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// String formats the range as "start-end", or just "start" for a single slot
+func (r SlotRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// ParseSlotRange parses "start-end" or a single slot number
+func ParseSlotRange(s string) (SlotRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return SlotRange{}, fmt.Errorf("invalid slot %q", first)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(last); err != nil {
+			return SlotRange{}, fmt.Errorf("invalid slot %q", last)
+		}
+	}
+	if start < 0 || end >= SlotCount || start > end {
+		return SlotRange{}, fmt.Errorf("invalid slot range %q", s)
+	}
+	return SlotRange{Start: start, End: end}, nil
+}
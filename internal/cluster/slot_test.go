@@ -0,0 +1,45 @@
+package cluster
+
+import "testing"
+
+// TestKeySlot tests slot hashing against known CRC16 values and hash tags.
+func TestKeySlot(t *testing.T) {
+	if got := crc16([]byte("123456789")); got != 0x31C3 {
+		t.Errorf("Expected CRC16 check value 0x31C3, got %#x", got)
+	}
+
+	cases := map[string]int{
+		"foo":   12182,
+		"bar":   5061,
+		"hello": 866,
+	}
+	for key, want := range cases {
+		if got := KeySlot(key); got != want {
+			t.Errorf("Expected slot %d for key '%s', got %d", want, key, got)
+		}
+	}
+
+	if KeySlot("{user1000}.following") != KeySlot("{user1000}.followers") {
+		t.Errorf("Expected keys with the same hash tag to share a slot")
+	}
+	if got, want := KeySlot("{}.a"), int(crc16([]byte("{}.a"))%SlotCount); got != want {
+		t.Errorf("Expected an empty hash tag to hash the whole key: want %d, got %d", want, got)
+	}
+}
+
+// TestParseSlotRange tests parsing of single slots and ranges.
+func TestParseSlotRange(t *testing.T) {
+	r, err := ParseSlotRange("100-200")
+	if err != nil || r != (SlotRange{Start: 100, End: 200}) {
+		t.Errorf("Expected 100-200, got %v (err %v)", r, err)
+	}
+	r, err = ParseSlotRange("7")
+	if err != nil || r != (SlotRange{Start: 7, End: 7}) || r.String() != "7" {
+		t.Errorf("Expected single slot 7, got %v (err %v)", r, err)
+	}
+	for _, bad := range []string{"", "x", "5-1", "-1", "0-16384"} {
+		if _, err := ParseSlotRange(bad); err == nil {
+			t.Errorf("Expected error parsing %q", bad)
+		}
+	}
+}
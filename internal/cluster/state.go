@@ -0,0 +1,332 @@
+// Package cluster implements the hash-slot sharding scheme: the keyspace is
+// divided into SlotCount slots, each owned by one node of the cluster, and
+// nodes redirect clients asking for keys in slots they don't own.
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// NodeInfo describes a node known to the cluster
+type NodeInfo struct {
+	ID     string
+	Addr   string
+	Slots  []SlotRange
+	Myself bool
+}
+
+// Assignment is a contiguous range of slots owned by one node
+type Assignment struct {
+	Range  SlotRange
+	NodeID string
+	Addr   string
+}
+
+// savedState is the on-disk form of a State
+type savedState struct {
+	MyID      string            `json:"my_id"`
+	Nodes     map[string]string `json:"nodes"`
+	Slots     []savedRange      `json:"slots"`
+	Migrating map[int]string    `json:"migrating,omitempty"`
+	Importing map[int]string    `json:"importing,omitempty"`
+}
+
+// savedRange is the on-disk form of an Assignment
+type savedRange struct {
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	NodeID string `json:"node"`
+}
+
+// State is this node's view of the cluster: the known nodes and which node
+// owns each slot. Every change is saved to the config file, so a restarted
+// node keeps its ID and slot map.
+type State struct {
+	mu       sync.RWMutex
+	filename string
+	myID     string
+	nodes    map[string]string // node ID to client address
+	slots    [SlotCount]string // slot to owning node ID; empty if unassigned
+
+	// Slots being moved: migrating maps a slot this node owns to the node
+	// receiving its keys, importing maps a slot to the node sending them
+	migrating map[int]string
+	importing map[int]string
+}
+
+// Load reads the cluster state from filename, creating a new node identity
+// if the file does not exist. addr is the address this node announces.
+func Load(filename, addr string) (*State, error) {
+	s := &State{
+		filename:  filename,
+		nodes:     make(map[string]string),
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
+
+	var saved savedState
+	data, err := os.ReadFile(filename)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("invalid cluster config %s: %w", filename, err)
+		}
+		s.myID = saved.MyID
+		for id, nodeAddr := range saved.Nodes {
+			s.nodes[id] = nodeAddr
+		}
+		for _, r := range saved.Slots {
+			if r.Start < 0 || r.End >= SlotCount || r.Start > r.End {
+				return nil, fmt.Errorf("invalid slot range %d-%d in cluster config %s", r.Start, r.End, filename)
+			}
+			for slot := r.Start; slot <= r.End; slot++ {
+				s.slots[slot] = r.NodeID
+			}
+		}
+		for slot, id := range saved.Migrating {
+			s.migrating[slot] = id
+		}
+		for slot, id := range saved.Importing {
+			s.importing[slot] = id
+		}
+		log.Printf("Loaded cluster config %s: node %s, %d known nodes", filename, s.myID, len(s.nodes))
+	case os.IsNotExist(err):
+		s.myID = newNodeID()
+		log.Printf("Created cluster node ID %s", s.myID)
+	default:
+		return nil, fmt.Errorf("failed to read cluster config %s: %w", filename, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[s.myID] = addr
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newNodeID generates a random 40 character node ID
+func newNodeID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic("cluster: failed to generate node ID: " + err.Error())
+	}
+	return hex.EncodeToString(id)
+}
+
+// MyID returns this node's ID
+func (s *State) MyID() string {
+	return s.myID
+}
+
+// AddNode records the address of another node
+func (s *State) AddNode(id, addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == s.myID {
+		return fmt.Errorf("cannot add this node to itself")
+	}
+	s.nodes[id] = addr
+	return s.save()
+}
+
+// NodeAddr returns the address of a known node
+func (s *State) NodeAddr(id string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	addr, ok := s.nodes[id]
+	return addr, ok
+}
+
+// SetSlots assigns a range of slots to a known node
+func (s *State) SetSlots(r SlotRange, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nodes[id]; !ok {
+		return fmt.Errorf("unknown node %s", id)
+	}
+	for slot := r.Start; slot <= r.End; slot++ {
+		s.slots[slot] = id
+		delete(s.migrating, slot)
+		delete(s.importing, slot)
+	}
+	return s.save()
+}
+
+// SetMigrating marks a slot owned by this node as being moved to another node
+func (s *State) SetMigrating(slot int, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slots[slot] != s.myID {
+		return fmt.Errorf("slot %d is not owned by this node", slot)
+	}
+	if _, ok := s.nodes[id]; !ok || id == s.myID {
+		return fmt.Errorf("invalid migration target %s", id)
+	}
+	s.migrating[slot] = id
+	return s.save()
+}
+
+// SetImporting marks a slot owned by another node as being moved to this node
+func (s *State) SetImporting(slot int, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slots[slot] == s.myID {
+		return fmt.Errorf("slot %d is already owned by this node", slot)
+	}
+	if _, ok := s.nodes[id]; !ok || id == s.myID {
+		return fmt.Errorf("invalid migration source %s", id)
+	}
+	s.importing[slot] = id
+	return s.save()
+}
+
+// SetStable cancels any migration of a slot
+func (s *State) SetStable(slot int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.migrating, slot)
+	delete(s.importing, slot)
+	return s.save()
+}
+
+// Migrating returns the ID and address of the node a slot is being moved to, if any
+func (s *State) Migrating(slot int) (id, addr string, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok = s.migrating[slot]
+	if !ok {
+		return "", "", false
+	}
+	return id, s.nodes[id], true
+}
+
+// Importing reports whether a slot is being moved to this node
+func (s *State) Importing(slot int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.importing[slot]
+	return ok
+}
+
+// AddSlots assigns a range of unassigned slots to this node
+func (s *State) AddSlots(r SlotRange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for slot := r.Start; slot <= r.End; slot++ {
+		if owner := s.slots[slot]; owner != "" && owner != s.myID {
+			return fmt.Errorf("slot %d is already owned by %s", slot, owner)
+		}
+	}
+	for slot := r.Start; slot <= r.End; slot++ {
+		s.slots[slot] = s.myID
+	}
+	return s.save()
+}
+
+// Owner returns the ID and address of the node owning a slot
+func (s *State) Owner(slot int) (id, addr string, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id = s.slots[slot]
+	if id == "" {
+		return "", "", false
+	}
+	addr, ok = s.nodes[id]
+	return id, addr, ok
+}
+
+// Assignments returns the slot map as contiguous ranges in slot order
+func (s *State) Assignments() []Assignment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var assignments []Assignment
+	for slot := 0; slot < SlotCount; slot++ {
+		id := s.slots[slot]
+		if id == "" {
+			continue
+		}
+		if n := len(assignments); n > 0 && assignments[n-1].NodeID == id && assignments[n-1].Range.End == slot-1 {
+			assignments[n-1].Range.End = slot
+			continue
+		}
+		assignments = append(assignments, Assignment{
+			Range:  SlotRange{Start: slot, End: slot},
+			NodeID: id,
+			Addr:   s.nodes[id],
+		})
+	}
+	return assignments
+}
+
+// Nodes returns every known node with the slots it owns, sorted by ID
+func (s *State) Nodes() []NodeInfo {
+	assignments := s.Assignments()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nodes := make([]NodeInfo, 0, len(s.nodes))
+	for id, addr := range s.nodes {
+		info := NodeInfo{ID: id, Addr: addr, Myself: id == s.myID}
+		for _, a := range assignments {
+			if a.NodeID == id {
+				info.Slots = append(info.Slots, a.Range)
+			}
+		}
+		nodes = append(nodes, info)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// save writes the state to the config file. The caller must hold s.mu.
+func (s *State) save() error {
+	if s.filename == "" {
+		return nil
+	}
+
+	saved := savedState{MyID: s.myID, Nodes: s.nodes, Migrating: s.migrating, Importing: s.importing}
+	for slot := 0; slot < SlotCount; slot++ {
+		id := s.slots[slot]
+		if id == "" {
+			continue
+		}
+		if n := len(saved.Slots); n > 0 && saved.Slots[n-1].NodeID == id && saved.Slots[n-1].End == slot-1 {
+			saved.Slots[n-1].End = slot
+			continue
+		}
+		saved.Slots = append(saved.Slots, savedRange{Start: slot, End: slot, NodeID: id})
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cluster config: %w", err)
+	}
+	tmp := s.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cluster config: %w", err)
+	}
+	if err := os.Rename(tmp, s.filename); err != nil {
+		return fmt.Errorf("failed to replace cluster config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package logging builds the structured loggers of the server: a level, text
+// or JSON output, an optional size-rotated file, and sampling for events that
+// happen once per connection or per command.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Config selects how log records are written
+type Config struct {
+	// Level is the least severe level written
+	Level slog.Level
+	// Format is "text" (the default) or "json"
+	Format string
+	// File is where records are written; empty writes to standard error
+	File string
+	// MaxSize rotates File once it would grow past this many bytes; zero never rotates
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept, as File.1 (the newest) to File.N
+	MaxBackups int
+}
+
+// New builds a logger from cfg. The closer releases the log file, if there is one.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	var w io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+	if cfg.File != "" {
+		file, err := OpenRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = file, file
+	}
+
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q, expected text or json", cfg.Format)
+	}
+	return slog.New(handler), closer, nil
+}
+
+// ParseLevel parses a level name such as debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// nopCloser is the closer of a logger that writes to standard error
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
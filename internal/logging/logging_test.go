@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.log")
+	logger, closer, err := New(Config{Level: slog.LevelWarn, Format: "json", File: file})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	logger.Info("ignored")
+	logger.Warn("Disk almost full", "free", 42)
+	closer.Close()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one record above the level, got %q", lines)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q", lines[0])
+	}
+	if record["msg"] != "Disk almost full" || record["level"] != "WARN" || record["free"] != float64(42) {
+		t.Errorf("Expected the warning with its attributes, got %v", record)
+	}
+
+	if _, _, err := New(Config{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if level, err := ParseLevel("debug"); err != nil || level != slog.LevelDebug {
+		t.Errorf("Expected debug, got %v (%v)", level, err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	defer f.Close()
+
+	// Each record fills most of a file, so every write after the first rotates
+	for _, record := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(record)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	expected := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q (%v)", name, content, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only two backups, got %v", err)
+	}
+}
+
+func TestSampled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := Sampled(slog.New(slog.NewTextHandler(&buf, nil)), 2, 3)
+
+	conn := logger.With("listener", "tcp")
+	for i := 0; i < 10; i++ {
+		conn.Info("Accepted connection")
+	}
+	for i := 0; i < 3; i++ {
+		logger.Error("Failed to accept connection")
+	}
+
+	// The first two, then the 5th and 8th; errors are never dropped
+	if n := strings.Count(buf.String(), "Accepted connection"); n != 4 {
+		t.Errorf("Expected 4 sampled records, got %d:\n%s", n, buf.String())
+	}
+	if n := strings.Count(buf.String(), "Failed to accept connection"); n != 3 {
+		t.Errorf("Expected every error, got %d", n)
+	}
+	if !strings.Contains(buf.String(), "listener=tcp") {
+		t.Errorf("Expected attributes to be kept, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sampleInterval is the period over which Sampled counts records
+const sampleInterval = time.Second
+
+// Sampled returns a logger that, per message and level, writes the first
+// records of every second and then one in every, so frequent events such as
+// connections being accepted cannot flood the output. Errors are always
+// written. An every of zero or less returns logger unchanged.
+func Sampled(logger *slog.Logger, first, every int) *slog.Logger {
+	if every <= 0 {
+		return logger
+	}
+	return slog.New(&sampler{
+		Handler: logger.Handler(),
+		first:   first,
+		every:   every,
+		counts:  &sampleCounts{m: make(map[sampleKey]*sampleCount)},
+	})
+}
+
+// sampler is a handler that drops records beyond the sampling budget
+type sampler struct {
+	slog.Handler
+	first, every int
+	counts       *sampleCounts // shared with the handlers derived by WithAttrs and WithGroup
+}
+
+// sampleKey identifies the records counted together
+type sampleKey struct {
+	level   slog.Level
+	message string
+}
+
+// sampleCount counts the records of a key in the current interval
+type sampleCount struct {
+	start time.Time
+	n     int
+}
+
+// sampleCounts holds the counts of every key
+type sampleCounts struct {
+	mu sync.Mutex
+	m  map[sampleKey]*sampleCount
+}
+
+// allow reports whether a record is within the budget of its key
+func (s *sampler) allow(r slog.Record) bool {
+	if r.Level >= slog.LevelError {
+		return true
+	}
+	key := sampleKey{level: r.Level, message: r.Message}
+	now := r.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	s.counts.mu.Lock()
+	defer s.counts.mu.Unlock()
+	c := s.counts.m[key]
+	if c == nil || now.Sub(c.start) >= sampleInterval {
+		c = &sampleCount{start: now}
+		s.counts.m[key] = c
+	}
+	c.n++
+	return c.n <= s.first || (c.n-s.first)%s.every == 0
+}
+
+func (s *sampler) Handle(ctx context.Context, r slog.Record) error {
+	if !s.allow(r) {
+		return nil
+	}
+	return s.Handler.Handle(ctx, r)
+}
+
+func (s *sampler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampler{Handler: s.Handler.WithAttrs(attrs), first: s.first, every: s.every, counts: s.counts}
+}
+
+func (s *sampler) WithGroup(name string) slog.Handler {
+	return &sampler{Handler: s.Handler.WithGroup(name), first: s.first, every: s.every, counts: s.counts}
+}
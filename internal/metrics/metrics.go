@@ -0,0 +1,259 @@
+// Package metrics keeps counters and histograms and writes them, together
+// with values gathered at scrape time, in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are histogram bounds in seconds suited to in-memory commands and fsyncs
+var DefaultLatencyBuckets = []float64{.00001, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// Registry holds metrics and writes them in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is anything a Registry writes
+type metric interface {
+	write(g *Gatherer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric to the registry
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// CounterVec counts events per value of one label
+type CounterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter partitioned by label
+func (r *Registry) NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter of a label value
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	c.values[value]++
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(g *Gatherer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, value := range sortedKeys(c.values) {
+		g.Counter(c.name, c.help, c.values[value], c.label, value)
+	}
+}
+
+// HistogramVec tracks the distribution of observations per value of one
+// label; an empty label name gives a single unlabeled histogram
+type HistogramVec struct {
+	name, help, label string
+	buckets           []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram is the state of one series: cumulative counts are computed when written
+type histogram struct {
+	counts []uint64 // per bucket, plus +Inf at the end
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram partitioned by label, with the given upper bounds
+func (r *Registry) NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, label: label, buckets: buckets, series: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// Observe records a value for a label value
+func (h *HistogramVec) Observe(value string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.series[value]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[value] = s
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// ObserveDuration records a duration in seconds for a label value
+func (h *HistogramVec) ObserveDuration(value string, d time.Duration) {
+	h.Observe(value, d.Seconds())
+}
+
+func (h *HistogramVec) write(g *Gatherer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	g.header(h.name, h.help, "histogram")
+	for _, value := range sortedKeys(h.series) {
+		s := h.series[value]
+		var labels []string
+		if h.label != "" {
+			labels = []string{h.label, value}
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			g.sample(h.name+"_bucket", float64(cumulative), append(labels, "le", formatFloat(bound))...)
+		}
+		g.sample(h.name+"_bucket", float64(s.count), append(labels, "le", "+Inf")...)
+		g.sample(h.name+"_sum", s.sum, labels...)
+		g.sample(h.name+"_count", float64(s.count), labels...)
+	}
+}
+
+// collectFunc reports values gathered at scrape time
+type collectFunc func(g *Gatherer)
+
+func (f collectFunc) write(g *Gatherer) { f(g) }
+
+// Collect registers a function that reports values computed at each scrape,
+// such as gauges read from other subsystems
+func (r *Registry) Collect(fn func(g *Gatherer)) {
+	r.register(collectFunc(fn))
+}
+
+// WriteTo writes every metric in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	g := &Gatherer{w: bufio.NewWriter(cw), seen: make(map[string]bool)}
+	for _, m := range metrics {
+		m.write(g)
+	}
+	err := g.w.Flush()
+	return cw.n, err
+}
+
+// Handler returns an HTTP handler serving the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Gatherer writes samples during a scrape
+type Gatherer struct {
+	w    *bufio.Writer
+	seen map[string]bool // metric names whose HELP and TYPE lines were written
+}
+
+// Gauge writes a gauge sample; labels are name, value pairs
+func (g *Gatherer) Gauge(name, help string, value float64, labels ...string) {
+	g.header(name, help, "gauge")
+	g.sample(name, value, labels...)
+}
+
+// Counter writes a counter sample; labels are name, value pairs
+func (g *Gatherer) Counter(name, help string, value float64, labels ...string) {
+	g.header(name, help, "counter")
+	g.sample(name, value, labels...)
+}
+
+// header writes the HELP and TYPE lines of a metric the first time it is seen
+func (g *Gatherer) header(name, help, kind string) {
+	if g.seen[name] {
+		return
+	}
+	g.seen[name] = true
+	fmt.Fprintf(g.w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+// sample writes one line; labels are name, value pairs
+func (g *Gatherer) sample(name string, value float64, labels ...string) {
+	g.w.WriteString(name)
+	if len(labels) > 0 {
+		g.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				g.w.WriteByte(',')
+			}
+			fmt.Fprintf(g.w, `%s="%s"`, labels[i], escapeLabel(labels[i+1]))
+		}
+		g.w.WriteByte('}')
+	}
+	g.w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// formatFloat formats a sample value as Prometheus expects
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes the text of a HELP line
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes a label value
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// sortedKeys returns the keys of a map in order, so output is stable between scrapes
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
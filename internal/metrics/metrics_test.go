@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	calls := r.NewCounterVec("calls_total", "Calls", "command")
+	latency := r.NewHistogramVec("latency_seconds", "Latency", "", []float64{0.1, 1})
+	r.Collect(func(g *Gatherer) {
+		g.Gauge("temperature", "Temperature", 21.5, "room", `a "quoted"\name`)
+		g.Gauge("temperature", "Temperature", 19, "room", "b")
+	})
+
+	calls.Inc("GET")
+	calls.Inc("GET")
+	calls.Inc("SET")
+	latency.Observe("", 0.05)
+	latency.Observe("", 0.1)
+	latency.Observe("", 5)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	expected := `# HELP calls_total Calls
+# TYPE calls_total counter
+calls_total{command="GET"} 2
+calls_total{command="SET"} 1
+# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.15
+latency_seconds_count 3
+# HELP temperature Temperature
+# TYPE temperature gauge
+temperature{room="a \"quoted\"\\name"} 21.5
+temperature{room="b"} 19
+`
+	if b.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
@@ -2,29 +2,79 @@ package persistence
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// MaxLineSize is the longest line of a file read back, the most a
+// bufio.Scanner holds; encrypted records are longer than their commands
+const MaxLineSize = math.MaxInt32
+
+// MaxCommandSize is the longest command that still fits MaxLineSize once it
+// is escaped as JSON in the Raft log and encrypted
+const MaxCommandSize = 192 << 20
+
 // AOF represents the Append-Only File persistence mechanism
 type AOF struct {
+	filename  string
+	keys      *Keyring
+	codec     *Codec // how the file on disk is written; appends keep it until the next rewrite
 	file      *os.File
 	writer    *bufio.Writer
 	isLoading bool
 	mu        sync.Mutex
+
+	// Statistics, guarded by mu
+	size           int64
+	written        int64
+	rewrites       int
+	lastRewrite    time.Time
+	lastRewriteErr error
+	lastErr        error
+
+	onFsync func(time.Duration) // called with the duration of each fsync of an append
+}
+
+// Stats describes the AOF file and the writes to it
+type Stats struct {
+	Enabled bool
+	// KeyID is the ID of the key the file is encrypted with, or "" in plaintext
+	KeyID string
+	// Size is the current size of the file in bytes
+	Size int64
+	// BytesWritten counts the bytes appended since the file was opened
+	BytesWritten int64
+	// Rewrites counts rewrites since the file was opened; LastRewrite is when the latest finished
+	Rewrites    int
+	LastRewrite time.Time
+	// LastRewriteErr is the error of the latest rewrite, or nil if it succeeded
+	LastRewriteErr error
+	// LastWriteErr is the error of the latest failed write, cleared by the next successful one
+	LastWriteErr error
 }
 
 // New creates a new AOF instance
 func New(filename string) (*AOF, error) {
+	return NewWithKeyring(filename, nil)
+}
+
+// NewWithKeyring creates a new AOF instance whose file is encrypted with the
+// current key of keys. A file written with a previous key, or in plaintext,
+// is read as it is and re-encrypted by the next Rewrite; see NeedsRewrite.
+func NewWithKeyring(filename string, keys *Keyring) (*AOF, error) {
 	if filename == "" {
 		return &AOF{}, nil // AOF disabled
 	}
 
-	log.Printf("Initializing AOF persistence: %s", filename)
+	slog.Info("Initializing AOF persistence", "file", filename)
 
 	// Open file in append and create mode
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -32,31 +82,41 @@ func New(filename string) (*AOF, error) {
 		return nil, fmt.Errorf("failed to open AOF file %s for writing: %w", filename, err)
 	}
 
+	a := &AOF{
+		filename: filename,
+		keys:     keys,
+		file:     file,
+		writer:   bufio.NewWriter(file),
+	}
+
 	// Check file integrity
-	if err := checkAOFIntegrity(filename); err != nil {
+	if err := a.checkIntegrity(); err != nil {
 		file.Close()
 		return nil, fmt.Errorf("AOF file integrity check failed: %w", err)
 	}
+	if a.codec.Encrypted() {
+		slog.Info("AOF file is encrypted", "file", filename, "key", a.codec.KeyID())
+	}
+	if info, err := file.Stat(); err == nil {
+		a.size = info.Size()
+	}
 
-	return &AOF{
-		file:   file,
-		writer: bufio.NewWriter(file),
-	}, nil
+	return a, nil
 }
 
-// checkAOFIntegrity verifies the integrity of the AOF file
-func checkAOFIntegrity(filename string) error {
-	file, err := os.OpenFile(filename, os.O_RDONLY, 0644)
+// checkIntegrity verifies the integrity of the AOF file and records how it is encrypted
+func (a *AOF) checkIntegrity() error {
+	file, err := os.OpenFile(a.filename, os.O_RDONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open AOF file for integrity check: %w", err)
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	lineNumber := 0
+	scanner := NewScanner(file, a.filename, a.keys)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineSize)
 
 	for scanner.Scan() {
-		lineNumber++
+		lineNumber := scanner.Line()
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
@@ -64,8 +124,15 @@ func checkAOFIntegrity(filename string) error {
 
 		// Check command format
 		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			return fmt.Errorf("invalid command format at line %d: %s", lineNumber, line)
+		if strings.ToUpper(parts[0]) == "DB" {
+			// Commands on databases other than 0 are tagged with the database number
+			if len(parts) < 3 {
+				return fmt.Errorf("invalid command format at line %d: %s", lineNumber, line)
+			}
+			if _, err := strconv.Atoi(parts[1]); err != nil {
+				return fmt.Errorf("invalid database at line %d: %s", lineNumber, line)
+			}
+			parts = parts[2:]
 		}
 
 		cmd := strings.ToUpper(parts[0])
@@ -78,28 +145,60 @@ func checkAOFIntegrity(filename string) error {
 			if len(parts) != 2 {
 				return fmt.Errorf("invalid DELETE command at line %d: %s", lineNumber, line)
 			}
+		case "FLUSHDB", "FLUSHALL":
+			if len(parts) > 2 {
+				return fmt.Errorf("invalid %s command at line %d: %s", cmd, lineNumber, line)
+			}
+		case "SWAPDB":
+			if len(parts) != 3 {
+				return fmt.Errorf("invalid SWAPDB command at line %d: %s", lineNumber, line)
+			}
+		case "NAMESPACE":
+			if len(parts) < 3 {
+				return fmt.Errorf("invalid NAMESPACE command at line %d: %s", lineNumber, line)
+			}
+		case "XADD", "XTRIM", "XSETID", "XGROUP", "XACK", "XCLAIM":
+			if len(parts) < 3 {
+				return fmt.Errorf("invalid %s command at line %d: %s", cmd, lineNumber, line)
+			}
 		default:
 			return fmt.Errorf("unknown command at line %d: %s", lineNumber, cmd)
 		}
 	}
 
 	if err := scanner.Err(); err != nil && err != io.EOF {
+		if errors.Is(err, ErrNoKey) || errors.Is(err, ErrWrongKey) {
+			return err
+		}
 		return fmt.Errorf("error scanning AOF file: %w", err)
 	}
 
+	a.codec = scanner.Codec()
 	return nil
 }
 
+// NeedsRewrite reports whether the file is not written with the current key
+// of the keyring, so it should be rewritten to re-encrypt it. This includes
+// a new, empty file, which gets its encryption header from the rewrite.
+func (a *AOF) NeedsRewrite() bool {
+	if a.file == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.codec.KeyID() != a.keys.Codec().KeyID()
+}
+
 // Load loads data from the AOF file
 func (a *AOF) Load(filename string, handler func(command string) error) error {
 	if filename == "" {
 		return nil // AOF disabled
 	}
 
-	log.Println("Loading data from AOF file...")
+	slog.Info("Loading data from AOF file", "file", filename)
 
 	// Check file integrity before loading
-	if err := checkAOFIntegrity(filename); err != nil {
+	if err := a.checkIntegrity(); err != nil {
 		return fmt.Errorf("AOF file integrity check failed before loading: %w", err)
 	}
 
@@ -112,14 +211,14 @@ func (a *AOF) Load(filename string, handler func(command string) error) error {
 	a.isLoading = true
 	defer func() { a.isLoading = false }()
 
-	scanner := bufio.NewScanner(file)
-	lineNumber := 0
+	scanner := NewScanner(file, filename, a.keys)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineSize)
 
 	for scanner.Scan() {
-		lineNumber++
+		lineNumber := scanner.Line()
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
-			log.Printf("Skipping empty command in AOF file at line %d", lineNumber)
+			slog.Warn("Skipping empty command in AOF file", "file", filename, "line", lineNumber)
 			continue
 		}
 
@@ -132,7 +231,7 @@ func (a *AOF) Load(filename string, handler func(command string) error) error {
 		return fmt.Errorf("error reading AOF file %s: %w", filename, err)
 	}
 
-	log.Println("Finished loading data from AOF file.")
+	slog.Info("Finished loading data from AOF file", "file", filename)
 	return nil
 }
 
@@ -150,24 +249,140 @@ func (a *AOF) Write(command string) error {
 	defer a.mu.Unlock()
 
 	// Write to buffer
-	_, err := a.writer.WriteString(command + "\n")
+	n, err := a.writer.WriteString(a.codec.Encode(command) + "\n")
 	if err != nil {
-		log.Printf("ERROR writing to AOF file: %v", err)
+		slog.Error("Failed to write to AOF file", "file", a.filename, "err", err)
+		a.lastErr = err
 		return err
 	}
 
 	// Flush buffer to file
 	if err := a.writer.Flush(); err != nil {
-		log.Printf("ERROR flushing AOF file: %v", err)
+		slog.Error("Failed to flush AOF file", "file", a.filename, "err", err)
+		a.lastErr = err
 		return err
 	}
 
 	// Force sync with disk
+	start := time.Now()
 	if err := a.file.Sync(); err != nil {
-		log.Printf("ERROR syncing AOF file: %v", err)
+		slog.Error("Failed to sync AOF file", "file", a.filename, "err", err)
+		a.lastErr = err
 		return err
 	}
+	if a.onFsync != nil {
+		a.onFsync(time.Since(start))
+	}
+
+	a.size += int64(n)
+	a.written += int64(n)
+	a.lastErr = nil
+	return nil
+}
+
+// Stats returns statistics about the file
+func (a *AOF) Stats() Stats {
+	if a.file == nil {
+		return Stats{}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Stats{
+		Enabled:        true,
+		KeyID:          a.codec.KeyID(),
+		Size:           a.size,
+		BytesWritten:   a.written,
+		Rewrites:       a.rewrites,
+		LastRewrite:    a.lastRewrite,
+		LastWriteErr:   a.lastErr,
+		LastRewriteErr: a.lastRewriteErr,
+	}
+}
+
+// OnFsync registers a function called with the duration of every fsync that
+// follows an append
+func (a *AOF) OnFsync(fn func(time.Duration)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onFsync = fn
+}
+
+// Rewrite atomically replaces the contents of the AOF file with the given
+// commands. The new file is written next to the old one and renamed over it,
+// so a crash during the rewrite leaves the previous file intact. It is always
+// encrypted with the current key, which completes a key rotation.
+func (a *AOF) Rewrite(commands []string) error {
+	if a.file == nil {
+		return nil // AOF disabled
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.rewrite(commands)
+	a.lastRewriteErr = err
+	return err
+}
+
+// rewrite does the work of Rewrite with the lock held
+func (a *AOF) rewrite(commands []string) error {
+	slog.Info("Rewriting AOF file", "file", a.filename, "commands", len(commands))
+
+	tmpFilename := a.filename + ".tmp"
+	tmp, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary AOF file %s: %w", tmpFilename, err)
+	}
+
+	codec := a.keys.Codec()
+	writer := bufio.NewWriter(tmp)
+	if header := codec.Header(); header != "" {
+		writer.WriteString(header + "\n")
+	}
+	for _, command := range commands {
+		if _, err := writer.WriteString(codec.Encode(command) + "\n"); err != nil {
+			tmp.Close()
+			os.Remove(tmpFilename)
+			return fmt.Errorf("failed to write temporary AOF file: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpFilename)
+		return fmt.Errorf("failed to flush temporary AOF file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpFilename)
+		return fmt.Errorf("failed to sync temporary AOF file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("failed to close temporary AOF file: %w", err)
+	}
+
+	if err := os.Rename(tmpFilename, a.filename); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("failed to replace AOF file: %w", err)
+	}
+
+	// Reopen the new file for appending; the old descriptor points at the replaced inode
+	file, err := os.OpenFile(a.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen AOF file %s: %w", a.filename, err)
+	}
+	a.file.Close()
+	a.file = file
+	a.writer = bufio.NewWriter(file)
+	a.codec = codec
+	if info, err := file.Stat(); err == nil {
+		a.size = info.Size()
+	}
+	a.rewrites++
+	a.lastRewrite = time.Now()
 
+	slog.Info("AOF rewrite complete", "file", a.filename, "size", a.size)
 	return nil
 }
 
@@ -180,7 +395,7 @@ func (a *AOF) Close() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	log.Printf("Closing AOF file...")
+	slog.Debug("Closing AOF file", "file", a.filename)
 	if err := a.writer.Flush(); err != nil {
 		return fmt.Errorf("failed to flush AOF file: %w", err)
 	}
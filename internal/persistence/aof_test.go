@@ -0,0 +1,211 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	oldKey = "0000000000000000000000000000000000000000000000000000000000000001"
+	newKey = "0000000000000000000000000000000000000000000000000000000000000002"
+)
+
+func mustKeyring(t *testing.T, text string) *Keyring {
+	keys, err := ParseKeyring(text)
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+	return keys
+}
+
+// openAOF opens filename with keys and rewrites it if it is not written with the current key
+func openAOF(t *testing.T, filename string, keys *Keyring, commands []string) (*AOF, error) {
+	aof, err := NewWithKeyring(filename, keys)
+	if err != nil {
+		return nil, err
+	}
+	if aof.NeedsRewrite() {
+		if err := aof.Rewrite(commands); err != nil {
+			t.Fatalf("Rewrite failed: %v", err)
+		}
+	}
+	return aof, nil
+}
+
+// loadAOF returns the commands in filename
+func loadAOF(t *testing.T, filename string, keys *Keyring) []string {
+	aof, err := NewWithKeyring(filename, keys)
+	if err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+	defer aof.Close()
+
+	var commands []string
+	if err := aof.Load(filename, func(command string) error {
+		commands = append(commands, command)
+		return nil
+	}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return commands
+}
+
+func TestEncryptedAOF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "aof.log")
+	keys := mustKeyring(t, oldKey)
+
+	aof, err := openAOF(t, filename, keys, nil)
+	if err != nil {
+		t.Fatalf("Failed to create AOF: %v", err)
+	}
+	aof.Write("SET token secret-session")
+	aof.Write("DELETE other")
+	aof.Close()
+
+	data, _ := os.ReadFile(filename)
+	if strings.Contains(string(data), "secret-session") {
+		t.Error("Expected the AOF file not to contain plaintext values")
+	}
+	got := loadAOF(t, filename, keys)
+	if strings.Join(got, "|") != "SET token secret-session|DELETE other" {
+		t.Errorf("Expected the written commands back, got %v", got)
+	}
+
+	if _, err := NewWithKeyring(filename, nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey without a key, got %v", err)
+	}
+	if _, err := NewWithKeyring(filename, mustKeyring(t, newKey)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey with another key, got %v", err)
+	}
+
+	// Flipping a byte of a record fails authentication
+	lines := strings.Split(string(data), "\n")
+	record := []byte(lines[1])
+	record[len(record)/2] ^= 'A' ^ 'B'
+	lines[1] = string(record)
+	os.WriteFile(filename, []byte(strings.Join(lines, "\n")), 0644)
+	if _, err := NewWithKeyring(filename, keys); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for a tampered record, got %v", err)
+	}
+}
+
+func TestEncryptedRecordOrder(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "aof.log")
+	keys := mustKeyring(t, oldKey)
+
+	aof, err := openAOF(t, filename, keys, []string{"SET a 1", "SET b 2"})
+	if err != nil {
+		t.Fatalf("Failed to create AOF: %v", err)
+	}
+	aof.Close()
+	// Appends after reopening continue the file
+	aof, err = openAOF(t, filename, keys, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen AOF: %v", err)
+	}
+	aof.Write("SET c 3")
+	aof.Close()
+	if got := loadAOF(t, filename, keys); strings.Join(got, "|") != "SET a 1|SET b 2|SET c 3" {
+		t.Fatalf("Expected every command back, got %v", got)
+	}
+
+	data, _ := os.ReadFile(filename)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") // header and three records
+
+	other := filepath.Join(dir, "other.log")
+	otherAOF, err := openAOF(t, other, keys, []string{"SET a 1"})
+	if err != nil {
+		t.Fatalf("Failed to create AOF: %v", err)
+	}
+	otherAOF.Close()
+	otherData, _ := os.ReadFile(other)
+	otherLines := strings.Split(strings.TrimSuffix(string(otherData), "\n"), "\n")
+
+	tampered := map[string][]string{
+		"dropped record":           {lines[0], lines[1], lines[3]},
+		"swapped records":          {lines[0], lines[2], lines[1], lines[3]},
+		"record from another file": {lines[0], otherLines[1], lines[2], lines[3]},
+		"header from another file": {otherLines[0], lines[1], lines[2], lines[3]},
+	}
+	for name, tamperedLines := range tampered {
+		os.WriteFile(filename, []byte(strings.Join(tamperedLines, "\n")+"\n"), 0644)
+		if _, err := NewWithKeyring(filename, keys); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt with %s, got %v", name, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "aof.log")
+
+	// A plaintext file is encrypted by the rewrite on opening
+	os.WriteFile(filename, []byte("SET a 1\n"), 0644)
+	aof, err := openAOF(t, filename, mustKeyring(t, oldKey), []string{"SET a 1"})
+	if err != nil {
+		t.Fatalf("Failed to open plaintext AOF with a key: %v", err)
+	}
+	aof.Write("SET b 2")
+	aof.Close()
+
+	// With the new key in front, the file is read with the old one and re-encrypted
+	rotated := mustKeyring(t, newKey+"\n"+oldKey)
+	aof, err = openAOF(t, filename, rotated, []string{"SET a 1", "SET b 2"})
+	if err != nil {
+		t.Fatalf("Failed to open AOF during rotation: %v", err)
+	}
+	aof.Write("SET c 3")
+	aof.Close()
+
+	if _, err := NewWithKeyring(filename, mustKeyring(t, oldKey)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected the old key to be retired after rotation, got %v", err)
+	}
+	got := loadAOF(t, filename, mustKeyring(t, newKey))
+	if strings.Join(got, "|") != "SET a 1|SET b 2|SET c 3" {
+		t.Errorf("Expected every command after rotation, got %v", got)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	keys, err := ParseKeyring("# rotated 2026-10\n" + newKey + "\n" + oldKey + "\n")
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	if ids := keys.IDs(); len(ids) != 2 || keys.Codec().KeyID() != ids[0] {
+		t.Errorf("Expected two keys with the first one current, got %v", ids)
+	}
+	// The same key in base64
+	if keys, err := ParseKeyring("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAI="); err != nil || keys.IDs()[0] != mustKeyring(t, newKey).IDs()[0] {
+		t.Errorf("Expected a base64 key to match its hex form, got %v", err)
+	}
+	for _, text := range []string{"", "tooshort", oldKey + "00"} {
+		if _, err := ParseKeyring(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestLargeRecords(t *testing.T) {
+	// Both well past the 64KB default of bufio.Scanner, the encrypted record even more so
+	command := "SET big " + strings.Repeat("v", 1<<20)
+	for _, keys := range []*Keyring{nil, mustKeyring(t, oldKey)} {
+		filename := filepath.Join(t.TempDir(), "aof.log")
+		aof, err := openAOF(t, filename, keys, nil)
+		if err != nil {
+			t.Fatalf("Failed to create AOF: %v", err)
+		}
+		if err := aof.Write(command); err != nil {
+			t.Fatalf("Failed to write a large record: %v", err)
+		}
+		aof.Write("DELETE other")
+		aof.Close()
+
+		got := loadAOF(t, filename, keys)
+		if len(got) != 2 || got[0] != command || got[1] != "DELETE other" {
+			t.Errorf("Expected the large record back (encrypted %v), got %d commands", keys != nil, len(got))
+		}
+	}
+}
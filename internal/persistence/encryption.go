@@ -0,0 +1,356 @@
+package persistence
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// KeySize is the length of an encryption key in bytes (AES-256)
+const KeySize = 32
+
+// headerMagic starts the first line of an encrypted file of any version
+const headerMagic = "#CACHEFLOW-ENCRYPTED "
+
+// headerPrefix starts the first line of an encrypted file, followed by the ID
+// of its key and the random ID of the file
+const headerPrefix = headerMagic + "v2 aes-256-gcm "
+
+// fileIDSize is the length of the random ID of an encrypted file in bytes
+const fileIDSize = 16
+
+var (
+	// ErrNoKey is returned when an encrypted file is opened without any key configured
+	ErrNoKey = errors.New("file is encrypted but no encryption key is configured")
+	// ErrWrongKey is returned when an encrypted file's key is not among the configured keys
+	ErrWrongKey = errors.New("file is encrypted with a key that is not configured")
+	// ErrCorrupt is returned when a record fails to decrypt or authenticate
+	ErrCorrupt = errors.New("encrypted record is corrupted or was tampered with")
+)
+
+// key is a single AES-256-GCM key
+type key struct {
+	id   string // first 4 bytes of the SHA-256 of the key, in hex
+	aead cipher.AEAD
+}
+
+// Keyring holds the keys of encrypted persistence files. The first key
+// encrypts everything written; the others are previous keys that are still
+// accepted when reading, so keys can be rotated by putting a new one in
+// front. A nil Keyring leaves files in plaintext.
+type Keyring struct {
+	keys []*key
+}
+
+// ParseKeyring parses keys separated by newlines, commas or spaces, each 32
+// bytes in hex or base64. Lines starting with # are comments.
+func ParseKeyring(text string) (*Keyring, error) {
+	var fields []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields = append(fields, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})...)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no encryption key found")
+	}
+
+	k := &Keyring{}
+	for i, field := range fields {
+		raw, err := decodeKey(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %w", i+1, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		k.keys = append(k.keys, &key{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+	return k, nil
+}
+
+// decodeKey decodes a key written in hex or base64
+func decodeKey(s string) ([]byte, error) {
+	if raw, err := hex.DecodeString(s); err == nil && len(raw) == KeySize {
+		return raw, nil
+	}
+	if raw, err := base64.StdEncoding.DecodeString(s); err == nil && len(raw) == KeySize {
+		return raw, nil
+	}
+	return nil, fmt.Errorf("expected %d bytes in hex or base64", KeySize)
+}
+
+// LoadKeyring reads a keyring from a file in the format of ParseKeyring
+func LoadKeyring(filename string) (*Keyring, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		slog.Warn("Key file is accessible by other users", "file", filename, "mode", info.Mode().Perm())
+	}
+	text, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	k, err := ParseKeyring(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return k, nil
+}
+
+// IDs returns the IDs of the keys, current key first
+func (k *Keyring) IDs() []string {
+	if k == nil {
+		return nil
+	}
+	ids := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		ids[i] = key.id
+	}
+	return ids
+}
+
+// Codec returns the codec that writes a new file, using the current key
+func (k *Keyring) Codec() *Codec {
+	if k == nil {
+		return &Codec{}
+	}
+	return &Codec{key: k.keys[0], file: newFileID()}
+}
+
+// newFileID returns a random ID for a new encrypted file
+func newFileID() []byte {
+	id := make([]byte, fileIDSize)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("persistence: failed to generate file ID: %v", err))
+	}
+	return id
+}
+
+// codecFor returns the codec of a file from its first line. header reports
+// whether that line is the encryption header rather than content.
+func (k *Keyring) codecFor(name, firstLine string) (codec *Codec, header bool, err error) {
+	if !strings.HasPrefix(firstLine, headerMagic) {
+		return &Codec{}, false, nil
+	}
+	rest, ok := strings.CutPrefix(firstLine, headerPrefix)
+	fields := strings.Fields(rest)
+	if !ok || len(fields) != 2 {
+		return nil, true, fmt.Errorf("%s: unsupported encryption header %q", name, strings.TrimSpace(firstLine))
+	}
+	id := fields[0]
+	file, err := hex.DecodeString(fields[1])
+	if err != nil || len(file) != fileIDSize {
+		return nil, true, fmt.Errorf("%s: invalid file ID in encryption header: %w", name, ErrCorrupt)
+	}
+	if k == nil {
+		return nil, true, fmt.Errorf("%s: %w (key %s)", name, ErrNoKey, id)
+	}
+	for _, key := range k.keys {
+		if key.id == id {
+			return &Codec{key: key, file: file}, true, nil
+		}
+	}
+	return nil, true, fmt.Errorf("%s: %w: it needs key %s, the configured keys are %s",
+		name, ErrWrongKey, id, strings.Join(k.IDs(), ", "))
+}
+
+// Codec converts the lines of a file to the form stored on disk: unchanged
+// for plaintext files, or one base64 AES-GCM record per line after a header
+// naming the key and a random ID of the file. Each record is authenticated
+// together with the file ID and its position, so records cannot be dropped,
+// reordered or moved between files without failing to decrypt. An encrypting
+// codec tracks the position, so it belongs to a single file and is not safe
+// for concurrent use.
+type Codec struct {
+	key     *key   // nil for plaintext
+	file    []byte // random ID of the file
+	records uint64 // records encoded or decoded so far
+}
+
+// Encrypted reports whether the codec encrypts
+func (c *Codec) Encrypted() bool {
+	return c.key != nil
+}
+
+// KeyID returns the ID of the codec's key, or "" for plaintext
+func (c *Codec) KeyID() string {
+	if c.key == nil {
+		return ""
+	}
+	return c.key.id
+}
+
+// Header returns the first line of a file written with the codec, without
+// the newline, or "" if plaintext files have none
+func (c *Codec) Header() string {
+	if c.key == nil {
+		return ""
+	}
+	return headerPrefix + c.key.id + " " + hex.EncodeToString(c.file)
+}
+
+// additionalData binds the next record to the file and its position in it
+func (c *Codec) additionalData() []byte {
+	ad := make([]byte, len(c.file)+8)
+	copy(ad, c.file)
+	binary.BigEndian.PutUint64(ad[len(c.file):], c.records)
+	return ad
+}
+
+// Encode returns a line as stored on disk after the lines encoded before it
+func (c *Codec) Encode(line string) string {
+	if c.key == nil {
+		return line
+	}
+	nonce := make([]byte, c.key.aead.NonceSize(), c.key.aead.NonceSize()+len(line)+c.key.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("persistence: failed to generate nonce: %v", err))
+	}
+	sealed := c.key.aead.Seal(nonce, nonce, []byte(line), c.additionalData())
+	c.records++
+	return base64.StdEncoding.EncodeToString(sealed)
+}
+
+// Decode returns the original line of one stored on disk after the lines
+// decoded before it
+func (c *Codec) Decode(stored string) (string, error) {
+	if c.key == nil {
+		return stored, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil || len(data) < c.key.aead.NonceSize() {
+		return "", ErrCorrupt
+	}
+	nonceSize := c.key.aead.NonceSize()
+	plain, err := c.key.aead.Open(nil, data[:nonceSize], data[nonceSize:], c.additionalData())
+	if err != nil {
+		return "", ErrCorrupt
+	}
+	c.records++
+	return string(plain), nil
+}
+
+// EncodeFile returns the contents of a new file holding the given lines.
+// Later calls to Encode append to that file.
+func (c *Codec) EncodeFile(lines []string) []byte {
+	if c.key != nil {
+		c.file = newFileID()
+		c.records = 0
+	}
+	var b strings.Builder
+	if header := c.Header(); header != "" {
+		b.WriteString(header + "\n")
+	}
+	for _, line := range lines {
+		b.WriteString(c.Encode(line) + "\n")
+	}
+	return []byte(b.String())
+}
+
+// Scanner reads the lines of a file written by a Codec, detecting from its
+// first line whether the file is encrypted and with which key
+type Scanner struct {
+	scanner *bufio.Scanner
+	name    string
+	keys    *Keyring
+	codec   *Codec
+	line    int
+	text    string
+	err     error
+}
+
+// NewScanner returns a scanner over r, a file called name, that decrypts with keys
+func NewScanner(r io.Reader, name string, keys *Keyring) *Scanner {
+	return &Scanner{scanner: bufio.NewScanner(r), name: name, keys: keys}
+}
+
+// Buffer sets the buffer of the underlying bufio.Scanner
+func (s *Scanner) Buffer(buf []byte, max int) {
+	s.scanner.Buffer(buf, max)
+}
+
+// Scan advances to the next line, returning false at the end of the file or
+// on an error. A record that fails to decrypt stops the scan with an error
+// wrapping ErrCorrupt.
+func (s *Scanner) Scan() bool {
+	if s.err != nil || !s.scanner.Scan() {
+		return false
+	}
+	s.line++
+	stored := s.scanner.Text()
+
+	if s.codec == nil {
+		codec, header, err := s.keys.codecFor(s.name, stored)
+		if err != nil {
+			s.err = err
+			return false
+		}
+		s.codec = codec
+		if header {
+			return s.Scan()
+		}
+	}
+
+	if strings.TrimSpace(stored) == "" {
+		s.text = ""
+		return true
+	}
+	text, err := s.codec.Decode(strings.TrimSpace(stored))
+	if err != nil {
+		s.err = fmt.Errorf("%s line %d: %w", s.name, s.line, err)
+		return false
+	}
+	s.text = text
+	return true
+}
+
+// Text returns the current line, decrypted
+func (s *Scanner) Text() string {
+	return s.text
+}
+
+// Line returns the line number of the current line in the file
+func (s *Scanner) Line() int {
+	return s.line
+}
+
+// Err returns the first error of the scan
+func (s *Scanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.scanner.Err()
+}
+
+// Codec returns the codec the file was written with, which is plaintext for
+// an empty file. It is only known once Scan has been called; after the last
+// line, encoding with it appends to the file.
+func (s *Scanner) Codec() *Codec {
+	if s.codec == nil {
+		return &Codec{}
+	}
+	return s.codec
+}
@@ -0,0 +1,140 @@
+// Package protocol defines the reply conventions of the line protocol shared
+// by the server, the sentinel and the client: error replies carrying a
+// machine-readable code, and the escaping that keeps stored values from being
+// mistaken for them.
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorPrefix starts every error reply
+const ErrorPrefix = "ERROR:"
+
+// Nil is the reply for a missing key
+const Nil = "NIL"
+
+// Code identifies the kind of an error reply. It is the first word after ErrorPrefix.
+type Code string
+
+const (
+	// CodeErr is a generic error without a more specific code
+	CodeErr Code = "ERR"
+	// CodeSyntax is a malformed command or wrong number of arguments
+	CodeSyntax Code = "SYNTAX"
+	// CodeUnknownCommand is a command the server does not implement
+	CodeUnknownCommand Code = "UNKNOWN"
+	// CodeWrongType is an operation against a key holding the wrong kind of value
+	CodeWrongType Code = "WRONGTYPE"
+	// CodeNoAuth is a command sent before authenticating
+	CodeNoAuth Code = "NOAUTH"
+	// CodeWrongPass is a failed AUTH
+	CodeWrongPass Code = "WRONGPASS"
+	// CodeNoPerm is a command or key the authenticated user may not use
+	CodeNoPerm Code = "NOPERM"
+	// CodeOOM is a write refused because the memory limit is reached
+	CodeOOM Code = "OOM"
+	// CodeReadOnly is a write sent to a replica
+	CodeReadOnly Code = "READONLY"
+	// CodeMoved redirects a key to the node that owns its slot
+	CodeMoved Code = "MOVED"
+	// CodeAsk redirects a single command to the node importing a slot
+	CodeAsk Code = "ASK"
+	// CodeClusterDown is a key in a slot no node serves
+	CodeClusterDown Code = "CLUSTERDOWN"
+	// CodeNotLeader is a write sent to a Raft follower
+	CodeNotLeader Code = "NOTLEADER"
+	// CodeIOErr is a failure talking to another node
+	CodeIOErr Code = "IOERR"
+	// CodeNoScript is an unknown script SHA
+	CodeNoScript Code = "NOSCRIPT"
+	// CodeBusy is an operation conflicting with one already in progress
+	CodeBusy Code = "BUSY"
+	// CodeNoGroup is an unknown stream consumer group
+	CodeNoGroup Code = "NOGROUP"
+)
+
+// codes is the table of known codes with a description of each
+var codes = map[Code]string{
+	CodeErr:            "generic error",
+	CodeSyntax:         "malformed command or wrong number of arguments",
+	CodeUnknownCommand: "unknown command",
+	CodeWrongType:      "operation against a key holding the wrong kind of value",
+	CodeNoAuth:         "authentication required",
+	CodeWrongPass:      "invalid username-password pair",
+	CodeNoPerm:         "permission denied",
+	CodeOOM:            "memory limit reached",
+	CodeReadOnly:       "write against a read only replica",
+	CodeMoved:          "key served by another node",
+	CodeAsk:            "key being imported by another node",
+	CodeClusterDown:    "hash slot not served",
+	CodeNotLeader:      "not the raft leader",
+	CodeIOErr:          "failure talking to another node",
+	CodeNoScript:       "no matching script",
+	CodeBusy:           "operation already in progress",
+	CodeNoGroup:        "no such key or consumer group",
+}
+
+// Known reports whether code is in the table
+func Known(code Code) bool {
+	_, ok := codes[code]
+	return ok
+}
+
+// Description returns what a known code means, or "" for an unknown one
+func Description(code Code) string {
+	return codes[code]
+}
+
+// Error formats an error reply: "ERROR: <code> <message>"
+func Error(code Code, message string) string {
+	if message == "" {
+		return ErrorPrefix + " " + string(code)
+	}
+	return ErrorPrefix + " " + string(code) + " " + message
+}
+
+// Errorf formats an error reply with a formatted message
+func Errorf(code Code, format string, args ...any) string {
+	return Error(code, fmt.Sprintf(format, args...))
+}
+
+// IsError reports whether a reply is an error reply
+func IsError(reply string) bool {
+	return strings.HasPrefix(reply, ErrorPrefix)
+}
+
+// ParseError splits an error reply into its code and message. Replies from
+// servers predating codes have no known code and parse as CodeErr with the
+// whole text as message. ok is false if reply is not an error reply.
+func ParseError(reply string) (code Code, message string, ok bool) {
+	text, ok := strings.CutPrefix(reply, ErrorPrefix)
+	if !ok {
+		return "", "", false
+	}
+	text = strings.TrimSpace(text)
+	word, rest, _ := strings.Cut(text, " ")
+	if Known(Code(word)) {
+		return Code(word), rest, true
+	}
+	return CodeErr, text, true
+}
+
+// escape starts escaped values
+const escape = `\`
+
+// EscapeValue prepares a stored value for a reply. Values that would read as
+// an error reply or as NIL, and values starting with the escape character
+// itself, get a leading backslash.
+func EscapeValue(value string) string {
+	if value == Nil || strings.HasPrefix(value, ErrorPrefix) || strings.HasPrefix(value, escape) {
+		return escape + value
+	}
+	return value
+}
+
+// UnescapeValue reverses EscapeValue on a value reply
+func UnescapeValue(reply string) string {
+	return strings.TrimPrefix(reply, escape)
+}
@@ -0,0 +1,43 @@
+package protocol
+
+import "testing"
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		reply   string
+		code    Code
+		message string
+		ok      bool
+	}{
+		{"ERROR: WRONGTYPE Operation against a key holding the wrong kind of value", CodeWrongType, "Operation against a key holding the wrong kind of value", true},
+		{"ERROR: MOVED 12182 127.0.0.1:7002", CodeMoved, "12182 127.0.0.1:7002", true},
+		{"ERROR: NOAUTH", CodeNoAuth, "", true},
+		{"ERROR: Unknown command", CodeErr, "Unknown command", true},
+		{"OK", "", "", false},
+	}
+	for _, tt := range tests {
+		code, message, ok := ParseError(tt.reply)
+		if code != tt.code || message != tt.message || ok != tt.ok {
+			t.Errorf("ParseError(%q): expected (%s, %q, %v), got (%s, %q, %v)", tt.reply, tt.code, tt.message, tt.ok, code, message, ok)
+		}
+	}
+
+	if reply := Errorf(CodeOOM, "limit %d reached", 10); reply != "ERROR: OOM limit 10 reached" {
+		t.Errorf("Expected formatted OOM reply, got %q", reply)
+	}
+}
+
+func TestEscapeValue(t *testing.T) {
+	for _, value := range []string{"plain", "NIL", "ERROR: SYNTAX x", `\`, `\\x`, "NILS", ""} {
+		escaped := EscapeValue(value)
+		if IsError(escaped) || escaped == Nil {
+			t.Errorf("Expected %q to be escaped, got %q", value, escaped)
+		}
+		if got := UnescapeValue(escaped); got != value {
+			t.Errorf("Expected %q after a round trip, got %q", value, got)
+		}
+	}
+	if escaped := EscapeValue("plain"); escaped != "plain" {
+		t.Errorf("Expected ordinary values unchanged, got %q", escaped)
+	}
+}
@@ -0,0 +1,1056 @@
+// Package raft implements the Raft consensus algorithm for replicating a
+// CacheFlow store across a group of nodes. Commands are strings in the AOF
+// format; they are applied to a StateMachine once a majority has stored them.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"sort"
+	"sync"
+	"time"
+
+	"CacheFlow/internal/persistence"
+)
+
+// StateMachine is the replicated application state, normally a store.Store
+type StateMachine interface {
+	// Apply executes a committed command
+	Apply(command string) error
+	// Snapshot returns the current state as a list of commands
+	Snapshot() []string
+	// Restore replaces the current state with a snapshot
+	Restore(commands []string) error
+}
+
+// State is the role a node currently plays
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+// String returns the lower-case name of the state
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "unknown"
+	}
+}
+
+// EntryType distinguishes state machine commands from Raft's own entries
+type EntryType int
+
+const (
+	// EntryCommand carries a command for the state machine
+	EntryCommand EntryType = iota
+	// EntryConfig carries a new group membership, effective as soon as it is appended
+	EntryConfig
+	// EntryNoop is appended by a new leader to commit entries from earlier terms
+	EntryNoop
+)
+
+// Entry is a single record in the replicated log
+type Entry struct {
+	Index   uint64            `json:"index"`
+	Term    uint64            `json:"term"`
+	Type    EntryType         `json:"type"`
+	Command string            `json:"command,omitempty"`
+	Members map[string]string `json:"members,omitempty"`
+}
+
+// Errors returned by Propose and the membership methods
+var (
+	ErrTimeout         = errors.New("raft: timed out waiting for commit")
+	ErrLostLeadership  = errors.New("raft: leadership lost before the entry was committed")
+	ErrConfigInFlight  = errors.New("raft: a membership change is already in progress")
+	ErrStopped         = errors.New("raft: node stopped")
+	ErrUnknownMember   = errors.New("raft: no such member")
+	ErrDuplicateMember = errors.New("raft: member already exists")
+)
+
+// NotLeaderError is returned when a request that requires the leader reaches
+// another node. LeaderClientAddr is empty if no leader is known.
+type NotLeaderError struct {
+	LeaderID         string
+	LeaderClientAddr string
+}
+
+// Error implements error
+func (e *NotLeaderError) Error() string {
+	if e.LeaderClientAddr == "" {
+		return "raft: not the leader, leader unknown"
+	}
+	return fmt.Sprintf("raft: not the leader, leader is %s at %s", e.LeaderID, e.LeaderClientAddr)
+}
+
+// Config holds the settings of a Raft node
+type Config struct {
+	// ID uniquely identifies the node within the group
+	ID string
+	// Addr is the address the Raft RPC listener binds to and peers dial
+	Addr string
+	// ClientAddr is the address clients use for this node, passed to followers for redirects
+	ClientAddr string
+	// Peers is the initial membership (ID to Raft address) including this node.
+	// It is only used when the node has no persisted state; leave it empty for a
+	// node that will be added to an existing group with AddMember.
+	Peers map[string]string
+	// Dir is where the log, vote and snapshots are persisted; empty keeps them in memory
+	Dir string
+	// Keys encrypts the files in Dir; nil leaves them in plaintext
+	Keys *persistence.Keyring
+	// SnapshotThreshold is the number of applied entries after which the log is compacted
+	SnapshotThreshold uint64
+	// HeartbeatInterval is how often the leader contacts followers
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is the minimum time without a leader before a follower campaigns
+	ElectionTimeout time.Duration
+	// CommitTimeout bounds how long Propose waits for an entry to be applied
+	CommitTimeout time.Duration
+}
+
+// withDefaults fills in zero durations and thresholds
+func (c Config) withDefaults() Config {
+	if c.SnapshotThreshold == 0 {
+		c.SnapshotThreshold = 1024
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if c.ElectionTimeout == 0 {
+		c.ElectionTimeout = 300 * time.Millisecond
+	}
+	if c.CommitTimeout == 0 {
+		c.CommitTimeout = 5 * time.Second
+	}
+	return c
+}
+
+// Status is a point-in-time view of a node
+type Status struct {
+	ID               string
+	State            State
+	Term             uint64
+	LeaderID         string
+	LeaderClientAddr string
+	CommitIndex      uint64
+	LastApplied      uint64
+	LastLogIndex     uint64
+	SnapshotIndex    uint64
+	Members          map[string]string
+}
+
+// waiter is notified when the entry a proposer is waiting on has been applied
+type waiter struct {
+	term uint64
+	ch   chan error
+}
+
+// Node is a single member of a Raft group
+type Node struct {
+	cfg       Config
+	sm        StateMachine
+	storage   *storage
+	transport *transport
+	listener  net.Listener
+
+	// applyMu serializes access to the state machine between the apply loop and snapshot installation
+	applyMu sync.Mutex
+
+	mu               sync.Mutex
+	applyCond        *sync.Cond
+	state            State
+	currentTerm      uint64
+	votedFor         string
+	log              []Entry // log[0] is a sentinel holding the snapshot index and term
+	snapMembers      map[string]string
+	lastSnapshot     *snapshot
+	members          map[string]string
+	commitIndex      uint64
+	lastApplied      uint64
+	leaderID         string
+	leaderClientAddr string
+	electionDeadline time.Time
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	replicators      map[string]chan struct{}
+	waiters          map[uint64]waiter
+	stopped          bool
+	done             chan struct{}
+}
+
+// New creates a node, loading any persisted state from cfg.Dir and restoring
+// the latest snapshot into sm. Call Start to join the group.
+func New(cfg Config, sm StateMachine) (*Node, error) {
+	cfg = cfg.withDefaults()
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("raft: node ID is required")
+	}
+
+	st, hs, snap, entries, err := openStorage(cfg.Dir, cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		cfg:         cfg,
+		sm:          sm,
+		storage:     st,
+		transport:   newTransport(),
+		state:       Follower,
+		currentTerm: hs.Term,
+		votedFor:    hs.VotedFor,
+		log:         []Entry{{}},
+		snapMembers: map[string]string{},
+		replicators: make(map[string]chan struct{}),
+		waiters:     make(map[uint64]waiter),
+		done:        make(chan struct{}),
+	}
+	n.applyCond = sync.NewCond(&n.mu)
+
+	if snap != nil {
+		if err := sm.Restore(snap.Data); err != nil {
+			st.close()
+			return nil, fmt.Errorf("raft: failed to restore snapshot: %w", err)
+		}
+		n.log[0] = Entry{Index: snap.Index, Term: snap.Term}
+		n.snapMembers = snap.Members
+		n.lastSnapshot = snap
+		n.commitIndex = snap.Index
+		n.lastApplied = snap.Index
+	}
+	for _, entry := range entries {
+		if entry.Index > n.lastIndex() {
+			n.log = append(n.log, entry)
+		}
+	}
+
+	// Bootstrap a brand-new group with an initial membership entry that every founding node writes identically
+	if snap == nil && len(entries) == 0 && len(cfg.Peers) > 0 {
+		bootstrap := Entry{Index: 1, Term: 0, Type: EntryConfig, Members: copyMembers(cfg.Peers)}
+		if err := st.appendEntries([]Entry{bootstrap}); err != nil {
+			st.close()
+			return nil, fmt.Errorf("raft: failed to persist bootstrap configuration: %w", err)
+		}
+		n.log = append(n.log, bootstrap)
+	}
+	n.updateMembers()
+
+	return n, nil
+}
+
+// Start binds the RPC listener and begins participating in the group
+func (n *Node) Start() error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Raft", &rpcService{node: n}); err != nil {
+		return fmt.Errorf("raft: failed to register RPC service: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", n.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("raft: failed to listen on %s: %w", n.cfg.Addr, err)
+	}
+
+	n.mu.Lock()
+	n.listener = listener
+	n.resetElectionDeadline()
+	n.mu.Unlock()
+
+	log.Printf("Raft node %s listening on %s (term %d, %d log entries)", n.cfg.ID, listener.Addr(), n.currentTerm, len(n.log)-1)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn)
+		}
+	}()
+	go n.ticker()
+	go n.applyLoop()
+	return nil
+}
+
+// Addr returns the address of the RPC listener, or nil before Start
+func (n *Node) Addr() net.Addr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listener == nil {
+		return nil
+	}
+	return n.listener.Addr()
+}
+
+// Stop leaves the group: it closes the listener and all peer connections and
+// fails pending proposals
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.done)
+	for index, w := range n.waiters {
+		w.ch <- ErrStopped
+		delete(n.waiters, index)
+	}
+	n.applyCond.Broadcast()
+	listener := n.listener
+	n.mu.Unlock()
+
+	if listener != nil {
+		listener.Close()
+	}
+	n.transport.close()
+
+	n.applyMu.Lock()
+	n.mu.Lock()
+	n.storage.close()
+	n.mu.Unlock()
+	n.applyMu.Unlock()
+}
+
+// Status returns a snapshot of the node's state
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:               n.cfg.ID,
+		State:            n.state,
+		Term:             n.currentTerm,
+		LeaderID:         n.leaderID,
+		LeaderClientAddr: n.leaderClientAddr,
+		CommitIndex:      n.commitIndex,
+		LastApplied:      n.lastApplied,
+		LastLogIndex:     n.lastIndex(),
+		SnapshotIndex:    n.log[0].Index,
+		Members:          copyMembers(n.members),
+	}
+}
+
+// Propose replicates a command and returns once it has been committed and
+// applied to the local state machine. Only the leader accepts proposals.
+func (n *Node) Propose(command string) error {
+	return n.propose(Entry{Type: EntryCommand, Command: command})
+}
+
+// AddMember adds a node to the group. The new node should be started with no
+// peers; it receives the log (or a snapshot) from the leader.
+func (n *Node) AddMember(id, addr string) error {
+	return n.changeMembers(func(members map[string]string) error {
+		if _, ok := members[id]; ok {
+			return ErrDuplicateMember
+		}
+		members[id] = addr
+		return nil
+	})
+}
+
+// RemoveMember removes a node from the group
+func (n *Node) RemoveMember(id string) error {
+	return n.changeMembers(func(members map[string]string) error {
+		if _, ok := members[id]; !ok {
+			return ErrUnknownMember
+		}
+		delete(members, id)
+		return nil
+	})
+}
+
+// changeMembers proposes a configuration entry derived from the current
+// membership. Only one change may be in flight at a time, which keeps any two
+// consecutive configurations' majorities overlapping.
+func (n *Node) changeMembers(change func(members map[string]string) error) error {
+	n.mu.Lock()
+	if n.state != Leader {
+		err := n.notLeader()
+		n.mu.Unlock()
+		return err
+	}
+	for _, entry := range n.log[1:] {
+		if entry.Type == EntryConfig && entry.Index > n.commitIndex {
+			n.mu.Unlock()
+			return ErrConfigInFlight
+		}
+	}
+	members := copyMembers(n.members)
+	if err := change(members); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	n.mu.Unlock()
+
+	return n.propose(Entry{Type: EntryConfig, Members: members})
+}
+
+// propose appends an entry on the leader and waits for it to be applied
+func (n *Node) propose(entry Entry) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return ErrStopped
+	}
+	if n.state != Leader {
+		err := n.notLeader()
+		n.mu.Unlock()
+		return err
+	}
+	entry, err := n.appendLocal(entry)
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	ch := make(chan error, 1)
+	n.waiters[entry.Index] = waiter{term: entry.Term, ch: ch}
+	n.triggerReplication()
+	n.mu.Unlock()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(n.cfg.CommitTimeout):
+		n.mu.Lock()
+		delete(n.waiters, entry.Index)
+		n.mu.Unlock()
+		return ErrTimeout
+	}
+}
+
+// notLeader builds the error returned by non-leaders. The caller must hold n.mu.
+func (n *Node) notLeader() error {
+	return &NotLeaderError{LeaderID: n.leaderID, LeaderClientAddr: n.leaderClientAddr}
+}
+
+// appendLocal assigns the next index and current term to an entry, persists
+// it and appends it to the leader's log. The caller must hold n.mu.
+func (n *Node) appendLocal(entry Entry) (Entry, error) {
+	entry.Index = n.lastIndex() + 1
+	entry.Term = n.currentTerm
+	if err := n.storage.appendEntries([]Entry{entry}); err != nil {
+		return entry, fmt.Errorf("raft: failed to persist entry: %w", err)
+	}
+	n.log = append(n.log, entry)
+	if entry.Type == EntryConfig {
+		n.updateMembers()
+	}
+	n.advanceCommit()
+	return entry, nil
+}
+
+// lastIndex returns the index of the last log entry. The caller must hold n.mu.
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+// lastTerm returns the term of the last log entry. The caller must hold n.mu.
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// entry returns the log entry at index, which must lie between the snapshot
+// index and the last index. The caller must hold n.mu.
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.log[0].Index]
+}
+
+// updateMembers recomputes the membership from the latest configuration
+// entry in the log, falling back to the snapshot's. The caller must hold n.mu.
+func (n *Node) updateMembers() {
+	n.members = n.snapMembers
+	for i := len(n.log) - 1; i > 0; i-- {
+		if n.log[i].Type == EntryConfig {
+			n.members = n.log[i].Members
+			break
+		}
+	}
+	if n.state == Leader {
+		n.startReplicators()
+	}
+}
+
+// isMember reports whether this node is part of the current membership. The caller must hold n.mu.
+func (n *Node) isMember() bool {
+	_, ok := n.members[n.cfg.ID]
+	return ok
+}
+
+// persistState saves the current term and vote. The caller must hold n.mu.
+func (n *Node) persistState() {
+	if err := n.storage.saveState(hardState{Term: n.currentTerm, VotedFor: n.votedFor}); err != nil {
+		log.Printf("Raft node %s failed to persist state: %v", n.cfg.ID, err)
+	}
+}
+
+// resetElectionDeadline picks a new randomized election timeout. The caller must hold n.mu.
+func (n *Node) resetElectionDeadline() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// becomeFollower steps down into the given term. The caller must hold n.mu.
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.persistState()
+	}
+	if n.state == Leader {
+		log.Printf("Raft node %s stepping down in term %d", n.cfg.ID, n.currentTerm)
+	}
+	n.state = Follower
+}
+
+// ticker starts elections when the leader has been silent for too long
+func (n *Node) ticker() {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.state != Leader && time.Now().After(n.electionDeadline) && n.isMember() {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// startElection becomes a candidate and requests votes from every other
+// member. The caller must hold n.mu.
+func (n *Node) startElection() {
+	n.state = Candidate
+	n.currentTerm++
+	n.votedFor = n.cfg.ID
+	n.leaderID = ""
+	n.leaderClientAddr = ""
+	n.persistState()
+	n.resetElectionDeadline()
+
+	term := n.currentTerm
+	args := &RequestVoteArgs{
+		Term:         term,
+		CandidateID:  n.cfg.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	log.Printf("Raft node %s starting election for term %d", n.cfg.ID, term)
+
+	votes := 1
+	quorum := len(n.members)/2 + 1
+	if votes >= quorum {
+		n.becomeLeader()
+		return
+	}
+
+	for id, addr := range n.members {
+		if id == n.cfg.ID {
+			continue
+		}
+		go func(addr string) {
+			var reply RequestVoteReply
+			if err := n.transport.call(addr, "RequestVote", args, &reply, n.cfg.ElectionTimeout); err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.currentTerm {
+				n.becomeFollower(reply.Term)
+				n.resetElectionDeadline()
+				return
+			}
+			if n.state != Candidate || n.currentTerm != term || !reply.VoteGranted {
+				return
+			}
+			votes++
+			if votes >= quorum {
+				n.becomeLeader()
+			}
+		}(addr)
+	}
+}
+
+// becomeLeader takes over as leader and commits a no-op entry for the new
+// term. The caller must hold n.mu.
+func (n *Node) becomeLeader() {
+	log.Printf("Raft node %s elected leader for term %d", n.cfg.ID, n.currentTerm)
+	n.state = Leader
+	n.leaderID = n.cfg.ID
+	n.leaderClientAddr = n.cfg.ClientAddr
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.replicators = make(map[string]chan struct{})
+	n.startReplicators()
+
+	if _, err := n.appendLocal(Entry{Type: EntryNoop}); err != nil {
+		log.Printf("Raft node %s failed to append no-op entry: %v", n.cfg.ID, err)
+	}
+	n.triggerReplication()
+}
+
+// startReplicators launches a replication goroutine for every member that
+// does not have one yet. The caller must hold n.mu.
+func (n *Node) startReplicators() {
+	for id, addr := range n.members {
+		if id == n.cfg.ID {
+			continue
+		}
+		if _, ok := n.replicators[id]; ok {
+			continue
+		}
+		n.nextIndex[id] = n.lastIndex() + 1
+		n.matchIndex[id] = 0
+		trigger := make(chan struct{}, 1)
+		n.replicators[id] = trigger
+		go n.replicate(id, addr, n.currentTerm, trigger)
+	}
+}
+
+// triggerReplication wakes every replicator so new entries go out without
+// waiting for the next heartbeat. The caller must hold n.mu.
+func (n *Node) triggerReplication() {
+	for _, trigger := range n.replicators {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicate keeps one follower up to date for as long as this node leads in term
+func (n *Node) replicate(id, addr string, term uint64, trigger chan struct{}) {
+	heartbeat := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		n.mu.Lock()
+		if n.stopped || n.state != Leader || n.currentTerm != term || n.members[id] != addr {
+			if n.replicators[id] == trigger {
+				delete(n.replicators, id)
+			}
+			n.mu.Unlock()
+			return
+		}
+		if n.nextIndex[id] <= n.log[0].Index {
+			n.sendSnapshot(id, addr, term)
+		} else {
+			n.sendEntries(id, addr, term)
+		}
+		n.mu.Unlock()
+
+		select {
+		case <-n.done:
+			return
+		case <-trigger:
+		case <-heartbeat.C:
+		}
+	}
+}
+
+// sendEntries sends an AppendEntries RPC to a follower. It is entered and
+// left with n.mu held but releases it during the call.
+func (n *Node) sendEntries(id, addr string, term uint64) {
+	prevIndex := n.nextIndex[id] - 1
+	args := &AppendEntriesArgs{
+		Term:             term,
+		LeaderID:         n.cfg.ID,
+		LeaderClientAddr: n.cfg.ClientAddr,
+		PrevLogIndex:     prevIndex,
+		PrevLogTerm:      n.entry(prevIndex).Term,
+		LeaderCommit:     n.commitIndex,
+	}
+	args.Entries = append([]Entry(nil), n.log[prevIndex+1-n.log[0].Index:]...)
+
+	n.mu.Unlock()
+	var reply AppendEntriesReply
+	err := n.transport.call(addr, "AppendEntries", args, &reply, n.cfg.ElectionTimeout)
+	n.mu.Lock()
+	if err != nil {
+		return
+	}
+
+	if reply.Term > n.currentTerm {
+		n.becomeFollower(reply.Term)
+		n.resetElectionDeadline()
+		return
+	}
+	if n.state != Leader || n.currentTerm != term {
+		return
+	}
+	if reply.Success {
+		if reply.MatchIndex > n.matchIndex[id] {
+			n.matchIndex[id] = reply.MatchIndex
+		}
+		n.nextIndex[id] = n.matchIndex[id] + 1
+		n.advanceCommit()
+		return
+	}
+	next := reply.ConflictIndex
+	if next < 1 {
+		next = 1
+	}
+	if next > n.lastIndex()+1 {
+		next = n.lastIndex() + 1
+	}
+	n.nextIndex[id] = next
+}
+
+// sendSnapshot sends the latest snapshot to a follower whose next entry has
+// been compacted away. Locking is as for sendEntries.
+func (n *Node) sendSnapshot(id, addr string, term uint64) {
+	snap, err := n.currentSnapshot()
+	if err != nil {
+		log.Printf("Raft node %s cannot send snapshot to %s: %v", n.cfg.ID, id, err)
+		return
+	}
+	args := &InstallSnapshotArgs{
+		Term:             term,
+		LeaderID:         n.cfg.ID,
+		LeaderClientAddr: n.cfg.ClientAddr,
+		Index:            snap.Index,
+		SnapshotTerm:     snap.Term,
+		Members:          snap.Members,
+		Data:             snap.Data,
+	}
+
+	n.mu.Unlock()
+	var reply InstallSnapshotReply
+	err = n.transport.call(addr, "InstallSnapshot", args, &reply, 10*n.cfg.ElectionTimeout)
+	n.mu.Lock()
+	if err != nil {
+		return
+	}
+
+	if reply.Term > n.currentTerm {
+		n.becomeFollower(reply.Term)
+		n.resetElectionDeadline()
+		return
+	}
+	if n.state != Leader || n.currentTerm != term {
+		return
+	}
+	if snap.Index > n.matchIndex[id] {
+		n.matchIndex[id] = snap.Index
+	}
+	n.nextIndex[id] = n.matchIndex[id] + 1
+	n.advanceCommit()
+}
+
+// currentSnapshot returns the snapshot the log was last compacted to. The caller must hold n.mu.
+func (n *Node) currentSnapshot() (*snapshot, error) {
+	if n.lastSnapshot != nil && n.lastSnapshot.Index == n.log[0].Index {
+		return n.lastSnapshot, nil
+	}
+	return nil, fmt.Errorf("no snapshot at index %d", n.log[0].Index)
+}
+
+// advanceCommit moves the commit index to the highest entry of the current
+// term stored on a majority of members. The caller must hold n.mu.
+func (n *Node) advanceCommit() {
+	if n.state != Leader {
+		return
+	}
+
+	var matches []uint64
+	for id := range n.members {
+		if id == n.cfg.ID {
+			matches = append(matches, n.lastIndex())
+		} else {
+			matches = append(matches, n.matchIndex[id])
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+	majority := matches[len(matches)/2]
+
+	if majority > n.commitIndex && n.entry(majority).Term == n.currentTerm {
+		n.commitIndex = majority
+		n.applyCond.Broadcast()
+		n.triggerReplication()
+
+		// A leader that removed itself hands over once the change is committed
+		if !n.isMember() {
+			log.Printf("Raft node %s removed from the group, stepping down", n.cfg.ID)
+			n.becomeFollower(n.currentTerm)
+		}
+	}
+}
+
+// handleRequestVote grants a vote to candidates whose log is at least as up to date as ours
+func (n *Node) handleRequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if args.Term > n.currentTerm {
+		n.becomeFollower(args.Term)
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return
+	}
+
+	upToDate := args.LastLogTerm > n.lastTerm() ||
+		(args.LastLogTerm == n.lastTerm() && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		n.votedFor = args.CandidateID
+		n.persistState()
+		n.resetElectionDeadline()
+		reply.VoteGranted = true
+	}
+}
+
+// handleAppendEntries accepts entries from the leader if they extend a
+// matching prefix of the log, overwriting any conflicting suffix
+func (n *Node) handleAppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if args.Term > n.currentTerm || (args.Term == n.currentTerm && n.state != Follower) {
+		n.becomeFollower(args.Term)
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+	n.leaderID = args.LeaderID
+	n.leaderClientAddr = args.LeaderClientAddr
+	n.resetElectionDeadline()
+
+	// Entries covered by our snapshot are committed and therefore already match
+	prevIndex, prevTerm, entries := args.PrevLogIndex, args.PrevLogTerm, args.Entries
+	if snapIndex := n.log[0].Index; prevIndex < snapIndex {
+		skip := snapIndex - prevIndex
+		if uint64(len(entries)) <= skip {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+		prevIndex, prevTerm = snapIndex, n.log[0].Term
+	}
+
+	if prevIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return nil
+	}
+	if n.entry(prevIndex).Term != prevTerm {
+		conflictTerm := n.entry(prevIndex).Term
+		index := prevIndex
+		for index > n.log[0].Index+1 && n.entry(index-1).Term == conflictTerm {
+			index--
+		}
+		reply.ConflictIndex = index
+		return nil
+	}
+
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndex() {
+			if n.entry(entry.Index).Term == entry.Term {
+				continue
+			}
+			// Conflict: drop our entry and everything after it
+			n.log = n.log[:entry.Index-n.log[0].Index]
+			if err := n.storage.rewriteLog(n.log[1:]); err != nil {
+				return fmt.Errorf("raft: failed to truncate log: %w", err)
+			}
+		}
+		if err := n.storage.appendEntries(entries[i:]); err != nil {
+			return fmt.Errorf("raft: failed to persist entries: %w", err)
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+	n.updateMembers()
+
+	lastNew := prevIndex + uint64(len(entries))
+	if args.LeaderCommit > n.commitIndex {
+		commit := args.LeaderCommit
+		if commit > lastNew {
+			commit = lastNew
+		}
+		if commit > n.commitIndex {
+			n.commitIndex = commit
+			n.applyCond.Broadcast()
+		}
+	}
+
+	reply.Success = true
+	reply.MatchIndex = lastNew
+	return nil
+}
+
+// handleInstallSnapshot replaces the state machine with the leader's snapshot
+func (n *Node) handleInstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if args.Term > n.currentTerm || (args.Term == n.currentTerm && n.state != Follower) {
+		n.becomeFollower(args.Term)
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+	n.leaderID = args.LeaderID
+	n.leaderClientAddr = args.LeaderClientAddr
+	n.resetElectionDeadline()
+
+	if args.Index <= n.commitIndex {
+		return nil // we already have everything the snapshot covers
+	}
+
+	snap := &snapshot{Index: args.Index, Term: args.SnapshotTerm, Members: args.Members, Data: args.Data}
+	if err := n.sm.Restore(snap.Data); err != nil {
+		return fmt.Errorf("raft: failed to restore snapshot: %w", err)
+	}
+	if err := n.storage.saveSnapshot(snap); err != nil {
+		return fmt.Errorf("raft: failed to persist snapshot: %w", err)
+	}
+
+	// Keep any entries that follow the snapshot if our log agrees with it
+	var rest []Entry
+	if args.Index <= n.lastIndex() && args.Index >= n.log[0].Index && n.entry(args.Index).Term == args.SnapshotTerm {
+		rest = append(rest, n.log[args.Index-n.log[0].Index+1:]...)
+	}
+	n.log = append([]Entry{{Index: args.Index, Term: args.SnapshotTerm}}, rest...)
+	n.snapMembers = args.Members
+	n.lastSnapshot = snap
+	if err := n.storage.rewriteLog(n.log[1:]); err != nil {
+		return fmt.Errorf("raft: failed to rewrite log: %w", err)
+	}
+	n.updateMembers()
+
+	n.commitIndex = args.Index
+	n.lastApplied = args.Index
+	log.Printf("Raft node %s installed snapshot at index %d", n.cfg.ID, args.Index)
+	return nil
+}
+
+// applyLoop applies committed entries to the state machine in log order and
+// compacts the log once enough entries have been applied
+func (n *Node) applyLoop() {
+	for {
+		n.mu.Lock()
+		for n.lastApplied >= n.commitIndex && !n.stopped {
+			n.applyCond.Wait()
+		}
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+		n.mu.Unlock()
+
+		n.applyMu.Lock()
+		n.applyCommitted()
+		n.maybeSnapshot()
+		n.applyMu.Unlock()
+	}
+}
+
+// applyCommitted applies every committed entry not yet applied. The caller must hold n.applyMu.
+func (n *Node) applyCommitted() {
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= n.commitIndex || n.stopped {
+			n.mu.Unlock()
+			return
+		}
+		entry := n.entry(n.lastApplied + 1)
+		n.mu.Unlock()
+
+		var err error
+		if entry.Type == EntryCommand {
+			err = n.sm.Apply(entry.Command)
+			if err != nil {
+				log.Printf("Raft node %s failed to apply entry %d: %v", n.cfg.ID, entry.Index, err)
+			}
+		}
+
+		n.mu.Lock()
+		n.lastApplied = entry.Index
+		if w, ok := n.waiters[entry.Index]; ok {
+			delete(n.waiters, entry.Index)
+			if w.term != entry.Term {
+				err = ErrLostLeadership
+			}
+			w.ch <- err
+		}
+		n.mu.Unlock()
+	}
+}
+
+// maybeSnapshot compacts the log into a snapshot of the state machine once
+// SnapshotThreshold entries have been applied since the last one. The caller
+// must hold n.applyMu, so the state machine reflects exactly lastApplied.
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	if n.lastApplied-n.log[0].Index < n.cfg.SnapshotThreshold {
+		n.mu.Unlock()
+		return
+	}
+	index := n.lastApplied
+	n.mu.Unlock()
+
+	data := n.sm.Snapshot()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.compact(index, data); err != nil {
+		log.Printf("Raft node %s failed to take snapshot: %v", n.cfg.ID, err)
+	}
+}
+
+// compact replaces the log up to index with a snapshot. The caller must hold n.mu.
+func (n *Node) compact(index uint64, data []string) error {
+	members := n.snapMembers
+	for i := 1; i < len(n.log) && n.log[i].Index <= index; i++ {
+		if n.log[i].Type == EntryConfig {
+			members = n.log[i].Members
+		}
+	}
+	snap := &snapshot{Index: index, Term: n.entry(index).Term, Members: members, Data: data}
+	if err := n.storage.saveSnapshot(snap); err != nil {
+		return err
+	}
+
+	n.log = append([]Entry{{Index: index, Term: snap.Term}}, n.log[index-n.log[0].Index+1:]...)
+	n.snapMembers = members
+	n.lastSnapshot = snap
+	if err := n.storage.rewriteLog(n.log[1:]); err != nil {
+		return err
+	}
+	log.Printf("Raft node %s compacted log at index %d (%d commands in snapshot)", n.cfg.ID, index, len(data))
+	return nil
+}
+
+// copyMembers returns a copy of a membership map
+func copyMembers(members map[string]string) map[string]string {
+	c := make(map[string]string, len(members))
+	for id, addr := range members {
+		c[id] = addr
+	}
+	return c
+}
@@ -0,0 +1,284 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/persistence"
+	"CacheFlow/internal/store"
+)
+
+// storeMachine adapts a store.Store to the StateMachine interface
+type storeMachine struct {
+	*store.Store
+}
+
+func (m storeMachine) Snapshot() []string              { return m.Store.Snapshot(nil) }
+func (m storeMachine) Restore(commands []string) error { return m.Store.Replace(commands) }
+
+// testNode bundles a node with the store it replicates into
+type testNode struct {
+	*Node
+	store *store.Store
+	cfg   Config
+}
+
+// freeAddr reserves a localhost address for a node to listen on
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// startNode creates and starts a node with a fresh in-memory store
+func startNode(t *testing.T, cfg Config) *testNode {
+	st, err := store.New("")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	cfg.HeartbeatInterval = 20 * time.Millisecond
+	cfg.ElectionTimeout = 150 * time.Millisecond
+	cfg.CommitTimeout = 2 * time.Second
+
+	node, err := New(cfg, storeMachine{st})
+	if err != nil {
+		t.Fatalf("Failed to create node %s: %v", cfg.ID, err)
+	}
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node %s: %v", cfg.ID, err)
+	}
+	t.Cleanup(node.Stop)
+	return &testNode{Node: node, store: st, cfg: cfg}
+}
+
+// startGroup starts a group of size nodes persisting to temporary directories
+func startGroup(t *testing.T, size int, snapshotThreshold uint64) []*testNode {
+	peers := make(map[string]string)
+	for i := 1; i <= size; i++ {
+		peers[fmt.Sprintf("n%d", i)] = freeAddr(t)
+	}
+
+	var nodes []*testNode
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		nodes = append(nodes, startNode(t, Config{
+			ID:                id,
+			Addr:              peers[id],
+			ClientAddr:        "client-" + id,
+			Peers:             peers,
+			Dir:               t.TempDir(),
+			SnapshotThreshold: snapshotThreshold,
+		}))
+	}
+	return nodes
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForLeader returns the single leader among the running nodes
+func waitForLeader(t *testing.T, nodes []*testNode) *testNode {
+	t.Helper()
+	var leader *testNode
+	waitFor(t, "leader election", func() bool {
+		leader = nil
+		for _, n := range nodes {
+			if n.Status().State == Leader {
+				if leader != nil {
+					return false
+				}
+				leader = n
+			}
+		}
+		return leader != nil
+	})
+	return leader
+}
+
+// without returns nodes minus the given one
+func without(nodes []*testNode, skip *testNode) []*testNode {
+	var rest []*testNode
+	for _, n := range nodes {
+		if n != skip {
+			rest = append(rest, n)
+		}
+	}
+	return rest
+}
+
+// TestReplication tests that committed commands reach every node and that
+// followers redirect proposals to the leader.
+func TestReplication(t *testing.T) {
+	nodes := startGroup(t, 3, 1024)
+	leader := waitForLeader(t, nodes)
+
+	for i := 0; i < 20; i++ {
+		if err := leader.Propose(fmt.Sprintf("SET key%d value%d", i, i)); err != nil {
+			t.Fatalf("Propose failed: %v", err)
+		}
+	}
+	for _, n := range nodes {
+		waitFor(t, "replication to "+n.cfg.ID, func() bool { return n.store.Exists("key19") })
+	}
+
+	follower := without(nodes, leader)[0]
+	err := follower.Propose("SET other value")
+	var notLeader *NotLeaderError
+	if !errors.As(err, &notLeader) {
+		t.Fatalf("Expected NotLeaderError from follower, got %v", err)
+	}
+	if notLeader.LeaderClientAddr != leader.cfg.ClientAddr {
+		t.Errorf("Expected redirect to %s, got %s", leader.cfg.ClientAddr, notLeader.LeaderClientAddr)
+	}
+}
+
+// TestLeaderFailure tests that the group keeps acknowledged writes and
+// accepts new ones after the leader dies.
+func TestLeaderFailure(t *testing.T) {
+	nodes := startGroup(t, 3, 1024)
+	leader := waitForLeader(t, nodes)
+	if err := leader.Propose("SET acknowledged yes"); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+
+	leader.Stop()
+	survivors := without(nodes, leader)
+	newLeader := waitForLeader(t, survivors)
+	if err := newLeader.Propose("SET after failover"); err != nil {
+		t.Fatalf("Propose on new leader failed: %v", err)
+	}
+
+	for _, n := range survivors {
+		waitFor(t, "writes on "+n.cfg.ID, func() bool {
+			return n.store.Exists("acknowledged") && n.store.Exists("after")
+		})
+	}
+}
+
+// TestSnapshotCatchUp tests that a node restarting after the log was
+// compacted catches up from a snapshot and keeps its persisted state.
+func TestSnapshotCatchUp(t *testing.T) {
+	nodes := startGroup(t, 3, 10)
+	leader := waitForLeader(t, nodes)
+	follower := without(nodes, leader)[0]
+
+	if err := leader.Propose("SET early value"); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	waitFor(t, "first write on follower", func() bool { return follower.store.Exists("early") })
+	follower.Stop()
+
+	for i := 0; i < 50; i++ {
+		if err := leader.Propose(fmt.Sprintf("SET key%d value%d", i, i)); err != nil {
+			t.Fatalf("Propose failed: %v", err)
+		}
+	}
+	waitFor(t, "leader compaction", func() bool { return leader.Status().SnapshotIndex > 10 })
+
+	restarted := startNode(t, follower.cfg)
+	waitFor(t, "snapshot catch-up", func() bool {
+		v, ok := restarted.store.Get("key49")
+		return ok && v == "value49"
+	})
+	if !restarted.store.Exists("early") {
+		t.Errorf("Expected restarted node to have the key written before it stopped")
+	}
+}
+
+// TestMembershipChange tests adding a node to a running group and removing one.
+func TestMembershipChange(t *testing.T) {
+	nodes := startGroup(t, 3, 1024)
+	leader := waitForLeader(t, nodes)
+	if err := leader.Propose("SET before join"); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+
+	joiner := startNode(t, Config{ID: "n4", Addr: freeAddr(t), Dir: t.TempDir()})
+	if err := leader.AddMember("n4", joiner.cfg.Addr); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	if err := leader.AddMember("n4", joiner.cfg.Addr); !errors.Is(err, ErrDuplicateMember) {
+		t.Errorf("Expected ErrDuplicateMember, got %v", err)
+	}
+	waitFor(t, "new member to catch up", func() bool { return joiner.store.Exists("before") })
+	if len(joiner.Status().Members) != 4 {
+		t.Errorf("Expected new member to know 4 members, got %v", joiner.Status().Members)
+	}
+
+	removed := without(nodes, leader)[0]
+	if err := leader.RemoveMember(removed.cfg.ID); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	removed.Stop()
+
+	// Three of the remaining members must still form a majority
+	if err := leader.Propose("SET after removal"); err != nil {
+		t.Fatalf("Propose after removal failed: %v", err)
+	}
+	waitFor(t, "write on new member", func() bool { return joiner.store.Exists("after") })
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	dir := t.TempDir()
+	key := "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	keys, err := persistence.ParseKeyring(key)
+	if err != nil {
+		t.Fatalf("Failed to parse key: %v", err)
+	}
+
+	// Plaintext files written without a key are re-encrypted on opening with one
+	st, _, _, _, err := openStorage(dir, nil)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	st.saveState(hardState{Term: 3, VotedFor: "secret-node"})
+	st.saveSnapshot(&snapshot{Index: 5, Term: 2, Data: []string{"SET token secret-session"}})
+	st.appendEntries([]Entry{{Index: 6, Term: 3, Command: "SET other secret-value"}})
+	st.close()
+
+	st, state, snap, entries, err := openStorage(dir, keys)
+	if err != nil {
+		t.Fatalf("Failed to open plaintext storage with a key: %v", err)
+	}
+	st.appendEntries([]Entry{{Index: 7, Term: 3, Command: "DELETE other"}})
+	st.close()
+	if state.Term != 3 || snap == nil || snap.Index != 5 || len(entries) != 1 {
+		t.Fatalf("Expected the plaintext state to load, got %+v %+v %+v", state, snap, entries)
+	}
+
+	for _, name := range []string{stateFilename, snapshotFilename, logFilename} {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		if strings.Contains(string(data), "secret") {
+			t.Errorf("Expected %s to be encrypted, got %q", name, data)
+		}
+	}
+
+	_, state, snap, entries, err = openStorage(dir, keys)
+	if err != nil {
+		t.Fatalf("Failed to reopen encrypted storage: %v", err)
+	}
+	if state.VotedFor != "secret-node" || snap.Data[0] != "SET token secret-session" || len(entries) != 2 {
+		t.Errorf("Expected the encrypted state to load, got %+v %+v %+v", state, snap, entries)
+	}
+	if _, _, _, _, err := openStorage(dir, nil); !errors.Is(err, persistence.ErrNoKey) {
+		t.Errorf("Expected ErrNoKey without a key, got %v", err)
+	}
+}
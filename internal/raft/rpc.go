@@ -0,0 +1,161 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// RequestVoteArgs is sent by candidates to gather votes
+type RequestVoteArgs struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// RequestVoteReply is the response to RequestVote
+type RequestVoteReply struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesArgs is sent by the leader to replicate entries and as a heartbeat
+type AppendEntriesArgs struct {
+	Term             uint64
+	LeaderID         string
+	LeaderClientAddr string
+	PrevLogIndex     uint64
+	PrevLogTerm      uint64
+	Entries          []Entry
+	LeaderCommit     uint64
+}
+
+// AppendEntriesReply is the response to AppendEntries. On failure
+// ConflictIndex tells the leader where to retry from.
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	MatchIndex    uint64
+	ConflictIndex uint64
+}
+
+// InstallSnapshotArgs carries a full snapshot to a follower that is behind the leader's log
+type InstallSnapshotArgs struct {
+	Term             uint64
+	LeaderID         string
+	LeaderClientAddr string
+	Index            uint64
+	SnapshotTerm     uint64
+	Members          map[string]string
+	Data             []string
+}
+
+// InstallSnapshotReply is the response to InstallSnapshot
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+// rpcService exposes a Node over net/rpc under the name "Raft"
+type rpcService struct {
+	node *Node
+}
+
+// RequestVote handles a vote request from a candidate
+func (s *rpcService) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	s.node.handleRequestVote(args, reply)
+	return nil
+}
+
+// AppendEntries handles log replication and heartbeats from the leader
+func (s *rpcService) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return s.node.handleAppendEntries(args, reply)
+}
+
+// InstallSnapshot handles a snapshot sent by the leader
+func (s *rpcService) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return s.node.handleInstallSnapshot(args, reply)
+}
+
+// errRPCTimeout is returned when a peer does not answer in time
+var errRPCTimeout = errors.New("raft RPC timed out")
+
+// transport keeps one net/rpc client per peer address and redials after failures
+type transport struct {
+	mu      sync.Mutex
+	clients map[string]*rpc.Client
+}
+
+// newTransport creates an empty transport
+func newTransport() *transport {
+	return &transport{clients: make(map[string]*rpc.Client)}
+}
+
+// call invokes a Raft method on the peer at addr, giving up after timeout
+func (t *transport) call(addr, method string, args, reply any, timeout time.Duration) error {
+	client, err := t.client(addr, timeout)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go("Raft."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			t.drop(addr, client)
+			return fmt.Errorf("raft RPC %s to %s failed: %w", method, addr, call.Error)
+		}
+		return nil
+	case <-time.After(timeout):
+		t.drop(addr, client)
+		return errRPCTimeout
+	}
+}
+
+// client returns a connected client for addr, dialing if necessary
+func (t *transport) client(addr string, timeout time.Duration) (*rpc.Client, error) {
+	t.mu.Lock()
+	client, ok := t.clients[addr]
+	t.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client = rpc.NewClient(conn)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.clients[addr]; ok {
+		client.Close()
+		return existing, nil
+	}
+	t.clients[addr] = client
+	return client, nil
+}
+
+// drop closes and forgets a client after a failure
+func (t *transport) drop(addr string, client *rpc.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clients[addr] == client {
+		delete(t.clients, addr)
+	}
+	client.Close()
+}
+
+// close closes every client
+func (t *transport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, client := range t.clients {
+		client.Close()
+		delete(t.clients, addr)
+	}
+}
@@ -0,0 +1,263 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"CacheFlow/internal/persistence"
+)
+
+// File names used inside the Raft data directory
+const (
+	stateFilename    = "raft-state.json"
+	logFilename      = "raft-log.jsonl"
+	snapshotFilename = "raft-snapshot.json"
+)
+
+// hardState is the part of the Raft state that must survive restarts besides the log
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// snapshot is a compacted prefix of the log: the state machine contents and
+// the membership as of the last included entry
+type snapshot struct {
+	Index   uint64            `json:"index"`
+	Term    uint64            `json:"term"`
+	Members map[string]string `json:"members"`
+	Data    []string          `json:"data"`
+}
+
+// storage persists Raft state in a directory. The log is an append-only file
+// of JSON lines that is rewritten on truncation and compaction. Every file is
+// encrypted with the current key of keys when a keyring is configured. A
+// storage with an empty directory keeps nothing on disk.
+type storage struct {
+	dir      string
+	keys     *persistence.Keyring
+	logCodec *persistence.Codec // appends to the log file
+	logFile  *os.File
+}
+
+// openStorage opens (or creates) the data directory and loads everything
+// persisted in it. Files written with another key of keys, or in plaintext,
+// are rewritten with the current key.
+func openStorage(dir string, keys *persistence.Keyring) (*storage, hardState, *snapshot, []Entry, error) {
+	var state hardState
+	if dir == "" {
+		return &storage{}, state, nil, nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, state, nil, nil, fmt.Errorf("failed to create Raft directory %s: %w", dir, err)
+	}
+	s := &storage{dir: dir, keys: keys}
+	current := keys.Codec().KeyID()
+
+	stateCodec, err := readJSON(filepath.Join(dir, stateFilename), keys, &state)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, state, nil, nil, fmt.Errorf("failed to read Raft state: %w", err)
+	}
+
+	var snap *snapshot
+	var loaded snapshot
+	snapCodec, err := readJSON(filepath.Join(dir, snapshotFilename), keys, &loaded)
+	if err == nil {
+		snap = &loaded
+	} else if !os.IsNotExist(err) {
+		return nil, state, nil, nil, fmt.Errorf("failed to read Raft snapshot: %w", err)
+	}
+
+	entries, logCodec, err := readLog(filepath.Join(dir, logFilename), keys)
+	if err != nil {
+		return nil, state, nil, nil, fmt.Errorf("failed to read Raft log: %w", err)
+	}
+
+	// Re-encrypt whatever is not written with the current key
+	if stateCodec != nil && stateCodec.KeyID() != current {
+		if err := s.saveState(state); err != nil {
+			return nil, state, nil, nil, fmt.Errorf("failed to re-encrypt Raft state: %w", err)
+		}
+	}
+	if snap != nil && snapCodec.KeyID() != current {
+		if err := s.saveSnapshot(snap); err != nil {
+			return nil, state, nil, nil, fmt.Errorf("failed to re-encrypt Raft snapshot: %w", err)
+		}
+	}
+	s.logCodec = logCodec
+	if logCodec.KeyID() != current {
+		if err := s.writeLog(entries); err != nil {
+			return nil, state, nil, nil, fmt.Errorf("failed to re-encrypt Raft log: %w", err)
+		}
+	}
+
+	s.logFile, err = os.OpenFile(filepath.Join(dir, logFilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, state, nil, nil, fmt.Errorf("failed to open Raft log: %w", err)
+	}
+
+	return s, state, snap, entries, nil
+}
+
+// readLog reads all entries from a log file and returns the codec it was
+// written with; a missing file is an empty plaintext log
+func readLog(filename string, keys *persistence.Keyring) ([]Entry, *persistence.Codec, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, &persistence.Codec{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := persistence.NewScanner(file, filename, keys)
+	scanner.Buffer(make([]byte, 64*1024), persistence.MaxLineSize)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal([]byte(scanner.Text()), &entry); err != nil {
+			// A torn write at the tail is the only expected corruption; the entry was never acknowledged
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, persistence.ErrCorrupt) {
+		// A torn encrypted record fails to decrypt, which is handled like a torn JSON line
+		return nil, nil, err
+	}
+	return entries, scanner.Codec(), nil
+}
+
+// readJSON decodes a JSON file into v and returns the codec it was written with
+func readJSON(filename string, keys *persistence.Keyring, v any) (*persistence.Codec, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := persistence.NewScanner(file, filename, keys)
+	scanner.Buffer(make([]byte, 64*1024), math.MaxInt32)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	return scanner.Codec(), json.Unmarshal([]byte(scanner.Text()), v)
+}
+
+// writeJSON encodes v as a single line and writes it to filename
+func (s *storage) writeJSON(filename string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, filename), s.keys.Codec().EncodeFile([]string{string(data)}))
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over filename
+func writeFileAtomic(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// saveState persists the current term and vote
+func (s *storage) saveState(state hardState) error {
+	if s.dir == "" {
+		return nil
+	}
+	return s.writeJSON(stateFilename, state)
+}
+
+// appendEntries appends entries to the log file and syncs it
+func (s *storage) appendEntries(entries []Entry) error {
+	if s.dir == "" || len(entries) == 0 {
+		return nil
+	}
+	writer := bufio.NewWriter(s.logFile)
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		writer.WriteString(s.logCodec.Encode(string(data)) + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return s.logFile.Sync()
+}
+
+// writeLog replaces the contents of the log file with the given entries
+func (s *storage) writeLog(entries []Entry) error {
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(line))
+	}
+	codec := s.keys.Codec()
+	if err := writeFileAtomic(filepath.Join(s.dir, logFilename), codec.EncodeFile(lines)); err != nil {
+		return err
+	}
+	s.logCodec = codec
+	return nil
+}
+
+// rewriteLog replaces the log file with the given entries and reopens it for appending
+func (s *storage) rewriteLog(entries []Entry) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := s.writeLog(entries); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(s.dir, logFilename), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.logFile.Close()
+	s.logFile = logFile
+	return nil
+}
+
+// saveSnapshot persists a snapshot
+func (s *storage) saveSnapshot(snap *snapshot) error {
+	if s.dir == "" {
+		return nil
+	}
+	return s.writeJSON(snapshotFilename, snap)
+}
+
+// close closes the log file
+func (s *storage) close() error {
+	if s.logFile == nil {
+		return nil
+	}
+	return s.logFile.Close()
+}
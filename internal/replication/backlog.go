@@ -0,0 +1,183 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// ErrOffsetUnavailable is returned when the requested offset is no longer (or not yet) held in the backlog
+var ErrOffsetUnavailable = errors.New("offset not available in replication backlog")
+
+// errInterrupted is returned by wait when the caller asked to stop waiting
+var errInterrupted = errors.New("wait interrupted")
+
+// Backlog is a fixed-size ring buffer holding the most recent part of the
+// replication stream. The stream is identified by a replication ID, and every
+// byte in it has an offset; a replica that reconnects with an ID and offset the
+// backlog still covers can resume without a full resync. After a promotion
+// the stream continues under a new ID, and the previous one stays valid up to
+// the offset of the promotion, where the two streams may start to differ.
+type Backlog struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	id     string
+	buf    []byte
+	base   int64 // offset of the first byte that was ever appended under this ID
+	offset int64 // offset just past the last byte appended
+
+	prevID     string // the ID before the latest promotion, or ""
+	prevOffset int64  // the last offset prevID is valid up to
+}
+
+// NewBacklog creates a backlog of the given size with a fresh replication ID
+func NewBacklog(size int) *Backlog {
+	if size <= 0 {
+		size = 1
+	}
+	b := &Backlog{
+		id:  newReplicationID(),
+		buf: make([]byte, size),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// newReplicationID generates a random 40 character replication ID
+func newReplicationID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic("replication: failed to generate ID: " + err.Error())
+	}
+	return hex.EncodeToString(id)
+}
+
+// ID returns the current replication ID
+func (b *Backlog) ID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.id
+}
+
+// Offset returns the offset just past the last command appended
+func (b *Backlog) Offset() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.offset
+}
+
+// Size returns the capacity of the backlog in bytes
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
+
+// Append adds a command to the stream, terminated by a newline
+func (b *Backlog) Append(command string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := command + "\n"
+	for i := 0; i < len(data); i++ {
+		b.buf[(b.offset+int64(i))%int64(len(b.buf))] = data[i]
+	}
+	b.offset += int64(len(data))
+	b.cond.Broadcast()
+}
+
+// Reset discards the buffered stream and continues under a new ID from the
+// given offset. Replicas call this after a full resync so that their own
+// backlog mirrors the stream of their primary.
+func (b *Backlog) Reset(id string, offset int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.id = id
+	b.base = offset
+	b.offset = offset
+	b.prevID = ""
+	b.cond.Broadcast()
+}
+
+// Promote continues the stream under a fresh ID, as a replica promoted to
+// primary does: its former primary may have written past this point under
+// the current ID, so it must not resume beyond it
+func (b *Backlog) Promote() {
+	b.SwitchID(newReplicationID())
+}
+
+// SwitchID continues the stream under id, keeping the current ID valid up to
+// the current offset. Replicas call this when their primary resumes them
+// under a new ID.
+func (b *Backlog) SwitchID(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if id == b.id {
+		return
+	}
+	b.prevID, b.prevOffset = b.id, b.offset
+	b.id = id
+	b.cond.Broadcast()
+}
+
+// Covers reports whether a replica with the given ID and offset can resume from this backlog
+func (b *Backlog) Covers(id string, offset int64) bool {
+	_, ok := b.Resume(id, offset)
+	return ok
+}
+
+// Resume reports whether a replica with the given ID and offset can resume
+// from this backlog, and returns the ID it continues under
+func (b *Backlog) Resume(id string, offset int64) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	known := id == b.id || (id == b.prevID && offset <= b.prevOffset)
+	return b.id, known && offset >= b.start() && offset <= b.offset
+}
+
+// start returns the oldest offset still held in the buffer. The caller must hold b.mu.
+func (b *Backlog) start() int64 {
+	start := b.offset - int64(len(b.buf))
+	if start < b.base {
+		start = b.base
+	}
+	return start
+}
+
+// readFrom copies the stream from offset up to the current end. The caller must hold b.mu.
+func (b *Backlog) readFrom(offset int64) ([]byte, error) {
+	if offset < b.start() || offset > b.offset {
+		return nil, ErrOffsetUnavailable
+	}
+	data := make([]byte, b.offset-offset)
+	for i := range data {
+		data[i] = b.buf[(offset+int64(i))%int64(len(b.buf))]
+	}
+	return data, nil
+}
+
+// wait blocks until the stream extends past offset or stop returns true, and
+// returns everything appended since offset
+func (b *Backlog) wait(id string, offset int64, stop func() bool) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.offset == offset && b.id == id && !stop() {
+		b.cond.Wait()
+	}
+	if stop() {
+		return nil, errInterrupted
+	}
+	if b.id != id {
+		return nil, ErrOffsetUnavailable
+	}
+	return b.readFrom(offset)
+}
+
+// interrupt wakes every goroutine blocked in wait so it can re-check its stop condition
+func (b *Backlog) interrupt() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cond.Broadcast()
+}
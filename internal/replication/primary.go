@@ -0,0 +1,211 @@
+package replication
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"CacheFlow/internal/store"
+)
+
+// writeTimeout bounds how long a replica may take to accept a chunk of the stream
+const writeTimeout = 10 * time.Second
+
+// ReplicaInfo describes a replica currently attached to a primary
+type ReplicaInfo struct {
+	Addr string
+	// ListenAddr is the address the replica serves clients on, if it announced one
+	ListenAddr string
+	AckOffset  int64
+}
+
+// Primary feeds the replication stream of a store to connected replicas.
+// Every node has a Primary, including replicas, so that a promoted replica
+// keeps its backlog and other replicas can resume from it.
+type Primary struct {
+	store   *store.Store
+	backlog *Backlog
+
+	mu       sync.Mutex
+	replicas map[*attachedReplica]struct{}
+
+	fullResyncs    atomic.Int64
+	partialResyncs atomic.Int64
+}
+
+// attachedReplica tracks the state of one replica connection
+type attachedReplica struct {
+	conn       net.Conn
+	listenAddr string
+	ackOffset  atomic.Int64
+	closed     atomic.Bool
+}
+
+// NewPrimary creates a Primary that records every write applied to st into a backlog of the given size
+func NewPrimary(st *store.Store, backlogSize int) *Primary {
+	p := &Primary{
+		store:    st,
+		backlog:  NewBacklog(backlogSize),
+		replicas: make(map[*attachedReplica]struct{}),
+	}
+	st.OnCommand(p.backlog.Append)
+	return p
+}
+
+// Backlog returns the replication backlog of this node
+func (p *Primary) Backlog() *Backlog {
+	return p.backlog
+}
+
+// Replicas returns the replicas currently attached to this node
+func (p *Primary) Replicas() []ReplicaInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	infos := make([]ReplicaInfo, 0, len(p.replicas))
+	for r := range p.replicas {
+		infos = append(infos, ReplicaInfo{
+			Addr:       r.conn.RemoteAddr().String(),
+			ListenAddr: r.listenAddr,
+			AckOffset:  r.ackOffset.Load(),
+		})
+	}
+	return infos
+}
+
+// FullResyncs returns the number of full resynchronizations served
+func (p *Primary) FullResyncs() int64 {
+	return p.fullResyncs.Load()
+}
+
+// PartialResyncs returns the number of partial resynchronizations served
+func (p *Primary) PartialResyncs() int64 {
+	return p.partialResyncs.Load()
+}
+
+// Serve takes over a connection on which a replica sent
+// "PSYNC <id> <offset> [<listen-addr>]" and streams the replication stream to it until the connection fails. If the
+// backlog still covers the requested position the replica continues from there;
+// otherwise it receives a snapshot of the store first.
+func (p *Primary) Serve(conn net.Conn, reader *bufio.Reader, args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("PSYNC requires replication ID and offset")
+	}
+	id := args[0]
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid PSYNC offset %q: %w", args[1], err)
+	}
+
+	writer := bufio.NewWriter(conn)
+	if current, ok := p.backlog.Resume(id, offset); ok {
+		id = current
+		log.Printf("Partial resync of replica %s from offset %d", conn.RemoteAddr(), offset)
+		p.partialResyncs.Add(1)
+		if _, err := writer.WriteString("CONTINUE " + id + "\n"); err != nil {
+			return err
+		}
+	} else {
+		var snapshot []string
+		snapshot = p.store.Snapshot(func() {
+			id = p.backlog.ID()
+			offset = p.backlog.Offset()
+		})
+		log.Printf("Full resync of replica %s: %d keys at offset %d", conn.RemoteAddr(), len(snapshot), offset)
+		p.fullResyncs.Add(1)
+
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		fmt.Fprintf(writer, "FULLRESYNC %s %d %d\n", id, offset, len(snapshot))
+		for _, command := range snapshot {
+			if _, err := writer.WriteString(command + "\n"); err != nil {
+				return fmt.Errorf("failed to send snapshot: %w", err)
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to send resync reply: %w", err)
+	}
+
+	r := &attachedReplica{conn: conn}
+	if len(args) == 3 {
+		r.listenAddr = listenAddr(args[2], conn.RemoteAddr())
+	}
+	r.ackOffset.Store(offset)
+	p.mu.Lock()
+	p.replicas[r] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.replicas, r)
+		p.mu.Unlock()
+	}()
+
+	go p.readAcks(r, reader)
+	return p.stream(r, id, offset)
+}
+
+// listenAddr completes an address announced by a replica: a missing or
+// unspecified host ("0.0.0.0", "::") is replaced by the IP it connected from
+func listenAddr(announced string, remote net.Addr) string {
+	host, port, err := net.SplitHostPort(announced)
+	if err != nil {
+		return announced
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return announced
+	}
+	remoteHost, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return announced
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
+
+// stream writes the backlog to the replica as it grows
+func (p *Primary) stream(r *attachedReplica, id string, offset int64) error {
+	defer r.closed.Store(true)
+
+	for {
+		data, err := p.backlog.wait(id, offset, r.closed.Load)
+		if err == errInterrupted {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("replica %s fell behind the backlog: %w", r.conn.RemoteAddr(), err)
+		}
+
+		r.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := r.conn.Write(data); err != nil {
+			return fmt.Errorf("failed to stream to replica %s: %w", r.conn.RemoteAddr(), err)
+		}
+		offset += int64(len(data))
+	}
+}
+
+// readAcks consumes "REPLCONF ACK <offset>" lines sent by the replica. When
+// the connection fails the stream is interrupted.
+func (p *Primary) readAcks(r *attachedReplica, reader *bufio.Reader) {
+	defer func() {
+		r.closed.Store(true)
+		p.backlog.interrupt()
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		parts := strings.Fields(line)
+		if len(parts) == 3 && strings.EqualFold(parts[0], "REPLCONF") && strings.EqualFold(parts[1], "ACK") {
+			if ack, err := strconv.ParseInt(parts[2], 10, 64); err == nil {
+				r.ackOffset.Store(ack)
+			}
+		}
+	}
+}
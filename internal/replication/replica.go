@@ -0,0 +1,289 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"CacheFlow/internal/store"
+)
+
+// Replica link states reported by Status
+const (
+	StateConnecting = "connecting"
+	StateSyncing    = "syncing"
+	StateConnected  = "connected"
+)
+
+// ackInterval is how often a replica reports its offset to the primary
+const ackInterval = time.Second
+
+// maxRetryDelay caps the backoff between reconnection attempts
+const maxRetryDelay = 5 * time.Second
+
+// ReplicaStatus describes the link between a replica and its primary
+type ReplicaStatus struct {
+	PrimaryAddr string
+	State       string
+	Offset      int64
+}
+
+// Replica keeps a store in sync with a remote primary. Commands received from
+// the primary are applied to the store, which appends them to the local
+// backlog, so the local replication offset always tracks the primary's.
+type Replica struct {
+	primaryAddr  string
+	announceAddr string
+	authUser     string
+	authPassword string
+	tlsConfig    *tls.Config
+	store        *store.Store
+	backlog      *Backlog
+
+	mu      sync.Mutex
+	state   string
+	conn    net.Conn
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewReplica creates a replica of the primary at addr. The backlog must be the
+// one that records writes applied to st (see Primary.Backlog).
+func NewReplica(addr string, st *store.Store, backlog *Backlog) *Replica {
+	return &Replica{
+		primaryAddr: addr,
+		store:       st,
+		backlog:     backlog,
+		state:       StateConnecting,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Announce sets the address this node serves clients on, reported to the
+// primary so that monitors can find its replicas. It must be called before Start.
+func (r *Replica) Announce(addr string) {
+	r.announceAddr = addr
+}
+
+// Auth sets the credentials sent with AUTH before PSYNC; an empty password
+// skips authentication. It must be called before Start.
+func (r *Replica) Auth(user, password string) {
+	r.authUser = user
+	r.authPassword = password
+}
+
+// TLS makes the replica connect to its primary over TLS. It must be called before Start.
+func (r *Replica) TLS(cfg *tls.Config) {
+	r.tlsConfig = cfg
+}
+
+// Start begins replicating in the background, reconnecting with backoff whenever the link drops
+func (r *Replica) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return
+	}
+	r.started = true
+
+	go func() {
+		defer close(r.done)
+
+		delay := 100 * time.Millisecond
+		for {
+			err := r.sync()
+			select {
+			case <-r.stop:
+				return
+			default:
+			}
+			log.Printf("Replication link to %s lost: %v; retrying in %s", r.primaryAddr, err, delay)
+			r.setState(StateConnecting, nil)
+
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+		}
+	}()
+}
+
+// Stop closes the link to the primary and waits for the replication goroutine to exit
+func (r *Replica) Stop() {
+	r.mu.Lock()
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	started := r.started
+	r.mu.Unlock()
+
+	if started {
+		<-r.done
+	}
+}
+
+// Status returns the current state of the replication link
+func (r *Replica) Status() ReplicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReplicaStatus{
+		PrimaryAddr: r.primaryAddr,
+		State:       r.state,
+		Offset:      r.backlog.Offset(),
+	}
+}
+
+// setState updates the link state and the connection Stop must close
+func (r *Replica) setState(state string, conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+	r.conn = conn
+}
+
+// sync runs a single replication session: handshake, optional snapshot
+// transfer and then the command stream until the connection fails
+func (r *Replica) sync() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if r.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.primaryAddr, r.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", r.primaryAddr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.setState(StateSyncing, conn)
+	select {
+	case <-r.stop:
+		return nil
+	default:
+	}
+
+	reader := bufio.NewReader(conn)
+	if err := r.authenticate(conn, reader); err != nil {
+		return err
+	}
+
+	id, offset := r.backlog.ID(), r.backlog.Offset()
+	psync := fmt.Sprintf("PSYNC %s %d", id, offset)
+	if r.announceAddr != "" {
+		psync += " " + r.announceAddr
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", psync); err != nil {
+		return fmt.Errorf("failed to send PSYNC: %w", err)
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read PSYNC reply: %w", err)
+	}
+	parts := strings.Fields(line)
+	switch {
+	case len(parts) == 2 && parts[0] == "CONTINUE":
+		// The primary may continue under a new ID if it was promoted since
+		r.backlog.SwitchID(parts[1])
+		log.Printf("Resuming replication from %s at offset %d", r.primaryAddr, offset)
+
+	case len(parts) == 4 && parts[0] == "FULLRESYNC":
+		offset, err = strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC offset: %s", parts[2])
+		}
+		count, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC key count: %s", parts[3])
+		}
+		snapshot := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			command, err := reader.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("failed to read snapshot: %w", err)
+			}
+			snapshot = append(snapshot, strings.TrimSpace(command))
+		}
+		if err := r.store.Replace(snapshot); err != nil {
+			return fmt.Errorf("failed to load snapshot: %w", err)
+		}
+		r.backlog.Reset(parts[1], offset)
+		log.Printf("Full resync from %s complete: %d keys at offset %d", r.primaryAddr, count, offset)
+
+	default:
+		return fmt.Errorf("unexpected PSYNC reply: %s", strings.TrimSpace(line))
+	}
+
+	r.setState(StateConnected, conn)
+	stopAcks := make(chan struct{})
+	defer close(stopAcks)
+	go r.sendAcks(conn, stopAcks)
+
+	for {
+		command, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read replication stream: %w", err)
+		}
+		if err := r.store.Apply(strings.TrimSuffix(command, "\n")); err != nil {
+			return fmt.Errorf("failed to apply replicated command: %w", err)
+		}
+	}
+}
+
+// authenticate sends AUTH if credentials are set
+func (r *Replica) authenticate(conn net.Conn, reader *bufio.Reader) error {
+	if r.authPassword == "" {
+		return nil
+	}
+	auth := "AUTH " + r.authPassword
+	if r.authUser != "" {
+		auth = fmt.Sprintf("AUTH %s %s", r.authUser, r.authPassword)
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", auth); err != nil {
+		return fmt.Errorf("failed to send AUTH: %w", err)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read AUTH reply: %w", err)
+	}
+	if line = strings.TrimSpace(line); line != "OK" {
+		return fmt.Errorf("authentication failed: %s", line)
+	}
+	return nil
+}
+
+// sendAcks periodically reports the processed offset to the primary
+func (r *Replica) sendAcks(conn net.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprintf(conn, "REPLCONF ACK %d\n", r.backlog.Offset()); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package replication
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/store"
+)
+
+// startPrimary creates an in-memory store with a Primary and serves PSYNC
+// requests on a localhost listener. It returns the store, the primary and the
+// listener address.
+func startPrimary(t *testing.T, backlogSize int) (*store.Store, *Primary, string) {
+	st, err := store.New("")
+	if err != nil {
+		t.Fatalf("Failed to create primary store: %v", err)
+	}
+	p := NewPrimary(st, backlogSize)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				parts := strings.Fields(line)
+				p.Serve(conn, reader, parts[1:])
+			}()
+		}
+	}()
+
+	return st, p, listener.Addr().String()
+}
+
+// newReplicaStore creates an in-memory store wired to its own backlog, the way a server sets up a node
+func newReplicaStore(t *testing.T, addr string) (*store.Store, *Replica) {
+	st, err := store.New("")
+	if err != nil {
+		t.Fatalf("Failed to create replica store: %v", err)
+	}
+	p := NewPrimary(st, 1<<16)
+	r := NewReplica(addr, st, p.Backlog())
+	t.Cleanup(r.Stop)
+	return st, r
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestBacklog tests offsets and coverage of the ring buffer.
+func TestBacklog(t *testing.T) {
+	b := NewBacklog(16)
+	b.Append("SET a 1") // 8 bytes
+	if b.Offset() != 8 {
+		t.Fatalf("Expected offset 8, got %d", b.Offset())
+	}
+	if !b.Covers(b.ID(), 0) {
+		t.Errorf("Expected backlog to cover offset 0")
+	}
+
+	b.Append("SET b 22") // 9 bytes, the first byte is overwritten
+	if b.Covers(b.ID(), 0) {
+		t.Errorf("Expected offset 0 to have been overwritten")
+	}
+	if !b.Covers(b.ID(), 8) {
+		t.Errorf("Expected backlog to cover offset 8")
+	}
+	if b.Covers("other", 8) {
+		t.Errorf("Expected a different replication ID not to be covered")
+	}
+
+	b.mu.Lock()
+	data, err := b.readFrom(8)
+	b.mu.Unlock()
+	if err != nil || string(data) != "SET b 22\n" {
+		t.Errorf("Expected to read %q, got %q (err %v)", "SET b 22\n", data, err)
+	}
+
+	b.Reset("newid", 100)
+	if b.ID() != "newid" || b.Offset() != 100 {
+		t.Errorf("Expected reset to newid@100, got %s@%d", b.ID(), b.Offset())
+	}
+	if b.Covers("newid", 99) || !b.Covers("newid", 100) {
+		t.Errorf("Expected only offset 100 to be covered after reset")
+	}
+}
+
+// TestBacklogPromote tests that the ID before a promotion is only accepted up
+// to the promotion offset.
+func TestBacklogPromote(t *testing.T) {
+	b := NewBacklog(64)
+	b.Append("SET a 1") // 8 bytes
+	old := b.ID()
+	b.Promote()
+	b.Append("SET b 2")
+
+	if b.ID() == old {
+		t.Fatalf("Expected a new replication ID after promotion")
+	}
+	if id, ok := b.Resume(old, 8); !ok || id != b.ID() {
+		t.Errorf("Expected the old ID to resume at the promotion offset under the new ID, got %s (%v)", id, ok)
+	}
+	if _, ok := b.Resume(old, 9); ok {
+		t.Errorf("Expected the old ID not to be accepted past the promotion offset")
+	}
+	if !b.Covers(b.ID(), 16) {
+		t.Errorf("Expected the new ID to cover offset 16")
+	}
+}
+
+// TestFullAndPartialResync tests that a replica receives a snapshot, follows
+// the stream, and resumes without a full resync after reconnecting.
+func TestFullAndPartialResync(t *testing.T) {
+	primaryStore, primary, addr := startPrimary(t, 1<<16)
+	primaryStore.Set("before", "snapshot value", 0)
+	primaryStore.Set("ttl", "expiring", time.Hour)
+
+	replicaStore, replica := newReplicaStore(t, addr)
+	replicaStore.Set("stale", "removed by resync", 0)
+	replica.Start()
+
+	waitFor(t, "snapshot", func() bool { return replicaStore.Exists("before") })
+	if v, _ := replicaStore.Get("before"); v != "snapshot value" {
+		t.Errorf("Expected snapshot value, got %v", v)
+	}
+	if !replicaStore.Exists("ttl") {
+		t.Errorf("Expected key with TTL to be part of the snapshot")
+	}
+	if replicaStore.Exists("stale") {
+		t.Errorf("Expected full resync to discard keys unknown to the primary")
+	}
+
+	primaryStore.Set("streamed", "value", 0)
+	primaryStore.Delete("before")
+	waitFor(t, "stream", func() bool { return replicaStore.Exists("streamed") && !replicaStore.Exists("before") })
+	waitFor(t, "offsets to match", func() bool {
+		return replica.Status().Offset == primary.Backlog().Offset()
+	})
+	if primary.FullResyncs() != 1 {
+		t.Errorf("Expected exactly one full resync, got %d", primary.FullResyncs())
+	}
+
+	// Drop the link, write while disconnected, then reconnect with the same backlog
+	replica.Stop()
+	primaryStore.Set("missed", "while away", 0)
+
+	resumed := NewReplica(addr, replicaStore, replica.backlog)
+	t.Cleanup(resumed.Stop)
+	resumed.Start()
+
+	waitFor(t, "partial resync", func() bool { return replicaStore.Exists("missed") })
+	if primary.FullResyncs() != 1 || primary.PartialResyncs() != 1 {
+		t.Errorf("Expected 1 full and 1 partial resync, got %d and %d", primary.FullResyncs(), primary.PartialResyncs())
+	}
+	if resumed.Status().State != StateConnected {
+		t.Errorf("Expected replica to be connected, got %s", resumed.Status().State)
+	}
+}
+
+// TestResyncAfterBacklogOverrun tests that a replica too far behind gets a full resync.
+func TestResyncAfterBacklogOverrun(t *testing.T) {
+	primaryStore, primary, addr := startPrimary(t, 32)
+	replicaStore, replica := newReplicaStore(t, addr)
+	replica.Start()
+	waitFor(t, "initial sync", func() bool { return replica.Status().State == StateConnected })
+	replica.Stop()
+
+	for i := 0; i < 10; i++ {
+		primaryStore.Set("key", strings.Repeat("x", i+1), 0)
+	}
+
+	resumed := NewReplica(addr, replicaStore, replica.backlog)
+	t.Cleanup(resumed.Stop)
+	resumed.Start()
+
+	waitFor(t, "full resync", func() bool {
+		v, ok := replicaStore.Get("key")
+		return ok && v == "xxxxxxxxxx"
+	})
+	if primary.FullResyncs() != 2 {
+		t.Errorf("Expected a second full resync, got %d full resyncs", primary.FullResyncs())
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 51234}
+	tests := map[string]string{
+		"10.0.0.7:6380":  "10.0.0.7:6380",
+		"[::]:6380":      "10.0.0.5:6380",
+		"0.0.0.0:6380":   "10.0.0.5:6380",
+		":6380":          "10.0.0.5:6380",
+		"cache-2:6380":   "cache-2:6380",
+		"not-an-address": "not-an-address",
+	}
+	for announced, expected := range tests {
+		if got := listenAddr(announced, remote); got != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, announced, got)
+		}
+	}
+}
@@ -0,0 +1,705 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// maxCallDepth bounds recursion, so a script cannot exhaust the Go stack
+	maxCallDepth = 200
+	// maxParseDepth bounds the nesting of expressions, tables and blocks, so
+	// neither can parsing
+	maxParseDepth = 200
+	// maxStringLen bounds the strings a script can build by concatenation
+	maxStringLen = 64 << 20
+	// checkEvery is how many steps run between checks of the deadline
+	checkEvery = 1000
+)
+
+// ErrTimeout is returned by Run when a script exceeds its time limit. Unlike
+// script errors, pcall cannot catch it.
+var ErrTimeout = errors.New("script exceeded its time limit")
+
+// Error is an error raised by a script, with error() or by a failing
+// operation. Value is what was raised, usually a string.
+type Error struct {
+	Value Value
+}
+
+func (e *Error) Error() string {
+	if t, ok := e.Value.(*Table); ok {
+		if msg, ok := t.Get("err").(string); ok {
+			return msg
+		}
+	}
+	return ToString(e.Value)
+}
+
+// flow is how control leaves a block
+type flow int
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowReturn
+)
+
+// scope holds the local variables of a block
+type scope struct {
+	vars    map[string]*Value
+	varargs []Value // the extra arguments of the enclosing function
+	parent  *scope
+}
+
+func newScope(parent *scope) *scope {
+	sc := &scope{parent: parent}
+	if parent != nil {
+		sc.varargs = parent.varargs
+	}
+	return sc
+}
+
+// declare adds a local variable
+func (sc *scope) declare(name string, v Value) {
+	if sc.vars == nil {
+		sc.vars = make(map[string]*Value)
+	}
+	sc.vars[name] = &v
+}
+
+// lookup finds a local variable, or returns nil for a global
+func (sc *scope) lookup(name string) *Value {
+	for s := sc; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// interp runs a script
+type interp struct {
+	ctx     context.Context
+	globals *Table
+	host    func(args []string) (Value, error)
+	steps   int
+	depth   int
+}
+
+// rtErrorf returns a script error with a formatted message
+func rtErrorf(format string, args ...any) error {
+	return &Error{Value: fmt.Sprintf(format, args...)}
+}
+
+// step counts a unit of work, failing once the deadline has passed
+func (it *interp) step() error {
+	it.steps++
+	if it.steps%checkEvery == 0 && it.ctx.Err() != nil {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// execBlock runs a block in a new scope
+func (it *interp) execBlock(b *block, parent *scope) (flow, []Value, error) {
+	return it.execStmts(b, newScope(parent))
+}
+
+// positioned prefixes the message of a string error with the line it was
+// raised on, unless it already has one
+func positioned(err error, line int) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	msg, ok := e.Value.(string)
+	if !ok || strings.HasPrefix(msg, "line ") {
+		return err
+	}
+	return &Error{Value: fmt.Sprintf("line %d: %s", line, msg)}
+}
+
+// exec runs a statement
+func (it *interp) exec(s stmt, sc *scope) (flow, []Value, error) {
+	if err := it.step(); err != nil {
+		return flowNormal, nil, err
+	}
+	switch s := s.(type) {
+	case *localStmt:
+		values, err := it.evalList(s.exprs, sc)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		for i, name := range s.names {
+			sc.declare(name, at(values, i))
+		}
+
+	case *assignStmt:
+		return flowNormal, nil, it.assign(s, sc)
+
+	case *callStmt:
+		if _, err := it.evalMulti(s.call, sc); err != nil {
+			return flowNormal, nil, err
+		}
+
+	case *doStmt:
+		return it.execBlock(s.body, sc)
+
+	case *whileStmt:
+		for {
+			cond, err := it.eval(s.cond, sc)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if !truthy(cond) {
+				break
+			}
+			f, values, err := it.execBlock(s.body, sc)
+			if err != nil || f == flowReturn {
+				return f, values, err
+			}
+			if f == flowBreak {
+				break
+			}
+		}
+
+	case *repeatStmt:
+		for {
+			// The condition sees the locals of the body
+			body := newScope(sc)
+			f, values, err := it.execStmts(s.body, body)
+			if err != nil || f == flowReturn {
+				return f, values, err
+			}
+			if f == flowBreak {
+				break
+			}
+			cond, err := it.eval(s.cond, body)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if truthy(cond) {
+				break
+			}
+		}
+
+	case *ifStmt:
+		for i, c := range s.conds {
+			cond, err := it.eval(c, sc)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if truthy(cond) {
+				return it.execBlock(s.blocks[i], sc)
+			}
+		}
+		if s.orElse != nil {
+			return it.execBlock(s.orElse, sc)
+		}
+
+	case *numForStmt:
+		return it.numFor(s, sc)
+
+	case *genForStmt:
+		return it.genFor(s, sc)
+
+	case *localFuncStmt:
+		// Declared first, so the function can call itself
+		sc.declare(s.name, nil)
+		*sc.lookup(s.name) = &function{def: s.fn, env: sc}
+
+	case *returnStmt:
+		values, err := it.evalList(s.exprs, sc)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		return flowReturn, values, nil
+
+	case *breakStmt:
+		return flowBreak, nil, nil
+	}
+	return flowNormal, nil, nil
+}
+
+// execStmts runs the statements of a block in the given scope
+func (it *interp) execStmts(b *block, sc *scope) (flow, []Value, error) {
+	// Counted even when empty, so loops without statements still time out
+	if err := it.step(); err != nil {
+		return flowNormal, nil, err
+	}
+	for _, s := range b.stmts {
+		f, values, err := it.exec(s, sc)
+		if err != nil {
+			return flowNormal, nil, positioned(err, s.stmtLine())
+		}
+		if f != flowNormal {
+			return f, values, nil
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (it *interp) assign(s *assignStmt, sc *scope) error {
+	// Table and key expressions are evaluated before any assignment
+	type slot struct {
+		table *Table
+		key   Value
+		name  string
+	}
+	slots := make([]slot, len(s.targets))
+	for i, target := range s.targets {
+		switch target := target.(type) {
+		case *nameExpr:
+			slots[i].name = target.name
+		case *indexExpr:
+			obj, err := it.eval(target.obj, sc)
+			if err != nil {
+				return err
+			}
+			t, ok := obj.(*Table)
+			if !ok {
+				return rtErrorf("attempt to index a %s value", typeName(obj))
+			}
+			key, err := it.eval(target.key, sc)
+			if err != nil {
+				return err
+			}
+			if err := checkKey(key); err != nil {
+				return err
+			}
+			slots[i] = slot{table: t, key: key}
+		}
+	}
+	values, err := it.evalList(s.exprs, sc)
+	if err != nil {
+		return err
+	}
+	for i, sl := range slots {
+		v := at(values, i)
+		switch {
+		case sl.table != nil:
+			sl.table.Set(sl.key, v)
+		case sc.lookup(sl.name) != nil:
+			*sc.lookup(sl.name) = v
+		default:
+			it.globals.Set(sl.name, v)
+		}
+	}
+	return nil
+}
+
+// checkKey returns an error for keys a table cannot hold
+func checkKey(key Value) error {
+	if key == nil {
+		return rtErrorf("table index is nil")
+	}
+	if n, ok := key.(float64); ok && math.IsNaN(n) {
+		return rtErrorf("table index is NaN")
+	}
+	return nil
+}
+
+func (it *interp) numFor(s *numForStmt, sc *scope) (flow, []Value, error) {
+	var bounds [3]float64
+	bounds[2] = 1
+	for i, e := range []expr{s.start, s.limit, s.step} {
+		if e == nil {
+			continue
+		}
+		v, err := it.eval(e, sc)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		n, ok := toNumber(v)
+		if !ok {
+			return flowNormal, nil, rtErrorf("'for' %s must be a number", [3]string{"initial value", "limit", "step"}[i])
+		}
+		bounds[i] = n
+	}
+	start, limit, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return flowNormal, nil, rtErrorf("'for' step is zero")
+	}
+	for i := start; (step > 0 && i <= limit) || (step < 0 && i >= limit); i += step {
+		body := newScope(sc)
+		body.declare(s.name, i)
+		f, values, err := it.execStmts(s.body, body)
+		if err != nil || f == flowReturn {
+			return f, values, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (it *interp) genFor(s *genForStmt, sc *scope) (flow, []Value, error) {
+	values, err := it.evalList(s.exprs, sc)
+	if err != nil {
+		return flowNormal, nil, err
+	}
+	fn, state, control := at(values, 0), at(values, 1), at(values, 2)
+	for {
+		results, err := it.call(fn, []Value{state, control})
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		if at(results, 0) == nil {
+			break
+		}
+		control = results[0]
+		body := newScope(sc)
+		for i, name := range s.names {
+			body.declare(name, at(results, i))
+		}
+		f, values, err := it.execStmts(s.body, body)
+		if err != nil || f == flowReturn {
+			return f, values, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+// at returns values[i], or nil past the end
+func at(values []Value, i int) Value {
+	if i < len(values) {
+		return values[i]
+	}
+	return nil
+}
+
+// evalList evaluates expressions; the last one contributes all its values
+func (it *interp) evalList(exprs []expr, sc *scope) ([]Value, error) {
+	values := make([]Value, 0, len(exprs))
+	for i, e := range exprs {
+		if i == len(exprs)-1 {
+			last, err := it.evalMulti(e, sc)
+			if err != nil {
+				return nil, err
+			}
+			return append(values, last...), nil
+		}
+		v, err := it.eval(e, sc)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// evalMulti evaluates an expression that may have several values
+func (it *interp) evalMulti(e expr, sc *scope) ([]Value, error) {
+	switch e := e.(type) {
+	case *callExpr:
+		fn, err := it.eval(e.fn, sc)
+		if err != nil {
+			return nil, err
+		}
+		args, err := it.evalList(e.args, sc)
+		if err != nil {
+			return nil, err
+		}
+		return it.call(fn, args)
+	case *methodExpr:
+		obj, err := it.eval(e.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		fn, err := it.index(obj, e.name)
+		if err != nil {
+			return nil, err
+		}
+		args, err := it.evalList(e.args, sc)
+		if err != nil {
+			return nil, err
+		}
+		return it.call(fn, append([]Value{obj}, args...))
+	case *varargExpr:
+		return sc.varargs, nil
+	}
+	v, err := it.eval(e, sc)
+	return []Value{v}, err
+}
+
+// eval evaluates an expression to a single value
+func (it *interp) eval(e expr, sc *scope) (Value, error) {
+	switch e := e.(type) {
+	case *constExpr:
+		return e.value, nil
+	case *nameExpr:
+		if v := sc.lookup(e.name); v != nil {
+			return *v, nil
+		}
+		return it.globals.Get(e.name), nil
+	case *indexExpr:
+		obj, err := it.eval(e.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		key, err := it.eval(e.key, sc)
+		if err != nil {
+			return nil, err
+		}
+		return it.index(obj, key)
+	case *callExpr, *methodExpr, *varargExpr:
+		values, err := it.evalMulti(e, sc)
+		return at(values, 0), err
+	case *parenExpr:
+		return it.eval(e.inner, sc)
+	case *funcExpr:
+		return &function{def: e, env: sc}, nil
+	case *tableExpr:
+		return it.table(e, sc)
+	case *unaryExpr:
+		return it.unary(e, sc)
+	case *binaryExpr:
+		return it.binary(e, sc)
+	}
+	return nil, rtErrorf("unsupported expression %T", e)
+}
+
+// index returns obj[key]; strings are indexed into the string library, so
+// s:upper() works
+func (it *interp) index(obj, key Value) (Value, error) {
+	switch obj := obj.(type) {
+	case *Table:
+		return obj.Get(key), nil
+	case string:
+		if lib, ok := it.globals.Get("string").(*Table); ok {
+			return lib.Get(key), nil
+		}
+	}
+	return nil, rtErrorf("attempt to index a %s value", typeName(obj))
+}
+
+func (it *interp) table(e *tableExpr, sc *scope) (Value, error) {
+	t := NewTable()
+	n := 0
+	for i, item := range e.items {
+		if item.key != nil {
+			key, err := it.eval(item.key, sc)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkKey(key); err != nil {
+				return nil, err
+			}
+			v, err := it.eval(item.value, sc)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(key, v)
+			continue
+		}
+		// The last positional item contributes all its values
+		if i == len(e.items)-1 {
+			values, err := it.evalMulti(item.value, sc)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range values {
+				n++
+				t.Set(float64(n), v)
+			}
+			continue
+		}
+		v, err := it.eval(item.value, sc)
+		if err != nil {
+			return nil, err
+		}
+		n++
+		t.Set(float64(n), v)
+	}
+	return t, nil
+}
+
+func (it *interp) unary(e *unaryExpr, sc *scope) (Value, error) {
+	v, err := it.eval(e.operand, sc)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "not":
+		return !truthy(v), nil
+	case "-":
+		n, ok := toNumber(v)
+		if !ok {
+			return nil, rtErrorf("attempt to perform arithmetic on a %s value", typeName(v))
+		}
+		return -n, nil
+	default: // #
+		switch v := v.(type) {
+		case string:
+			return float64(len(v)), nil
+		case *Table:
+			return float64(v.Len()), nil
+		}
+		return nil, rtErrorf("attempt to get length of a %s value", typeName(v))
+	}
+}
+
+func (it *interp) binary(e *binaryExpr, sc *scope) (Value, error) {
+	left, err := it.eval(e.left, sc)
+	if err != nil {
+		return nil, err
+	}
+	// and and or only evaluate their right operand when needed
+	switch e.op {
+	case "and":
+		if !truthy(left) {
+			return left, nil
+		}
+		return it.eval(e.right, sc)
+	case "or":
+		if truthy(left) {
+			return left, nil
+		}
+		return it.eval(e.right, sc)
+	}
+	right, err := it.eval(e.right, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return equal(left, right), nil
+	case "~=":
+		return !equal(left, right), nil
+	case "<", "<=", ">", ">=":
+		return compare(e.op, left, right)
+	case "..":
+		return concat(left, right)
+	}
+	return arith(e.op, left, right)
+}
+
+// equal compares two values without conversions
+func equal(a, b Value) bool {
+	return a == b
+}
+
+func compare(op string, a, b Value) (Value, error) {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			switch op {
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			}
+			return x >= y, nil
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			switch op {
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			}
+			return x >= y, nil
+		}
+	}
+	if typeName(a) == typeName(b) {
+		return nil, rtErrorf("attempt to compare two %s values", typeName(a))
+	}
+	return nil, rtErrorf("attempt to compare %s with %s", typeName(a), typeName(b))
+}
+
+func concat(a, b Value) (Value, error) {
+	var parts [2]string
+	for i, v := range []Value{a, b} {
+		switch v := v.(type) {
+		case string:
+			parts[i] = v
+		case float64:
+			parts[i] = FormatNumber(v)
+		default:
+			return nil, rtErrorf("attempt to concatenate a %s value", typeName(v))
+		}
+	}
+	if len(parts[0])+len(parts[1]) > maxStringLen {
+		return nil, rtErrorf("string length overflow")
+	}
+	return parts[0] + parts[1], nil
+}
+
+func arith(op string, a, b Value) (Value, error) {
+	x, ok := toNumber(a)
+	if !ok {
+		return nil, rtErrorf("attempt to perform arithmetic on a %s value", typeName(a))
+	}
+	y, ok := toNumber(b)
+	if !ok {
+		return nil, rtErrorf("attempt to perform arithmetic on a %s value", typeName(b))
+	}
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		return x / y, nil
+	case "%":
+		if math.IsInf(y, 0) && !math.IsInf(x, 0) {
+			if (x >= 0) == (y > 0) {
+				return x, nil
+			}
+			return y, nil
+		}
+		return x - math.Floor(x/y)*y, nil
+	}
+	return math.Pow(x, y), nil
+}
+
+// call calls a function value with arguments
+func (it *interp) call(fn Value, args []Value) ([]Value, error) {
+	if err := it.step(); err != nil {
+		return nil, err
+	}
+	switch fn := fn.(type) {
+	case *goFunction:
+		return fn.fn(it, args)
+	case *function:
+		if it.depth >= maxCallDepth {
+			return nil, rtErrorf("stack overflow")
+		}
+		it.depth++
+		defer func() { it.depth-- }()
+
+		sc := newScope(fn.env)
+		for i, param := range fn.def.params {
+			sc.declare(param, at(args, i))
+		}
+		sc.varargs = nil
+		if fn.def.vararg && len(args) > len(fn.def.params) {
+			sc.varargs = args[len(fn.def.params):]
+		}
+		f, values, err := it.execStmts(fn.def.body, sc)
+		if err != nil {
+			return nil, err
+		}
+		if f == flowReturn {
+			return values, nil
+		}
+		return nil, nil
+	}
+	return nil, rtErrorf("attempt to call a %s value", typeName(fn))
+}
@@ -0,0 +1,775 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// newGlobals returns the global environment of a run: the base functions and
+// the string, table, math and cacheflow libraries. There is no access to
+// files, the OS or other scripts.
+func newGlobals() *Table {
+	g := NewTable()
+	register(g, map[string]func(*interp, []Value) ([]Value, error){
+		"assert":   baseAssert,
+		"error":    baseError,
+		"ipairs":   baseIpairs,
+		"next":     baseNext,
+		"pairs":    basePairs,
+		"pcall":    basePcall,
+		"select":   baseSelect,
+		"tonumber": baseTonumber,
+		"tostring": baseTostring,
+		"type":     baseType,
+		"unpack":   tableUnpack,
+	})
+
+	str := NewTable()
+	register(str, map[string]func(*interp, []Value) ([]Value, error){
+		"byte":    strByte,
+		"char":    strChar,
+		"find":    strFind,
+		"format":  strFormat,
+		"len":     strLen,
+		"lower":   strLower,
+		"rep":     strRep,
+		"reverse": strReverse,
+		"sub":     strSub,
+		"upper":   strUpper,
+	})
+	g.Set("string", str)
+
+	tbl := NewTable()
+	register(tbl, map[string]func(*interp, []Value) ([]Value, error){
+		"concat": tableConcat,
+		"insert": tableInsert,
+		"remove": tableRemove,
+		"unpack": tableUnpack,
+	})
+	g.Set("table", tbl)
+
+	m := NewTable()
+	register(m, map[string]func(*interp, []Value) ([]Value, error){
+		"abs":   mathFunc(math.Abs),
+		"ceil":  mathFunc(math.Ceil),
+		"floor": mathFunc(math.Floor),
+		"sqrt":  mathFunc(math.Sqrt),
+		"fmod":  mathFmod,
+		"max":   mathMax,
+		"min":   mathMin,
+	})
+	m.Set("huge", math.Inf(1))
+	m.Set("pi", math.Pi)
+	g.Set("math", m)
+
+	host := NewTable()
+	register(host, map[string]func(*interp, []Value) ([]Value, error){
+		"call":         hostCall,
+		"pcall":        hostPcall,
+		"error_reply":  hostErrorReply,
+		"status_reply": hostStatusReply,
+		"sha1hex":      hostSHA1,
+	})
+	g.Set("cacheflow", host)
+	// Scripts written for Redis find the same functions under their usual name
+	g.Set("redis", host)
+	return g
+}
+
+// register adds functions to a library table
+func register(t *Table, fns map[string]func(*interp, []Value) ([]Value, error)) {
+	for name, fn := range fns {
+		t.Set(name, &goFunction{name: name, fn: fn})
+	}
+}
+
+// argError returns an error about a bad argument of a builtin
+func argError(i int, name, msg string) error {
+	return rtErrorf("bad argument #%d to '%s' (%s)", i, name, msg)
+}
+
+// checkNumber returns argument i (from 1) as a number
+func checkNumber(args []Value, i int, name string) (float64, error) {
+	n, ok := toNumber(at(args, i-1))
+	if !ok {
+		return 0, argError(i, name, "number expected, got "+typeName(at(args, i-1)))
+	}
+	return n, nil
+}
+
+// checkInt returns argument i as an integer, or def if it is nil
+func checkInt(args []Value, i int, name string, def int) (int, error) {
+	if at(args, i-1) == nil {
+		return def, nil
+	}
+	n, err := checkNumber(args, i, name)
+	return int(n), err
+}
+
+// checkString returns argument i as a string; numbers are converted
+func checkString(args []Value, i int, name string) (string, error) {
+	switch v := at(args, i-1).(type) {
+	case string:
+		return v, nil
+	case float64:
+		return FormatNumber(v), nil
+	}
+	return "", argError(i, name, "string expected, got "+typeName(at(args, i-1)))
+}
+
+// checkTable returns argument i as a table
+func checkTable(args []Value, i int, name string) (*Table, error) {
+	t, ok := at(args, i-1).(*Table)
+	if !ok {
+		return nil, argError(i, name, "table expected, got "+typeName(at(args, i-1)))
+	}
+	return t, nil
+}
+
+func baseAssert(it *interp, args []Value) ([]Value, error) {
+	if truthy(at(args, 0)) {
+		return args, nil
+	}
+	if len(args) > 1 {
+		return nil, &Error{Value: args[1]}
+	}
+	return nil, rtErrorf("assertion failed!")
+}
+
+func baseError(it *interp, args []Value) ([]Value, error) {
+	return nil, &Error{Value: at(args, 0)}
+}
+
+func baseIpairs(it *interp, args []Value) ([]Value, error) {
+	if _, err := checkTable(args, 1, "ipairs"); err != nil {
+		return nil, err
+	}
+	iter := &goFunction{name: "ipairs_iter", fn: func(it *interp, args []Value) ([]Value, error) {
+		t, err := checkTable(args, 1, "ipairs")
+		if err != nil {
+			return nil, err
+		}
+		n, _ := toNumber(at(args, 1))
+		i := n + 1
+		v := t.Get(i)
+		if v == nil {
+			return []Value{nil}, nil
+		}
+		return []Value{i, v}, nil
+	}}
+	return []Value{iter, args[0], 0.0}, nil
+}
+
+func baseNext(it *interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "next")
+	if err != nil {
+		return nil, err
+	}
+	keys := t.keys()
+	i := 0
+	if k := at(args, 1); k != nil {
+		for i < len(keys) && keys[i] != k {
+			i++
+		}
+		if i == len(keys) {
+			return nil, rtErrorf("invalid key to 'next'")
+		}
+		i++
+	}
+	for ; i < len(keys); i++ {
+		if v := t.Get(keys[i]); v != nil {
+			return []Value{keys[i], v}, nil
+		}
+	}
+	return []Value{nil}, nil
+}
+
+// basePairs iterates over a snapshot of the keys, so assigning to fields
+// during the loop is safe
+func basePairs(it *interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "pairs")
+	if err != nil {
+		return nil, err
+	}
+	keys := t.keys()
+	i := 0
+	iter := &goFunction{name: "pairs_iter", fn: func(it *interp, args []Value) ([]Value, error) {
+		for ; i < len(keys); i++ {
+			if v := t.Get(keys[i]); v != nil {
+				i++
+				return []Value{keys[i-1], v}, nil
+			}
+		}
+		return []Value{nil}, nil
+	}}
+	return []Value{iter, t, nil}, nil
+}
+
+// basePcall calls a function, returning false and the error instead of
+// raising it. Timeouts are not caught.
+func basePcall(it *interp, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, argError(1, "pcall", "value expected")
+	}
+	results, err := it.call(args[0], args[1:])
+	var e *Error
+	if errors.As(err, &e) {
+		return []Value{false, e.Value}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]Value{true}, results...), nil
+}
+
+func baseSelect(it *interp, args []Value) ([]Value, error) {
+	if at(args, 0) == "#" {
+		return []Value{float64(len(args) - 1)}, nil
+	}
+	n, err := checkInt(args, 1, "select", 0)
+	if err != nil {
+		return nil, err
+	}
+	rest := args[1:]
+	switch {
+	case n < 0 && -n <= len(rest):
+		return rest[len(rest)+n:], nil
+	case n < 1:
+		return nil, argError(1, "select", "index out of range")
+	case n > len(rest):
+		return nil, nil
+	}
+	return rest[n-1:], nil
+}
+
+func baseTonumber(it *interp, args []Value) ([]Value, error) {
+	if at(args, 1) == nil {
+		n, ok := toNumber(at(args, 0))
+		if !ok {
+			return []Value{nil}, nil
+		}
+		return []Value{n}, nil
+	}
+	base, err := checkInt(args, 2, "tonumber", 10)
+	if err != nil {
+		return nil, err
+	}
+	if base < 2 || base > 36 {
+		return nil, argError(2, "tonumber", "base out of range")
+	}
+	s, err := checkString(args, 1, "tonumber")
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(strings.ToLower(strings.TrimSpace(s)), base, 64)
+	if err != nil {
+		return []Value{nil}, nil
+	}
+	return []Value{float64(n)}, nil
+}
+
+func baseTostring(it *interp, args []Value) ([]Value, error) {
+	return []Value{ToString(at(args, 0))}, nil
+}
+
+func baseType(it *interp, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, argError(1, "type", "value expected")
+	}
+	return []Value{typeName(args[0])}, nil
+}
+
+// strRange converts Lua string positions, which count from 1 and may be
+// negative to count from the end, into a slice range of a string of length n
+func strRange(i, j, n int) (int, int) {
+	if i < 0 {
+		i = max(n+i+1, 1)
+	} else if i == 0 {
+		i = 1
+	}
+	if j < 0 {
+		j = n + j + 1
+	} else if j > n {
+		j = n
+	}
+	if i > j {
+		return 0, 0
+	}
+	return i - 1, j
+}
+
+func strByte(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "byte")
+	if err != nil {
+		return nil, err
+	}
+	i, err := checkInt(args, 2, "byte", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := checkInt(args, 3, "byte", i)
+	if err != nil {
+		return nil, err
+	}
+	from, to := strRange(i, j, len(s))
+	var values []Value
+	for _, c := range []byte(s[from:to]) {
+		values = append(values, float64(c))
+	}
+	return values, nil
+}
+
+func strChar(it *interp, args []Value) ([]Value, error) {
+	b := make([]byte, len(args))
+	for i := range args {
+		n, err := checkInt(args, i+1, "char", 0)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n > 255 {
+			return nil, argError(i+1, "char", "value out of range")
+		}
+		b[i] = byte(n)
+	}
+	return []Value{string(b)}, nil
+}
+
+// strFind finds plain substrings; Lua patterns are not supported
+func strFind(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "find")
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := checkString(args, 2, "find")
+	if err != nil {
+		return nil, err
+	}
+	init, err := checkInt(args, 3, "find", 1)
+	if err != nil {
+		return nil, err
+	}
+	if !truthy(at(args, 3)) && strings.ContainsAny(pattern, "^$*+?.([%-") {
+		return nil, rtErrorf("patterns are not supported, pass true as the fourth argument of 'find' for a plain search")
+	}
+	from, _ := strRange(init, len(s), len(s))
+	if init > len(s)+1 {
+		return []Value{nil}, nil
+	}
+	i := strings.Index(s[from:], pattern)
+	if i < 0 {
+		return []Value{nil}, nil
+	}
+	return []Value{float64(from + i + 1), float64(from + i + len(pattern))}, nil
+}
+
+// strFormat supports the %d, %i, %u, %c, %x, %X, %o, %e, %E, %f, %g, %G, %q,
+// %s and %% directives with flags, width and precision
+func strFormat(it *interp, args []Value) ([]Value, error) {
+	format, err := checkString(args, 1, "format")
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	arg := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		start := i
+		i++
+		for i < len(format) && strings.IndexByte("-+ #0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i >= len(format) {
+			return nil, rtErrorf("invalid conversion '%s' to 'format'", format[start:])
+		}
+		spec, verb := format[start:i], format[i]
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		arg++
+		switch verb {
+		case 'd', 'i', 'u':
+			n, err := checkNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			if n != math.Trunc(n) {
+				return nil, argError(arg, "format", "number has no integer representation")
+			}
+			fmt.Fprintf(&b, spec+"d", int64(n))
+		case 'c':
+			n, err := checkNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(byte(n))
+		case 'x', 'X', 'o':
+			n, err := checkNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+string(verb), int64(n))
+		case 'e', 'E', 'f', 'g', 'G':
+			n, err := checkNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+string(verb), n)
+		case 'q':
+			s, err := checkString(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(strconv.Quote(s))
+		case 's':
+			if arg > len(args) {
+				return nil, argError(arg, "format", "no value")
+			}
+			fmt.Fprintf(&b, spec+"s", ToString(args[arg-1]))
+		default:
+			return nil, rtErrorf("invalid conversion '%s' to 'format'", format[start:i+1])
+		}
+		if b.Len() > maxStringLen {
+			return nil, rtErrorf("string length overflow")
+		}
+	}
+	return []Value{b.String()}, nil
+}
+
+func strLen(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "len")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{float64(len(s))}, nil
+}
+
+func strLower(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "lower")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{strings.ToLower(s)}, nil
+}
+
+func strUpper(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "upper")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{strings.ToUpper(s)}, nil
+}
+
+func strRep(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "rep")
+	if err != nil {
+		return nil, err
+	}
+	n, err := checkInt(args, 2, "rep", 0)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return []Value{""}, nil
+	}
+	if len(s)*n > maxStringLen || len(s)*n/n != len(s) {
+		return nil, rtErrorf("resulting string too large")
+	}
+	return []Value{strings.Repeat(s, n)}, nil
+}
+
+func strReverse(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "reverse")
+	if err != nil {
+		return nil, err
+	}
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return []Value{string(b)}, nil
+}
+
+func strSub(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "sub")
+	if err != nil {
+		return nil, err
+	}
+	i, err := checkInt(args, 2, "sub", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := checkInt(args, 3, "sub", -1)
+	if err != nil {
+		return nil, err
+	}
+	from, to := strRange(i, j, len(s))
+	return []Value{s[from:to]}, nil
+}
+
+func tableConcat(it *interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "concat")
+	if err != nil {
+		return nil, err
+	}
+	sep := ""
+	if at(args, 1) != nil {
+		if sep, err = checkString(args, 2, "concat"); err != nil {
+			return nil, err
+		}
+	}
+	i, err := checkInt(args, 3, "concat", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := checkInt(args, 4, "concat", t.Len())
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for k := i; k <= j; k++ {
+		switch v := t.Get(float64(k)).(type) {
+		case string:
+			b.WriteString(v)
+		case float64:
+			b.WriteString(FormatNumber(v))
+		default:
+			return nil, rtErrorf("invalid value (at index %d) in table for 'concat'", k)
+		}
+		if k < j {
+			b.WriteString(sep)
+		}
+		if b.Len() > maxStringLen {
+			return nil, rtErrorf("string length overflow")
+		}
+	}
+	return []Value{b.String()}, nil
+}
+
+func tableInsert(it *interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "insert")
+	if err != nil {
+		return nil, err
+	}
+	n := t.Len()
+	switch len(args) {
+	case 2:
+		t.Set(float64(n+1), args[1])
+	case 3:
+		pos, err := checkInt(args, 2, "insert", 0)
+		if err != nil {
+			return nil, err
+		}
+		if pos < 1 || pos > n+1 {
+			return nil, argError(2, "insert", "position out of bounds")
+		}
+		for k := n; k >= pos; k-- {
+			t.Set(float64(k+1), t.Get(float64(k)))
+		}
+		t.Set(float64(pos), args[2])
+	default:
+		return nil, rtErrorf("wrong number of arguments to 'insert'")
+	}
+	return nil, nil
+}
+
+func tableRemove(it *interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "remove")
+	if err != nil {
+		return nil, err
+	}
+	n := t.Len()
+	pos, err := checkInt(args, 2, "remove", n)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 && at(args, 1) == nil {
+		return []Value{nil}, nil
+	}
+	if pos < 1 || pos > n+1 {
+		return nil, argError(2, "remove", "position out of bounds")
+	}
+	v := t.Get(float64(pos))
+	for k := pos; k < n; k++ {
+		t.Set(float64(k), t.Get(float64(k+1)))
+	}
+	if pos <= n {
+		t.Set(float64(n), nil)
+	}
+	return []Value{v}, nil
+}
+
+func tableUnpack(it *interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 1, "unpack")
+	if err != nil {
+		return nil, err
+	}
+	i, err := checkInt(args, 2, "unpack", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := checkInt(args, 3, "unpack", t.Len())
+	if err != nil {
+		return nil, err
+	}
+	if j-i >= 1<<16 {
+		return nil, rtErrorf("too many results to unpack")
+	}
+	var values []Value
+	for k := i; k <= j; k++ {
+		values = append(values, t.Get(float64(k)))
+	}
+	return values, nil
+}
+
+// mathFunc wraps a one argument math function
+func mathFunc(fn func(float64) float64) func(*interp, []Value) ([]Value, error) {
+	return func(it *interp, args []Value) ([]Value, error) {
+		n, err := checkNumber(args, 1, "math")
+		if err != nil {
+			return nil, err
+		}
+		return []Value{fn(n)}, nil
+	}
+}
+
+func mathFmod(it *interp, args []Value) ([]Value, error) {
+	x, err := checkNumber(args, 1, "fmod")
+	if err != nil {
+		return nil, err
+	}
+	y, err := checkNumber(args, 2, "fmod")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{math.Mod(x, y)}, nil
+}
+
+func mathMax(it *interp, args []Value) ([]Value, error) {
+	return extreme(args, "max", func(a, b float64) bool { return a > b })
+}
+
+func mathMin(it *interp, args []Value) ([]Value, error) {
+	return extreme(args, "min", func(a, b float64) bool { return a < b })
+}
+
+// extreme returns the argument that wins every comparison with better
+func extreme(args []Value, name string, better func(a, b float64) bool) ([]Value, error) {
+	best, err := checkNumber(args, 1, name)
+	if err != nil {
+		return nil, err
+	}
+	for i := 2; i <= len(args); i++ {
+		n, err := checkNumber(args, i, name)
+		if err != nil {
+			return nil, err
+		}
+		if better(n, best) {
+			best = n
+		}
+	}
+	return []Value{best}, nil
+}
+
+// commandArgs converts the arguments of cacheflow.call to strings
+func commandArgs(args []Value) ([]string, error) {
+	if len(args) == 0 {
+		return nil, rtErrorf("please specify at least one argument for cacheflow.call()")
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			parts[i] = arg
+		case float64:
+			parts[i] = FormatNumber(arg)
+		default:
+			return nil, rtErrorf("command arguments must be strings or numbers")
+		}
+	}
+	return parts, nil
+}
+
+// runCommand runs a command through the host; a failing command returns an
+// error table
+func runCommand(it *interp, args []Value) (Value, *Table, error) {
+	parts, err := commandArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if it.host == nil {
+		return nil, nil, rtErrorf("commands are not available")
+	}
+	reply, err := it.host(parts)
+	if errors.Is(err, ErrTimeout) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, ErrorReply(err.Error()), nil
+	}
+	return reply, nil, nil
+}
+
+// hostCall runs a command, raising its error if it fails
+func hostCall(it *interp, args []Value) ([]Value, error) {
+	reply, errReply, err := runCommand(it, args)
+	if err != nil {
+		return nil, err
+	}
+	if errReply != nil {
+		return nil, &Error{Value: errReply}
+	}
+	return []Value{reply}, nil
+}
+
+// hostPcall runs a command, returning its error as an error table
+func hostPcall(it *interp, args []Value) ([]Value, error) {
+	reply, errReply, err := runCommand(it, args)
+	if err != nil {
+		return nil, err
+	}
+	if errReply != nil {
+		return []Value{errReply}, nil
+	}
+	return []Value{reply}, nil
+}
+
+func hostErrorReply(it *interp, args []Value) ([]Value, error) {
+	msg, err := checkString(args, 1, "error_reply")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{ErrorReply(msg)}, nil
+}
+
+func hostStatusReply(it *interp, args []Value) ([]Value, error) {
+	msg, err := checkString(args, 1, "status_reply")
+	if err != nil {
+		return nil, err
+	}
+	t := NewTable()
+	t.Set("ok", msg)
+	return []Value{t}, nil
+}
+
+func hostSHA1(it *interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 1, "sha1hex")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{SHA1(s)}, nil
+}
+
+// ErrorReply returns the table a script returns to reply with an error
+func ErrorReply(msg string) *Table {
+	t := NewTable()
+	t.Set("err", msg)
+	return t
+}
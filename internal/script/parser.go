@@ -0,0 +1,790 @@
+package script
+
+import "fmt"
+
+// block is a sequence of statements
+type block struct {
+	stmts []stmt
+}
+
+// stmt is a statement; line is where it starts
+type stmt interface {
+	stmtLine() int
+}
+
+// expr is an expression
+type expr interface{}
+
+type (
+	localStmt struct {
+		line  int
+		names []string
+		exprs []expr
+	}
+	assignStmt struct {
+		line    int
+		targets []expr // nameExpr or indexExpr
+		exprs   []expr
+	}
+	callStmt struct {
+		line int
+		call expr // callExpr or methodExpr
+	}
+	doStmt struct {
+		line int
+		body *block
+	}
+	whileStmt struct {
+		line int
+		cond expr
+		body *block
+	}
+	repeatStmt struct {
+		line int
+		body *block
+		cond expr
+	}
+	ifStmt struct {
+		line   int
+		conds  []expr
+		blocks []*block
+		orElse *block // nil without else
+	}
+	numForStmt struct {
+		line               int
+		name               string
+		start, limit, step expr // step is nil for 1
+		body               *block
+	}
+	genForStmt struct {
+		line  int
+		names []string
+		exprs []expr
+		body  *block
+	}
+	localFuncStmt struct {
+		line int
+		name string
+		fn   *funcExpr
+	}
+	returnStmt struct {
+		line  int
+		exprs []expr
+	}
+	breakStmt struct {
+		line int
+	}
+)
+
+func (s *localStmt) stmtLine() int     { return s.line }
+func (s *assignStmt) stmtLine() int    { return s.line }
+func (s *callStmt) stmtLine() int      { return s.line }
+func (s *doStmt) stmtLine() int        { return s.line }
+func (s *whileStmt) stmtLine() int     { return s.line }
+func (s *repeatStmt) stmtLine() int    { return s.line }
+func (s *ifStmt) stmtLine() int        { return s.line }
+func (s *numForStmt) stmtLine() int    { return s.line }
+func (s *genForStmt) stmtLine() int    { return s.line }
+func (s *localFuncStmt) stmtLine() int { return s.line }
+func (s *returnStmt) stmtLine() int    { return s.line }
+func (s *breakStmt) stmtLine() int     { return s.line }
+
+type (
+	constExpr  struct{ value Value } // nil, booleans, numbers and strings
+	varargExpr struct{}
+	nameExpr   struct{ name string }
+	indexExpr  struct{ obj, key expr }
+	callExpr   struct {
+		fn   expr
+		args []expr
+	}
+	methodExpr struct {
+		obj  expr
+		name string
+		args []expr
+	}
+	funcExpr struct {
+		name   string
+		params []string
+		vararg bool
+		body   *block
+	}
+	tableExpr struct {
+		items []tableItem
+	}
+	binaryExpr struct {
+		op          string
+		left, right expr
+	}
+	unaryExpr struct {
+		op      string
+		operand expr
+	}
+	parenExpr struct{ inner expr } // truncates a call to one value
+)
+
+// tableItem is a field of a table constructor; key is nil for positional items
+type tableItem struct {
+	key, value expr
+}
+
+// binaryPriority holds the left and right priority of the binary operators;
+// a right priority lower than the left one makes an operator right associative
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {9, 8},
+	"+":  {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "%": {11, 11},
+	"^": {14, 13},
+}
+
+// unaryPriority is the priority of not, # and unary minus
+const unaryPriority = 12
+
+// parser builds the syntax tree of a script
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int // nesting of the expression, table or block being parsed
+}
+
+// parse parses a script into its main block
+func parse(src string) (*block, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", describe(tok))
+	}
+	return body, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// is reports whether the next token is the keyword or operator text
+func (p *parser) is(text string) bool {
+	tok := p.peek()
+	return (tok.kind == tokKeyword || tok.kind == tokOp) && tok.text == text
+}
+
+// accept consumes the next token if it is the keyword or operator text
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+// expect consumes the keyword or operator text or fails
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("%q expected near %s", text, describe(p.peek()))
+	}
+	return nil
+}
+
+// name consumes a name
+func (p *parser) name() (string, error) {
+	tok := p.peek()
+	if tok.kind != tokName {
+		return "", p.errorf("name expected near %s", describe(tok))
+	}
+	p.advance()
+	return tok.text, nil
+}
+
+// enter goes one nesting level deeper, failing past maxParseDepth; leave
+// comes back up
+func (p *parser) enter() error {
+	if p.depth >= maxParseDepth {
+		return p.errorf("chunk has too many syntax levels")
+	}
+	p.depth++
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// describe names a token in an error message
+func describe(tok token) string {
+	switch tok.kind {
+	case tokEOF:
+		return "<eof>"
+	case tokNumber:
+		return fmt.Sprintf("'%v'", tok.num)
+	case tokString:
+		return fmt.Sprintf("%q", tok.text)
+	}
+	return "'" + tok.text + "'"
+}
+
+// blockEnd reports whether the next token ends a block
+func (p *parser) blockEnd() bool {
+	tok := p.peek()
+	if tok.kind == tokEOF {
+		return true
+	}
+	if tok.kind != tokKeyword {
+		return false
+	}
+	switch tok.text {
+	case "end", "else", "elseif", "until":
+		return true
+	}
+	return false
+}
+
+// block parses statements up to the end of a block
+func (p *parser) block() (*block, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	b := &block{}
+	for !p.blockEnd() {
+		if p.accept(";") {
+			continue
+		}
+		if p.is("return") {
+			s, err := p.returnStmt()
+			if err != nil {
+				return nil, err
+			}
+			b.stmts = append(b.stmts, s)
+			if !p.blockEnd() {
+				return nil, p.errorf("'end' expected after return")
+			}
+			break
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		b.stmts = append(b.stmts, s)
+	}
+	return b, nil
+}
+
+func (p *parser) returnStmt() (stmt, error) {
+	line := p.advance().line
+	s := &returnStmt{line: line}
+	if p.blockEnd() || p.is(";") {
+		p.accept(";")
+		return s, nil
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	s.exprs = exprs
+	return s, nil
+}
+
+// statement parses any statement but return
+func (p *parser) statement() (stmt, error) {
+	line := p.peek().line
+	switch {
+	case p.accept("break"):
+		return &breakStmt{line: line}, nil
+	case p.accept("do"):
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &doStmt{line: line, body: body}, p.expect("end")
+	case p.accept("while"):
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{line: line, cond: cond, body: body}, p.expect("end")
+	case p.accept("repeat"):
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("until"); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		return &repeatStmt{line: line, body: body, cond: cond}, nil
+	case p.is("if"):
+		return p.ifStmt()
+	case p.accept("for"):
+		return p.forStmt(line)
+	case p.accept("function"):
+		return p.functionStmt(line)
+	case p.accept("local"):
+		if p.accept("function") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn, err := p.funcBody(name)
+			if err != nil {
+				return nil, err
+			}
+			return &localFuncStmt{line: line, name: name, fn: fn}, nil
+		}
+		names, err := p.nameList()
+		if err != nil {
+			return nil, err
+		}
+		s := &localStmt{line: line, names: names}
+		if p.accept("=") {
+			if s.exprs, err = p.exprList(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+	return p.exprStmt(line)
+}
+
+func (p *parser) ifStmt() (stmt, error) {
+	s := &ifStmt{line: p.peek().line}
+	for p.accept("if") || p.accept("elseif") {
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.conds = append(s.conds, cond)
+		s.blocks = append(s.blocks, body)
+		if !p.is("elseif") {
+			break
+		}
+	}
+	if p.accept("else") {
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.orElse = body
+	}
+	return s, p.expect("end")
+}
+
+func (p *parser) forStmt(line int) (stmt, error) {
+	first, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("=") {
+		s := &numForStmt{line: line, name: first}
+		if s.start, err = p.expr(0); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if s.limit, err = p.expr(0); err != nil {
+			return nil, err
+		}
+		if p.accept(",") {
+			if s.step, err = p.expr(0); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		if s.body, err = p.block(); err != nil {
+			return nil, err
+		}
+		return s, p.expect("end")
+	}
+
+	s := &genForStmt{line: line, names: []string{first}}
+	for p.accept(",") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		s.names = append(s.names, name)
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	if s.exprs, err = p.exprList(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	if s.body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return s, p.expect("end")
+}
+
+// functionStmt parses function a.b.c() ... end and function a:m() ... end
+func (p *parser) functionStmt(line int) (stmt, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	fullName := name
+	var target expr = &nameExpr{name: name}
+	method := false
+	for p.is(".") || p.is(":") {
+		method = p.advance().text == ":"
+		field, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		fullName += "." + field
+		target = &indexExpr{obj: target, key: &constExpr{value: field}}
+		if method {
+			break
+		}
+	}
+	fn, err := p.funcBody(fullName)
+	if err != nil {
+		return nil, err
+	}
+	if method {
+		fn.params = append([]string{"self"}, fn.params...)
+	}
+	return &assignStmt{line: line, targets: []expr{target}, exprs: []expr{fn}}, nil
+}
+
+// exprStmt parses an assignment or a function call
+func (p *parser) exprStmt(line int) (stmt, error) {
+	first, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("=") && !p.is(",") {
+		switch first.(type) {
+		case *callExpr, *methodExpr:
+			return &callStmt{line: line, call: first}, nil
+		}
+		return nil, p.errorf("syntax error near %s", describe(p.peek()))
+	}
+
+	targets := []expr{first}
+	for p.accept(",") {
+		target, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	for _, target := range targets {
+		switch target.(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			return nil, p.errorf("cannot assign to this expression")
+		}
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return &assignStmt{line: line, targets: targets, exprs: exprs}, nil
+}
+
+func (p *parser) nameList() ([]string, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{name}
+	for p.accept(",") {
+		if name, err = p.name(); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	e, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	exprs := []expr{e}
+	for p.accept(",") {
+		if e, err = p.expr(0); err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	return exprs, nil
+}
+
+// expr parses an expression whose binary operators bind tighter than limit
+func (p *parser) expr(limit int) (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	var left expr
+	var err error
+	if p.is("not") || p.is("-") || p.is("#") {
+		op := p.advance().text
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		operand, err := p.expr(unaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		left = &unaryExpr{op: op, operand: operand}
+	} else if left, err = p.simpleExpr(); err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp && tok.kind != tokKeyword {
+			return left, nil
+		}
+		prio, ok := binaryPriority[tok.text]
+		if !ok || prio[0] <= limit {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.expr(prio[1])
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *parser) simpleExpr() (expr, error) {
+	tok := p.peek()
+	switch {
+	case tok.kind == tokNumber:
+		p.advance()
+		return &constExpr{value: tok.num}, nil
+	case tok.kind == tokString:
+		p.advance()
+		return &constExpr{value: tok.text}, nil
+	case p.accept("nil"):
+		return &constExpr{}, nil
+	case p.accept("true"):
+		return &constExpr{value: true}, nil
+	case p.accept("false"):
+		return &constExpr{value: false}, nil
+	case p.accept("..."):
+		return &varargExpr{}, nil
+	case p.is("{"):
+		return p.table()
+	case p.accept("function"):
+		return p.funcBody("")
+	}
+	return p.suffixedExpr()
+}
+
+// primaryExpr parses a name or a parenthesized expression
+func (p *parser) primaryExpr() (expr, error) {
+	if p.accept("(") {
+		inner, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		return &parenExpr{inner: inner}, p.expect(")")
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, p.errorf("unexpected %s", describe(p.peek()))
+	}
+	return &nameExpr{name: name}, nil
+}
+
+// suffixedExpr parses a primary expression followed by fields, indexes and calls
+func (p *parser) suffixedExpr() (expr, error) {
+	e, err := p.primaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			field, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			e = &indexExpr{obj: e, key: &constExpr{value: field}}
+		case p.accept("["):
+			key, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{obj: e, key: key}
+		case p.accept(":"):
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &methodExpr{obj: e, name: name, args: args}
+		case p.is("(") || p.is("{") || p.peek().kind == tokString:
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &callExpr{fn: e, args: args}
+		default:
+			return e, nil
+		}
+	}
+}
+
+// callArgs parses (args), a table constructor or a string literal
+func (p *parser) callArgs() ([]expr, error) {
+	if tok := p.peek(); tok.kind == tokString {
+		p.advance()
+		return []expr{&constExpr{value: tok.text}}, nil
+	}
+	if p.is("{") {
+		t, err := p.table()
+		if err != nil {
+			return nil, err
+		}
+		return []expr{t}, nil
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.accept(")") {
+		return nil, nil
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return args, p.expect(")")
+}
+
+// table parses a table constructor
+func (p *parser) table() (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	t := &tableExpr{}
+	for !p.accept("}") {
+		var item tableItem
+		var err error
+		switch {
+		case p.accept("["):
+			if item.key, err = p.expr(0); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+		case p.peek().kind == tokName && p.pos+1 < len(p.tokens) &&
+			p.tokens[p.pos+1].kind == tokOp && p.tokens[p.pos+1].text == "=":
+			item.key = &constExpr{value: p.advance().text}
+			p.advance()
+		}
+		if item.value, err = p.expr(0); err != nil {
+			return nil, err
+		}
+		t.items = append(t.items, item)
+		if !p.accept(",") && !p.accept(";") {
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return t, nil
+}
+
+// funcBody parses the parameters and body of a function
+func (p *parser) funcBody(name string) (*funcExpr, error) {
+	fn := &funcExpr{name: name}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.accept(")") {
+		if p.accept("...") {
+			fn.vararg = true
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+		param, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		fn.params = append(fn.params, param)
+		if !p.accept(",") {
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	fn.body = body
+	return fn, p.expect("end")
+}
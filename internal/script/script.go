@@ -0,0 +1,65 @@
+// Package script implements the scripts run by EVAL: a sandboxed interpreter
+// for a subset of Lua 5.1 with access to the commands of the store.
+package script
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// Script is a compiled script
+type Script struct {
+	Source string
+	SHA    string // the SHA1 digest of Source, which EVALSHA refers to
+	body   *block
+}
+
+// Env is what a run of a script can see: KEYS, ARGV and a function running
+// commands. Call returns the reply of a command or its error message.
+type Env struct {
+	Keys []string
+	Args []string
+	Call func(args []string) (Value, error)
+}
+
+// Compile parses a script
+func Compile(source string) (*Script, error) {
+	body, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{Source: source, SHA: SHA1(source), body: body}, nil
+}
+
+// SHA1 returns the hex SHA1 digest of a script
+func SHA1(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// Run runs the script with fresh globals and returns its first return value.
+// Errors raised by the script are returned as *Error; running past the
+// deadline of ctx returns ErrTimeout.
+func (s *Script) Run(ctx context.Context, env Env) (Value, error) {
+	it := &interp{ctx: ctx, globals: newGlobals(), host: env.Call}
+	keys := NewTable()
+	for i, key := range env.Keys {
+		keys.Set(float64(i+1), key)
+	}
+	args := NewTable()
+	for i, arg := range env.Args {
+		args.Set(float64(i+1), arg)
+	}
+	it.globals.Set("KEYS", keys)
+	it.globals.Set("ARGV", args)
+
+	if ctx.Err() != nil {
+		return nil, ErrTimeout
+	}
+	_, values, err := it.execBlock(s.body, nil)
+	if err != nil {
+		return nil, err
+	}
+	return at(values, 0), nil
+}
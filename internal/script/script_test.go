@@ -0,0 +1,155 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// run compiles and runs source with no commands available
+func run(t *testing.T, source string, env Env) (Value, error) {
+	t.Helper()
+	s, err := Compile(source)
+	if err != nil {
+		t.Fatalf("Expected %q to compile, got %v", source, err)
+	}
+	return s.Run(context.Background(), env)
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		source string
+		want   Value
+	}{
+		{"return 1 + 2 * 3 ^ 2", 19.0},
+		{"return 7 % 3, 2", 1.0},
+		{"return '10' + 5", 15.0},
+		{"return 'a' .. 1 .. 'b'", "a1b"},
+		{"return 2 ^ 3 ^ 2", 512.0},
+		{"return not nil and 1 or 2", 1.0},
+		{"return #'hello' + #{1, 2, 3}", 8.0},
+		{"local t = {} t[1] = 'x' t[2] = 'y' return table.concat(t, ',')", "x,y"},
+		{"local n = 0 for i = 10, 1, -2 do n = n + i end return n", 30.0},
+		{"local s = '' for k, v in pairs({b = 2, a = 1}) do s = s .. k .. v end return s", "a1b2"},
+		{"local s = 0 for i, v in ipairs({4, 5, nil, 6}) do s = s + v end return s", 9.0},
+		{"local i = 0 while true do i = i + 1 if i == 5 then break end end return i", 5.0},
+		{"local i = 0 repeat local j = i i = i + 1 until j >= 3 return i", 4.0},
+		{"local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end return fib(15)", 610.0},
+		{"local function f(...) return select('#', ...) end return f(1, nil, 3)", 3.0},
+		{"local t = {f = function(self, x) return self.v + x end, v = 1} return t:f(2)", 3.0},
+		{"return ('abc'):upper()", "ABC"},
+		{"return string.format('%s=%05.1f %d%%', 'x', 3.14159, 42)", "x=003.1 42%"},
+		{"return string.sub('hello', 2, -2)", "ell"},
+		{"return string.find('a.b', '.', 1, true)", 2.0},
+		{"local ok, err = pcall(error, 'boom') return tostring(ok) .. ' ' .. err", "false boom"},
+		{"return tonumber('ff', 16)", 255.0},
+		{"return math.max(3, 9, 1) - math.floor(2.7)", 7.0},
+		{"local a, b = 1 return b", nil},
+		{"x = 3 return x", 3.0},
+	}
+	for _, tt := range tests {
+		got, err := run(t, tt.source, Env{})
+		if err != nil {
+			t.Errorf("%q: expected no error, got %v", tt.source, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.source, tt.want, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := Compile("if x then"); err == nil || !strings.Contains(err.Error(), `"end" expected`) {
+		t.Errorf("Expected a syntax error, got %v", err)
+	}
+
+	_, err := run(t, "local x = 1\nreturn x + {}", Env{})
+	var e *Error
+	if !errors.As(err, &e) || e.Error() != "line 2: attempt to perform arithmetic on a table value" {
+		t.Errorf("Expected an arithmetic error on line 2, got %v", err)
+	}
+
+	if _, err := run(t, "local function f() return f() + 1 end return f()", Env{}); err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("Expected a stack overflow, got %v", err)
+	}
+
+	// Deep nesting fails to compile instead of exhausting the stack
+	for _, source := range []string{
+		"return " + strings.Repeat("(", 100000) + "1" + strings.Repeat(")", 100000),
+		"return " + strings.Repeat("- ", 100000) + "1",
+		"return " + strings.Repeat("{", 100000) + strings.Repeat("}", 100000),
+		strings.Repeat("do ", 100000) + strings.Repeat("end ", 100000),
+	} {
+		if _, err := Compile(source); err == nil || !strings.Contains(err.Error(), "too many syntax levels") {
+			t.Errorf("Expected too many syntax levels for %.20q..., got %v", source, err)
+		}
+	}
+	if got, err := run(t, "return "+strings.Repeat("(", 50)+"1"+strings.Repeat(")", 50), Env{}); err != nil || got != 1.0 {
+		t.Errorf("Expected moderate nesting to run, got %v (%v)", got, err)
+	}
+
+	// Sandboxed scripts have no access to the host
+	for _, name := range []string{"os", "io", "require", "load", "dofile"} {
+		if got, _ := run(t, "return "+name, Env{}); got != nil {
+			t.Errorf("Expected %s to be unavailable, got %v", name, got)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s, err := Compile("while true do pcall(function() end) end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.Run(ctx, Env{}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	data := map[string]string{}
+	env := Env{
+		Keys: []string{"counter"},
+		Args: []string{"5"},
+		Call: func(args []string) (Value, error) {
+			switch strings.ToUpper(args[0]) {
+			case "GET":
+				v, ok := data[args[1]]
+				if !ok {
+					return false, nil
+				}
+				return v, nil
+			case "SET":
+				data[args[1]] = args[2]
+				return "OK", nil
+			}
+			return nil, errors.New("UNKNOWN_COMMAND " + args[0])
+		},
+	}
+
+	source := `
+local current = tonumber(cacheflow.call('GET', KEYS[1]) or '0')
+cacheflow.call('SET', KEYS[1], current + ARGV[1])
+return redis.call('GET', KEYS[1])`
+	for _, want := range []string{"5", "10"} {
+		if got, err := run(t, source, env); err != nil || got != want {
+			t.Errorf("Expected %s, got %v (%v)", want, got, err)
+		}
+	}
+
+	got, err := run(t, "return cacheflow.pcall('NOPE')", env)
+	if reply, ok := got.(*Table); err != nil || !ok || reply.Get("err") != "UNKNOWN_COMMAND NOPE" {
+		t.Errorf("Expected an error table, got %v (%v)", got, err)
+	}
+	if _, err := run(t, "cacheflow.call('NOPE')", env); err == nil || err.Error() != "UNKNOWN_COMMAND NOPE" {
+		t.Errorf("Expected the command error to be raised, got %v", err)
+	}
+	if got, _ := run(t, "return cacheflow.sha1hex('')", env); got != "da39a3ee5e6b4b0d3255bfef95601890afd80709" {
+		t.Errorf("Expected the SHA1 of the empty string, got %v", got)
+	}
+}
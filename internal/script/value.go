@@ -0,0 +1,225 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value is a script value: nil, bool, float64, string, *Table, or a function
+type Value any
+
+// Table is the table type of scripts: an array part holding the keys 1 to n
+// and a hash part holding the others
+type Table struct {
+	arr  []Value
+	hash map[Value]Value
+}
+
+// NewTable returns an empty table
+func NewTable() *Table {
+	return &Table{}
+}
+
+// NewArray returns a table holding values at the keys 1 to len(values)
+func NewArray(values ...Value) *Table {
+	t := &Table{}
+	for i, v := range values {
+		t.Set(float64(i+1), v)
+	}
+	return t
+}
+
+// Get returns the value of key, or nil
+func (t *Table) Get(key Value) Value {
+	if n, ok := key.(float64); ok {
+		if i, ok := arrayIndex(n); ok && i <= len(t.arr) {
+			return t.arr[i-1]
+		}
+	}
+	if t.hash == nil {
+		return nil
+	}
+	return t.hash[key]
+}
+
+// Set sets the value of key; a nil value removes it. Keys must not be nil or NaN.
+func (t *Table) Set(key, value Value) {
+	if n, ok := key.(float64); ok {
+		if i, ok := arrayIndex(n); ok {
+			switch {
+			case i <= len(t.arr):
+				t.arr[i-1] = value
+				for len(t.arr) > 0 && t.arr[len(t.arr)-1] == nil {
+					t.arr = t.arr[:len(t.arr)-1]
+				}
+				return
+			case i == len(t.arr)+1 && value != nil:
+				t.arr = append(t.arr, value)
+				// Keys that were in the hash part now continue the array
+				for t.hash != nil {
+					next := float64(len(t.arr) + 1)
+					v, ok := t.hash[next]
+					if !ok {
+						break
+					}
+					delete(t.hash, next)
+					t.arr = append(t.arr, v)
+				}
+				return
+			}
+		}
+	}
+	if value == nil {
+		delete(t.hash, key)
+		return
+	}
+	if t.hash == nil {
+		t.hash = make(map[Value]Value)
+	}
+	t.hash[key] = value
+}
+
+// Len returns the length of the array part, as the # operator does
+func (t *Table) Len() int {
+	return len(t.arr)
+}
+
+// keys returns the keys of the table: the array part in order, then the
+// numbers and strings of the hash part sorted, then any other keys
+func (t *Table) keys() []Value {
+	keys := make([]Value, 0, len(t.arr)+len(t.hash))
+	for i := range t.arr {
+		keys = append(keys, float64(i+1))
+	}
+	var numbers []float64
+	var strs []string
+	var others []Value
+	for k := range t.hash {
+		switch k := k.(type) {
+		case float64:
+			numbers = append(numbers, k)
+		case string:
+			strs = append(strs, k)
+		default:
+			others = append(others, k)
+		}
+	}
+	sort.Float64s(numbers)
+	sort.Strings(strs)
+	for _, n := range numbers {
+		keys = append(keys, n)
+	}
+	for _, s := range strs {
+		keys = append(keys, s)
+	}
+	return append(keys, others...)
+}
+
+// arrayIndex converts a number to an array index if it is a positive integer
+func arrayIndex(n float64) (int, bool) {
+	if n >= 1 && n <= math.MaxInt32 && n == math.Trunc(n) {
+		return int(n), true
+	}
+	return 0, false
+}
+
+// function is a function defined by a script
+type function struct {
+	def *funcExpr
+	env *scope
+}
+
+// goFunction is a function provided by the host
+type goFunction struct {
+	name string
+	fn   func(it *interp, args []Value) ([]Value, error)
+}
+
+// typeName returns the type of a value as type() reports it
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *function, *goFunction:
+		return "function"
+	}
+	return "userdata"
+}
+
+// truthy reports whether a value counts as true: anything but nil and false
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// FormatNumber formats a number the way tostring does: integers without a
+// fractional part
+func FormatNumber(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	case n == math.Trunc(n) && math.Abs(n) < 1e15:
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// ToString converts a value to a string as tostring does
+func ToString(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return FormatNumber(v)
+	case string:
+		return v
+	case *function:
+		return fmt.Sprintf("function: %p", v)
+	case *goFunction:
+		return "builtin: " + v.name
+	}
+	return fmt.Sprintf("%s: %p", typeName(v), v)
+}
+
+// toNumber converts a number, or a string holding one, to a number
+func toNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			n, err := strconv.ParseUint(s[2:], 16, 64)
+			return float64(n), err == nil
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || strings.ContainsAny(s, "nN") {
+			// Rejects inf, nan and the like, which Lua does not read as numbers
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
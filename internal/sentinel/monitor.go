@@ -0,0 +1,386 @@
+package sentinel
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"CacheFlow/internal/protocol"
+)
+
+// monitor probes every instance on each CheckInterval and fails over when the primary is down
+func (s *Sentinel) monitor() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.probeAll()
+		s.helloPeers()
+
+		s.mu.Lock()
+		force := s.forceFailover
+		s.forceFailover = false
+		s.mu.Unlock()
+
+		if force {
+			s.startFailover(true)
+		} else if s.primaryDown() {
+			s.startFailover(false)
+		} else {
+			s.mu.Lock()
+			s.electionAt = time.Time{}
+			s.mu.Unlock()
+			s.reconcile(false)
+		}
+	}
+}
+
+// requestTimeout bounds a single exchange with an instance or peer
+func (s *Sentinel) requestTimeout() time.Duration {
+	if timeout := s.cfg.DownAfter / 2; timeout < time.Second {
+		return timeout
+	}
+	return time.Second
+}
+
+// query sends commands to addr over a new connection, using TLS if tlsConfig
+// is set, and returns their replies
+func query(addr string, timeout time.Duration, tlsConfig *tls.Config, cmds ...string) ([]string, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: timeout}
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	reader := bufio.NewReader(conn)
+	replies := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			return nil, err
+		}
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, strings.TrimSpace(reply))
+	}
+	return replies, nil
+}
+
+// queryInstance sends commands to a monitored server, over TLS and logging in
+// first if configured, and returns the replies to cmds
+func (s *Sentinel) queryInstance(addr string, cmds ...string) ([]string, error) {
+	if s.cfg.AuthPassword == "" {
+		return query(addr, s.requestTimeout(), s.cfg.TLSConfig, cmds...)
+	}
+	auth := "AUTH " + s.cfg.AuthPassword
+	if s.cfg.AuthUser != "" {
+		auth = fmt.Sprintf("AUTH %s %s", s.cfg.AuthUser, s.cfg.AuthPassword)
+	}
+	replies, err := query(addr, s.requestTimeout(), s.cfg.TLSConfig, append([]string{auth}, cmds...)...)
+	if err != nil {
+		return nil, err
+	}
+	if replies[0] != "OK" {
+		return nil, fmt.Errorf("authentication to %s failed: %s", addr, replies[0])
+	}
+	return replies[1:], nil
+}
+
+// probeAll asks every instance for its role, and the primary for its replicas
+func (s *Sentinel) probeAll() {
+	s.mu.Lock()
+	primary := s.primary
+	addrs := make([]string, 0, len(s.instances))
+	for addr := range s.instances {
+		addrs = append(addrs, addr)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			s.probe(addr, addr == primary)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// probe updates the state of one instance. Replicas listed by the primary are added to the monitored set.
+func (s *Sentinel) probe(addr string, isPrimary bool) {
+	cmds := []string{"ROLE"}
+	if isPrimary {
+		cmds = append(cmds, "REPLICAS")
+	}
+	replies, err := s.queryInstance(addr, cmds...)
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(replies[0])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inst, ok := s.instances[addr]
+	if !ok {
+		return
+	}
+	switch {
+	case len(fields) == 4 && fields[0] == "primary":
+		inst.role, inst.primaryAddr, inst.linkState = "primary", "", ""
+		inst.offset, _ = strconv.ParseInt(fields[2], 10, 64)
+	case len(fields) == 4 && fields[0] == "replica":
+		inst.role, inst.primaryAddr, inst.linkState = "replica", fields[1], fields[2]
+		inst.offset, _ = strconv.ParseInt(fields[3], 10, 64)
+	default:
+		log.Printf("Unexpected ROLE reply from %s: %s", addr, replies[0])
+		return
+	}
+	if !inst.lastOK.IsZero() && s.isDown(inst) {
+		log.Printf("Instance %s is reachable again", addr)
+	}
+	inst.lastOK = time.Now()
+
+	if len(replies) > 1 && !protocol.IsError(replies[1]) {
+		for _, entry := range strings.Split(replies[1], ",") {
+			if fields := strings.Fields(entry); len(fields) == 2 {
+				if _, ok := s.instances[fields[0]]; !ok {
+					log.Printf("Discovered replica %s of %s", fields[0], addr)
+					s.addInstance(fields[0])
+				}
+			}
+		}
+	}
+}
+
+// primaryDown reports whether the current primary is subjectively down
+func (s *Sentinel) primaryDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isDown(s.instances[s.primary])
+}
+
+// reconcile points every reachable instance that is not replicating from the
+// current primary at it. Unless force is set it waits for DownAfter after
+// startup, so peers get a chance to announce a newer primary first, and does
+// nothing while the primary is down.
+func (s *Sentinel) reconcile(force bool) {
+	s.mu.Lock()
+	if !force && (time.Since(s.started) < s.cfg.DownAfter || s.isDown(s.instances[s.primary])) {
+		s.mu.Unlock()
+		return
+	}
+	primary := s.primary
+	var stale []string
+	for addr, inst := range s.instances {
+		if addr == primary || inst.role == "" || s.isDown(inst) {
+			continue
+		}
+		if inst.role == "primary" || inst.primaryAddr != primary {
+			stale = append(stale, addr)
+		}
+	}
+	s.mu.Unlock()
+
+	host, port, err := net.SplitHostPort(primary)
+	if err != nil {
+		log.Printf("Invalid primary address %s: %v", primary, err)
+		return
+	}
+	for _, addr := range stale {
+		log.Printf("Reconfiguring %s to replicate from %s", addr, primary)
+		replies, err := s.queryInstance(addr, fmt.Sprintf("REPLICAOF %s %s", host, port))
+		if err != nil || replies[0] != "OK" {
+			log.Printf("Failed to reconfigure %s: %v %v", addr, err, replies)
+			continue
+		}
+		s.mu.Lock()
+		if inst, ok := s.instances[addr]; ok {
+			inst.role, inst.primaryAddr = "replica", primary
+		}
+		s.mu.Unlock()
+	}
+}
+
+// askPeers sends a command to every peer in parallel. Peers that fail to answer get an empty reply.
+func (s *Sentinel) askPeers(cmd string) []string {
+	replies := make([]string, len(s.cfg.Peers))
+	var wg sync.WaitGroup
+	for i, peer := range s.cfg.Peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			if r, err := query(peer, s.requestTimeout(), nil, cmd); err == nil {
+				replies[i] = r[0]
+			}
+		}(i, peer)
+	}
+	wg.Wait()
+	return replies
+}
+
+// helloPeers announces the current primary and the epoch it was elected in
+func (s *Sentinel) helloPeers() {
+	s.mu.Lock()
+	cmd := fmt.Sprintf("SENTINEL HELLO %s %s %d %s", s.cfg.Name, s.primary, s.configEpoch, s.id)
+	s.mu.Unlock()
+	s.askPeers(cmd)
+}
+
+// startFailover checks that enough sentinels agree the primary is down, asks
+// the peers to elect this sentinel for a new epoch and, if it wins, promotes a
+// replica. A forced failover skips the agreement and the election.
+func (s *Sentinel) startFailover(force bool) {
+	s.mu.Lock()
+	if !force && time.Now().Before(s.failoverAfter) {
+		s.mu.Unlock()
+		return
+	}
+	primary := s.primary
+	s.mu.Unlock()
+
+	if !force {
+		agreed := 1
+		for _, reply := range s.askPeers(fmt.Sprintf("SENTINEL IS-PRIMARY-DOWN %s %s 0 *", s.cfg.Name, primary)) {
+			if strings.HasPrefix(reply, "1 ") {
+				agreed++
+			}
+		}
+		if agreed < s.cfg.Quorum {
+			return
+		}
+
+		s.mu.Lock()
+		if s.electionAt.IsZero() {
+			log.Printf("Primary %s is down according to %d sentinels", primary, agreed)
+			s.electionAt = time.Now().Add(time.Duration(rand.Int63n(int64(4 * s.cfg.CheckInterval))))
+		}
+		wait := time.Now().Before(s.electionAt)
+		s.mu.Unlock()
+		if wait {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.electionAt = time.Time{}
+	s.currentEpoch++
+	epoch := s.currentEpoch
+	s.votedEpoch, s.votedFor = epoch, s.id
+	// Randomize the retry delay so that competing candidates don't keep splitting the vote
+	s.failoverAfter = time.Now().Add(s.cfg.FailoverTimeout + time.Duration(rand.Int63n(int64(s.cfg.FailoverTimeout)/2+1)))
+	s.save()
+	s.mu.Unlock()
+
+	if !force {
+		votes := 1
+		cmd := fmt.Sprintf("SENTINEL IS-PRIMARY-DOWN %s %s %d %s", s.cfg.Name, primary, epoch, s.id)
+		for _, reply := range s.askPeers(cmd) {
+			fields := strings.Fields(reply)
+			if len(fields) != 3 {
+				continue
+			}
+			votedEpoch, _ := strconv.ParseInt(fields[2], 10, 64)
+			if fields[1] == s.id && votedEpoch == epoch {
+				votes++
+			}
+		}
+		needed := (len(s.cfg.Peers)+1)/2 + 1
+		if s.cfg.Quorum > needed {
+			needed = s.cfg.Quorum
+		}
+		if votes < needed {
+			log.Printf("Lost the election for epoch %d with %d of %d votes", epoch, votes, needed)
+			return
+		}
+		log.Printf("Elected to fail over %s in epoch %d with %d votes", primary, epoch, votes)
+	}
+
+	s.failover(primary, epoch)
+}
+
+// failover promotes the best replica of primary and points the others at it
+func (s *Sentinel) failover(primary string, epoch int64) {
+	candidate, ok := s.pickReplica(primary)
+	if !ok {
+		log.Printf("Failover of %s aborted: no replica is available", primary)
+		return
+	}
+
+	log.Printf("Promoting %s to primary", candidate)
+	replies, err := s.queryInstance(candidate, "REPLICAOF NO ONE", "ROLE")
+	if err != nil {
+		log.Printf("Failed to promote %s: %v", candidate, err)
+		return
+	}
+	if replies[0] != "OK" || !strings.HasPrefix(replies[1], "primary ") {
+		log.Printf("Failed to promote %s: %s, %s", candidate, replies[0], replies[1])
+		return
+	}
+
+	s.mu.Lock()
+	if s.configEpoch >= epoch {
+		s.mu.Unlock()
+		log.Printf("Failover in epoch %d superseded by epoch %d", epoch, s.configEpoch)
+		return
+	}
+	s.setPrimary(candidate, epoch)
+	s.instances[candidate].role = "primary"
+	s.save()
+	s.mu.Unlock()
+	log.Printf("Failover complete: %s is the primary of %s (epoch %d)", candidate, s.cfg.Name, epoch)
+
+	s.helloPeers()
+	s.reconcile(true)
+}
+
+// pickReplica chooses the replica of primary to promote: among those answering
+// probes, the one with the highest replication offset, ties broken by address
+func (s *Sentinel) pickReplica(primary string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var candidates []*instance
+	for addr, inst := range s.instances {
+		if addr == primary || inst.role != "replica" || inst.primaryAddr != primary || s.isDown(inst) {
+			continue
+		}
+		candidates = append(candidates, inst)
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].offset != candidates[j].offset {
+			return candidates[i].offset > candidates[j].offset
+		}
+		return candidates[i].addr < candidates[j].addr
+	})
+	return candidates[0].addr, true
+}
@@ -0,0 +1,414 @@
+// Package sentinel implements a monitor that watches a primary and its
+// replicas, agrees with peer sentinels that the primary is down, and fails
+// over to the most up to date replica. Clients ask a sentinel for the address
+// of the current primary.
+package sentinel
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"CacheFlow/internal/protocol"
+)
+
+// Config holds the settings a Sentinel is created with
+type Config struct {
+	// Addr is the TCP address the sentinel answers clients and peers on
+	Addr string
+	// Name identifies the monitored group; clients ask for its primary by name
+	Name string
+	// PrimaryAddr is the address of the primary when the sentinel starts for the first time
+	PrimaryAddr string
+	// Peers are the addresses of the other sentinels monitoring the same group
+	Peers []string
+	// Quorum is the number of sentinels that must consider the primary down
+	// before a failover starts; defaults to a majority of all sentinels
+	Quorum int
+	// DownAfter is how long the primary may fail to answer before it is considered down
+	DownAfter time.Duration
+	// FailoverTimeout is the minimum time between two failover attempts
+	FailoverTimeout time.Duration
+	// CheckInterval is how often every instance is probed
+	CheckInterval time.Duration
+	// StateFile is where the current primary and epochs are persisted; empty disables it
+	StateFile string
+	// AuthUser and AuthPassword log in to the monitored servers; an empty
+	// password skips authentication
+	AuthUser     string
+	AuthPassword string
+	// TLSConfig enables TLS for connections to the monitored servers; peer
+	// sentinels are always reached in plain text
+	TLSConfig *tls.Config
+}
+
+// DefaultConfig returns a configuration with the default timings
+func DefaultConfig(addr, name, primaryAddr string) Config {
+	return Config{
+		Addr:            addr,
+		Name:            name,
+		PrimaryAddr:     primaryAddr,
+		DownAfter:       5 * time.Second,
+		FailoverTimeout: 30 * time.Second,
+		CheckInterval:   time.Second,
+	}
+}
+
+// instance is the sentinel's view of a monitored server
+type instance struct {
+	addr string
+	// role, primaryAddr, linkState and offset are taken from the last ROLE reply
+	role        string
+	primaryAddr string
+	linkState   string
+	offset      int64
+	lastOK      time.Time
+}
+
+// Sentinel monitors one primary and its replicas
+type Sentinel struct {
+	cfg      Config
+	id       string
+	listener net.Listener
+	started  time.Time
+
+	mu           sync.Mutex
+	primary      string
+	configEpoch  int64 // epoch of the failover that elected the current primary
+	currentEpoch int64 // highest epoch seen in elections
+	votedEpoch   int64
+	votedFor     string
+	instances    map[string]*instance
+	// failoverAfter delays failover attempts after an election this sentinel took part in
+	failoverAfter time.Time
+	// electionAt is when this sentinel will ask for votes once the primary is
+	// objectively down; randomized so that sentinels rarely ask at the same time
+	electionAt    time.Time
+	forceFailover bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a sentinel from a Config, restoring the state file if there is one
+func New(cfg Config) (*Sentinel, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("a primary name is required")
+	}
+	if cfg.PrimaryAddr == "" {
+		return nil, fmt.Errorf("a primary address is required")
+	}
+	if strings.ContainsAny(cfg.Name, " \t") {
+		return nil, fmt.Errorf("invalid primary name %q", cfg.Name)
+	}
+	if cfg.Quorum <= 0 {
+		cfg.Quorum = (len(cfg.Peers)+1)/2 + 1
+	}
+	if cfg.Quorum > len(cfg.Peers)+1 {
+		return nil, fmt.Errorf("quorum %d exceeds the number of sentinels (%d)", cfg.Quorum, len(cfg.Peers)+1)
+	}
+	defaults := DefaultConfig("", "", "")
+	if cfg.DownAfter <= 0 {
+		cfg.DownAfter = defaults.DownAfter
+	}
+	if cfg.FailoverTimeout <= 0 {
+		cfg.FailoverTimeout = defaults.FailoverTimeout
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaults.CheckInterval
+	}
+
+	s := &Sentinel{
+		cfg:       cfg,
+		id:        newID(),
+		primary:   cfg.PrimaryAddr,
+		instances: make(map[string]*instance),
+		done:      make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.addInstance(s.primary)
+	s.save()
+	log.Printf("Sentinel %s monitoring %s at %s (quorum %d of %d)", s.id, cfg.Name, s.primary, cfg.Quorum, len(cfg.Peers)+1)
+	return s, nil
+}
+
+// newID generates a random 40 character sentinel ID
+func newID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic("sentinel: failed to generate ID: " + err.Error())
+	}
+	return hex.EncodeToString(id)
+}
+
+// ID returns the sentinel's ID
+func (s *Sentinel) ID() string {
+	return s.id
+}
+
+// Primary returns the address of the current primary
+func (s *Sentinel) Primary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.primary
+}
+
+// Listen binds the sentinel's listener without serving yet
+func (s *Sentinel) Listen() error {
+	listener, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start listener: %w", err)
+	}
+	s.listener = listener
+	return nil
+}
+
+// Addr returns the address the sentinel is listening on, or nil before Listen
+func (s *Sentinel) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Start listens and serves until Close is called
+func (s *Sentinel) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Serve monitors the group and accepts connections on the listener bound by Listen
+func (s *Sentinel) Serve() error {
+	log.Printf("Sentinel listening on %s", s.listener.Addr())
+	s.started = time.Now()
+
+	s.wg.Add(1)
+	go s.monitor()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			log.Printf("Error accepting connection: %v", err)
+			continue
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// Close stops monitoring and closes the listener
+func (s *Sentinel) Close() error {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return nil
+	default:
+		close(s.done)
+	}
+	s.mu.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+// handleConnection answers commands from clients and peer sentinels
+func (s *Sentinel) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		cmd, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		response := s.handleCommand(strings.TrimSpace(cmd))
+		if _, err := writer.WriteString(response + "\n"); err != nil {
+			return
+		}
+		writer.Flush()
+	}
+}
+
+// handleCommand processes a single command and returns the response
+func (s *Sentinel) handleCommand(cmd string) string {
+	parts := strings.Fields(cmd)
+	if len(parts) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "Empty command")
+	}
+
+	switch strings.ToUpper(parts[0]) {
+	case "PING":
+		return "PONG"
+	case "SENTINEL":
+		if len(parts) < 3 {
+			return protocol.Error(protocol.CodeSyntax, "SENTINEL requires a subcommand and a primary name")
+		}
+		if parts[2] != s.cfg.Name {
+			return protocol.Errorf(protocol.CodeErr, "No such primary %s", parts[2])
+		}
+		return s.handleSentinelCommand(strings.ToUpper(parts[1]), parts[3:])
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown command")
+	}
+}
+
+// handleSentinelCommand processes "SENTINEL <subcommand> <name> [args...]"
+func (s *Sentinel) handleSentinelCommand(sub string, args []string) string {
+	switch sub {
+	case "PRIMARY":
+		return s.Primary()
+
+	case "REPLICAS":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var entries []string
+		for _, inst := range s.sortedInstances() {
+			if inst.addr == s.primary {
+				continue
+			}
+			state := "up"
+			if s.isDown(inst) {
+				state = "down"
+			}
+			entries = append(entries, fmt.Sprintf("%s %s %d", inst.addr, state, inst.offset))
+		}
+		return strings.Join(entries, ", ")
+
+	case "SENTINELS":
+		return strings.Join(s.cfg.Peers, ", ")
+
+	case "IS-PRIMARY-DOWN":
+		if len(args) != 3 {
+			return protocol.Error(protocol.CodeSyntax, "IS-PRIMARY-DOWN requires address, epoch and candidate ID")
+		}
+		epoch, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return protocol.Error(protocol.CodeSyntax, "invalid epoch")
+		}
+		return s.isPrimaryDown(args[0], epoch, args[2])
+
+	case "HELLO":
+		if len(args) != 3 {
+			return protocol.Error(protocol.CodeSyntax, "HELLO requires primary address, config epoch and sentinel ID")
+		}
+		epoch, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return protocol.Error(protocol.CodeSyntax, "invalid epoch")
+		}
+		s.hello(args[0], epoch, args[2])
+		return "OK"
+
+	case "FAILOVER":
+		s.mu.Lock()
+		s.forceFailover = true
+		s.mu.Unlock()
+		return "OK"
+
+	default:
+		return protocol.Errorf(protocol.CodeUnknownCommand, "Unknown SENTINEL subcommand %s", sub)
+	}
+}
+
+// isPrimaryDown answers a peer asking whether addr is down. A candidate ID
+// other than "*" also asks for this sentinel's vote in epoch, which is given
+// to the first candidate asking in each epoch. The reply is
+// "<down 0|1> <voted leader|*> <voted epoch>".
+func (s *Sentinel) isPrimaryDown(addr string, epoch int64, candidate string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	down := 0
+	if inst, ok := s.instances[addr]; ok && addr == s.primary && s.isDown(inst) {
+		down = 1
+	}
+	if candidate != "*" {
+		if epoch > s.currentEpoch {
+			s.currentEpoch = epoch
+		}
+		if epoch > s.votedEpoch {
+			s.votedEpoch, s.votedFor = epoch, candidate
+			// Give the candidate time to fail over before starting an election of our own
+			s.failoverAfter = time.Now().Add(s.cfg.FailoverTimeout)
+			log.Printf("Voted for sentinel %s in epoch %d", candidate, epoch)
+		}
+		s.save()
+	}
+
+	leader := s.votedFor
+	if leader == "" {
+		leader = "*"
+	}
+	return fmt.Sprintf("%d %s %d", down, leader, s.votedEpoch)
+}
+
+// hello adopts the primary announced by a peer if it was elected in a newer epoch
+func (s *Sentinel) hello(addr string, epoch int64, from string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+	}
+	if epoch > s.configEpoch && addr != s.primary {
+		log.Printf("Switching primary from %s to %s (epoch %d, announced by sentinel %s)", s.primary, addr, epoch, from)
+		s.setPrimary(addr, epoch)
+	} else if epoch > s.configEpoch {
+		s.configEpoch = epoch
+	}
+	s.save()
+}
+
+// setPrimary records a new primary. The old one stays monitored so it can be
+// reconfigured as a replica when it comes back. The caller must hold s.mu.
+func (s *Sentinel) setPrimary(addr string, epoch int64) {
+	s.primary = addr
+	s.configEpoch = epoch
+	s.addInstance(addr)
+	s.instances[addr].lastOK = time.Now()
+}
+
+// addInstance starts monitoring addr if it is not monitored yet. The caller
+// must hold s.mu, except during New.
+func (s *Sentinel) addInstance(addr string) {
+	if _, ok := s.instances[addr]; !ok {
+		s.instances[addr] = &instance{addr: addr, lastOK: time.Now()}
+	}
+}
+
+// isDown reports whether an instance has failed to answer for DownAfter. The caller must hold s.mu.
+func (s *Sentinel) isDown(inst *instance) bool {
+	return time.Since(inst.lastOK) > s.cfg.DownAfter
+}
+
+// sortedInstances returns the monitored instances by address. The caller must hold s.mu.
+func (s *Sentinel) sortedInstances() []*instance {
+	instances := make([]*instance, 0, len(s.instances))
+	for _, inst := range s.instances {
+		instances = append(instances, inst)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].addr < instances[j].addr })
+	return instances
+}
@@ -0,0 +1,243 @@
+package sentinel
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+	"CacheFlow/internal/server"
+)
+
+// startNode starts a server on a random localhost port, optionally replicating from primary
+func startNode(t *testing.T, primary string) *server.Server {
+	cfg := server.DefaultConfig("127.0.0.1:0")
+	cfg.AOFFilename = ""
+	cfg.ReplicaOf = primary
+	srv, err := server.NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve()
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// reserveAddrs returns n free localhost addresses
+func reserveAddrs(t *testing.T, n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to reserve port: %v", err)
+		}
+		addrs[i] = listener.Addr().String()
+		listener.Close()
+	}
+	return addrs
+}
+
+// startSentinels starts n sentinels monitoring primary that know each other
+func startSentinels(t *testing.T, n int, primary string) ([]*Sentinel, []string) {
+	addrs := reserveAddrs(t, n)
+	sentinels := make([]*Sentinel, n)
+	for i, addr := range addrs {
+		cfg := DefaultConfig(addr, "cache", primary)
+		for j, peer := range addrs {
+			if j != i {
+				cfg.Peers = append(cfg.Peers, peer)
+			}
+		}
+		cfg.DownAfter = 400 * time.Millisecond
+		cfg.FailoverTimeout = 2 * time.Second
+		cfg.CheckInterval = 100 * time.Millisecond
+
+		s, err := New(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create sentinel: %v", err)
+		}
+		if err := s.Listen(); err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		go s.Serve()
+		t.Cleanup(func() { s.Close() })
+		sentinels[i] = s
+	}
+	return sentinels, addrs
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// do sends a single command to addr
+func do(t *testing.T, addr, cmd string) string {
+	ctx := context.Background()
+	c, err := client.New(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", addr, err)
+	}
+	defer c.Close()
+	response, err := c.Do(ctx, cmd)
+	if err != nil {
+		t.Fatalf("%s on %s failed: %v", cmd, addr, err)
+	}
+	return response
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	primary := startNode(t, "")
+	primaryAddr := primary.Addr().String()
+	replica1 := startNode(t, primaryAddr)
+	replica2 := startNode(t, primaryAddr)
+	replicaAddrs := map[string]bool{replica1.Addr().String(): true, replica2.Addr().String(): true}
+
+	sentinels, sentinelAddrs := startSentinels(t, 3, primaryAddr)
+
+	c, err := client.NewSentinelClient("cache", sentinelAddrs...)
+	if err != nil {
+		t.Fatalf("Failed to connect through sentinels: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(ctx, "key", "before", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// Every sentinel must learn about both replicas before the primary fails
+	waitFor(t, 5*time.Second, "replica discovery", func() bool {
+		for _, s := range sentinels {
+			reply := s.handleCommand("SENTINEL REPLICAS cache")
+			if strings.Count(reply, " up ") != 2 {
+				return false
+			}
+		}
+		return true
+	})
+	waitFor(t, 5*time.Second, "replication", func() bool {
+		for addr := range replicaAddrs {
+			if do(t, addr, "GET key") != "before" {
+				return false
+			}
+		}
+		return true
+	})
+
+	primary.Close()
+
+	var promoted string
+	waitFor(t, 10*time.Second, "failover", func() bool {
+		promoted = sentinels[0].Primary()
+		for _, s := range sentinels {
+			if s.Primary() != promoted || !replicaAddrs[promoted] {
+				return false
+			}
+		}
+		return true
+	})
+	if role := do(t, promoted, "ROLE"); !strings.HasPrefix(role, "primary ") {
+		t.Errorf("Expected %s to be promoted, got role %q", promoted, role)
+	}
+
+	for addr := range replicaAddrs {
+		if addr == promoted {
+			continue
+		}
+		waitFor(t, 5*time.Second, "replica reconfiguration", func() bool {
+			return strings.HasPrefix(do(t, addr, "ROLE"), "replica "+promoted+" ")
+		})
+	}
+
+	// The client follows the primary and keeps the data written before the failover
+	if value, err := c.Get(ctx, "key"); err != nil || value != "before" {
+		t.Errorf("Expected value before, got %q (%v)", value, err)
+	}
+	if err := c.Set(ctx, "key", "after", 0); err != nil {
+		t.Fatalf("Set after failover failed: %v", err)
+	}
+	if addr, err := client.DiscoverPrimary(ctx, "cache", sentinelAddrs...); err != nil || addr != promoted {
+		t.Errorf("Expected discovered primary %s, got %s (%v)", promoted, addr, err)
+	}
+}
+
+func TestNoFailoverWithoutQuorum(t *testing.T) {
+	primary := startNode(t, "")
+	primaryAddr := primary.Addr().String()
+	startNode(t, primaryAddr)
+
+	// A single sentinel that requires two votes can never fail over on its own
+	addrs := reserveAddrs(t, 2)
+	cfg := DefaultConfig(addrs[0], "cache", primaryAddr)
+	cfg.Peers = []string{addrs[1]}
+	cfg.Quorum = 2
+	cfg.DownAfter = 200 * time.Millisecond
+	cfg.CheckInterval = 50 * time.Millisecond
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sentinel: %v", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	primary.Close()
+	time.Sleep(time.Second)
+	if got := s.Primary(); got != primaryAddr {
+		t.Errorf("Expected primary to stay %s, got %s", primaryAddr, got)
+	}
+}
+
+func TestVoteOncePerEpoch(t *testing.T) {
+	s, err := New(DefaultConfig("127.0.0.1:0", "cache", "127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("Failed to create sentinel: %v", err)
+	}
+
+	if reply := s.handleCommand("SENTINEL IS-PRIMARY-DOWN cache 127.0.0.1:1 1 a"); reply != "0 a 1" {
+		t.Errorf("Expected vote for a, got %q", reply)
+	}
+	if reply := s.handleCommand("SENTINEL IS-PRIMARY-DOWN cache 127.0.0.1:1 1 b"); reply != "0 a 1" {
+		t.Errorf("Expected vote to stay with a, got %q", reply)
+	}
+	if reply := s.handleCommand("SENTINEL IS-PRIMARY-DOWN cache 127.0.0.1:1 2 b"); reply != "0 b 2" {
+		t.Errorf("Expected vote for b in epoch 2, got %q", reply)
+	}
+	if reply := s.handleCommand("SENTINEL PRIMARY other"); !strings.HasPrefix(reply, "ERROR") {
+		t.Errorf("Expected error for unknown primary name, got %q", reply)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	cfg := DefaultConfig("127.0.0.1:0", "cache", "127.0.0.1:1")
+	cfg.StateFile = t.TempDir() + "/sentinel.json"
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sentinel: %v", err)
+	}
+	s.handleCommand("SENTINEL HELLO cache 127.0.0.1:2 3 peer")
+
+	restarted, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to restart sentinel: %v", err)
+	}
+	if restarted.ID() != s.ID() {
+		t.Errorf("Expected ID %s after restart, got %s", s.ID(), restarted.ID())
+	}
+	if got := restarted.Primary(); got != "127.0.0.1:2" {
+		t.Errorf("Expected primary 127.0.0.1:2 after restart, got %s", got)
+	}
+}
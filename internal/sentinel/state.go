@@ -0,0 +1,88 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// savedState is the on-disk form of a sentinel's state
+type savedState struct {
+	ID           string   `json:"id"`
+	Primary      string   `json:"primary"`
+	ConfigEpoch  int64    `json:"config_epoch"`
+	CurrentEpoch int64    `json:"current_epoch"`
+	VotedEpoch   int64    `json:"voted_epoch"`
+	VotedFor     string   `json:"voted_for,omitempty"`
+	Instances    []string `json:"instances,omitempty"`
+}
+
+// load restores the state file, if any. A restarted sentinel keeps its ID,
+// the primary it last agreed on, and its votes.
+func (s *Sentinel) load() error {
+	if s.cfg.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.cfg.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sentinel state %s: %w", s.cfg.StateFile, err)
+	}
+
+	var saved savedState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("invalid sentinel state %s: %w", s.cfg.StateFile, err)
+	}
+	if saved.ID != "" {
+		s.id = saved.ID
+	}
+	if saved.Primary != "" {
+		s.primary = saved.Primary
+	}
+	s.configEpoch = saved.ConfigEpoch
+	s.currentEpoch = saved.CurrentEpoch
+	s.votedEpoch = saved.VotedEpoch
+	s.votedFor = saved.VotedFor
+	for _, addr := range saved.Instances {
+		s.addInstance(addr)
+	}
+	log.Printf("Loaded sentinel state %s: primary %s, epoch %d", s.cfg.StateFile, s.primary, s.configEpoch)
+	return nil
+}
+
+// save writes the state file. The caller must hold s.mu. Failures are logged,
+// since the in-memory state stays valid and the next change retries.
+func (s *Sentinel) save() {
+	if s.cfg.StateFile == "" {
+		return
+	}
+
+	saved := savedState{
+		ID:           s.id,
+		Primary:      s.primary,
+		ConfigEpoch:  s.configEpoch,
+		CurrentEpoch: s.currentEpoch,
+		VotedEpoch:   s.votedEpoch,
+		VotedFor:     s.votedFor,
+	}
+	for _, inst := range s.sortedInstances() {
+		saved.Instances = append(saved.Instances, inst.addr)
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode sentinel state: %v", err)
+		return
+	}
+	tmp := s.cfg.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Failed to write sentinel state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.cfg.StateFile); err != nil {
+		log.Printf("Failed to replace sentinel state: %v", err)
+	}
+}
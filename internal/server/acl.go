@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"CacheFlow/internal/acl"
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/replication"
+)
+
+// loadUsers reads the ACL file, if there is one, and applies RequirePass to the default user
+func (s *Server) loadUsers(requirePass string) error {
+	if s.aclFile != "" {
+		err := s.acl.Load(s.aclFile)
+		switch {
+		case err == nil:
+			s.log.Info("Loaded ACL users", "file", s.aclFile, "users", len(s.acl.Users()))
+		case errors.Is(err, os.ErrNotExist):
+			s.log.Info("ACL file does not exist yet, starting with the default user", "file", s.aclFile)
+		default:
+			return err
+		}
+	}
+	if requirePass != "" {
+		return s.acl.SetUser(acl.DefaultUser, "resetpass", ">"+requirePass)
+	}
+	return nil
+}
+
+// newReplica creates a replica of addr that connects with the server's credentials and TLS settings
+func (s *Server) newReplica(addr string) *replication.Replica {
+	replica := replication.NewReplica(addr, s.store, s.primary.Backlog())
+	replica.Auth(s.authUser, s.authPassword)
+	if s.peerTLS != nil {
+		replica.TLS(s.peerTLS)
+	}
+	return replica
+}
+
+// authorize checks that the session is logged in and that its user may run
+// the command on the keys it names. It returns an error reply, or "" if the
+// command may run.
+func (s *Server) authorize(sess *session, command string, parts []string) string {
+	if sess.user == "" {
+		return protocol.Error(protocol.CodeNoAuth, "Authentication required")
+	}
+	// Anyone may ask who they are
+	if command == "ACL" && len(parts) == 2 && strings.ToUpper(parts[1]) == "WHOAMI" {
+		return ""
+	}
+	// and look after their own connection
+	if command == "CLIENT" && len(parts) >= 2 && selfServiceClientCommands[strings.ToUpper(parts[1])] {
+		return ""
+	}
+	keys := s.commandKeys(command, parts)
+	if err := s.acl.Check(sess.user, command, keys); err != nil {
+		return protocol.Errorf(protocol.CodeNoPerm, "%v", err)
+	}
+	// Namespaces restrict their keys to their own users on top of the ACL
+	for _, key := range keys {
+		if err := s.store.CheckAccess(sess.user, key); err != nil {
+			return protocol.Errorf(protocol.CodeNoPerm, "%v", err)
+		}
+	}
+	return ""
+}
+
+// handleAuth processes AUTH [user] password. A password alone logs in as the default user.
+func (s *Server) handleAuth(sess *session, args []string) string {
+	var user, password string
+	switch len(args) {
+	case 1:
+		user, password = acl.DefaultUser, args[0]
+	case 2:
+		user, password = args[0], args[1]
+	default:
+		return protocol.Error(protocol.CodeSyntax, "AUTH requires [user] password")
+	}
+	if err := s.acl.Authenticate(user, password); err != nil {
+		return protocol.Errorf(protocol.CodeWrongPass, "%v", err)
+	}
+	sess.user = user
+	if sess.client != nil {
+		sess.client.setUser(user)
+	}
+	return "OK"
+}
+
+// handleACLCommand processes the ACL subcommands:
+//
+//	ACL WHOAMI                  the user of this connection
+//	ACL USERS                   every user name
+//	ACL LIST                    every user as a rule line
+//	ACL GETUSER name            one user as a rule line
+//	ACL SETUSER name [rule...]  create or modify a user
+//	ACL DELUSER name [name...]  remove users
+//	ACL CAT [category]          the command categories, or the commands of one
+//	ACL LOAD                    reload the users from the ACL file
+//	ACL SAVE                    write the users to the ACL file
+func (s *Server) handleACLCommand(sess *session, args []string) string {
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "ACL requires a subcommand")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "WHOAMI":
+		return sess.user
+
+	case "USERS":
+		return strings.Join(s.acl.Users(), ", ")
+
+	case "LIST":
+		return strings.Join(s.acl.List(), ", ")
+
+	case "GETUSER":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "ACL GETUSER requires a user name")
+		}
+		line, ok := s.acl.Describe(args[1])
+		if !ok {
+			return protocol.Nil
+		}
+		return line
+
+	case "SETUSER":
+		if len(args) < 2 {
+			return protocol.Error(protocol.CodeSyntax, "ACL SETUSER requires a user name")
+		}
+		if err := s.acl.SetUser(args[1], args[2:]...); err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return "OK"
+
+	case "DELUSER":
+		if len(args) < 2 {
+			return protocol.Error(protocol.CodeSyntax, "ACL DELUSER requires at least one user name")
+		}
+		deleted, err := s.acl.DelUser(args[1:]...)
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return fmt.Sprintf("%d", deleted)
+
+	case "CAT":
+		if len(args) == 1 {
+			return strings.Join(s.acl.Categories(), ", ")
+		}
+		commands, ok := s.acl.CategoryCommands(strings.ToLower(args[1]))
+		if !ok {
+			return protocol.Errorf(protocol.CodeErr, "Unknown category %s", args[1])
+		}
+		return strings.ToLower(strings.Join(commands, ", "))
+
+	case "LOAD":
+		if s.aclFile == "" {
+			return protocol.Error(protocol.CodeErr, "no ACL file is configured")
+		}
+		if err := s.acl.Load(s.aclFile); err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return "OK"
+
+	case "SAVE":
+		if s.aclFile == "" {
+			return protocol.Error(protocol.CodeErr, "no ACL file is configured")
+		}
+		if err := s.acl.Save(s.aclFile); err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return "OK"
+
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown ACL subcommand")
+	}
+}
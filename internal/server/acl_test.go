@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+)
+
+// startConfiguredNode starts a server on a random localhost port after letting configure adjust its config
+func startConfiguredNode(t *testing.T, configure func(cfg *Config)) *Server {
+	cfg := DefaultConfig("127.0.0.1:0")
+	cfg.AOFFilename = ""
+	configure(&cfg)
+	srv, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve()
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestAuth(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.RequirePass = "secret" })
+	sess := srv.newSession()
+
+	if r := srv.handleCommand(sess, "GET key"); !strings.HasPrefix(r, "ERROR: NOAUTH") {
+		t.Errorf("Expected NOAUTH before AUTH, got %s", r)
+	}
+	if r := srv.handleCommand(sess, "AUTH wrong"); !strings.HasPrefix(r, "ERROR: WRONGPASS") {
+		t.Errorf("Expected WRONGPASS, got %s", r)
+	}
+	if r := srv.handleCommand(sess, "AUTH secret"); r != "OK" {
+		t.Fatalf("Expected AUTH to succeed, got %s", r)
+	}
+	if r := srv.handleCommand(sess, "ACL WHOAMI"); r != "default" {
+		t.Errorf("Expected to be the default user, got %s", r)
+	}
+
+	// Typed client methods report the codes as errors
+	ctx := context.Background()
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, client.ErrNoAuth) {
+		t.Errorf("Expected ErrNoAuth, got %v", err)
+	}
+	if _, err := client.NewWithOptions(srv.Addr().String(), client.Options{Password: "wrong"}); !errors.Is(err, client.ErrWrongPass) {
+		t.Errorf("Expected ErrWrongPass from a client with the wrong password, got %v", err)
+	}
+
+	authed, err := client.NewWithOptions(srv.Addr().String(), client.Options{Password: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to connect with a password: %v", err)
+	}
+	defer authed.Close()
+	if err := authed.Set(ctx, "key", "value", 0); err != nil {
+		t.Errorf("Expected Set to succeed after AUTH, got %v", err)
+	}
+}
+
+func TestACLPermissions(t *testing.T) {
+	ctx := context.Background()
+	srv := startPlainNode(t)
+	admin := srv.newSession()
+
+	for _, cmd := range []string{
+		"ACL SETUSER reader on >pw ~public:* +@read",
+		"ACL SETUSER writer on >pw2 ~* +@read +@write -delete",
+	} {
+		if r := srv.handleCommand(admin, cmd); r != "OK" {
+			t.Fatalf("%s failed: %s", cmd, r)
+		}
+	}
+	if r := srv.handleCommand(admin, "ACL USERS"); r != "default, reader, writer" {
+		t.Errorf("Expected three users, got %s", r)
+	}
+
+	reader, err := client.NewWithOptions(srv.Addr().String(), client.Options{Username: "reader", Password: "pw"})
+	if err != nil {
+		t.Fatalf("Failed to connect as reader: %v", err)
+	}
+	defer reader.Close()
+	writer, err := client.NewWithOptions(srv.Addr().String(), client.Options{Username: "writer", Password: "pw2"})
+	if err != nil {
+		t.Fatalf("Failed to connect as writer: %v", err)
+	}
+	defer writer.Close()
+
+	if err := writer.Set(ctx, "public:1", "value", 0); err != nil {
+		t.Fatalf("Expected writer to be able to SET, got %v", err)
+	}
+	if err := writer.Delete(ctx, "public:1"); !errors.Is(err, client.ErrNoPerm) {
+		t.Errorf("Expected writer to be denied DELETE, got %v", err)
+	}
+	if value, err := reader.Get(ctx, "public:1"); err != nil || value != "value" {
+		t.Errorf("Expected reader to read public:1, got %q (%v)", value, err)
+	}
+	if _, err := reader.Get(ctx, "private"); !errors.Is(err, client.ErrNoPerm) {
+		t.Errorf("Expected reader to be denied a key outside its patterns, got %v", err)
+	}
+	if err := reader.Set(ctx, "public:1", "other", 0); !errors.Is(err, client.ErrNoPerm) {
+		t.Errorf("Expected reader to be denied SET, got %v", err)
+	}
+	if r, err := reader.Do(ctx, "ACL WHOAMI"); err != nil || r != "reader" {
+		t.Errorf("Expected WHOAMI to be allowed for everyone, got %q (%v)", r, err)
+	}
+
+	// Changes apply to connections already logged in
+	if r := srv.handleCommand(admin, "ACL SETUSER reader -get"); r != "OK" {
+		t.Fatalf("ACL SETUSER failed: %s", r)
+	}
+	if _, err := reader.Get(ctx, "public:1"); !errors.Is(err, client.ErrNoPerm) {
+		t.Errorf("Expected GET to be revoked, got %v", err)
+	}
+	if r := srv.handleCommand(admin, "ACL DELUSER reader nobody"); r != "1" {
+		t.Errorf("Expected one user deleted, got %s", r)
+	}
+	if _, err := reader.Exists(ctx, "public:1"); !errors.Is(err, client.ErrNoPerm) {
+		t.Errorf("Expected a deleted user to lose access, got %v", err)
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	path := t.TempDir() + "/users.acl"
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.ACLFile = path })
+	sess := srv.newSession()
+
+	for _, cmd := range []string{"ACL SETUSER app on >pw ~app:* +@all", "ACL SAVE", "ACL DELUSER app", "ACL LOAD"} {
+		if r := srv.handleCommand(sess, cmd); r != "OK" && r != "1" {
+			t.Fatalf("%s failed: %s", cmd, r)
+		}
+	}
+	line := srv.handleCommand(sess, "ACL GETUSER app")
+	if !strings.HasPrefix(line, "user app on #") || !strings.HasSuffix(line, "~app:* +@all") {
+		t.Errorf("Expected app to be reloaded, got %s", line)
+	}
+
+	restarted := startConfiguredNode(t, func(cfg *Config) { cfg.ACLFile = path })
+	if err := restarted.acl.Authenticate("app", "pw"); err != nil {
+		t.Errorf("Expected users to be loaded at startup, got %v", err)
+	}
+}
+
+func TestReplicationWithAuth(t *testing.T) {
+	ctx := context.Background()
+	primary := startConfiguredNode(t, func(cfg *Config) { cfg.RequirePass = "secret" })
+	replica := startConfiguredNode(t, func(cfg *Config) {
+		cfg.ReplicaOf = primary.Addr().String()
+		cfg.RequirePass = "secret"
+		cfg.AuthPassword = "secret"
+	})
+
+	c, err := client.NewWithOptions(primary.Addr().String(), client.Options{Password: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(ctx, "key", "value", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if value, ok := replica.store.Get("key"); ok && value == "value" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the replica to authenticate and sync")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+	"CacheFlow/internal/protocol"
+)
+
+func TestClientReconnects(t *testing.T) {
+	ctx := context.Background()
+	addr, kill := startPongServer(t, "127.0.0.1:0")
+
+	c, err := client.NewWithOptions(addr, client.Options{MaxRetries: 5, MinRetryBackoff: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	// The server restarts on the same address; the next command redials
+	kill()
+	startPongServer(t, addr)
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Expected Ping to succeed after reconnecting, got %v", err)
+	}
+}
+
+func TestClientHonorsDeadline(t *testing.T) {
+	// A server that accepts connections but never replies
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c, err := client.New(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Get(ctx, "key")
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, client.ErrNetwork) {
+		t.Errorf("Expected a network error caused by the deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected Get to give up at the deadline, took %v", elapsed)
+	}
+}
+
+func TestClientServerError(t *testing.T) {
+	ctx := context.Background()
+	srv := startPlainNode(t)
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.Get(ctx, "two words")
+	var serverErr *client.ServerError
+	if !errors.Is(err, client.ErrServer) || errors.Is(err, client.ErrNetwork) || !errors.As(err, &serverErr) {
+		t.Fatalf("Expected a server error, got %v", err)
+	}
+	if !errors.Is(err, client.ErrSyntax) || errors.Is(err, client.ErrWrongType) {
+		t.Errorf("Expected a SYNTAX error, got %v", err)
+	}
+	if serverErr.Code != protocol.CodeSyntax || serverErr.Message != "GET requires key" {
+		t.Errorf("Expected SYNTAX %q, got %s %q", "GET requires key", serverErr.Code, serverErr.Message)
+	}
+	// Do returns error replies as plain lines
+	if reply, err := c.Do(ctx, "GET"); err != nil || reply != "ERROR: SYNTAX GET requires key" {
+		t.Errorf("Expected the raw error reply, got %q (%v)", reply, err)
+	}
+}
+
+func TestValuesLookingLikeReplies(t *testing.T) {
+	ctx := context.Background()
+	srv := startPlainNode(t)
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	for _, value := range []string{"ERROR: SYNTAX not really", "NIL", `\escaped`, "plain"} {
+		if err := c.Set(ctx, "key", value, 0); err != nil {
+			t.Fatalf("Set %q failed: %v", value, err)
+		}
+		if got, err := c.Get(ctx, "key"); err != nil || got != value {
+			t.Errorf("Expected value %q, got %q (%v)", value, got, err)
+		}
+	}
+	if reply, _ := c.Do(ctx, "GET key"); reply != "plain" {
+		t.Errorf("Expected ordinary values unescaped on the wire, got %q", reply)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cacheflow.sock")
+
+	// A socket file left behind by a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.UnixSocket = path
+		cfg.UnixSocketPerm = 0700
+	})
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the socket to have mode 0700, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	// Both listeners serve the same store
+	local, err := client.New("unix://" + path)
+	if err != nil {
+		t.Fatalf("Failed to connect over the Unix socket: %v", err)
+	}
+	defer local.Close()
+	if err := local.Set(ctx, "key", "value", 0); err != nil {
+		t.Fatalf("Set over the Unix socket failed: %v", err)
+	}
+	remote, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect over TCP: %v", err)
+	}
+	defer remote.Close()
+	if value, err := remote.Get(ctx, "key"); err != nil || value != "value" {
+		t.Errorf("Expected value over TCP, got %q (%v)", value, err)
+	}
+
+	// A socket in use is not taken over
+	other, err := NewWithConfig(Config{UnixSocket: path})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := other.Listen(); err == nil {
+		other.Close()
+		t.Error("Expected listening on a socket in use to fail")
+	}
+
+	srv.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket file to be removed on Close, got %v", err)
+	}
+}
+
+func TestUnixSocketOnly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cacheflow.sock")
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.Addr = ""
+		cfg.UnixSocket = path
+	})
+	if srv.Addr().Network() != "unix" {
+		t.Errorf("Expected the server address to be the Unix socket, got %v", srv.Addr())
+	}
+
+	c, err := client.New("unix://" + path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+}
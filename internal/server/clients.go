@@ -0,0 +1,385 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"CacheFlow/internal/protocol"
+)
+
+// clientConn is the entry of a connection in the client registry
+type clientConn struct {
+	id      int64
+	conn    net.Conn
+	created time.Time
+
+	name       atomic.Pointer[string]
+	user       atomic.Pointer[string]
+	lastCmd    atomic.Pointer[string] // the latest command, in lower case
+	lastActive atomic.Int64           // unix nanoseconds of the latest command
+	flags      atomic.Pointer[string] // N for a normal client, O for a monitor, S for a replica
+	db         atomic.Int64           // the database selected with SELECT
+	qbuf       atomic.Int64           // request bytes read but not processed yet
+	obuf       atomic.Int64           // bytes of the reply being written
+	killed     atomic.Bool            // set by CLIENT KILL of the client itself
+}
+
+// clientRegistry tracks the connections being served
+type clientRegistry struct {
+	nextID atomic.Int64
+
+	mu      sync.Mutex
+	clients map[int64]*clientConn
+}
+
+// register adds a connection and returns its entry
+func (r *clientRegistry) register(conn net.Conn) *clientConn {
+	c := &clientConn{id: r.nextID.Add(1), conn: conn, created: time.Now()}
+	c.lastActive.Store(c.created.UnixNano())
+	c.setFlags("N")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clients == nil {
+		r.clients = make(map[int64]*clientConn)
+	}
+	r.clients[c.id] = c
+	return c
+}
+
+// unregister removes a connection that was closed
+func (r *clientRegistry) unregister(c *clientConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+// list returns the registered connections ordered by ID
+func (r *clientRegistry) list() []*clientConn {
+	r.mu.Lock()
+	clients := make([]*clientConn, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// started records that the client sent a command
+func (c *clientConn) started(command string) {
+	command = strings.ToLower(command)
+	c.lastCmd.Store(&command)
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// setName sets the name of the connection; an empty name removes it
+func (c *clientConn) setName(name string) {
+	c.name.Store(&name)
+}
+
+// setUser records the user the connection is logged in as
+func (c *clientConn) setUser(user string) {
+	c.user.Store(&user)
+}
+
+// setFlags records the mode of the connection
+func (c *clientConn) setFlags(flags string) {
+	c.flags.Store(&flags)
+}
+
+// loadString returns the value of a string field, or "" if it was never set
+func loadString(p *atomic.Pointer[string]) string {
+	if v := p.Load(); v != nil {
+		return *v
+	}
+	return ""
+}
+
+// describe renders the connection as a CLIENT LIST line
+func (c *clientConn) describe(now time.Time) string {
+	cmd := loadString(&c.lastCmd)
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d cmd=%s user=%s qbuf=%d obuf=%d",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), loadString(&c.name),
+		int64(now.Sub(c.created).Seconds()), int64(now.Sub(time.Unix(0, c.lastActive.Load())).Seconds()),
+		loadString(&c.flags), c.db.Load(), cmd, loadString(&c.user), c.qbuf.Load(), c.obuf.Load())
+}
+
+// ClientInfo describes a connection being served
+type ClientInfo struct {
+	ID    int64
+	Addr  string
+	Name  string
+	User  string
+	Age   time.Duration
+	Idle  time.Duration
+	Flags string
+	// DB is the database the connection has selected
+	DB int
+	// Command is the latest command of the connection, in lower case
+	Command string
+}
+
+// Clients returns the connections being served, ordered by ID
+func (s *Server) Clients() []ClientInfo {
+	now := time.Now()
+	var infos []ClientInfo
+	for _, c := range s.registry.list() {
+		infos = append(infos, ClientInfo{
+			ID:      c.id,
+			Addr:    c.conn.RemoteAddr().String(),
+			Name:    loadString(&c.name),
+			User:    loadString(&c.user),
+			Age:     now.Sub(c.created),
+			Idle:    now.Sub(time.Unix(0, c.lastActive.Load())),
+			Flags:   loadString(&c.flags),
+			DB:      int(c.db.Load()),
+			Command: loadString(&c.lastCmd),
+		})
+	}
+	return infos
+}
+
+// pauseState holds the pause set by CLIENT PAUSE
+type pauseState struct {
+	mu     sync.Mutex
+	until  time.Time
+	all    bool          // whether every command is paused, not just writes
+	resume chan struct{} // closed when the pause is changed or lifted
+}
+
+// pause holds commands back until d has passed, replacing any earlier pause
+func (p *pauseState) pause(d time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until = time.Now().Add(d)
+	p.all = all
+	p.wake()
+}
+
+// unpause lifts the pause
+func (p *pauseState) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until = time.Time{}
+	p.wake()
+}
+
+// wake releases the commands waiting on the current pause so they look at
+// the new one. The caller must hold p.mu.
+func (p *pauseState) wake() {
+	if p.resume != nil {
+		close(p.resume)
+	}
+	p.resume = make(chan struct{})
+}
+
+// paused reports whether writes are paused
+func (p *pauseState) paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(p.until)
+}
+
+// wait blocks while a pause applies to a command, or until done is closed
+func (p *pauseState) wait(write bool, done <-chan struct{}) {
+	for {
+		p.mu.Lock()
+		remaining := time.Until(p.until)
+		applies := remaining > 0 && (write || p.all)
+		resume := p.resume
+		p.mu.Unlock()
+		if !applies {
+			return
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-resume:
+		case <-done:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// selfServiceClientCommands are the CLIENT subcommands every user may run on their own connection
+var selfServiceClientCommands = map[string]bool{"ID": true, "GETNAME": true, "SETNAME": true, "INFO": true}
+
+// handleClientCommand processes CLIENT subcommands
+func (s *Server) handleClientCommand(sess *session, args []string) string {
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "CLIENT requires a subcommand")
+	}
+	self := sess.client
+	if self == nil {
+		return protocol.Error(protocol.CodeErr, "CLIENT is only available on client connections")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		return strconv.FormatInt(self.id, 10)
+
+	case "INFO":
+		return self.describe(time.Now())
+
+	case "SETNAME":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "CLIENT SETNAME requires a name")
+		}
+		self.setName(args[1])
+		return "OK"
+
+	case "GETNAME":
+		if name := loadString(&self.name); name != "" {
+			return protocol.EscapeValue(name)
+		}
+		return protocol.Nil
+
+	case "LIST":
+		return s.clientList(args[1:])
+
+	case "KILL":
+		return s.clientKill(self, args[1:])
+
+	case "PAUSE":
+		if len(args) < 2 || len(args) > 3 {
+			return protocol.Error(protocol.CodeSyntax, "CLIENT PAUSE requires a timeout in milliseconds and an optional WRITE or ALL")
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || ms < 0 {
+			return protocol.Error(protocol.CodeSyntax, "timeout must be a number of milliseconds")
+		}
+		all := true
+		if len(args) == 3 {
+			switch strings.ToUpper(args[2]) {
+			case "WRITE":
+				all = false
+			case "ALL":
+			default:
+				return protocol.Error(protocol.CodeSyntax, "pause mode must be WRITE or ALL")
+			}
+		}
+		s.pause.pause(time.Duration(ms)*time.Millisecond, all)
+		return "OK"
+
+	case "UNPAUSE":
+		s.pause.unpause()
+		return "OK"
+
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown CLIENT subcommand")
+	}
+}
+
+// clientList processes the arguments of CLIENT LIST [ID id ...]
+func (s *Server) clientList(args []string) string {
+	ids := make(map[int64]bool)
+	if len(args) > 0 {
+		if strings.ToUpper(args[0]) != "ID" || len(args) == 1 {
+			return protocol.Error(protocol.CodeSyntax, "CLIENT LIST takes ID followed by client IDs")
+		}
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return protocol.Errorf(protocol.CodeSyntax, "Invalid client ID %s", arg)
+			}
+			ids[id] = true
+		}
+	}
+
+	now := time.Now()
+	var lines []string
+	for _, c := range s.registry.list() {
+		if len(ids) == 0 || ids[c.id] {
+			lines = append(lines, c.describe(now))
+		}
+	}
+	return strings.Join(lines, ", ")
+}
+
+// clientKill processes the arguments of CLIENT KILL addr, which closes one
+// connection, or CLIENT KILL filter value ..., which closes every
+// connection matching all of ID, ADDR, LADDR and USER filters and returns
+// how many were closed. SKIPME no lets the filters match the caller itself.
+func (s *Server) clientKill(self *clientConn, args []string) string {
+	if len(args) == 1 {
+		for _, c := range s.registry.list() {
+			if c.conn.RemoteAddr().String() == args[0] {
+				s.kill(self, c)
+				return "OK"
+			}
+		}
+		return protocol.Error(protocol.CodeErr, "No such client")
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return protocol.Error(protocol.CodeSyntax, "CLIENT KILL requires an address or filter and value pairs")
+	}
+
+	skipMe := true
+	var filters []func(c *clientConn) bool
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return protocol.Errorf(protocol.CodeSyntax, "Invalid client ID %s", value)
+			}
+			filters = append(filters, func(c *clientConn) bool { return c.id == id })
+		case "ADDR":
+			filters = append(filters, func(c *clientConn) bool { return c.conn.RemoteAddr().String() == value })
+		case "LADDR":
+			filters = append(filters, func(c *clientConn) bool { return c.conn.LocalAddr().String() == value })
+		case "USER":
+			filters = append(filters, func(c *clientConn) bool { return loadString(&c.user) == value })
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return protocol.Error(protocol.CodeSyntax, "SKIPME must be yes or no")
+			}
+		default:
+			return protocol.Errorf(protocol.CodeSyntax, "Unknown CLIENT KILL filter %s", args[i])
+		}
+	}
+
+	var killed int
+	for _, c := range s.registry.list() {
+		if skipMe && c == self {
+			continue
+		}
+		matches := true
+		for _, filter := range filters {
+			matches = matches && filter(c)
+		}
+		if matches {
+			s.kill(self, c)
+			killed++
+		}
+	}
+	return strconv.Itoa(killed)
+}
+
+// kill closes a connection. The caller's own connection is closed once the reply is sent.
+func (s *Server) kill(self, c *clientConn) {
+	if c == self {
+		c.killed.Store(true)
+		return
+	}
+	c.conn.Close()
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+)
+
+func TestClientCommands(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+
+	// Clients are listed in the order they were registered, which a reply guarantees
+	admin := dialRaw(t, srv)
+	admin.send("PING")
+	other := dialRaw(t, srv)
+	other.send("PING")
+
+	if reply := admin.send("CLIENT GETNAME"); reply != "NIL" {
+		t.Errorf("Expected no name, got %q", reply)
+	}
+	if reply := admin.send("CLIENT SETNAME ops"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := admin.send("CLIENT GETNAME"); reply != "ops" {
+		t.Errorf("Expected ops, got %q", reply)
+	}
+	adminID := admin.send("CLIENT ID")
+
+	list := strings.Split(admin.send("CLIENT LIST"), ", ")
+	if len(list) != 2 {
+		t.Fatalf("Expected two clients, got %q", list)
+	}
+	if !strings.HasPrefix(list[0], "id="+adminID+" addr="+admin.LocalAddr().String()) ||
+		!strings.Contains(list[0], " name=ops ") || !strings.Contains(list[0], " cmd=client ") {
+		t.Errorf("Expected the admin connection first, got %q", list[0])
+	}
+	if !strings.Contains(list[1], " cmd=ping ") || !strings.Contains(list[1], " user=default ") {
+		t.Errorf("Expected the other connection to have sent PING, got %q", list[1])
+	}
+	if reply := admin.send("CLIENT LIST ID " + adminID); reply != admin.send("CLIENT INFO") {
+		t.Errorf("Expected CLIENT LIST ID to match CLIENT INFO, got %q", reply)
+	}
+
+	// Killing by address closes the other connection only
+	if reply := admin.send("CLIENT KILL ADDR " + other.LocalAddr().String()); reply != "1" {
+		t.Errorf("Expected one client killed, got %q", reply)
+	}
+	if reply := other.send("PING"); reply != "" {
+		t.Errorf("Expected the killed connection to be closed, got %q", reply)
+	}
+	if reply := admin.send("CLIENT KILL 127.0.0.1:1"); !strings.HasPrefix(reply, "ERROR: ERR No such client") {
+		t.Errorf("Expected an error for an unknown address, got %q", reply)
+	}
+
+	// SKIPME no lets a client kill itself once the reply is sent
+	if reply := admin.send("CLIENT KILL ID " + adminID + " SKIPME no"); reply != "1" {
+		t.Errorf("Expected one client killed, got %q", reply)
+	}
+	if reply := admin.send("PING"); reply != "" {
+		t.Errorf("Expected the connection to be closed, got %q", reply)
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+
+	admin := dialRaw(t, srv)
+	conn := dialRaw(t, srv)
+	if reply := admin.send("CLIENT PAUSE 200 WRITE"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+
+	// Reads go through while writes wait for the pause to end
+	start := time.Now()
+	if reply := conn.send("GET key"); reply != "NIL" || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Expected GET to answer at once, got %q after %v", reply, time.Since(start))
+	}
+	if reply := conn.send("SET key value"); reply != "OK" || time.Since(start) < 150*time.Millisecond {
+		t.Errorf("Expected SET to wait for the pause, got %q after %v", reply, time.Since(start))
+	}
+
+	// UNPAUSE releases waiting commands early
+	admin.send("CLIENT PAUSE 10000")
+	done := make(chan string)
+	go func() { done <- conn.send("GET key") }()
+	time.Sleep(50 * time.Millisecond)
+	admin.send("CLIENT UNPAUSE")
+	select {
+	case reply := <-done:
+		if reply != "value" {
+			t.Errorf("Expected value, got %q", reply)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected UNPAUSE to release the command")
+	}
+}
+
+func TestClientName(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+
+	admin := dialRaw(t, srv)
+	admin.send("ACL SETUSER app on >pw +@read ~*")
+	c, err := client.NewWithOptions(srv.Addr().String(), client.Options{Username: "app", Password: "pw", ClientName: "billing"})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	var found bool
+	for _, info := range srv.Clients() {
+		if info.Name == "billing" {
+			found = true
+			if info.User != "app" {
+				t.Errorf("Expected user app, got %q", info.User)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected a client named billing, got %+v", srv.Clients())
+	}
+
+	// Naming its own connection needs no permission, listing the others does
+	if reply, _ := c.Do(context.Background(), "CLIENT LIST"); !strings.HasPrefix(reply, "ERROR: NOPERM") {
+		t.Errorf("Expected CLIENT LIST to be refused, got %q", reply)
+	}
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"CacheFlow/internal/client"
+	"CacheFlow/internal/cluster"
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/store"
+)
+
+// slotLockStripes is the number of locks the slots are spread over
+const slotLockStripes = 256
+
+// lockSlots read-locks the stripes of the keys' slots and returns the unlock
+// function. Stripes are locked once each and in order, so commands locking
+// several cannot deadlock with each other or with MIGRATE.
+func (s *Server) lockSlots(keys []string) func() {
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, int(cluster.KeySlot(key)%slotLockStripes))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	for _, stripe := range stripes {
+		s.slotLocks[stripe].RLock()
+	}
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			s.slotLocks[stripes[i]].RUnlock()
+		}
+	}
+}
+
+// checkSlot returns a redirect reply if the key's slot is served by another
+// node, or an empty string if this node should execute the command on
+// database db. While a slot migrates, the source keeps serving keys it still
+// has and sends clients to the target (ASK) for the rest; the target only
+// serves the slot to clients that sent ASKING.
+func (s *Server) checkSlot(db int, key string, asking bool) string {
+	slot := cluster.KeySlot(key)
+	id, addr, ok := s.cluster.Owner(slot)
+	if !ok {
+		return protocol.Errorf(protocol.CodeClusterDown, "Hash slot %d not served", slot)
+	}
+	if id == s.cluster.MyID() {
+		if _, target, migrating := s.cluster.Migrating(slot); migrating && !s.store.DB(db).Exists(key) {
+			return protocol.Errorf(protocol.CodeAsk, "%d %s", slot, target)
+		}
+		return ""
+	}
+	if asking && s.cluster.Importing(slot) {
+		return ""
+	}
+	return protocol.Errorf(protocol.CodeMoved, "%d %s", slot, addr)
+}
+
+// handleMigrate processes MIGRATE host port key [key ...], moving each key
+// with its value and remaining TTL to another node and deleting it here. A
+// key is moved from every database that holds it.
+// Each key is locked for the duration of its transfer, so no write to it is
+// lost in between.
+func (s *Server) handleMigrate(args []string) string {
+	if s.cluster == nil {
+		return protocol.Error(protocol.CodeErr, "cluster mode is not enabled")
+	}
+	if len(args) < 3 {
+		return protocol.Error(protocol.CodeSyntax, "MIGRATE requires host, port and at least one key")
+	}
+
+	target, err := s.dialPeer(net.JoinHostPort(args[0], args[1]))
+	if err != nil {
+		return protocol.Errorf(protocol.CodeIOErr, "%v", err)
+	}
+	defer target.Close()
+
+	ctx := context.Background()
+	moved := 0
+	for _, key := range args[2:] {
+		ok, err := s.migrateKey(ctx, target, key)
+		if err != nil {
+			return protocol.Errorf(protocol.CodeIOErr, "migrating %s: %v", key, err)
+		}
+		if ok {
+			moved++
+		}
+	}
+	if moved == 0 {
+		return "NOKEY"
+	}
+	return "OK"
+}
+
+// migrateKey moves a single key to the target node, from every database
+// that holds it. It reports false if the key does not exist in any.
+func (s *Server) migrateKey(ctx context.Context, target *client.Client, key string) (bool, error) {
+	lock := &s.slotLocks[cluster.KeySlot(key)%slotLockStripes]
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Check every copy first, so a stream does not leave the key half moved
+	type dump struct {
+		db    int
+		value any
+		ttl   time.Duration
+	}
+	var dumps []dump
+	for db := 0; db < s.store.Databases(); db++ {
+		value, ttl, ok := s.store.DB(db).Dump(key)
+		if !ok {
+			continue
+		}
+		if _, isStream := value.(*store.Stream); isStream {
+			return false, fmt.Errorf("streams cannot be migrated")
+		}
+		dumps = append(dumps, dump{db: db, value: value, ttl: ttl})
+	}
+	if len(dumps) == 0 {
+		return false, nil
+	}
+
+	for _, d := range dumps {
+		// ASKING lets the target serve the importing slot for a single command
+		if _, err := target.Do(ctx, "ASKING"); err != nil {
+			return false, err
+		}
+		response, err := target.Do(ctx, fmt.Sprintf("RESTORE %s %d %d %v", key, restoreMillis(d.ttl), d.db, d.value))
+		if err != nil {
+			return false, err
+		}
+		if response != "OK" {
+			return false, fmt.Errorf("target replied %s", response)
+		}
+
+		if s.raft != nil {
+			if reply := s.proposeWrite(d.db, []string{"DELETE", key}); reply != "OK" {
+				return false, fmt.Errorf("failed to delete migrated key: %s", reply)
+			}
+		} else {
+			s.store.DB(d.db).Delete(key)
+		}
+	}
+	return true, nil
+}
+
+// restoreMillis returns the TTL argument of RESTORE for a remaining time to
+// live: 0 for none, and otherwise at least 1, rounding up, since 0 would keep
+// a key about to expire for ever
+func restoreMillis(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// handleRestore processes RESTORE key ttl-ms db value, creating a key
+// received from MIGRATE in database db; a TTL of 0 means no expiration, even
+// in a namespace with a default TTL
+func (s *Server) handleRestore(args []string) string {
+	if len(args) < 4 {
+		return protocol.Error(protocol.CodeSyntax, "RESTORE requires key, ttl, database and value")
+	}
+	ms, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || ms < 0 {
+		return protocol.Error(protocol.CodeSyntax, "RESTORE ttl must be a non-negative number of milliseconds")
+	}
+	db, err := strconv.Atoi(args[2])
+	if err != nil || db < 0 || db >= s.store.Databases() {
+		return protocol.Error(protocol.CodeSyntax, "RESTORE database is out of range")
+	}
+	key, value := args[0], strings.Join(args[3:], " ")
+	ttl := time.Duration(ms) * time.Millisecond
+
+	return s.restoreKey(db, key, value, ttl)
+}
+
+// dialPeer connects to another node with the credentials and TLS settings used for replication
+func (s *Server) dialPeer(addr string) (*client.Client, error) {
+	return client.NewWithOptions(addr, client.Options{
+		Username:  s.authUser,
+		Password:  s.authPassword,
+		TLSConfig: s.peerTLS,
+	})
+}
+
+// handleClusterCommand processes the CLUSTER command family
+func (s *Server) handleClusterCommand(args []string) string {
+	if s.cluster == nil {
+		return protocol.Error(protocol.CodeErr, "cluster mode is not enabled")
+	}
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "CLUSTER requires a subcommand")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "MYID":
+		return s.cluster.MyID()
+
+	case "MEET":
+		if len(args) != 3 {
+			return protocol.Error(protocol.CodeSyntax, "CLUSTER MEET requires host and port")
+		}
+		addr := net.JoinHostPort(args[1], args[2])
+		peer, err := s.dialPeer(addr)
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		defer peer.Close()
+		id, err := peer.Do(context.Background(), "CLUSTER MYID")
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		if protocol.IsError(id) {
+			return id
+		}
+		if err := s.cluster.AddNode(id, addr); err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return "OK"
+
+	case "ADDSLOTS":
+		if len(args) < 2 {
+			return protocol.Error(protocol.CodeSyntax, "CLUSTER ADDSLOTS requires slot ranges")
+		}
+		ranges, err := parseSlotRanges(args[1:])
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		for _, r := range ranges {
+			if err := s.cluster.AddSlots(r); err != nil {
+				return protocol.Errorf(protocol.CodeErr, "%v", err)
+			}
+		}
+		return "OK"
+
+	case "SETSLOT":
+		return s.handleSetSlot(args[1:])
+
+	case "COUNTKEYSINSLOT":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "CLUSTER COUNTKEYSINSLOT requires slot")
+		}
+		slot, err := parseSlot(args[1])
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return strconv.Itoa(len(s.keysInSlot(slot, 0)))
+
+	case "GETKEYSINSLOT":
+		if len(args) != 3 {
+			return protocol.Error(protocol.CodeSyntax, "CLUSTER GETKEYSINSLOT requires slot and count")
+		}
+		slot, err := parseSlot(args[1])
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		count, err := strconv.Atoi(args[2])
+		if err != nil || count <= 0 {
+			return protocol.Error(protocol.CodeSyntax, "count must be a positive number")
+		}
+		return strings.Join(s.keysInSlot(slot, count), " ")
+
+	case "SLOTS":
+		var entries []string
+		for _, a := range s.cluster.Assignments() {
+			entries = append(entries, a.Range.String()+" "+a.Addr)
+		}
+		return strings.Join(entries, ", ")
+
+	case "NODES":
+		var entries []string
+		for _, n := range s.cluster.Nodes() {
+			entry := n.ID + " " + n.Addr
+			if n.Myself {
+				entry += " myself"
+			}
+			for _, r := range n.Slots {
+				entry += " " + r.String()
+			}
+			entries = append(entries, entry)
+		}
+		return strings.Join(entries, ", ")
+
+	case "KEYSLOT":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "CLUSTER KEYSLOT requires key")
+		}
+		return fmt.Sprintf("%d", cluster.KeySlot(args[1]))
+
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown CLUSTER subcommand")
+	}
+}
+
+// handleSetSlot processes CLUSTER SETSLOT:
+//
+//	SETSLOT <range> NODE <id>      assign the slots to a node, ending any migration
+//	SETSLOT <slot> MIGRATING <id>  start moving a slot this node owns to another node
+//	SETSLOT <slot> IMPORTING <id>  start accepting a slot from another node
+//	SETSLOT <slot> STABLE          cancel a migration
+func (s *Server) handleSetSlot(args []string) string {
+	if len(args) < 2 {
+		return protocol.Error(protocol.CodeSyntax, "CLUSTER SETSLOT requires slot and subcommand")
+	}
+
+	action := strings.ToUpper(args[1])
+	if action == "NODE" {
+		if len(args) != 3 {
+			return protocol.Error(protocol.CodeSyntax, "CLUSTER SETSLOT NODE requires node ID")
+		}
+		r, err := cluster.ParseSlotRange(args[0])
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		if err := s.cluster.SetSlots(r, args[2]); err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return "OK"
+	}
+
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return protocol.Errorf(protocol.CodeErr, "%v", err)
+	}
+	switch {
+	case action == "MIGRATING" && len(args) == 3:
+		err = s.cluster.SetMigrating(slot, args[2])
+	case action == "IMPORTING" && len(args) == 3:
+		err = s.cluster.SetImporting(slot, args[2])
+	case action == "STABLE" && len(args) == 2:
+		err = s.cluster.SetStable(slot)
+	default:
+		return protocol.Error(protocol.CodeSyntax, "CLUSTER SETSLOT expects NODE, MIGRATING, IMPORTING or STABLE")
+	}
+	if err != nil {
+		return protocol.Errorf(protocol.CodeErr, "%v", err)
+	}
+	return "OK"
+}
+
+// keysInSlot returns up to limit keys stored here that hash to slot, each
+// once however many databases hold it; a limit of 0 returns all
+func (s *Server) keysInSlot(slot, limit int) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for db := 0; db < s.store.Databases() && (limit <= 0 || len(keys) < limit); db++ {
+		match := func(key string) bool { return !seen[key] && cluster.KeySlot(key) == slot }
+		remaining := 0
+		if limit > 0 {
+			remaining = limit - len(keys)
+		}
+		for _, key := range s.store.DB(db).Keys(match, remaining) {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parseSlot parses a single slot number
+func parseSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= cluster.SlotCount {
+		return 0, fmt.Errorf("invalid slot %q", arg)
+	}
+	return slot, nil
+}
+
+// parseSlotRanges parses a list of slot ranges
+func parseSlotRanges(args []string) ([]cluster.SlotRange, error) {
+	ranges := make([]cluster.SlotRange, 0, len(args))
+	for _, arg := range args {
+		r, err := cluster.ParseSlotRange(arg)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+	"CacheFlow/internal/cluster"
+)
+
+// startClusterNode starts a cluster-enabled server on a random localhost port
+func startClusterNode(t *testing.T) (*Server, string) {
+	cfg := DefaultConfig("127.0.0.1:0")
+	cfg.AOFFilename = ""
+	cfg.ClusterEnabled = true
+	cfg.ClusterConfigFile = filepath.Join(t.TempDir(), "nodes.json")
+
+	// The announced address must be known before the node is created
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	cfg.Addr = listener.Addr().String()
+	listener.Close()
+
+	srv, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve()
+	t.Cleanup(func() { srv.Close() })
+	return srv, cfg.Addr
+}
+
+// mustDo sends a raw command and fails the test on network errors
+func mustDo(t *testing.T, addr, cmd string) string {
+	ctx := context.Background()
+	t.Helper()
+	c, err := client.New(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", addr, err)
+	}
+	defer c.Close()
+	response, err := c.Do(ctx, cmd)
+	if err != nil {
+		t.Fatalf("%s on %s failed: %v", cmd, addr, err)
+	}
+	return response
+}
+
+// startTwoNodeCluster starts two nodes that know each other and returns
+// the second server along with both addresses and IDs
+func startTwoNodeCluster(t *testing.T) (srv2 *Server, addr1, id1, addr2, id2 string) {
+	_, addr1 = startClusterNode(t)
+	srv2, addr2 = startClusterNode(t)
+	id1 = mustDo(t, addr1, "CLUSTER MYID")
+	id2 = mustDo(t, addr2, "CLUSTER MYID")
+
+	for _, pair := range [][2]string{{addr1, addr2}, {addr2, addr1}} {
+		host, port, _ := net.SplitHostPort(pair[1])
+		if r := mustDo(t, pair[0], fmt.Sprintf("CLUSTER MEET %s %s", host, port)); r != "OK" {
+			t.Fatalf("CLUSTER MEET failed: %s", r)
+		}
+	}
+	return srv2, addr1, id1, addr2, id2
+}
+
+// TestClusterRedirects tests MOVED replies and routing by the cluster client.
+func TestClusterRedirects(t *testing.T) {
+	ctx := context.Background()
+	_, addr1, id1, addr2, id2 := startTwoNodeCluster(t)
+	for _, addr := range []string{addr1, addr2} {
+		mustDo(t, addr, "CLUSTER SETSLOT 0-8191 NODE "+id1)
+		mustDo(t, addr, "CLUSTER SETSLOT 8192-16383 NODE "+id2)
+	}
+
+	// "bar" hashes to slot 5061 (node 1), "foo" to slot 12182 (node 2)
+	if r := mustDo(t, addr1, "SET foo value"); r != "ERROR: MOVED 12182 "+addr2 {
+		t.Errorf("Expected MOVED to node 2, got %s", r)
+	}
+	if r := mustDo(t, addr1, "SET bar value"); r != "OK" {
+		t.Errorf("Expected node 1 to accept its own key, got %s", r)
+	}
+	if r := mustDo(t, addr2, "CLUSTER SLOTS"); r != fmt.Sprintf("0-8191 %s, 8192-16383 %s", addr1, addr2) {
+		t.Errorf("Unexpected slot map: %s", r)
+	}
+
+	cc, err := client.NewCluster(addr1)
+	if err != nil {
+		t.Fatalf("Failed to create cluster client: %v", err)
+	}
+	defer cc.Close()
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := cc.Set(ctx, key, "v"+key, 0); err != nil {
+			t.Fatalf("Cluster Set of %s failed: %v", key, err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner := addr1
+		if cluster.KeySlot(key) >= 8192 {
+			owner = addr2
+		}
+		if r := mustDo(t, owner, "GET "+key); r != "v"+key {
+			t.Errorf("Expected %s to be stored on its owner %s, got %s", key, owner, r)
+		}
+		if v, err := cc.Get(ctx, key); err != nil || v != "v"+key {
+			t.Errorf("Cluster Get of %s returned %q (err %v)", key, v, err)
+		}
+	}
+
+	// A stale slot map is corrected by following MOVED
+	mustDo(t, addr1, "CLUSTER SETSLOT 0-16383 NODE "+id2)
+	mustDo(t, addr2, "CLUSTER SETSLOT 0-16383 NODE "+id2)
+	if err := cc.Set(ctx, "bar", "moved", 0); err != nil {
+		t.Fatalf("Cluster Set after reassignment failed: %v", err)
+	}
+	if r := mustDo(t, addr2, "GET bar"); r != "moved" {
+		t.Errorf("Expected write to follow MOVED to node 2, got %s", r)
+	}
+	if !strings.Contains(mustDo(t, addr1, "CLUSTER NODES"), id1+" "+addr1+" myself") {
+		t.Errorf("Expected CLUSTER NODES to mark this node")
+	}
+}
+
+// TestSlotMigration tests redirects while a slot moves between nodes and
+// that MIGRATE preserves values and TTLs.
+func TestSlotMigration(t *testing.T) {
+	ctx := context.Background()
+	srv2, addr1, id1, addr2, id2 := startTwoNodeCluster(t)
+	for _, addr := range []string{addr1, addr2} {
+		mustDo(t, addr, "CLUSTER SETSLOT 0-16383 NODE "+id1)
+	}
+
+	// All these keys share slot 12182 with "foo"
+	mustDo(t, addr1, "SET {foo}plain some value")
+	mustDo(t, addr1, "SET {foo}expiring soon 1h")
+	mustDo(t, addr1, "SET {foo}stays here")
+
+	host2, port2, _ := net.SplitHostPort(addr2)
+	mustDo(t, addr2, "CLUSTER SETSLOT 12182 IMPORTING "+id1)
+	mustDo(t, addr1, "CLUSTER SETSLOT 12182 MIGRATING "+id2)
+	if r := mustDo(t, addr1, "CLUSTER COUNTKEYSINSLOT 12182"); r != "3" {
+		t.Errorf("Expected 3 keys in slot, got %s", r)
+	}
+	if r := mustDo(t, addr1, "MIGRATE "+host2+" "+port2+" {foo}plain {foo}expiring {foo}missing"); r != "OK" {
+		t.Fatalf("MIGRATE failed: %s", r)
+	}
+
+	// The source still serves keys it has and sends clients to the target for the rest
+	if r := mustDo(t, addr1, "GET {foo}stays"); r != "here" {
+		t.Errorf("Expected source to serve a key it still holds, got %s", r)
+	}
+	if r := mustDo(t, addr1, "GET {foo}plain"); r != "ERROR: ASK 12182 "+addr2 {
+		t.Errorf("Expected ASK for a migrated key, got %s", r)
+	}
+	if r := mustDo(t, addr2, "GET {foo}plain"); r != "ERROR: MOVED 12182 "+addr1 {
+		t.Errorf("Expected target to redirect clients that did not send ASKING, got %s", r)
+	}
+
+	c, err := client.New(addr2)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	c.Do(ctx, "ASKING")
+	if r, _ := c.Do(ctx, "GET {foo}plain"); r != "some value" {
+		t.Errorf("Expected target to serve migrated key after ASKING, got %s", r)
+	}
+	if r, _ := c.Do(ctx, "GET {foo}plain"); !strings.HasPrefix(r, "ERROR: MOVED") {
+		t.Errorf("Expected ASKING to apply to a single command, got %s", r)
+	}
+
+	// The cluster client follows ASK without caching it
+	cc, err := client.NewCluster(addr1)
+	if err != nil {
+		t.Fatalf("Failed to create cluster client: %v", err)
+	}
+	defer cc.Close()
+	if v, err := cc.Get(ctx, "{foo}expiring"); err != nil || v != "soon" {
+		t.Errorf("Expected cluster client to follow ASK, got %q (err %v)", v, err)
+	}
+
+	mustDo(t, addr1, "MIGRATE "+host2+" "+port2+" {foo}stays")
+	if r := mustDo(t, addr1, "CLUSTER GETKEYSINSLOT 12182 10"); r != "" {
+		t.Errorf("Expected source slot to be empty, got %s", r)
+	}
+	for _, addr := range []string{addr2, addr1} {
+		mustDo(t, addr, "CLUSTER SETSLOT 12182 NODE "+id2)
+	}
+	if r := mustDo(t, addr1, "GET {foo}stays"); r != "ERROR: MOVED 12182 "+addr2 {
+		t.Errorf("Expected MOVED after migration, got %s", r)
+	}
+
+	value, ttl, ok := srv2.store.Dump("{foo}expiring")
+	if !ok || value != "soon" || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected value and TTL to survive migration, got %v %s %v", value, ttl, ok)
+	}
+	if v, err := cc.Get(ctx, "{foo}stays"); err != nil || v != "here" {
+		t.Errorf("Expected cluster client to follow MOVED, got %q (err %v)", v, err)
+	}
+	if _, err := strconv.Atoi(mustDo(t, addr2, "CLUSTER KEYSLOT {foo}x")); err != nil {
+		t.Errorf("Expected CLUSTER KEYSLOT to return a number")
+	}
+}
+
+// TestMigrateDatabases tests that MIGRATE moves a key from every database,
+// such as ones loaded from an AOF written before cluster mode was enabled,
+// and keeps a key without a TTL persistent.
+func TestMigrateDatabases(t *testing.T) {
+	srv1, addr1 := startClusterNode(t)
+	srv2, addr2 := startClusterNode(t)
+	id1 := mustDo(t, addr1, "CLUSTER MYID")
+	id2 := mustDo(t, addr2, "CLUSTER MYID")
+	for _, pair := range [][2]string{{addr1, addr2}, {addr2, addr1}} {
+		host, port, _ := net.SplitHostPort(pair[1])
+		mustDo(t, pair[0], fmt.Sprintf("CLUSTER MEET %s %s", host, port))
+	}
+	for _, addr := range []string{addr1, addr2} {
+		mustDo(t, addr, "CLUSTER SETSLOT 0-16383 NODE "+id1)
+	}
+	// A default TTL on the target must not apply to keys that have none
+	mustDo(t, addr2, "NAMESPACE SET foo PREFIX {foo} TTL 1h")
+
+	srv1.store.DB(0).Set("{foo}key", "in db 0", 0)
+	srv1.store.DB(3).Set("{foo}key", "in db 3", 0)
+	srv1.store.DB(3).Set("{foo}only3", "here", 0)
+
+	host2, port2, _ := net.SplitHostPort(addr2)
+	mustDo(t, addr2, "CLUSTER SETSLOT 12182 IMPORTING "+id1)
+	mustDo(t, addr1, "CLUSTER SETSLOT 12182 MIGRATING "+id2)
+	if r := mustDo(t, addr1, "CLUSTER COUNTKEYSINSLOT 12182"); r != "2" {
+		t.Errorf("Expected 2 distinct keys in slot across databases, got %s", r)
+	}
+	if r := mustDo(t, addr1, "MIGRATE "+host2+" "+port2+" {foo}key"); r != "OK" {
+		t.Fatalf("MIGRATE failed: %s", r)
+	}
+	if r := mustDo(t, addr1, "CLUSTER GETKEYSINSLOT 12182 10"); r != "{foo}only3" {
+		t.Errorf("Expected only the key left in db 3, got %s", r)
+	}
+	if srv1.store.DB(0).Exists("{foo}key") || srv1.store.DB(3).Exists("{foo}key") {
+		t.Error("Expected the key to be deleted from every database of the source")
+	}
+
+	for db, want := range map[int]string{0: "in db 0", 3: "in db 3"} {
+		value, ttl, ok := srv2.store.DB(db).Dump("{foo}key")
+		if !ok || value != want || ttl != 0 {
+			t.Errorf("Expected %q without expiry in db %d, got %v %s %v", want, db, value, ttl, ok)
+		}
+	}
+}
+
+func TestLockSlots(t *testing.T) {
+	s := &Server{}
+	keys := []string{"b", "a", "{b}other", "b"}
+	unlock := s.lockSlots(keys)
+	for _, key := range keys {
+		stripe := &s.slotLocks[cluster.KeySlot(key)%slotLockStripes]
+		if stripe.TryLock() {
+			t.Fatalf("Expected the stripe of %s to be locked", key)
+		}
+	}
+	unlock()
+	for _, key := range keys {
+		stripe := &s.slotLocks[cluster.KeySlot(key)%slotLockStripes]
+		if !stripe.TryLock() {
+			t.Fatalf("Expected the stripe of %s to be unlocked", key)
+		}
+		stripe.Unlock()
+	}
+}
+
+func TestRestoreMillis(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want int64
+	}{
+		{0, 0},
+		{time.Microsecond, 1},
+		{time.Millisecond, 1},
+		{1500 * time.Microsecond, 2},
+		{time.Hour, 3600000},
+	}
+	for _, tt := range tests {
+		if got := restoreMillis(tt.ttl); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.ttl, tt.want, got)
+		}
+	}
+}
+
+// TestClusterMeetWithAuth tests that CLUSTER MEET logs in to the peer and passes its errors through.
+func TestClusterMeetWithAuth(t *testing.T) {
+	secured := func(cfg *Config) {
+		cfg.ClusterEnabled = true
+		cfg.ClusterConfigFile = filepath.Join(t.TempDir(), "nodes.json")
+		cfg.RequirePass = "secret"
+		cfg.AuthPassword = "secret"
+	}
+	srv1 := startConfiguredNode(t, secured)
+	srv2 := startConfiguredNode(t, secured)
+	plain := startPlainNode(t)
+	sess := srv1.newSession()
+	if r := srv1.handleCommand(sess, "AUTH secret"); r != "OK" {
+		t.Fatalf("AUTH failed: %s", r)
+	}
+
+	host, port, _ := net.SplitHostPort(srv2.Addr().String())
+	if r := srv1.handleCommand(sess, fmt.Sprintf("CLUSTER MEET %s %s", host, port)); r != "OK" {
+		t.Fatalf("Expected CLUSTER MEET to log in to the peer, got %s", r)
+	}
+	if r := srv1.handleCommand(sess, "CLUSTER NODES"); !strings.Contains(r, srv2.cluster.MyID()) {
+		t.Errorf("Expected the peer to be known after MEET, got %s", r)
+	}
+
+	host, port, _ = net.SplitHostPort(plain.Addr().String())
+	if r := srv1.handleCommand(sess, fmt.Sprintf("CLUSTER MEET %s %s", host, port)); r != "ERROR: ERR cluster mode is not enabled" {
+		t.Errorf("Expected the peer's error to be passed through, got %s", r)
+	}
+}
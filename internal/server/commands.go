@@ -0,0 +1,548 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/store"
+)
+
+// CommandFlag is a property of a command the server acts on
+type CommandFlag string
+
+const (
+	// FlagWrite marks commands that modify the dataset: replicas refuse them
+	// and CLIENT PAUSE WRITE holds them back
+	FlagWrite CommandFlag = "write"
+	// FlagReadOnly marks commands that only read the dataset
+	FlagReadOnly CommandFlag = "readonly"
+	// FlagAdmin marks commands that administer the server
+	FlagAdmin CommandFlag = "admin"
+	// FlagFast marks commands that take constant or logarithmic time
+	FlagFast CommandFlag = "fast"
+	// FlagNoAuth lets a command run before the connection authenticates
+	FlagNoAuth CommandFlag = "noauth"
+	// FlagNoScript keeps scripts and Request.Call from running a command
+	FlagNoScript CommandFlag = "noscript"
+	// FlagNoPause lets a command run while clients are paused
+	FlagNoPause CommandFlag = "nopause"
+	// FlagExclusive makes a command run alone: no other command runs until it
+	// returns. CLIENT PAUSE WRITE holds such commands back, as they may write.
+	FlagExclusive CommandFlag = "exclusive"
+	// FlagNoRedirect marks commands that check which cluster node owns their
+	// keys themselves instead of being redirected before they run
+	FlagNoRedirect CommandFlag = "noredirect"
+)
+
+// flagMovableKeys is reported by COMMAND INFO for commands with a Keys function
+const flagMovableKeys = "movablekeys"
+
+// knownFlags are the flags a command may declare
+var knownFlags = []CommandFlag{
+	FlagWrite, FlagReadOnly, FlagAdmin, FlagFast, FlagNoAuth, FlagNoScript, FlagNoPause, FlagExclusive, FlagNoRedirect,
+}
+
+// flagCategories are the ACL categories the flags of a registered command put it in
+var flagCategories = map[CommandFlag]string{
+	FlagWrite:    "write",
+	FlagReadOnly: "read",
+	FlagAdmin:    "admin",
+	FlagFast:     "fast",
+}
+
+// Command declares a command: how it is called, how the server treats it and
+// what it does. Built-in commands are declared the same way as the ones
+// registered with Register and Server.RegisterCommand.
+type Command struct {
+	// Name is what clients send, in any case
+	Name string
+	// Arity is the number of words of a valid command line, including the
+	// name; a negative arity -n means at least n
+	Arity int
+	Flags []CommandFlag
+	// FirstKey, LastKey and KeyStep locate the keys among the words of a
+	// command line: every KeyStep-th word from FirstKey to LastKey, where a
+	// negative LastKey counts from the end. A zero FirstKey means no keys.
+	// Keys are checked against ACLs and namespaces, and redirected in cluster mode.
+	FirstKey, LastKey, KeyStep int
+	// Keys returns the keys among the arguments for commands whose key
+	// positions depend on them; it takes the place of FirstKey to KeyStep
+	Keys func(args []string) []string
+	// Categories are the ACL categories of the command besides the ones its
+	// flags imply; only used for registered commands
+	Categories []string
+	// Handler runs the command and returns its reply line
+	Handler func(req *Request) string
+
+	// checksArgs is set on built-in commands whose handlers check the number
+	// of arguments themselves, with their own messages, instead of the server
+	checksArgs bool
+}
+
+// Request is a command line being handled
+type Request struct {
+	// Name is the command name in upper case
+	Name string
+	// Args are the words after the name
+	Args []string
+	// Line is the whole command line, for commands whose last argument may
+	// contain spaces
+	Line string
+
+	server  *Server
+	sess    *session
+	nested  *session // the session of Call, created on first use
+	command *Command
+	parts   []string
+	asking  bool
+	release func() // releases the locks the request runs under
+}
+
+// User returns the user the connection is logged in as
+func (r *Request) User() string {
+	return r.sess.user
+}
+
+// DB returns the database the connection has selected
+func (r *Request) DB() *store.DB {
+	return r.server.db(r.sess)
+}
+
+// Call runs another command as part of the request and returns its reply.
+// It runs as the same user without waiting for the locks the request holds,
+// and is persisted and replicated on its own. Commands flagged FlagNoScript
+// are refused.
+func (r *Request) Call(args ...string) string {
+	if r.nested == nil {
+		r.nested = nestedSession(r.sess)
+	}
+	return r.server.callNested(r.nested, args)
+}
+
+// wait releases the locks the request runs under while it waits on ch,
+// so other commands, such as the writes it waits for, can run. It reports
+// false, with a redirect reply if the keys of the request moved to another
+// cluster node meanwhile, if the wait ended without ch receiving.
+func (r *Request) wait(ch <-chan struct{}, timeout <-chan time.Time) (bool, string) {
+	s := r.server
+	r.release()
+	r.release = func() {}
+	received := false
+	select {
+	case <-ch:
+		received = true
+	case <-timeout:
+	case <-s.done:
+	}
+	release, redirect := s.acquire(r.sess, r.command, r.parts, r.asking)
+	r.release = release
+	return received && redirect == "", redirect
+}
+
+// nestedSession returns the session commands run by another command use: a
+// copy of its session that does not wait for locks the outer command holds
+func nestedSession(sess *session) *session {
+	nested := *sess
+	nested.nested = true
+	nested.asking = false
+	nested.client = nil
+	return &nested
+}
+
+// callNested runs a command on a nested session
+func (s *Server) callNested(sess *session, args []string) string {
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "Empty command")
+	}
+	if command, ok := s.commands.lookup(strings.ToUpper(args[0])); ok && command.has(FlagNoScript) {
+		return protocol.Error(protocol.CodeErr, "This command is not allowed from scripts")
+	}
+	return s.handleCommand(sess, strings.Join(args, " "))
+}
+
+// has reports whether the command has a flag
+func (c *Command) has(flag CommandFlag) bool {
+	return slices.Contains(c.Flags, flag)
+}
+
+// arityOK reports whether a command line of n words has a valid length
+func (c *Command) arityOK(n int) bool {
+	if c.Arity < 0 {
+		return n >= -c.Arity
+	}
+	return n == c.Arity
+}
+
+// keys returns the keys of a command line
+func (c *Command) keys(parts []string) []string {
+	if c.Keys != nil {
+		return c.Keys(parts[1:])
+	}
+	if c.FirstKey <= 0 || c.FirstKey >= len(parts) {
+		return nil
+	}
+	last := c.LastKey
+	if last < 0 {
+		last += len(parts)
+	}
+	last = min(last, len(parts)-1)
+	var keys []string
+	for i := c.FirstKey; i <= last; i += max(c.KeyStep, 1) {
+		keys = append(keys, parts[i])
+	}
+	return keys
+}
+
+// validate checks that a command is well formed
+func (c *Command) validate() error {
+	switch {
+	case c.Name == "" || strings.ContainsAny(c.Name, " \t\r\n"):
+		return fmt.Errorf("invalid command name %q", c.Name)
+	case c.Arity == 0:
+		return fmt.Errorf("command %s: arity must not be zero", c.Name)
+	case c.Handler == nil:
+		return fmt.Errorf("command %s: no handler", c.Name)
+	case c.FirstKey < 0 || c.KeyStep < 0:
+		return fmt.Errorf("command %s: invalid key positions", c.Name)
+	}
+	for _, flag := range c.Flags {
+		if !slices.Contains(knownFlags, flag) {
+			return fmt.Errorf("command %s: unknown flag %q", c.Name, flag)
+		}
+	}
+	return nil
+}
+
+// commandTable holds the commands a server serves by name
+type commandTable struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+// lookup returns a command by its name in upper case
+func (t *commandTable) lookup(name string) (*Command, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	c, ok := t.commands[name]
+	return c, ok
+}
+
+// add adds a command, failing if it is invalid or its name is taken
+func (t *commandTable) add(cmd Command) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	cmd.Name = strings.ToUpper(cmd.Name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.commands[cmd.Name]; ok {
+		return fmt.Errorf("command %s is already registered", cmd.Name)
+	}
+	if t.commands == nil {
+		t.commands = make(map[string]*Command)
+	}
+	t.commands[cmd.Name] = &cmd
+	return nil
+}
+
+// all returns every command, sorted by name
+func (t *commandTable) all() []*Command {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	commands := make([]*Command, 0, len(t.commands))
+	for _, c := range t.commands {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// plugins holds the commands added with Register
+var plugins struct {
+	mu       sync.Mutex
+	commands []Command
+}
+
+// Register adds a command to every server created afterwards. It is meant to
+// be called from the init function of a package that adds commands, so the
+// server binary only needs to import that package. It panics if the command
+// is invalid; a name taken by another command makes NewWithConfig fail.
+func Register(cmd Command) {
+	if err := cmd.validate(); err != nil {
+		panic(err)
+	}
+	plugins.mu.Lock()
+	defer plugins.mu.Unlock()
+	plugins.commands = append(plugins.commands, cmd)
+}
+
+// RegisterCommand adds a command to the server. It fails if the command is
+// invalid or its name is taken. The command is known to ACL rules in the
+// categories its flags imply and the ones it declares.
+func (s *Server) RegisterCommand(cmd Command) error {
+	if err := s.commands.add(cmd); err != nil {
+		return err
+	}
+	categories := slices.Clone(cmd.Categories)
+	for _, flag := range cmd.Flags {
+		if category, ok := flagCategories[flag]; ok {
+			categories = append(categories, category)
+		}
+	}
+	s.acl.RegisterCommand(strings.ToUpper(cmd.Name), categories)
+	return nil
+}
+
+// registerCommands adds the built-in commands and those added with Register
+func (s *Server) registerCommands() error {
+	for _, cmd := range s.builtinCommands() {
+		if err := s.commands.add(cmd); err != nil {
+			return err
+		}
+	}
+	plugins.mu.Lock()
+	defer plugins.mu.Unlock()
+	for _, cmd := range plugins.commands {
+		if err := s.RegisterCommand(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flags builds a flag list
+func flags(f ...CommandFlag) []CommandFlag {
+	return f
+}
+
+// builtinCommands declares the commands of the server. Their ACL categories
+// are in the acl package, since ACL rules refer to them before any server exists.
+func (s *Server) builtinCommands() []Command {
+	return []Command{
+		{Name: "SET", Arity: -3, checksArgs: true, Flags: flags(FlagWrite), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleSet},
+		{Name: "GET", Arity: 2, checksArgs: true, Flags: flags(FlagReadOnly, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleGet},
+		{Name: "DELETE", Arity: 2, checksArgs: true, Flags: flags(FlagWrite, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleDelete},
+		{Name: "EXISTS", Arity: 2, checksArgs: true, Flags: flags(FlagReadOnly, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleExists},
+		{Name: "SELECT", Arity: 2, checksArgs: true, Flags: flags(FlagFast), Handler: func(r *Request) string {
+			return s.handleSelect(r.sess, r.Args)
+		}},
+		{Name: "SWAPDB", Arity: 3, checksArgs: true, Flags: flags(FlagWrite, FlagFast), Handler: func(r *Request) string {
+			return s.handleSwapDB(r.Args)
+		}},
+		{Name: "FLUSHDB", Arity: -1, checksArgs: true, Flags: flags(FlagWrite), Handler: func(r *Request) string {
+			return s.handleFlush(r.sess, r.Name, r.Args)
+		}},
+		{Name: "FLUSHALL", Arity: -1, checksArgs: true, Flags: flags(FlagWrite), Handler: func(r *Request) string {
+			return s.handleFlush(r.sess, r.Name, r.Args)
+		}},
+		{Name: "NAMESPACE", Arity: -2, checksArgs: true, Flags: flags(FlagWrite, FlagAdmin), Handler: func(r *Request) string {
+			return s.handleNamespace(r.Args)
+		}},
+		{Name: "XADD", Arity: -5, Flags: flags(FlagWrite, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXAdd},
+		{Name: "XTRIM", Arity: 4, Flags: flags(FlagWrite), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXTrim},
+		{Name: "XSETID", Arity: 3, Flags: flags(FlagWrite, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXSetID},
+		{Name: "XLEN", Arity: 2, Flags: flags(FlagReadOnly, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXLen},
+		{Name: "XRANGE", Arity: -4, Flags: flags(FlagReadOnly), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXRange},
+		{Name: "XREVRANGE", Arity: -4, Flags: flags(FlagReadOnly), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXRange},
+		{Name: "XREAD", Arity: -4, Flags: flags(FlagReadOnly), Keys: xreadKeys, Handler: s.handleXRead},
+		{Name: "XREADGROUP", Arity: -7, Flags: flags(FlagWrite), Keys: xreadGroupKeys, Handler: s.handleXReadGroup},
+		{Name: "XGROUP", Arity: -4, Flags: flags(FlagWrite), FirstKey: 2, LastKey: 2, KeyStep: 1, Handler: s.handleXGroup},
+		{Name: "XACK", Arity: -4, Flags: flags(FlagWrite, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXAck},
+		{Name: "XCLAIM", Arity: -6, Flags: flags(FlagWrite, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXClaim},
+		{Name: "XAUTOCLAIM", Arity: -6, Flags: flags(FlagWrite, FlagFast), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXAutoClaim},
+		{Name: "XPENDING", Arity: -3, Flags: flags(FlagReadOnly), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: s.handleXPending},
+		{Name: "EVAL", Arity: -4, checksArgs: true, Flags: flags(FlagExclusive, FlagNoScript, FlagNoRedirect), Keys: evalKeys, Handler: func(r *Request) string {
+			return s.handleEval(r.sess, r.Line, append([]string{r.Name}, r.Args...))
+		}},
+		{Name: "EVALSHA", Arity: -3, checksArgs: true, Flags: flags(FlagExclusive, FlagNoScript, FlagNoRedirect), Keys: evalSHAKeys, Handler: func(r *Request) string {
+			return s.handleEvalSHA(r.sess, append([]string{r.Name}, r.Args...))
+		}},
+		{Name: "SCRIPT", Arity: -2, checksArgs: true, Flags: flags(FlagNoScript), Handler: func(r *Request) string {
+			return s.handleScript(r.Line, r.Args)
+		}},
+		{Name: "COMMAND", Arity: -1, Handler: func(r *Request) string {
+			return s.handleCommandCommand(r.Args)
+		}},
+		{Name: "PING", Arity: -1, checksArgs: true, Flags: flags(FlagFast), Handler: handlePing},
+		{Name: "AUTH", Arity: -2, checksArgs: true, Flags: flags(FlagNoAuth, FlagNoScript, FlagNoPause, FlagFast), Handler: func(r *Request) string {
+			return s.handleAuth(r.sess, r.Args)
+		}},
+		{Name: "ACL", Arity: -2, checksArgs: true, Flags: flags(FlagAdmin, FlagNoScript), Handler: func(r *Request) string {
+			return s.handleACLCommand(r.sess, r.Args)
+		}},
+		{Name: "REPLICAOF", Arity: 3, checksArgs: true, Flags: flags(FlagAdmin, FlagNoScript), Handler: s.handleReplicaOf},
+		{Name: "PSYNC", Arity: -1, checksArgs: true, Flags: flags(FlagAdmin, FlagNoScript), Handler: connectionOnly},
+		{Name: "ROLE", Arity: 1, checksArgs: true, Flags: flags(FlagAdmin, FlagFast), Handler: func(r *Request) string {
+			if len(r.Args) != 0 {
+				return protocol.Error(protocol.CodeSyntax, "ROLE takes no arguments")
+			}
+			return s.role()
+		}},
+		{Name: "REPLICAS", Arity: 1, checksArgs: true, Flags: flags(FlagAdmin), Handler: s.handleReplicas},
+		{Name: "CLUSTER", Arity: -2, checksArgs: true, Flags: flags(FlagAdmin, FlagNoScript), Handler: func(r *Request) string {
+			return s.handleClusterCommand(r.Args)
+		}},
+		{Name: "ASKING", Arity: 1, checksArgs: true, Flags: flags(FlagFast, FlagNoScript), Handler: s.handleAsking},
+		{Name: "MIGRATE", Arity: -4, checksArgs: true, Flags: flags(FlagWrite, FlagNoScript, FlagNoRedirect), FirstKey: 3, LastKey: -1, KeyStep: 1, Handler: func(r *Request) string {
+			return s.handleMigrate(r.Args)
+		}},
+		{Name: "RESTORE", Arity: -5, checksArgs: true, Flags: flags(FlagWrite), FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: func(r *Request) string {
+			return s.handleRestore(r.Args)
+		}},
+		{Name: "RAFT", Arity: -2, checksArgs: true, Flags: flags(FlagAdmin, FlagNoScript), Handler: func(r *Request) string {
+			return s.handleRaftCommand(r.Args)
+		}},
+		{Name: "INFO", Arity: -1, checksArgs: true, Flags: flags(FlagAdmin), Handler: func(r *Request) string {
+			return s.handleInfo(r.Args)
+		}},
+		{Name: "SLOWLOG", Arity: -2, checksArgs: true, Flags: flags(FlagAdmin), Handler: func(r *Request) string {
+			return s.handleSlowLog(r.Args)
+		}},
+		{Name: "LATENCY", Arity: -2, checksArgs: true, Flags: flags(FlagAdmin), Handler: func(r *Request) string {
+			return s.handleLatency(r.Args)
+		}},
+		{Name: "MONITOR", Arity: 1, checksArgs: true, Flags: flags(FlagAdmin, FlagNoScript), Handler: connectionOnly},
+		{Name: "CLIENT", Arity: -2, checksArgs: true, Flags: flags(FlagAdmin, FlagNoScript, FlagNoPause), Handler: func(r *Request) string {
+			return s.handleClientCommand(r.sess, r.Args)
+		}},
+	}
+}
+
+// connectionOnly handles commands that take over a client connection, such as
+// MONITOR, when they reach the command handler some other way
+func connectionOnly(r *Request) string {
+	return protocol.Errorf(protocol.CodeErr, "%s is only available to client connections", r.Name)
+}
+
+// lockExclusive keeps commands from interleaving with those flagged
+// FlagExclusive: an exclusive command holds the lock for its whole run, other
+// commands share it. Nested commands already hold it.
+func (s *Server) lockExclusive(sess *session, command *Command) func() {
+	switch {
+	case sess.nested:
+		return func() {}
+	case command.has(FlagExclusive):
+		s.exclusive.Lock()
+		return s.exclusive.Unlock
+	}
+	s.exclusive.RLock()
+	return s.exclusive.RUnlock
+}
+
+// acquire takes the locks a command runs under, and in cluster mode checks
+// that this node serves its keys, returning a redirect reply if not. The
+// returned function releases the locks.
+func (s *Server) acquire(sess *session, command *Command, parts []string, asking bool) (func(), string) {
+	unlockExclusive := s.lockExclusive(sess, command)
+	if s.cluster == nil || command.has(FlagNoRedirect) {
+		return unlockExclusive, ""
+	}
+	keys := command.keys(parts)
+	if len(keys) == 0 {
+		return unlockExclusive, ""
+	}
+	unlockSlots := s.lockSlots(keys)
+	release := func() {
+		unlockSlots()
+		unlockExclusive()
+	}
+	for _, key := range keys {
+		if redirect := s.checkSlot(sess.db, key, asking); redirect != "" {
+			return release, redirect
+		}
+	}
+	return release, ""
+}
+
+// commandKeys returns the keys a command line accesses
+func (s *Server) commandKeys(name string, parts []string) []string {
+	if command, ok := s.commands.lookup(name); ok && len(parts) > 0 {
+		return command.keys(parts)
+	}
+	return nil
+}
+
+// handleCommandCommand processes COMMAND [COUNT | LIST | INFO [name ...] | GETKEYS command [arg ...]].
+// COMMAND alone describes every command.
+func (s *Server) handleCommandCommand(args []string) string {
+	if len(args) == 0 {
+		return s.describeCommands(nil)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "COUNT":
+		if len(args) != 1 {
+			return protocol.Error(protocol.CodeSyntax, "COMMAND COUNT takes no arguments")
+		}
+		return fmt.Sprint(len(s.commands.all()))
+	case "LIST":
+		if len(args) != 1 {
+			return protocol.Error(protocol.CodeSyntax, "COMMAND LIST takes no arguments")
+		}
+		commands := s.commands.all()
+		names := make([]string, len(commands))
+		for i, c := range commands {
+			names[i] = strings.ToLower(c.Name)
+		}
+		return strings.Join(names, ", ")
+	case "INFO":
+		return s.describeCommands(args[1:])
+	case "GETKEYS":
+		if len(args) < 2 {
+			return protocol.Error(protocol.CodeSyntax, "COMMAND GETKEYS requires a command")
+		}
+		command, ok := s.commands.lookup(strings.ToUpper(args[1]))
+		if !ok {
+			return protocol.Errorf(protocol.CodeErr, "Invalid command specified %q", args[1])
+		}
+		if !command.arityOK(len(args) - 1) {
+			return protocol.Error(protocol.CodeSyntax, "Invalid number of arguments specified for command")
+		}
+		keys := command.keys(args[1:])
+		if len(keys) == 0 {
+			return protocol.Error(protocol.CodeErr, "The command has no key arguments")
+		}
+		return strings.Join(keys, ", ")
+	}
+	return protocol.Errorf(protocol.CodeSyntax, "unknown COMMAND subcommand %q", args[0])
+}
+
+// describeCommands describes the named commands, NIL for unknown ones, or
+// every command if none is named
+func (s *Server) describeCommands(names []string) string {
+	var entries []string
+	if len(names) == 0 {
+		for _, c := range s.commands.all() {
+			entries = append(entries, s.describeCommand(c))
+		}
+	}
+	for _, name := range names {
+		c, ok := s.commands.lookup(strings.ToUpper(name))
+		if !ok {
+			entries = append(entries, protocol.Nil)
+			continue
+		}
+		entries = append(entries, s.describeCommand(c))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// describeCommand renders a command as COMMAND INFO reports it
+func (s *Server) describeCommand(c *Command) string {
+	flagNames := make([]string, 0, len(c.Flags)+1)
+	for _, flag := range c.Flags {
+		flagNames = append(flagNames, string(flag))
+	}
+	first, last, step := c.FirstKey, c.LastKey, c.KeyStep
+	if c.Keys != nil {
+		flagNames = append(flagNames, flagMovableKeys)
+		first, last, step = 0, 0, 0
+	}
+	return fmt.Sprintf("name=%s arity=%d flags=%s first_key=%d last_key=%d key_step=%d categories=%s",
+		strings.ToLower(c.Name), c.Arity, strings.Join(flagNames, ","), first, last, step,
+		strings.Join(s.acl.CommandCategories(c.Name), ","))
+}
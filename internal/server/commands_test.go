@@ -0,0 +1,89 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"CacheFlow/internal/protocol"
+)
+
+func TestCommandIntrospection(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	conn := dialRaw(t, srv)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"COMMAND INFO get nope", "name=get arity=2 flags=readonly,fast first_key=1 last_key=1 key_step=1 categories=read,string,fast, NIL"},
+		{"COMMAND INFO eval", "name=eval arity=-4 flags=exclusive,noscript,noredirect,movablekeys first_key=0 last_key=0 key_step=0 categories=scripting"},
+		{"COMMAND COUNT", strconv.Itoa(len(srv.commands.all()))},
+		{"COMMAND GETKEYS MIGRATE host 7000 a b", "a, b"},
+		{"COMMAND GETKEYS EVAL 2 x y 0 return 1", "x, y"},
+		{"COMMAND GETKEYS PING", "ERROR: ERR The command has no key arguments"},
+		{"GET a b", "ERROR: SYNTAX GET requires key"},
+		{"XLEN a b", "ERROR: SYNTAX wrong number of arguments for 'xlen' command"},
+	}
+	for _, tt := range tests {
+		if reply := conn.send(tt.command); reply != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.want, reply)
+		}
+	}
+	if reply := conn.send("COMMAND LIST"); !strings.Contains(reply, "client, cluster, command") {
+		t.Errorf("Expected sorted command names, got %q", reply)
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+
+	incrBy := Command{
+		Name:     "incrby",
+		Arity:    3,
+		Flags:    []CommandFlag{FlagWrite},
+		FirstKey: 1, LastKey: 1, KeyStep: 1,
+		Handler: func(req *Request) string {
+			by, err := strconv.Atoi(req.Args[1])
+			if err != nil {
+				return protocol.Error(protocol.CodeSyntax, "increment is not a number")
+			}
+			// A missing key reads as NIL, which counts as zero
+			n, _ := strconv.Atoi(req.Call("GET", req.Args[0]))
+			if reply := req.Call("SET", req.Args[0], strconv.Itoa(n+by)); reply != "OK" {
+				return reply
+			}
+			return strconv.Itoa(n + by)
+		},
+	}
+	if err := srv.RegisterCommand(incrBy); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := srv.RegisterCommand(incrBy); err == nil {
+		t.Error("Expected registering a taken name to fail")
+	}
+	if err := srv.RegisterCommand(Command{Name: "BROKEN", Arity: 1}); err == nil {
+		t.Error("Expected a command without a handler to be refused")
+	}
+
+	conn := dialRaw(t, srv)
+	for _, want := range []string{"5", "10"} {
+		if reply := conn.send("INCRBY counter 5"); reply != want {
+			t.Errorf("Expected %s, got %q", want, reply)
+		}
+	}
+	if reply := conn.send("INCRBY counter"); reply != "ERROR: SYNTAX wrong number of arguments for 'incrby' command" {
+		t.Errorf("Expected an arity error, got %q", reply)
+	}
+	if reply := conn.send("COMMAND INFO incrby"); reply != "name=incrby arity=3 flags=write first_key=1 last_key=1 key_step=1 categories=write" {
+		t.Errorf("Expected the registered command to be described, got %q", reply)
+	}
+
+	// Registered commands are subject to ACL rules like built-in ones
+	conn.send("ACL SETUSER reader on >pw +@read ~*")
+	reader := dialRaw(t, srv)
+	reader.send("AUTH reader pw")
+	if reply := reader.send("INCRBY counter 1"); !strings.HasPrefix(reply, "ERROR: NOPERM") {
+		t.Errorf("Expected NOPERM, got %q", reply)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/store"
+)
+
+// db returns the database the session has selected
+func (s *Server) db(sess *session) *store.DB {
+	return s.store.DB(sess.db)
+}
+
+// parseDB parses a database index, returning an error reply if it is not one
+func (s *Server) parseDB(arg string) (int, string) {
+	db, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, protocol.Errorf(protocol.CodeSyntax, "invalid database index %q", arg)
+	}
+	if db < 0 || db >= s.store.Databases() {
+		return 0, protocol.Errorf(protocol.CodeErr, "DB index is out of range, expected 0 to %d", s.store.Databases()-1)
+	}
+	return db, ""
+}
+
+// handleSelect processes SELECT index, which switches the connection to another database
+func (s *Server) handleSelect(sess *session, args []string) string {
+	if len(args) != 1 {
+		return protocol.Error(protocol.CodeSyntax, "SELECT requires a database index")
+	}
+	db, reply := s.parseDB(args[0])
+	if reply != "" {
+		return reply
+	}
+	// Slots map keys of database 0 only
+	if s.cluster != nil && db != 0 {
+		return protocol.Error(protocol.CodeErr, "SELECT is not allowed in cluster mode")
+	}
+	sess.db = db
+	if sess.client != nil {
+		sess.client.db.Store(int64(db))
+	}
+	return "OK"
+}
+
+// handleSwapDB processes SWAPDB a b, which exchanges the keys of two databases
+// for every connection at once
+func (s *Server) handleSwapDB(args []string) string {
+	if len(args) != 2 {
+		return protocol.Error(protocol.CodeSyntax, "SWAPDB requires two database indexes")
+	}
+	a, reply := s.parseDB(args[0])
+	if reply != "" {
+		return reply
+	}
+	b, reply := s.parseDB(args[1])
+	if reply != "" {
+		return reply
+	}
+	if s.cluster != nil {
+		return protocol.Error(protocol.CodeErr, "SWAPDB is not allowed in cluster mode")
+	}
+	if s.raft != nil {
+		return s.proposeWrite(0, []string{"SWAPDB", strconv.Itoa(a), strconv.Itoa(b)})
+	}
+	if err := s.store.SwapDB(a, b); err != nil {
+		return protocol.Errorf(protocol.CodeErr, "%v", err)
+	}
+	return "OK"
+}
+
+// handleFlush processes FLUSHDB [ASYNC|SYNC] and FLUSHALL [ASYNC|SYNC]. With
+// ASYNC the reply is sent at once and the memory is freed in the background.
+func (s *Server) handleFlush(sess *session, command string, args []string) string {
+	async := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && strings.ToUpper(args[0]) == "ASYNC":
+		async = true
+	case len(args) == 1 && strings.ToUpper(args[0]) == "SYNC":
+	default:
+		return protocol.Errorf(protocol.CodeSyntax, "%s takes ASYNC or SYNC", command)
+	}
+
+	if s.raft != nil {
+		parts := append([]string{command}, args...)
+		if command == "FLUSHALL" {
+			return s.proposeWrite(0, parts)
+		}
+		return s.proposeWrite(sess.db, parts)
+	}
+	if command == "FLUSHALL" {
+		s.store.FlushAll(async)
+	} else {
+		s.db(sess).Flush(async)
+	}
+	return "OK"
+}
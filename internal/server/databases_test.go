@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"CacheFlow/internal/client"
+)
+
+func TestSelect(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.Databases = 4 })
+
+	a := dialRaw(t, srv)
+	b := dialRaw(t, srv)
+	a.send("SET team billing")
+	if reply := b.send("SELECT 1"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := b.send("GET team"); reply != "NIL" {
+		t.Errorf("Expected db 1 not to see the key of db 0, got %q", reply)
+	}
+	b.send("SET team search")
+	if reply := a.send("GET team"); reply != "billing" {
+		t.Errorf("Expected db 0 to keep its value, got %q", reply)
+	}
+	if reply := b.send("CLIENT INFO"); !strings.Contains(reply, " db=1 ") {
+		t.Errorf("Expected CLIENT INFO to show db=1, got %q", reply)
+	}
+
+	for _, cmd := range []string{"SELECT 4", "SELECT -1", "SWAPDB 0 4"} {
+		if reply := a.send(cmd); !strings.HasPrefix(reply, "ERROR: ERR DB index is out of range") {
+			t.Errorf("Expected %s to be out of range, got %q", cmd, reply)
+		}
+	}
+	if reply := a.send("SELECT one"); !strings.HasPrefix(reply, "ERROR: SYNTAX") {
+		t.Errorf("Expected a syntax error, got %q", reply)
+	}
+
+	// SWAPDB switches every connection to the other keyspace at once
+	if reply := a.send("SWAPDB 0 1"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := a.send("GET team"); reply != "search" {
+		t.Errorf("Expected db 0 to hold the value of db 1, got %q", reply)
+	}
+	if reply := b.send("GET team"); reply != "billing" {
+		t.Errorf("Expected db 1 to hold the value of db 0, got %q", reply)
+	}
+
+	if reply := b.send("FLUSHDB ASYNC"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := b.send("EXISTS team"); reply != "0" {
+		t.Errorf("Expected FLUSHDB to empty db 1, got %q", reply)
+	}
+	if reply := a.send("EXISTS team"); reply != "1" {
+		t.Errorf("Expected FLUSHDB to leave db 0 alone, got %q", reply)
+	}
+	if reply := a.send("FLUSHALL NOW"); !strings.HasPrefix(reply, "ERROR: SYNTAX") {
+		t.Errorf("Expected a syntax error, got %q", reply)
+	}
+	if reply := a.send("FLUSHALL"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := a.send("EXISTS team"); reply != "0" {
+		t.Errorf("Expected FLUSHALL to empty db 0, got %q", reply)
+	}
+}
+
+func TestDatabasesPersisted(t *testing.T) {
+	aof := filepath.Join(t.TempDir(), "aof.log")
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.AOFFilename = aof })
+
+	c, err := client.NewWithOptions(srv.Addr().String(), client.Options{DB: 3})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(context.Background(), "session", "abc", 0); err != nil {
+		t.Fatalf("Failed to set: %v", err)
+	}
+	if info := srv.Info("keyspace"); len(info) != 1 || len(info[0].Fields) != 1 || info[0].Fields[0].Name != "db3" {
+		t.Errorf("Expected keyspace to list db3 only, got %+v", info)
+	}
+	srv.Close()
+
+	restarted := startConfiguredNode(t, func(cfg *Config) { cfg.AOFFilename = aof })
+	conn := dialRaw(t, restarted)
+	if reply := conn.send("EXISTS session"); reply != "0" {
+		t.Errorf("Expected the key to stay out of db 0, got %q", reply)
+	}
+	conn.send("SELECT 3")
+	if reply := conn.send("GET session"); reply != "abc" {
+		t.Errorf("Expected the key to be reloaded into db 3, got %q", reply)
+	}
+}
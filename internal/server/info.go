@@ -0,0 +1,271 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/store"
+)
+
+// Version is the CacheFlow version reported by INFO
+const Version = "0.1.0"
+
+// infoSections are the sections of INFO in the order they are reported
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "commandstats", "keyspace", "namespaces"}
+
+// InfoSection is a named group of INFO fields
+type InfoSection struct {
+	Name   string
+	Fields []InfoField
+}
+
+// InfoField is a single INFO value
+type InfoField struct {
+	Name  string
+	Value string
+}
+
+// serverStats are the counters reported in the stats section of INFO
+type serverStats struct {
+	connections   atomic.Int64 // connections accepted
+	rejectedConns atomic.Int64 // connections rejected by MaxClients
+	commands      atomic.Int64 // commands processed
+	errorReplies  atomic.Int64 // replies that were errors
+	netInput      atomic.Int64 // bytes of requests read
+	netOutput     atomic.Int64 // bytes of replies written
+}
+
+// infoBuilder collects the fields of one section
+type infoBuilder struct {
+	fields []InfoField
+}
+
+// add appends a field, formatting its value with %v
+func (b *infoBuilder) add(name string, value any) {
+	b.fields = append(b.fields, InfoField{Name: name, Value: fmt.Sprintf("%v", value)})
+}
+
+// Info returns the named sections in their usual order, or every section if
+// none is named or one of them is "all". Unknown names are ignored.
+func (s *Server) Info(sections ...string) []InfoSection {
+	wanted := make(map[string]bool)
+	for _, name := range sections {
+		wanted[strings.ToLower(name)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["everything"] || wanted["default"]
+
+	// The store walks every key for its stats, so they are collected once
+	var storeStats *store.Stats
+	dataset := func() store.Stats {
+		if storeStats == nil {
+			stats := s.store.Stats()
+			storeStats = &stats
+		}
+		return *storeStats
+	}
+
+	var result []InfoSection
+	for _, name := range infoSections {
+		if !all && !wanted[name] {
+			continue
+		}
+		var b infoBuilder
+		switch name {
+		case "server":
+			s.serverInfo(&b)
+		case "clients":
+			b.add("connected_clients", s.clients.Load())
+			b.add("maxclients", s.limits.maxClients)
+			b.add("idle_timeout_seconds", int64(s.limits.idleTimeout.Seconds()))
+		case "memory":
+			var mem runtime.MemStats
+			runtime.ReadMemStats(&mem)
+			b.add("used_memory", mem.HeapAlloc)
+			b.add("used_memory_sys", mem.Sys)
+			b.add("used_memory_dataset", dataset().DataSize)
+			b.add("lazyfree_pending_objects", dataset().LazyFreePending)
+			b.add("number_of_cached_scripts", s.scripts.len())
+			b.add("gc_runs", mem.NumGC)
+			b.add("goroutines", runtime.NumGoroutine())
+		case "persistence":
+			s.persistenceInfo(&b)
+		case "stats":
+			stats := dataset()
+			b.add("total_connections_received", s.stats.connections.Load())
+			b.add("rejected_connections", s.stats.rejectedConns.Load())
+			b.add("total_commands_processed", s.stats.commands.Load())
+			b.add("total_error_replies", s.stats.errorReplies.Load())
+			b.add("total_net_input_bytes", s.stats.netInput.Load())
+			b.add("total_net_output_bytes", s.stats.netOutput.Load())
+			b.add("keyspace_hits", stats.Hits)
+			b.add("keyspace_misses", stats.Misses)
+			b.add("expired_keys", stats.ExpiredKeys)
+			b.add("sync_full", s.primary.FullResyncs())
+			b.add("sync_partial_ok", s.primary.PartialResyncs())
+		case "replication":
+			s.replicationInfo(&b)
+		case "commandstats":
+			s.commandStats.info(&b)
+		case "namespaces":
+			for _, ns := range s.store.Namespaces() {
+				b.add("ns_"+ns.Name, fmt.Sprintf("keys=%d,used_memory=%d,maxmemory=%d,evicted_keys=%d", ns.Keys, ns.Memory, ns.MaxMemory, ns.EvictedKeys))
+			}
+		case "keyspace":
+			for db, keyspace := range dataset().Keyspace {
+				if keyspace.Keys > 0 {
+					b.add(fmt.Sprintf("db%d", db), fmt.Sprintf("keys=%d,expires=%d", keyspace.Keys, keyspace.Expires))
+				}
+			}
+		}
+		result = append(result, InfoSection{Name: name, Fields: b.fields})
+	}
+	return result
+}
+
+// serverInfo adds the fields of the server section
+func (s *Server) serverInfo(b *infoBuilder) {
+	mode := "standalone"
+	switch {
+	case s.raft != nil:
+		mode = "raft"
+	case s.cluster != nil:
+		mode = "cluster"
+	}
+	var tcpAddr, unixSocket string
+	if s.listener != nil {
+		tcpAddr = s.listener.Addr().String()
+	}
+	if s.unixListener != nil {
+		unixSocket = s.unixListener.Addr().String()
+	}
+
+	b.add("version", Version)
+	b.add("go_version", runtime.Version())
+	b.add("os", runtime.GOOS+"/"+runtime.GOARCH)
+	b.add("process_id", os.Getpid())
+	b.add("mode", mode)
+	b.add("tcp_addr", tcpAddr)
+	b.add("unix_socket", unixSocket)
+	b.add("tls", yesNo(s.tlsConfig != nil))
+	b.add("uptime_in_seconds", int64(time.Since(s.started).Seconds()))
+}
+
+// persistenceInfo adds the fields of the persistence section
+func (s *Server) persistenceInfo(b *infoBuilder) {
+	aof := s.store.AOFStats()
+	var lastRewrite int64
+	if !aof.LastRewrite.IsZero() {
+		lastRewrite = aof.LastRewrite.Unix()
+	}
+	status := "ok"
+	if aof.LastWriteErr != nil {
+		status = "err"
+	}
+
+	b.add("aof_enabled", boolInt(aof.Enabled))
+	b.add("aof_encrypted", boolInt(aof.KeyID != ""))
+	b.add("aof_key_id", aof.KeyID)
+	b.add("aof_current_size", aof.Size)
+	b.add("aof_bytes_written", aof.BytesWritten)
+	b.add("aof_rewrites", aof.Rewrites)
+	b.add("aof_last_rewrite_time", lastRewrite)
+	b.add("aof_last_write_status", status)
+}
+
+// replicationInfo adds the fields of the replication section
+func (s *Server) replicationInfo(b *infoBuilder) {
+	s.mu.Lock()
+	replica := s.replica
+	s.mu.Unlock()
+
+	backlog := s.primary.Backlog()
+	if replica != nil {
+		status := replica.Status()
+		b.add("role", "replica")
+		b.add("primary_addr", status.PrimaryAddr)
+		b.add("primary_link_status", status.State)
+		b.add("repl_offset", status.Offset)
+	} else {
+		replicas := s.primary.Replicas()
+		b.add("role", "primary")
+		b.add("connected_replicas", len(replicas))
+		for i, r := range replicas {
+			addr := r.ListenAddr
+			if addr == "" {
+				addr = r.Addr
+			}
+			b.add("replica"+strconv.Itoa(i), fmt.Sprintf("addr=%s,offset=%d", addr, r.AckOffset))
+		}
+		b.add("repl_offset", backlog.Offset())
+	}
+	b.add("repl_id", backlog.ID())
+	b.add("repl_backlog_size", backlog.Size())
+
+	if s.raft != nil {
+		status := s.raft.Status()
+		b.add("raft_state", status.State)
+		b.add("raft_term", status.Term)
+		b.add("raft_leader", status.LeaderID)
+		b.add("raft_commit_index", status.CommitIndex)
+		b.add("raft_members", len(status.Members))
+	}
+}
+
+// formatInfo renders sections as a single reply line: a "# Name" entry
+// opens each section, followed by its "field:value" entries
+func formatInfo(sections []InfoSection) string {
+	var entries []string
+	for _, section := range sections {
+		entries = append(entries, "# "+strings.ToUpper(section.Name[:1])+section.Name[1:])
+		for _, field := range section.Fields {
+			entries = append(entries, field.Name+":"+field.Value)
+		}
+	}
+	return strings.Join(entries, ", ")
+}
+
+// handleInfo processes INFO [section ...]
+func (s *Server) handleInfo(args []string) string {
+	for _, name := range args {
+		if !isInfoSection(strings.ToLower(name)) {
+			return protocol.Errorf(protocol.CodeSyntax, "Unknown INFO section %s", name)
+		}
+	}
+	return formatInfo(s.Info(args...))
+}
+
+// isInfoSection reports whether name selects INFO sections
+func isInfoSection(name string) bool {
+	switch name {
+	case "all", "everything", "default":
+		return true
+	}
+	for _, section := range infoSections {
+		if section == name {
+			return true
+		}
+	}
+	return false
+}
+
+// yesNo formats a flag as INFO reports it
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// boolInt formats a flag as 1 or 0
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
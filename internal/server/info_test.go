@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+)
+
+func TestInfo(t *testing.T) {
+	ctx := context.Background()
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.AOFFilename = filepath.Join(t.TempDir(), "aof.log")
+	})
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	c.Set(ctx, "a", "1", 0)
+	c.Set(ctx, "b", "2", time.Hour)
+	c.Get(ctx, "a")
+	c.Get(ctx, "missing")
+	c.Do(ctx, "BOGUS")
+
+	info, err := c.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	for _, section := range infoSections {
+		if _, ok := info[section]; !ok {
+			t.Errorf("Expected section %s, got %v", section, info)
+		}
+	}
+	if v := info.Get("server", "version"); v != Version {
+		t.Errorf("Expected version %s, got %q", Version, v)
+	}
+	if n := info.Int("clients", "connected_clients"); n != 1 {
+		t.Errorf("Expected 1 connected client, got %d", n)
+	}
+	if v := info.Get("keyspace", "db0"); v != "keys=2,expires=1" {
+		t.Errorf("Expected keys=2,expires=1, got %q", v)
+	}
+	if hits, misses := info.Int("stats", "keyspace_hits"), info.Int("stats", "keyspace_misses"); hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d and %d", hits, misses)
+	}
+	// The two SETs, two GETs and the unknown command; INFO itself is counted after replying
+	if n := info.Int("stats", "total_commands_processed"); n != 5 {
+		t.Errorf("Expected 5 commands processed, got %d", n)
+	}
+	if n := info.Int("stats", "total_error_replies"); n != 1 {
+		t.Errorf("Expected 1 error reply, got %d", n)
+	}
+	if info.Int("persistence", "aof_enabled") != 1 || info.Int("persistence", "aof_bytes_written") == 0 {
+		t.Errorf("Expected AOF writes to be counted, got %v", info["persistence"])
+	}
+	if role := info.Get("replication", "role"); role != "primary" {
+		t.Errorf("Expected role primary, got %q", role)
+	}
+
+	// Sections can be selected, and unknown ones are rejected
+	info, err = c.Info(ctx, "clients", "keyspace")
+	if err != nil || len(info) != 2 {
+		t.Errorf("Expected two sections, got %v (%v)", info, err)
+	}
+	if _, err := c.Info(ctx, "bogus"); !errors.Is(err, client.ErrSyntax) {
+		t.Errorf("Expected a syntax error for an unknown section, got %v", err)
+	}
+
+	// The Go API returns the same sections without a connection
+	sections := srv.Info("memory")
+	if len(sections) != 1 || sections[0].Name != "memory" || len(sections[0].Fields) == 0 {
+		t.Errorf("Expected the memory section, got %+v", sections)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"CacheFlow/internal/protocol"
+)
+
+// latencyHistoryLen is how many samples the latency monitor keeps per event
+const latencyHistoryLen = 160
+
+// Events recorded by the latency monitor
+const (
+	latencyCommand     = "command"      // a command, timed as for the slow log
+	latencyAOFFsync    = "aof-fsync"    // an fsync after an AOF append
+	latencyExpireCycle = "expire-cycle" // a sweep removing expired keys
+)
+
+// LatencySample is the worst latency of an event within one second
+type LatencySample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// latencyMonitor keeps the history of internal events that took at least a threshold
+type latencyMonitor struct {
+	threshold time.Duration // zero disables the monitor
+
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+// latencyEvent is the history of one event
+type latencyEvent struct {
+	samples []LatencySample // oldest first
+	max     time.Duration
+}
+
+// add records an event if it took at least the threshold. Events within the
+// same second share a sample holding the worst of them.
+func (m *latencyMonitor) add(event string, d time.Duration) {
+	if m.threshold <= 0 || d < m.threshold {
+		return
+	}
+	now := time.Now().Truncate(time.Second)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.events == nil {
+		m.events = make(map[string]*latencyEvent)
+	}
+	e := m.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	e.max = max(e.max, d)
+	if n := len(e.samples); n > 0 && e.samples[n-1].Time.Equal(now) {
+		e.samples[n-1].Latency = max(e.samples[n-1].Latency, d)
+		return
+	}
+	if len(e.samples) >= latencyHistoryLen {
+		e.samples = append(e.samples[:0], e.samples[1:]...)
+	}
+	e.samples = append(e.samples, LatencySample{Time: now, Latency: d})
+}
+
+// latest returns the latest sample and the worst latency of every event, by event name
+func (m *latencyMonitor) latest() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []string
+	for _, name := range sortedEvents(m.events) {
+		e := m.events[name]
+		last := e.samples[len(e.samples)-1]
+		entries = append(entries, fmt.Sprintf("%s %d %d %d", name, last.Time.Unix(), last.Latency.Milliseconds(), e.max.Milliseconds()))
+	}
+	return entries
+}
+
+// history returns the samples of an event, oldest first
+func (m *latencyMonitor) history(event string) []LatencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.events[event]; e != nil {
+		return append([]LatencySample(nil), e.samples...)
+	}
+	return nil
+}
+
+// reset removes the history of the named events, or of every event if none
+// is named, and returns how many were removed
+func (m *latencyMonitor) reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = nil
+		return n
+	}
+	var n int
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+// sortedEvents returns the names of events in order
+func sortedEvents(events map[string]*latencyEvent) []string {
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LatencyHistory returns the samples recorded for an event, oldest first
+func (s *Server) LatencyHistory(event string) []LatencySample {
+	return s.latency.history(event)
+}
+
+// commandStat counts the calls of one command and the time spent in them
+type commandStat struct {
+	calls atomic.Int64
+	usec  atomic.Int64
+}
+
+// commandStats tracks every known command, for the commandstats section of INFO
+type commandStats struct {
+	mu    sync.Mutex
+	stats map[string]*commandStat
+}
+
+// add counts a call of command
+func (c *commandStats) add(command string, d time.Duration) {
+	c.mu.Lock()
+	if c.stats == nil {
+		c.stats = make(map[string]*commandStat)
+	}
+	stat := c.stats[command]
+	if stat == nil {
+		stat = &commandStat{}
+		c.stats[command] = stat
+	}
+	c.mu.Unlock()
+
+	stat.calls.Add(1)
+	stat.usec.Add(d.Microseconds())
+}
+
+// info adds a cmdstat_<command> field per command that has been called
+func (c *commandStats) info(b *infoBuilder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.stats))
+	for name := range c.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		calls, usec := c.stats[name].calls.Load(), c.stats[name].usec.Load()
+		b.add("cmdstat_"+strings.ToLower(name), fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f",
+			calls, usec, float64(usec)/float64(calls)))
+	}
+}
+
+// commandDone records a processed command in the command statistics, the
+// slow log, the latency monitor and the metrics
+func (s *Server) commandDone(client net.Addr, parts []string, d time.Duration) {
+	if len(parts) == 0 {
+		return
+	}
+	if command := strings.ToUpper(parts[0]); s.acl.KnownCommand(command) {
+		s.commandStats.add(command, d)
+	}
+	s.slowLog.add(client.String(), parts, d)
+	s.latency.add(latencyCommand, d)
+	s.metrics.observe(parts, d)
+}
+
+// fsyncDone records the duration of an AOF fsync
+func (s *Server) fsyncDone(d time.Duration) {
+	s.latency.add(latencyAOFFsync, d)
+	if s.metrics != nil {
+		s.metrics.fsync.ObserveDuration("", d)
+	}
+}
+
+// handleLatency processes LATENCY LATEST | HISTORY event | RESET [event ...]
+func (s *Server) handleLatency(args []string) string {
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "LATENCY requires a subcommand")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "LATEST":
+		return strings.Join(s.latency.latest(), ", ")
+
+	case "HISTORY":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "LATENCY HISTORY requires an event name")
+		}
+		var entries []string
+		for _, sample := range s.latency.history(args[1]) {
+			entries = append(entries, fmt.Sprintf("%d %d", sample.Time.Unix(), sample.Latency.Milliseconds()))
+		}
+		return strings.Join(entries, ", ")
+
+	case "RESET":
+		return strconv.Itoa(s.latency.reset(args[1:]...))
+
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown LATENCY subcommand")
+	}
+}
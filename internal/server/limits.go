@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"CacheFlow/internal/protocol"
+)
+
+// connLimits are the per-connection limits of a Config; zero disables each
+type connLimits struct {
+	maxClients        int
+	idleTimeout       time.Duration
+	maxRequestSize    int
+	maxArgSize        int
+	outputBufferLimit int
+	outputTimeout     time.Duration
+}
+
+// errRequestTooLarge is returned by readRequest for a line longer than the limit
+var errRequestTooLarge = errors.New("request too large")
+
+// admit counts a new connection, rejecting it with an error reply if the
+// server already has MaxClients connections. It returns false if conn was
+// rejected; otherwise the caller must call release when conn closes.
+func (s *Server) admit(conn net.Conn) bool {
+	clients := s.clients.Add(1)
+	if s.limits.maxClients <= 0 || clients <= int64(s.limits.maxClients) {
+		return true
+	}
+	s.clients.Add(-1)
+	s.stats.rejectedConns.Add(1)
+	s.connLog.Warn("Rejecting connection: max number of clients reached", "client", conn.RemoteAddr(), "maxclients", s.limits.maxClients)
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte(protocol.Error(protocol.CodeErr, "max number of clients reached") + "\n"))
+	conn.Close()
+	return false
+}
+
+// release uncounts a connection accepted by admit
+func (s *Server) release() {
+	s.clients.Add(-1)
+}
+
+// readRequest reads a command line, waiting at most the idle timeout for it
+// and failing with errRequestTooLarge if it exceeds MaxRequestSize
+func (s *Server) readRequest(conn net.Conn, reader *bufio.Reader) (string, error) {
+	if s.limits.idleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.limits.idleTimeout))
+	}
+
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if s.limits.maxRequestSize > 0 && len(line)+len(chunk) > s.limits.maxRequestSize {
+			return "", errRequestTooLarge
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		s.stats.netInput.Add(int64(len(line)))
+		return string(line), nil
+	}
+}
+
+// checkArgs returns an error reply if an argument exceeds MaxArgSize, or "" if the command may run
+func (s *Server) checkArgs(parts []string) string {
+	if s.limits.maxArgSize <= 0 {
+		return ""
+	}
+	for i, part := range parts {
+		if len(part) > s.limits.maxArgSize {
+			return protocol.Errorf(protocol.CodeErr, "argument %d is %d bytes, over the limit of %d", i, len(part), s.limits.maxArgSize)
+		}
+	}
+	return ""
+}
+
+// writeReply sends a reply, failing if it exceeds OutputBufferLimit or the
+// client does not accept it within OutputTimeout. Either disconnects the client.
+func (s *Server) writeReply(conn net.Conn, writer *bufio.Writer, reply string) error {
+	if s.limits.outputBufferLimit > 0 && len(reply)+1 > s.limits.outputBufferLimit {
+		return fmt.Errorf("reply of %d bytes exceeds the output buffer limit of %d", len(reply)+1, s.limits.outputBufferLimit)
+	}
+	if s.limits.outputTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.limits.outputTimeout))
+	}
+	if protocol.IsError(reply) {
+		s.stats.errorReplies.Add(1)
+	}
+	if _, err := writer.WriteString(reply + "\n"); err != nil {
+		return err
+	}
+	s.stats.netOutput.Add(int64(len(reply) + 1))
+	return writer.Flush()
+}
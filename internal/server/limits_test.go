@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/persistence"
+)
+
+// rawConn is a plain connection for sending request lines byte for byte
+type rawConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func dialRaw(t *testing.T, srv *Server) *rawConn {
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &rawConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes a request line and returns the reply, or "" if the connection was closed
+func (c *rawConn) send(line string) string {
+	c.Write([]byte(line + "\n"))
+	reply, _ := c.reader.ReadString('\n')
+	return strings.TrimSpace(reply)
+}
+
+func TestMaxClients(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.MaxClients = 1 })
+
+	first := dialRaw(t, srv)
+	if reply := first.send("PING"); reply != "PONG" {
+		t.Fatalf("Expected PONG, got %q", reply)
+	}
+	second := dialRaw(t, srv)
+	if reply, _ := second.reader.ReadString('\n'); !strings.HasPrefix(reply, "ERROR: ERR max number of clients") {
+		t.Errorf("Expected the second client to be rejected, got %q", reply)
+	}
+
+	// Closing the first connection frees its place
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for dialRaw(t, srv).send("PING") != "PONG" {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a free client slot")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.IdleTimeout = 100 * time.Millisecond })
+
+	conn := dialRaw(t, srv)
+	if reply := conn.send("PING"); reply != "PONG" {
+		t.Fatalf("Expected PONG, got %q", reply)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if reply := conn.send("PING"); reply != "" {
+		t.Errorf("Expected the idle connection to be closed, got %q", reply)
+	}
+}
+
+func TestRequestSizeLimits(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.MaxRequestSize = 1024
+		cfg.MaxArgSize = 16
+	})
+
+	// An oversized argument is refused but the connection stays usable
+	conn := dialRaw(t, srv)
+	if reply := conn.send("SET key " + strings.Repeat("v", 17)); !strings.HasPrefix(reply, "ERROR: ERR argument 2") {
+		t.Errorf("Expected an argument size error, got %q", reply)
+	}
+	if reply := conn.send("PING"); reply != "PONG" {
+		t.Errorf("Expected the connection to stay open, got %q", reply)
+	}
+
+	// An oversized request closes the connection
+	if reply := conn.send("SET key " + strings.Repeat("v ", 1024)); !strings.HasPrefix(reply, "ERROR: ERR Protocol error") {
+		t.Errorf("Expected a protocol error, got %q", reply)
+	}
+	if reply, err := conn.reader.ReadString('\n'); err == nil {
+		t.Errorf("Expected the connection to be closed, got %q", reply)
+	}
+}
+
+func TestRequestSizePersistence(t *testing.T) {
+	aofFile := filepath.Join(t.TempDir(), "aof.log")
+	for _, size := range []int{0, persistence.MaxCommandSize + 1} {
+		cfg := DefaultConfig("127.0.0.1:0")
+		cfg.AOFFilename = aofFile
+		cfg.MaxRequestSize = size
+		if _, err := NewWithConfig(cfg); err == nil {
+			t.Errorf("Expected a max request size of %d to be refused with an AOF file", size)
+		}
+	}
+
+	// A request the default limit accepts is read back from the AOF file
+	value := strings.Repeat("v", 256<<10)
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.AOFFilename = aofFile })
+	if reply := dialRaw(t, srv).send("SET big " + value); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	srv.Close()
+	srv = startConfiguredNode(t, func(cfg *Config) { cfg.AOFFilename = aofFile })
+	if reply := dialRaw(t, srv).send("GET big"); reply != value {
+		t.Errorf("Expected the large value back after a restart, got %d bytes", len(reply))
+	}
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.OutputBufferLimit = 64 })
+
+	conn := dialRaw(t, srv)
+	if reply := conn.send("SET key " + strings.Repeat("v", 100)); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := conn.send("GET key"); reply != "" {
+		t.Errorf("Expected a reply over the limit to disconnect the client, got %q", reply)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"CacheFlow/internal/metrics"
+)
+
+// serverMetrics holds the metrics recorded as commands run; the rest are
+// read from the store and the server when the endpoint is scraped
+type serverMetrics struct {
+	registry *metrics.Registry
+	commands *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	fsync    *metrics.HistogramVec
+	known    func(command string) bool // whether a command gets its own label
+
+	addr     string
+	listener net.Listener
+	http     *http.Server
+	log      *slog.Logger
+}
+
+// newMetrics registers the metrics of a server that exports them on addr
+func (s *Server) newMetrics(addr string) *serverMetrics {
+	registry := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: registry,
+		commands: registry.NewCounterVec("cacheflow_commands_total", "Commands processed, by command", "command"),
+		latency: registry.NewHistogramVec("cacheflow_command_duration_seconds", "Time spent processing commands, by command",
+			"command", metrics.DefaultLatencyBuckets),
+		fsync: registry.NewHistogramVec("cacheflow_aof_fsync_duration_seconds", "Time spent syncing AOF appends to disk",
+			"", metrics.DefaultLatencyBuckets),
+		known: s.acl.KnownCommand,
+		addr:  addr,
+		log:   s.log,
+	}
+	registry.Collect(s.collectMetrics)
+	return m
+}
+
+// observe records a processed command. The label is the command name for
+// known commands and "unknown" otherwise, so clients cannot create series at will.
+func (m *serverMetrics) observe(parts []string, d time.Duration) {
+	if m == nil || len(parts) == 0 {
+		return
+	}
+	command := strings.ToUpper(parts[0])
+	if !m.known(command) {
+		command = "unknown"
+	}
+	m.commands.Inc(command)
+	m.latency.ObserveDuration(command, d)
+}
+
+// collectMetrics reports the values read at scrape time
+func (s *Server) collectMetrics(g *metrics.Gatherer) {
+	stats := s.store.Stats()
+	g.Counter("cacheflow_keyspace_hits_total", "Lookups that found a key", float64(stats.Hits))
+	g.Counter("cacheflow_keyspace_misses_total", "Lookups that did not find a key", float64(stats.Misses))
+	ratio := 0.0
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		ratio = float64(stats.Hits) / float64(lookups)
+	}
+	g.Gauge("cacheflow_keyspace_hit_ratio", "Share of lookups that found a key", ratio)
+	g.Gauge("cacheflow_keys", "Keys in the store", float64(stats.Keys))
+	g.Gauge("cacheflow_expiring_keys", "Keys with a TTL", float64(stats.Expires))
+	g.Counter("cacheflow_expired_keys_total", "Keys removed because their TTL passed", float64(stats.ExpiredKeys))
+	g.Counter("cacheflow_evicted_keys_total", "Keys removed to free memory", float64(stats.EvictedKeys))
+
+	g.Gauge("cacheflow_connected_clients", "Connections currently served", float64(s.clients.Load()))
+	g.Counter("cacheflow_connections_received_total", "Connections accepted", float64(s.stats.connections.Load()))
+	g.Counter("cacheflow_connections_rejected_total", "Connections rejected by the client limit", float64(s.stats.rejectedConns.Load()))
+
+	aof := s.store.AOFStats()
+	if !aof.Enabled {
+		return
+	}
+	var lastRewrite float64
+	if !aof.LastRewrite.IsZero() {
+		lastRewrite = float64(aof.LastRewrite.Unix())
+	}
+	g.Gauge("cacheflow_aof_size_bytes", "Size of the AOF file", float64(aof.Size))
+	g.Counter("cacheflow_aof_rewrites_total", "AOF rewrites since the server started", float64(aof.Rewrites))
+	g.Gauge("cacheflow_aof_last_rewrite_timestamp_seconds", "When the latest AOF rewrite finished", lastRewrite)
+	g.Gauge("cacheflow_aof_last_rewrite_success", "Whether the latest AOF rewrite succeeded", float64(boolInt(aof.LastRewriteErr == nil)))
+	g.Gauge("cacheflow_aof_last_write_success", "Whether the latest AOF append succeeded", float64(boolInt(aof.LastWriteErr == nil)))
+}
+
+// listen binds the metrics listener
+func (m *serverMetrics) listen() error {
+	listener, err := net.Listen("tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry.Handler())
+	m.listener = listener
+	m.http = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return nil
+}
+
+// serve answers scrapes until the server is closed
+func (m *serverMetrics) serve() {
+	m.log.Info("Metrics available", "url", "http://"+m.listener.Addr().String()+"/metrics")
+	if err := m.http.Serve(m.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		m.log.Error("Metrics listener stopped", "err", err)
+	}
+}
+
+// close stops the metrics listener
+func (m *serverMetrics) close() {
+	if m != nil && m.http != nil {
+		m.http.Close()
+	}
+}
+
+// MetricsAddr returns the address metrics are served on, or nil if they are
+// disabled or the server is not listening yet
+func (s *Server) MetricsAddr() net.Addr {
+	if s.metrics == nil || s.metrics.listener == nil {
+		return nil
+	}
+	return s.metrics.listener.Addr()
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"CacheFlow/internal/client"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.AOFFilename = filepath.Join(t.TempDir(), "aof.log")
+		cfg.MetricsAddr = "127.0.0.1:0"
+	})
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	c.Set(ctx, "a", "1", 0)
+	c.Get(ctx, "a")
+	c.Get(ctx, "a")
+	c.Get(ctx, "missing")
+	c.Do(ctx, "BOGUS")
+
+	resp, err := http.Get("http://" + srv.MetricsAddr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the Prometheus text format, got %q", ct)
+	}
+
+	for _, line := range []string{
+		`cacheflow_commands_total{command="GET"} 3`,
+		`cacheflow_commands_total{command="SET"} 1`,
+		`cacheflow_commands_total{command="unknown"} 1`,
+		`cacheflow_command_duration_seconds_count{command="GET"} 3`,
+		`cacheflow_keyspace_hits_total 2`,
+		`cacheflow_keyspace_misses_total 1`,
+		`cacheflow_keys 1`,
+		`cacheflow_connected_clients 1`,
+		`cacheflow_aof_fsync_duration_seconds_count 1`,
+		`cacheflow_aof_last_rewrite_success 1`,
+		`# TYPE cacheflow_command_duration_seconds histogram`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Expected %q in metrics, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	if addr := srv.MetricsAddr(); addr != nil {
+		t.Errorf("Expected no metrics listener, got %v", addr)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitorBufferSize is how many lines a monitor may fall behind before it is disconnected
+const monitorBufferSize = 4096
+
+// monitors fans processed commands out to the connections in MONITOR mode
+type monitors struct {
+	count atomic.Int64 // lets feed return at once when nobody is monitoring
+
+	mu   sync.Mutex
+	subs map[*monitor]struct{}
+}
+
+// monitor is one connection in MONITOR mode
+type monitor struct {
+	lines chan string // closed when the monitor falls too far behind
+}
+
+// subscribe adds a monitor
+func (m *monitors) subscribe() *monitor {
+	sub := &monitor{lines: make(chan string, monitorBufferSize)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.subs == nil {
+		m.subs = make(map[*monitor]struct{})
+	}
+	m.subs[sub] = struct{}{}
+	m.count.Add(1)
+	return sub
+}
+
+// unsubscribe removes a monitor unless feed already dropped it
+func (m *monitors) unsubscribe(sub *monitor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(sub)
+}
+
+// remove drops a monitor and closes its lines. The caller must hold m.mu.
+func (m *monitors) remove(sub *monitor) {
+	if _, ok := m.subs[sub]; !ok {
+		return
+	}
+	delete(m.subs, sub)
+	close(sub.lines)
+	m.count.Add(-1)
+}
+
+// feed sends a command to every monitor. A monitor whose buffer is full is
+// dropped rather than slowing down the command.
+func (m *monitors) feed(client net.Addr, parts []string) {
+	if m.count.Load() == 0 || len(parts) == 0 {
+		return
+	}
+	line := formatMonitor(time.Now(), client, parts)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.subs {
+		select {
+		case sub.lines <- line:
+		default:
+			m.remove(sub)
+		}
+	}
+}
+
+// formatMonitor renders a command as MONITOR reports it: the time in seconds,
+// the client address and the quoted arguments, with passwords redacted
+func formatMonitor(t time.Time, client net.Addr, parts []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [%s]", t.Unix(), t.Nanosecond()/1000, client)
+	for i, arg := range parts {
+		if redacted(parts, i) {
+			arg = "(redacted)"
+		}
+		b.WriteString(" " + strconv.Quote(arg))
+	}
+	return b.String()
+}
+
+// serveMonitor streams processed commands to a connection that sent MONITOR
+// until it disconnects, falls behind or the server is closed. Anything the
+// client sends meanwhile is ignored.
+func (s *Server) serveMonitor(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer) {
+	sub := s.monitors.subscribe()
+	defer s.monitors.unsubscribe(sub)
+	if err := s.writeReply(conn, writer, "OK"); err != nil {
+		return
+	}
+
+	// A monitor does not send commands, so the idle timeout does not apply
+	conn.SetReadDeadline(time.Time{})
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case line, ok := <-sub.lines:
+			if !ok {
+				s.connLog.Warn("Closing monitor that fell behind", "client", conn.RemoteAddr(), "limit", monitorBufferSize)
+				return
+			}
+			if err := s.writeReply(conn, writer, line); err != nil {
+				s.connLog.Warn("Closing monitor", "client", conn.RemoteAddr(), "err", err)
+				return
+			}
+		case <-gone:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
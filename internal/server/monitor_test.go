@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+)
+
+func TestMonitor(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	lines, err := c.Monitor(ctx)
+	if err != nil {
+		t.Fatalf("Monitor failed: %v", err)
+	}
+
+	c.Set(ctx, "greeting", "hello world", 0)
+	c.Do(ctx, "AUTH secret")
+	c.Do(ctx, "ACL SETUSER default >pw")
+	// Commands refused before they run are not shown, those of scripts are
+	if reply := dialRaw(t, srv).send("GET greeting"); !strings.HasPrefix(reply, "ERROR: NOAUTH") {
+		t.Fatalf("Expected NOAUTH, got %q", reply)
+	}
+	c.Do(ctx, "BOGUS")
+	c.Do(ctx, "XLEN a b")
+	c.Do(ctx, "EVAL 0 0 return cacheflow.call('GET', 'greeting')")
+	expected := []string{
+		`"SET" "greeting" "hello" "world"`,
+		`"AUTH" "(redacted)"`,
+		`"ACL" "SETUSER" "default" "(redacted)"`,
+		`"EVAL" "0" "0" "return" "cacheflow.call('GET'," "'greeting')"`,
+		`"GET" "greeting"`,
+	}
+	for _, want := range expected {
+		select {
+		case line := <-lines:
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) != 3 || !strings.HasPrefix(fields[1], "[127.0.0.1:") || fields[2] != want {
+				t.Errorf("Expected %s from the client, got %q", want, line)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for %s", want)
+		}
+	}
+
+	// Canceling the context ends the stream
+	cancel()
+	for range lines {
+	}
+}
+
+func TestMonitorFallsBehind(t *testing.T) {
+	var m monitors
+	sub := m.subscribe()
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	for i := 0; i <= monitorBufferSize; i++ {
+		m.feed(addr, []string{"PING"})
+	}
+	if n := m.count.Load(); n != 0 {
+		t.Errorf("Expected the monitor to be dropped, got %d monitors", n)
+	}
+	var received int
+	for range sub.lines {
+		received++
+	}
+	if received != monitorBufferSize {
+		t.Errorf("Expected %d buffered lines, got %d", monitorBufferSize, received)
+	}
+	m.unsubscribe(sub)
+}
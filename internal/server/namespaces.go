@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/store"
+)
+
+// setKey sets a key in database db, applying the quota and default TTL of
+// its namespace, and returns the reply. In raft mode a write over the quota
+// is refused rather than evicting keys, since nodes would pick different ones.
+func (s *Server) setKey(db int, key, value string, ttl time.Duration) string {
+	return s.writeKey(db, key, value, ttl, true)
+}
+
+// restoreKey sets a key moved from another node like setKey, but with
+// exactly the given TTL: a key without one stays without one
+func (s *Server) restoreKey(db int, key, value string, ttl time.Duration) string {
+	return s.writeKey(db, key, value, ttl, false)
+}
+
+// writeKey does the work of setKey and restoreKey
+func (s *Server) writeKey(db int, key, value string, ttl time.Duration, defaultTTL bool) string {
+	if s.raft == nil {
+		set := s.store.DB(db).Set
+		if !defaultTTL {
+			set = s.store.DB(db).Restore
+		}
+		if err := set(key, value, ttl); err != nil {
+			return protocol.Errorf(protocol.CodeOOM, "%v", err)
+		}
+		return "OK"
+	}
+
+	if err := s.store.DB(db).CheckQuota(key, value); err != nil {
+		return protocol.Errorf(protocol.CodeOOM, "%v", err)
+	}
+	if ns, ok := s.store.NamespaceOf(key); ok && ttl == 0 && defaultTTL {
+		ttl = ns.DefaultTTL
+	}
+	parts := []string{"SET", key, value}
+	if ttl > 0 {
+		parts = append(parts, ttl.String())
+	}
+	return s.proposeWrite(db, parts)
+}
+
+// handleNamespace processes NAMESPACE SET name [options], DEL name, GET name and LIST
+func (s *Server) handleNamespace(args []string) string {
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "NAMESPACE requires a subcommand")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		ns, err := store.ParseNamespace(args[1:])
+		if err != nil {
+			return protocol.Errorf(protocol.CodeSyntax, "%v", err)
+		}
+		if s.isReplica() {
+			return protocol.Error(protocol.CodeReadOnly, "You can't write against a read only replica")
+		}
+		if s.raft != nil {
+			return s.proposeWrite(0, append([]string{"NAMESPACE", "SET"}, ns.Args()...))
+		}
+		if err := s.store.SetNamespace(ns); err != nil {
+			return protocol.Errorf(protocol.CodeErr, "%v", err)
+		}
+		return "OK"
+
+	case "DEL":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "NAMESPACE DEL requires a name")
+		}
+		if s.isReplica() {
+			return protocol.Error(protocol.CodeReadOnly, "You can't write against a read only replica")
+		}
+		if _, ok := s.namespace(args[1]); !ok {
+			return "0"
+		}
+		if s.raft != nil {
+			if reply := s.proposeWrite(0, []string{"NAMESPACE", "DEL", args[1]}); reply != "OK" {
+				return reply
+			}
+			return "1"
+		}
+		if s.store.DeleteNamespace(args[1]) {
+			return "1"
+		}
+		return "0"
+
+	case "GET":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "NAMESPACE GET requires a name")
+		}
+		info, ok := s.namespace(args[1])
+		if !ok {
+			return protocol.Nil
+		}
+		return describeNamespace(info)
+
+	case "LIST":
+		if len(args) != 1 {
+			return protocol.Error(protocol.CodeSyntax, "NAMESPACE LIST takes no arguments")
+		}
+		var lines []string
+		for _, info := range s.store.Namespaces() {
+			lines = append(lines, describeNamespace(info))
+		}
+		return strings.Join(lines, ", ")
+
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown NAMESPACE subcommand")
+	}
+}
+
+// namespace looks a namespace up by name
+func (s *Server) namespace(name string) (store.NamespaceInfo, bool) {
+	for _, info := range s.store.Namespaces() {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return store.NamespaceInfo{}, false
+}
+
+// describeNamespace renders a namespace as a NAMESPACE LIST line
+func describeNamespace(info store.NamespaceInfo) string {
+	return fmt.Sprintf("name=%s prefix=%s maxmemory=%d used_memory=%d keys=%d evicted_keys=%d ttl=%s policy=%s users=%s",
+		info.Name, info.Prefix, info.MaxMemory, info.Memory, info.Keys, info.EvictedKeys,
+		info.DefaultTTL, info.Policy, strings.Join(info.Users, ","))
+}
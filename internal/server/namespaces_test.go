@@ -0,0 +1,67 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamespaceCommands(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+
+	admin := dialRaw(t, srv)
+	if reply := admin.send("NAMESPACE SET billing MAXMEMORY 20 TTL 1h USERS default,billing"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := admin.send("NAMESPACE SET search POLICY lru"); !strings.HasPrefix(reply, "ERROR: SYNTAX unknown eviction policy") {
+		t.Errorf("Expected an unknown policy error, got %q", reply)
+	}
+
+	if reply := admin.send("SET billing:a 1234567890"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := admin.send("SET billing:b 1234567890"); !strings.HasPrefix(reply, "ERROR: OOM namespace memory quota exceeded") {
+		t.Errorf("Expected an OOM error, got %q", reply)
+	}
+	want := "name=billing prefix=billing: maxmemory=20 used_memory=19 keys=1 evicted_keys=0 ttl=1h0m0s policy=noeviction users=default,billing"
+	if reply := admin.send("NAMESPACE GET billing"); reply != want {
+		t.Errorf("Expected %q, got %q", want, reply)
+	}
+	if reply := admin.send("NAMESPACE LIST"); reply != want {
+		t.Errorf("Expected %q, got %q", want, reply)
+	}
+	if info := srv.Info("namespaces"); len(info[0].Fields) != 1 || info[0].Fields[0].Name != "ns_billing" {
+		t.Errorf("Expected INFO to list ns_billing, got %+v", info)
+	}
+
+	// Users outside the namespace cannot reach its keys even if their ACL allows it
+	admin.send("ACL SETUSER search on >pw +@all ~*")
+	search := dialRaw(t, srv)
+	search.send("AUTH search pw")
+	if reply := search.send("GET billing:a"); !strings.HasPrefix(reply, "ERROR: NOPERM") {
+		t.Errorf("Expected NOPERM, got %q", reply)
+	}
+	if reply := search.send("SET search:a 1"); reply != "OK" {
+		t.Errorf("Expected keys outside the namespace to be allowed, got %q", reply)
+	}
+
+	if reply := admin.send("NAMESPACE DEL billing"); reply != "1" {
+		t.Errorf("Expected 1, got %q", reply)
+	}
+	if reply := admin.send("NAMESPACE GET billing"); reply != "NIL" {
+		t.Errorf("Expected NIL, got %q", reply)
+	}
+	if reply := search.send("GET billing:a"); reply != "1234567890" {
+		t.Errorf("Expected the key to stay once its namespace is deleted, got %q", reply)
+	}
+}
+
+func TestNamespaceOnReplica(t *testing.T) {
+	primary := startConfiguredNode(t, func(cfg *Config) {})
+	replica := startConfiguredNode(t, func(cfg *Config) { cfg.ReplicaOf = primary.Addr().String() })
+
+	// Namespaces reach a replica through replication only
+	conn := dialRaw(t, replica)
+	if reply := conn.send("NAMESPACE SET billing MAXMEMORY 20"); !strings.HasPrefix(reply, "ERROR: READONLY") {
+		t.Errorf("Expected a replica to refuse NAMESPACE SET, got %s", reply)
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+)
+
+func TestPoolConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	srv := startPlainNode(t)
+
+	pool, err := client.NewPool(srv.Addr().String(), client.PoolOptions{MinIdle: 2, MaxOpen: 4})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	if stats := pool.Stats(); stats.Idle != 2 {
+		t.Errorf("Expected 2 idle connections after start, got %d", stats.Idle)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("key-%d-%d", g, i)
+				if err := pool.Set(ctx, key, key, 0); err != nil {
+					t.Errorf("Set failed: %v", err)
+					return
+				}
+				if value, err := pool.Get(ctx, key); err != nil || value != key {
+					t.Errorf("Expected value %s, got %q (%v)", key, value, err)
+					return
+				}
+				if open := pool.Stats().Open; open > 4 {
+					t.Errorf("Expected at most 4 open connections, got %d", open)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if stats := pool.Stats(); stats.Hits == 0 {
+		t.Error("Expected idle connections to be reused")
+	}
+}
+
+func TestClientConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	srv := startPlainNode(t)
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("key-%d-%d", g, i)
+				if err := c.Set(ctx, key, key, 0); err != nil {
+					t.Errorf("Set failed: %v", err)
+					return
+				}
+				if value, err := c.Get(ctx, key); err != nil || value != key {
+					t.Errorf("Expected value %s, got %q (%v)", key, value, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	srv := startPlainNode(t)
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	p := c.Pipeline()
+	var sets []*client.Reply
+	for i := 0; i < 100; i++ {
+		sets = append(sets, p.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i), time.Minute))
+	}
+	get := p.Get("key42")
+	missing := p.Get("missing")
+	exists := p.Exists("key7")
+	bad := p.Do("BOGUS")
+	if p.Len() != 104 {
+		t.Errorf("Expected 104 queued commands, got %d", p.Len())
+	}
+	if _, err := get.Result(); err == nil {
+		t.Error("Expected an error before Exec")
+	}
+
+	if err := p.Exec(ctx); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	for i, r := range sets {
+		if _, err := r.Result(); err != nil {
+			t.Errorf("Set %d failed: %v", i, err)
+		}
+	}
+	if value, err := get.Result(); err != nil || value != "value42" {
+		t.Errorf("Expected value42, got %q (%v)", value, err)
+	}
+	if value, err := missing.Result(); err != nil || value != "" {
+		t.Errorf("Expected empty value for a missing key, got %q (%v)", value, err)
+	}
+	if ok, err := exists.Bool(); err != nil || !ok {
+		t.Errorf("Expected key7 to exist, got %v (%v)", ok, err)
+	}
+	if value, _ := bad.Result(); value != "ERROR: UNKNOWN Unknown command" {
+		t.Errorf("Expected unknown command error, got %q", value)
+	}
+	if p.Len() != 0 {
+		t.Errorf("Expected an empty pipeline after Exec, got %d", p.Len())
+	}
+
+	// The connection stays usable after a pipeline
+	if value, err := c.Get(ctx, "key99"); err != nil || value != "value99" {
+		t.Errorf("Expected value99, got %q (%v)", value, err)
+	}
+}
+
+// startPongServer answers every line on addr with PONG until kill is called, which
+// closes the listener and every connection
+func startPongServer(t *testing.T, addr string) (string, func()) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := reader.ReadString('\n'); err != nil {
+						return
+					}
+					conn.Write([]byte("PONG\n"))
+				}
+			}()
+		}
+	}()
+	kill := func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	t.Cleanup(kill)
+	return listener.Addr().String(), kill
+}
+
+func TestPoolDiscardsBrokenConnections(t *testing.T) {
+	ctx := context.Background()
+	addr, kill := startPongServer(t, "127.0.0.1:0")
+
+	pool, err := client.NewPool(addr, client.PoolOptions{MinIdle: 2, HealthCheckInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+	if err := pool.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	kill()
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Open != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected dead connections to be closed, got %+v", pool.Stats())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := pool.Ping(ctx); err == nil {
+		t.Error("Expected Ping to fail while the server is down")
+	}
+	if open := pool.Stats().Open; open != 0 {
+		t.Errorf("Expected no open connections after a failed dial, got %d", open)
+	}
+}
+
+func TestPoolDialHonorsContext(t *testing.T) {
+	// A server that accepts connections but never answers, so logging in stalls
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	pool, err := client.NewPool(listener.Addr().String(), client.PoolOptions{
+		Client: client.Options{ClientName: "pooled", MaxRetries: -1, ReadTimeout: 10 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := pool.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the dial to end with the context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the dial to give up with the context, took %s", elapsed)
+	}
+	if open := pool.Stats().Open; open != 0 {
+		t.Errorf("Expected the failed dial not to count as open, got %d", open)
+	}
+}
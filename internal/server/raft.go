@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"CacheFlow/internal/persistence"
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/raft"
+	"CacheFlow/internal/store"
+)
+
+// storeMachine lets a Raft node apply committed commands to the store
+type storeMachine struct {
+	*store.Store
+}
+
+// Snapshot returns the store contents in AOF format
+func (m storeMachine) Snapshot() []string {
+	return m.Store.Snapshot(nil)
+}
+
+// Restore replaces the store contents with a snapshot
+func (m storeMachine) Restore(commands []string) error {
+	return m.Store.Replace(commands)
+}
+
+// newRaftNode creates the Raft node for a server running in Raft mode
+func newRaftNode(cfg Config, st *store.Store, keys *persistence.Keyring) (*raft.Node, error) {
+	clientAddr := cfg.AnnounceAddr
+	if clientAddr == "" {
+		clientAddr = cfg.Addr
+	}
+	dir := cfg.RaftDir
+	if dir == "" {
+		dir = "raft-" + cfg.RaftID
+	}
+	return raft.New(raft.Config{
+		ID:         cfg.RaftID,
+		Addr:       cfg.RaftAddr,
+		ClientAddr: clientAddr,
+		Peers:      cfg.RaftPeers,
+		Dir:        dir,
+		Keys:       keys,
+	}, storeMachine{st})
+}
+
+// proposeWrite commits a write command on database db through Raft; it is
+// applied to the store on every node, including this one, once a majority
+// has logged it
+func (s *Server) proposeWrite(db int, parts []string) string {
+	command := strings.Join(append([]string{strings.ToUpper(parts[0])}, parts[1:]...), " ")
+	if err := s.raft.Propose(store.TagCommand(db, command)); err != nil {
+		return raftError(err)
+	}
+	return "OK"
+}
+
+// raftError converts a Raft error into a reply; writes sent to a follower
+// are redirected to the leader's client address
+func raftError(err error) string {
+	var notLeader *raft.NotLeaderError
+	if errors.As(err, &notLeader) {
+		if notLeader.LeaderClientAddr == "" {
+			return protocol.Error(protocol.CodeNotLeader, "no leader elected")
+		}
+		return protocol.Error(protocol.CodeNotLeader, notLeader.LeaderClientAddr)
+	}
+	return protocol.Errorf(protocol.CodeErr, "%v", err)
+}
+
+// handleRaftCommand processes RAFT STATUS, RAFT ADD id addr and RAFT REMOVE id
+func (s *Server) handleRaftCommand(args []string) string {
+	if s.raft == nil {
+		return protocol.Error(protocol.CodeErr, "raft mode is not enabled")
+	}
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "RAFT requires a subcommand")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "STATUS":
+		status := s.raft.Status()
+		ids := make([]string, 0, len(status.Members))
+		for id, addr := range status.Members {
+			ids = append(ids, id+"="+addr)
+		}
+		sort.Strings(ids)
+		return fmt.Sprintf("id=%s state=%s term=%d leader=%s commit=%d applied=%d last=%d snapshot=%d members=%s",
+			status.ID, status.State, status.Term, status.LeaderID, status.CommitIndex,
+			status.LastApplied, status.LastLogIndex, status.SnapshotIndex, strings.Join(ids, ","))
+
+	case "ADD":
+		if len(args) != 3 {
+			return protocol.Error(protocol.CodeSyntax, "RAFT ADD requires id and address")
+		}
+		if err := s.raft.AddMember(args[1], args[2]); err != nil {
+			return raftError(err)
+		}
+		return "OK"
+
+	case "REMOVE":
+		if len(args) != 2 {
+			return protocol.Error(protocol.CodeSyntax, "RAFT REMOVE requires id")
+		}
+		if err := s.raft.RemoveMember(args[1]); err != nil {
+			return raftError(err)
+		}
+		return "OK"
+
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown RAFT subcommand")
+	}
+}
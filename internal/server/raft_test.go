@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/raft"
+)
+
+// TestRaftRejectsAOF tests that raft mode cannot be combined with an AOF.
+func TestRaftRejectsAOF(t *testing.T) {
+	cfg := DefaultConfig("127.0.0.1:0")
+	cfg.AOFFilename = filepath.Join(t.TempDir(), "aof.log")
+	cfg.RaftID = "n1"
+	if _, err := NewWithConfig(cfg); err == nil || !strings.Contains(err.Error(), "AOF") {
+		t.Errorf("Expected raft mode with an AOF to be rejected, got %v", err)
+	}
+}
+
+// TestRaftRestart tests that a restarted raft node recovers its data from the Raft log alone.
+func TestRaftRestart(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	raftAddr := listener.Addr().String()
+	listener.Close()
+	dir := t.TempDir()
+
+	start := func() *Server {
+		srv := startConfiguredNode(t, func(cfg *Config) {
+			cfg.RaftID = "n1"
+			cfg.RaftAddr = raftAddr
+			cfg.RaftPeers = map[string]string{"n1": raftAddr}
+			cfg.RaftDir = dir
+		})
+		deadline := time.Now().Add(5 * time.Second)
+		for srv.raft.Status().State != raft.Leader {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for the node to become leader")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return srv
+	}
+
+	srv := start()
+	sess := srv.newSession()
+	for _, cmd := range []string{"SET key 1", "SET removed 1", "DELETE removed", "SET key 2"} {
+		if r := srv.handleCommand(sess, cmd); protocol.IsError(r) {
+			t.Fatalf("%s failed: %s", cmd, r)
+		}
+	}
+	srv.Close()
+
+	restarted := start()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		value, _ := restarted.store.Get("key")
+		if value == "2" && !restarted.store.Exists("removed") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected key to be 2 and removed to be gone after restart, got %v", value)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// waitForKey polls srv until key holds value, or is missing if value is empty
+func waitForKey(t *testing.T, srv *Server, key, value string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, ok := srv.store.Get(key)
+		if (value == "" && !ok) || (ok && got == value) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s to be %q, got %v", key, value, got)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDivergedPrimaryResyncsFromPromotedReplica(t *testing.T) {
+	oldPrimary := startConfiguredNode(t, func(cfg *Config) {})
+	promoted := startConfiguredNode(t, func(cfg *Config) { cfg.ReplicaOf = oldPrimary.Addr().String() })
+	oldConn := dialRaw(t, oldPrimary)
+	newConn := dialRaw(t, promoted)
+
+	oldConn.send("SET shared 1")
+	waitForKey(t, promoted, "shared", "1")
+	if reply := newConn.send("REPLICAOF NO ONE"); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+
+	// The old primary writes past the promotion point before it is demoted,
+	// less than the promoted replica, so its offset is within the new stream
+	oldConn.send("SET d x")
+	newConn.send("SET after promotion")
+	host, port, _ := net.SplitHostPort(promoted.Addr().String())
+	if reply := oldConn.send("REPLICAOF " + host + " " + port); reply != "OK" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+
+	waitForKey(t, oldPrimary, "after", "promotion")
+	waitForKey(t, oldPrimary, "d", "")
+	if full, partial := promoted.primary.FullResyncs(), promoted.primary.PartialResyncs(); full != 1 || partial != 0 {
+		t.Errorf("Expected the diverged primary to get a full resync, got %d full and %d partial", full, partial)
+	}
+}
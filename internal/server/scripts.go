@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/script"
+)
+
+// scriptCache holds the scripts loaded by EVAL and SCRIPT LOAD by SHA1
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[string]*script.Script
+}
+
+// get returns a cached script
+func (c *scriptCache) get(sha string) (*script.Script, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sc, ok := c.scripts[strings.ToLower(sha)]
+	return sc, ok
+}
+
+// load compiles a script, or returns it from the cache
+func (c *scriptCache) load(source string) (*script.Script, error) {
+	if sc, ok := c.get(script.SHA1(source)); ok {
+		return sc, nil
+	}
+	sc, err := script.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scripts == nil {
+		c.scripts = make(map[string]*script.Script)
+	}
+	c.scripts[sc.SHA] = sc
+	return sc, nil
+}
+
+// flush empties the cache
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = nil
+}
+
+// len returns how many scripts are cached
+func (c *scriptCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.scripts)
+}
+
+// skipFields returns line without its first n whitespace separated fields,
+// keeping the spacing of the rest
+func skipFields(line string, n int) string {
+	for i := 0; i < n; i++ {
+		line = strings.TrimLeft(line, " \t")
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			return ""
+		}
+		line = line[end:]
+	}
+	return strings.TrimLeft(line, " \t")
+}
+
+// parseScriptArgs splits "numkeys [key ...] rest" into the keys and the rest
+func parseScriptArgs(args []string) (keys, rest []string, reply string) {
+	if len(args) == 0 {
+		return nil, nil, protocol.Error(protocol.CodeSyntax, "the number of keys is missing")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return nil, nil, protocol.Errorf(protocol.CodeSyntax, "invalid number of keys %q", args[0])
+	}
+	if n > len(args)-1 {
+		return nil, nil, protocol.Error(protocol.CodeSyntax, "number of keys can't be greater than number of args")
+	}
+	return args[1 : 1+n], args[1+n:], ""
+}
+
+// evalKeys returns the keys declared by the arguments of EVAL
+func evalKeys(args []string) []string {
+	keys, _, _ := parseScriptArgs(args)
+	return keys
+}
+
+// evalSHAKeys returns the keys declared by the arguments of EVALSHA
+func evalSHAKeys(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	return evalKeys(args[1:])
+}
+
+// handleEval processes EVAL numkeys [key ...] numargs [arg ...] script. The
+// script is the rest of the line, so it comes last.
+func (s *Server) handleEval(sess *session, cmd string, parts []string) string {
+	keys, rest, reply := parseScriptArgs(parts[1:])
+	if reply != "" {
+		return reply
+	}
+	if len(rest) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "the number of arguments is missing")
+	}
+	n, err := strconv.Atoi(rest[0])
+	if err != nil || n < 0 {
+		return protocol.Errorf(protocol.CodeSyntax, "invalid number of arguments %q", rest[0])
+	}
+	if 1+n >= len(rest) {
+		return protocol.Error(protocol.CodeSyntax, "EVAL requires a script after its arguments")
+	}
+	args := rest[1 : 1+n]
+	source := skipFields(cmd, len(parts)-len(rest)+1+n)
+
+	sc, err := s.scripts.load(source)
+	if err != nil {
+		return protocol.Errorf(protocol.CodeErr, "script compile error: %v", err)
+	}
+	return s.runScript(sess, sc, keys, args)
+}
+
+// handleEvalSHA processes EVALSHA sha numkeys [key ...] [arg ...]
+func (s *Server) handleEvalSHA(sess *session, parts []string) string {
+	if len(parts) < 3 {
+		return protocol.Error(protocol.CodeSyntax, "EVALSHA requires sha and numkeys")
+	}
+	keys, args, reply := parseScriptArgs(parts[2:])
+	if reply != "" {
+		return reply
+	}
+	sc, ok := s.scripts.get(parts[1])
+	if !ok {
+		return protocol.Error(protocol.CodeNoScript, "No matching script. Please use EVAL.")
+	}
+	return s.runScript(sess, sc, keys, args)
+}
+
+// handleScript processes SCRIPT LOAD script, SCRIPT EXISTS sha [sha ...] and SCRIPT FLUSH
+func (s *Server) handleScript(cmd string, args []string) string {
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "SCRIPT requires a subcommand")
+	}
+	switch strings.ToUpper(args[0]) {
+	case "LOAD":
+		if len(args) < 2 {
+			return protocol.Error(protocol.CodeSyntax, "SCRIPT LOAD requires a script")
+		}
+		sc, err := s.scripts.load(skipFields(cmd, 2))
+		if err != nil {
+			return protocol.Errorf(protocol.CodeErr, "script compile error: %v", err)
+		}
+		return sc.SHA
+	case "EXISTS":
+		if len(args) < 2 {
+			return protocol.Error(protocol.CodeSyntax, "SCRIPT EXISTS requires at least one sha")
+		}
+		found := make([]string, len(args)-1)
+		for i, sha := range args[1:] {
+			found[i] = "0"
+			if _, ok := s.scripts.get(sha); ok {
+				found[i] = "1"
+			}
+		}
+		return strings.Join(found, ", ")
+	case "FLUSH":
+		if len(args) != 1 {
+			return protocol.Error(protocol.CodeSyntax, "SCRIPT FLUSH takes no arguments")
+		}
+		s.scripts.flush()
+		return "OK"
+	}
+	return protocol.Errorf(protocol.CodeSyntax, "unknown SCRIPT subcommand %q", args[0])
+}
+
+// runScript runs a script, which holds the exclusive lock. Its commands run as
+// nested commands of the connection, and reach the AOF, replicas and
+// the Raft log one by one like commands of clients, so scripts are persisted
+// and replicated by their effects.
+func (s *Server) runScript(sess *session, sc *script.Script, keys, args []string) string {
+	if s.cluster != nil {
+		for _, key := range keys {
+			if redirect := s.checkSlot(sess.db, key, false); redirect != "" {
+				return redirect
+			}
+		}
+	}
+
+	inner := nestedSession(sess)
+	call := func(args []string) (script.Value, error) {
+		reply := s.callNested(inner, args)
+		if code, msg, ok := protocol.ParseError(reply); ok {
+			return nil, errors.New(strings.TrimSpace(string(code) + " " + msg))
+		}
+		if reply == protocol.Nil {
+			return false, nil
+		}
+		return protocol.UnescapeValue(reply), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.scriptTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.scriptTimeout)
+	}
+	defer cancel()
+	result, err := sc.Run(ctx, script.Env{Keys: keys, Args: args, Call: call})
+	if errors.Is(err, script.ErrTimeout) {
+		return protocol.Errorf(protocol.CodeBusy, "script exceeded the time limit of %v, the writes it made are kept", s.scriptTimeout)
+	}
+	var scriptErr *script.Error
+	if errors.As(err, &scriptErr) {
+		if t, ok := scriptErr.Value.(*script.Table); ok && t.Get("err") != nil {
+			return scriptReply(t)
+		}
+		return protocol.Errorf(protocol.CodeErr, "script error: %v", err)
+	}
+	if err != nil {
+		return protocol.Errorf(protocol.CodeErr, "script error: %v", err)
+	}
+	return scriptReply(result)
+}
+
+// scriptReply converts the value a script returns to a reply: nil and false
+// are NIL, true is 1, tables with an err or ok field are error and status
+// replies, and other tables are lists of their array part
+func scriptReply(v script.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return protocol.Nil
+	case bool:
+		if !v {
+			return protocol.Nil
+		}
+		return "1"
+	case float64:
+		return script.FormatNumber(v)
+	case string:
+		return protocol.EscapeValue(v)
+	case *script.Table:
+		if msg, ok := v.Get("err").(string); ok {
+			code, rest, _ := strings.Cut(msg, " ")
+			if protocol.Known(protocol.Code(code)) {
+				return protocol.Error(protocol.Code(code), rest)
+			}
+			return protocol.Error(protocol.CodeErr, msg)
+		}
+		if msg, ok := v.Get("ok").(string); ok {
+			return protocol.EscapeValue(msg)
+		}
+		items := make([]string, 0, v.Len())
+		for i := 1; i <= v.Len(); i++ {
+			items = append(items, scriptReply(v.Get(float64(i))))
+		}
+		return strings.Join(items, ", ")
+	}
+	return protocol.Error(protocol.CodeErr, "script returned a value that is not a reply")
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) { cfg.ScriptTimeout = 100 * time.Millisecond })
+	conn := dialRaw(t, srv)
+
+	cas := "EVAL 1 lock 2 old new if cacheflow.call('GET', KEYS[1]) == ARGV[1] then return cacheflow.call('SET', KEYS[1], ARGV[2]) end return false"
+	if reply := conn.send(cas); reply != "NIL" {
+		t.Errorf("Expected NIL for a missing key, got %q", reply)
+	}
+	conn.send("SET lock old")
+	if reply := conn.send(cas); reply != "OK" {
+		t.Errorf("Expected OK, got %q", reply)
+	}
+	if reply := conn.send("GET lock"); reply != "new" {
+		t.Errorf("Expected new, got %q", reply)
+	}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"EVAL 0 0 return {1, 'two', false, {3}}", "1, two, NIL, 3"},
+		{"EVAL 0 0 return cacheflow.error_reply('WRONGTYPE not a counter')", "ERROR: WRONGTYPE not a counter"},
+		{"EVAL 0 0 return cacheflow.status_reply('DONE')", "DONE"},
+		{"EVAL 0 0 return cacheflow.call('GET', 'missing')", "NIL"},
+		{"EVAL 0 0 return cacheflow.call('NOPE')", "ERROR: UNKNOWN Unknown command"},
+		{"EVAL 0 0 return cacheflow.pcall('NOPE')['err']", "UNKNOWN Unknown command"},
+		{"EVAL 0 0 return cacheflow.call('CLIENT', 'LIST')", "ERROR: ERR This command is not allowed from scripts"},
+		{"EVAL 0 0 error('boom')", "ERROR: ERR script error: line 1: boom"},
+		{"EVAL 0 0 return (", "ERROR: ERR script compile error: line 1: unexpected <eof>"},
+		{"EVAL 1 a 2 x", "ERROR: SYNTAX EVAL requires a script after its arguments"},
+		{"EVAL 0 0 while true do end", "ERROR: BUSY script exceeded the time limit of 100ms, the writes it made are kept"},
+	}
+	for _, tt := range tests {
+		if reply := conn.send(tt.command); reply != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.want, reply)
+		}
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	conn := dialRaw(t, srv)
+
+	sha := conn.send("SCRIPT LOAD return ARGV[1] .. KEYS[1]")
+	if len(sha) != 40 {
+		t.Fatalf("Expected a SHA1, got %q", sha)
+	}
+	if reply := conn.send("EVALSHA " + sha + " 1 key arg"); reply != "argkey" {
+		t.Errorf("Expected argkey, got %q", reply)
+	}
+	if reply := conn.send("SCRIPT EXISTS " + sha + " ffff"); reply != "1, 0" {
+		t.Errorf("Expected 1, 0, got %q", reply)
+	}
+	if reply := conn.send("SCRIPT FLUSH"); reply != "OK" {
+		t.Errorf("Expected OK, got %q", reply)
+	}
+	if reply := conn.send("EVALSHA " + sha + " 0"); !strings.HasPrefix(reply, "ERROR: NOSCRIPT") {
+		t.Errorf("Expected NOSCRIPT, got %q", reply)
+	}
+}
+
+func TestEvalAtomic(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+
+	// Without atomic scripts, concurrent read-modify-write cycles lose updates
+	const clients, rounds = 4, 25
+	incr := "EVAL 1 counter 0 local n = tonumber(cacheflow.call('GET', KEYS[1]) or '0') return cacheflow.call('SET', KEYS[1], n + 1)"
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		conn := dialRaw(t, srv)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				conn.send(incr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	conn := dialRaw(t, srv)
+	if reply := conn.send("GET counter"); reply != strconv.Itoa(clients*rounds) {
+		t.Errorf("Expected %d, got %q", clients*rounds, reply)
+	}
+}
@@ -2,80 +2,547 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"CacheFlow/internal/acl"
+	"CacheFlow/internal/cluster"
+	"CacheFlow/internal/persistence"
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/raft"
+	"CacheFlow/internal/replication"
 	"CacheFlow/internal/store"
 )
 
+// Config holds the settings a Server is created with
+type Config struct {
+	// Addr is the TCP address to listen on; empty serves only UnixSocket
+	Addr string
+	// UnixSocket is the path of a Unix domain socket to listen on in addition
+	// to Addr; empty disables it. Connections on it never use TLS.
+	UnixSocket string
+	// UnixSocketPerm is the file mode the socket is given; zero leaves the
+	// mode the umask produces
+	UnixSocketPerm os.FileMode
+	// AOFFilename is the append-only file used for persistence; empty disables it
+	AOFFilename string
+	// Databases is the number of databases connections can SELECT
+	Databases int
+	// ReplicaOf is the address of a primary to replicate from; empty runs as a primary
+	ReplicaOf string
+	// ReplBacklogSize is the size in bytes of the replication backlog
+	ReplBacklogSize int
+	// AnnounceAddr is the address other nodes and redirected clients use to
+	// reach this server; defaults to Addr
+	AnnounceAddr string
+
+	// RaftID enables Raft mode: writes are committed through a Raft group before being applied.
+	// The Raft log persists the data, so AOFFilename must be empty.
+	RaftID string
+	// RaftAddr is the address the Raft RPC listener binds to
+	RaftAddr string
+	// RaftPeers is the initial group membership (ID to Raft address), including this node
+	RaftPeers map[string]string
+	// RaftDir is where the Raft log, vote and snapshots are stored
+	RaftDir string
+
+	// ClusterEnabled enables hash-slot sharding across multiple nodes
+	ClusterEnabled bool
+	// ClusterConfigFile is where the node ID and slot map are persisted
+	ClusterConfigFile string
+
+	// ACLFile holds the users, one "user <name> <rules...>" line each; empty
+	// keeps users in memory only
+	ACLFile string
+	// RequirePass sets a password on the default user, so connections must AUTH
+	RequirePass string
+	// AuthUser and AuthPassword are the credentials this server presents to
+	// other nodes: its primary, the targets of MIGRATE and CLUSTER MEET peers
+	AuthUser     string
+	AuthPassword string
+
+	// TLSCertFile and TLSKeyFile enable TLS on the listener. They are reloaded
+	// when the files change, so certificates can be rotated without a restart.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSCAFile holds the CAs client certificates are verified against, and
+	// that this server trusts when connecting to other nodes
+	TLSCAFile string
+	// TLSAuthClients is "yes" (the default) to require a client certificate
+	// when TLSCAFile is set, "optional" to verify one if presented, or "no"
+	TLSAuthClients string
+	// TLSCertUsers logs clients presenting a verified certificate in as the
+	// ACL user named by its Common Name, if that user exists and is enabled
+	TLSCertUsers bool
+	// TLSReplication connects to the primary and to MIGRATE targets over TLS,
+	// presenting this server's certificate
+	TLSReplication bool
+
+	// EncryptionKeyFile holds the keys that encrypt the AOF file and the Raft
+	// directory, in the format of persistence.ParseKeyring. The first key
+	// encrypts; the others are only used to read files written before a
+	// rotation, which are re-encrypted when the server starts.
+	EncryptionKeyFile string
+	// EncryptionKeys holds the keys themselves, as an alternative to
+	// EncryptionKeyFile (for example from an environment variable)
+	EncryptionKeys string
+
+	// MaxClients is the number of connections served at once; further
+	// connections get an error reply and are closed. Zero is unlimited.
+	MaxClients int
+	// IdleTimeout closes connections that send no command for this long; zero keeps them open
+	IdleTimeout time.Duration
+	// MaxRequestSize is the longest command line in bytes; a longer one
+	// closes the connection. Zero is unlimited, which persistence does not
+	// allow: with an AOF file or Raft it is at most persistence.MaxCommandSize.
+	MaxRequestSize int
+	// MaxArgSize is the longest single argument in bytes; zero is unlimited
+	MaxArgSize int
+	// OutputBufferLimit is the largest reply in bytes; a client whose reply
+	// would exceed it is disconnected. Zero is unlimited.
+	OutputBufferLimit int
+	// OutputTimeout disconnects a client that does not accept a reply within
+	// this time, so slow consumers cannot hold on to server memory. Zero waits forever.
+	OutputTimeout time.Duration
+
+	// SlowlogThreshold is how long a command must take to enter the slow
+	// log; zero logs every command and a negative value disables the log
+	SlowlogThreshold time.Duration
+	// SlowlogMaxLen is how many entries the slow log keeps
+	SlowlogMaxLen int
+	// ScriptTimeout is how long EVAL may run a script before stopping it,
+	// since commands of other clients wait while a script runs; zero is unlimited
+	ScriptTimeout time.Duration
+
+	// LatencyThreshold is how long a command or an internal event such as
+	// an AOF fsync must take to be recorded by LATENCY; zero disables it
+	LatencyThreshold time.Duration
+
+	// MetricsAddr is the address of an HTTP listener exporting Prometheus
+	// metrics at /metrics; empty disables it
+	MetricsAddr string
+
+	// Logger receives the server's log records; nil uses slog.Default, which
+	// the store and the AOF always log to
+	Logger *slog.Logger
+	// ConnLogger receives the records of events that happen once per
+	// connection, such as connections being accepted and closed, so they
+	// can be sampled; nil uses Logger
+	ConnLogger *slog.Logger
+}
+
+// DefaultConfig returns the configuration used by New
+func DefaultConfig(addr string) Config {
+	return Config{
+		Addr:              addr,
+		AOFFilename:       "aof.log",
+		Databases:         store.DefaultDatabases,
+		ReplBacklogSize:   1 << 20,
+		ClusterConfigFile: "nodes.json",
+		MaxClients:        10000,
+		MaxRequestSize:    64 << 20,
+		OutputBufferLimit: 256 << 20,
+		OutputTimeout:     time.Minute,
+		SlowlogThreshold:  10 * time.Millisecond,
+		SlowlogMaxLen:     128,
+		LatencyThreshold:  10 * time.Millisecond,
+		ScriptTimeout:     5 * time.Second,
+	}
+}
+
 // Server represents our cache server
 type Server struct {
-	store *store.Store
-	addr  string
+	store    *store.Store
+	addr     string
+	announce string
+	listener net.Listener // TCP listener; nil when only the Unix socket is served
+
+	unixSocket     string
+	unixSocketPerm os.FileMode
+	unixListener   net.Listener
+
+	limits  connLimits
+	clients atomic.Int64 // connections currently served
+	stats   serverStats
+	started time.Time
+	metrics *serverMetrics // nil unless MetricsAddr is set
+	log     *slog.Logger
+	connLog *slog.Logger // for per-connection events
+
+	slowLog      slowLog
+	latency      latencyMonitor
+	commandStats commandStats
+	monitors     monitors
+	registry     clientRegistry
+	pause        pauseState
+
+	commands commandTable
+	// exclusive makes commands flagged FlagExclusive, such as scripts, run
+	// alone: they hold it exclusively, other commands share it
+	exclusive     sync.RWMutex
+	scripts       scriptCache
+	scriptTimeout time.Duration
+	// streamMu serializes stream writes in raft mode, see streamWriter
+	streamMu sync.Mutex
+
+	primary *replication.Primary
+	raft    *raft.Node
+	cluster *cluster.State
+
+	acl          *acl.ACL
+	aclFile      string
+	authUser     string
+	authPassword string
+
+	tlsConfig    *tls.Config // listener configuration; nil without TLS
+	peerTLS      *tls.Config // configuration for connections to other nodes; nil without TLS
+	tlsCertUsers bool
+
+	// slotLocks keep key commands from racing with MIGRATE moving the same keys
+	slotLocks [slotLockStripes]sync.RWMutex
+
+	mu      sync.Mutex
+	replMu  sync.Mutex // serializes REPLICAOF role changes
+	replica *replication.Replica
+	done    chan struct{}
 }
 
 // New creates a new Server instance
 func New(addr string) (*Server, error) {
-	log.Println("Initializing server...")
+	return NewWithConfig(DefaultConfig(addr))
+}
 
-	aofFilename := "aof.log"
+// NewWithConfig creates a new Server instance from a Config
+func NewWithConfig(cfg Config) (*Server, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	connLogger := cfg.ConnLogger
+	if connLogger == nil {
+		connLogger = logger
+	}
+	logger.Info("Initializing server")
 
-	storage, err := store.New(aofFilename)
+	if cfg.RaftID != "" && cfg.AOFFilename != "" {
+		// The Raft log already persists every write; replaying both would apply them twice
+		return nil, fmt.Errorf("raft mode and an AOF cannot be combined; set AOFFilename to \"\"")
+	}
+	if cfg.AOFFilename != "" || cfg.RaftID != "" {
+		if cfg.MaxRequestSize <= 0 || cfg.MaxRequestSize > persistence.MaxCommandSize {
+			return nil, fmt.Errorf("max request size must be between 1 and %d bytes with persistence, so the AOF and Raft log can be read back", persistence.MaxCommandSize)
+		}
+	}
+
+	keys, err := loadKeyring(cfg)
 	if err != nil {
-		log.Printf("Failed to initialize store with AOF '%s': %v", aofFilename, err)
+		return nil, fmt.Errorf("encryption key initialization failed: %w", err)
+	}
+	storage, err := store.NewWithConfig(store.Config{
+		AOFFilename: cfg.AOFFilename,
+		Keys:        keys,
+		Databases:   cfg.Databases,
+	})
+	if err != nil {
+		logger.Error("Failed to initialize store", "aof", cfg.AOFFilename, "err", err)
 		return nil, fmt.Errorf("store initialization failed: %w", err)
 	}
-	log.Println("Store initialized successfully.")
+	logger.Info("Store initialized", "aof", cfg.AOFFilename)
 
 	server := &Server{
-		store: storage,
-		addr:  addr,
+		store:          storage,
+		addr:           cfg.Addr,
+		unixSocket:     cfg.UnixSocket,
+		unixSocketPerm: cfg.UnixSocketPerm,
+		limits: connLimits{
+			maxClients:        cfg.MaxClients,
+			idleTimeout:       cfg.IdleTimeout,
+			maxRequestSize:    cfg.MaxRequestSize,
+			maxArgSize:        cfg.MaxArgSize,
+			outputBufferLimit: cfg.OutputBufferLimit,
+			outputTimeout:     cfg.OutputTimeout,
+		},
+		slowLog:       slowLog{threshold: cfg.SlowlogThreshold, maxLen: cfg.SlowlogMaxLen},
+		latency:       latencyMonitor{threshold: cfg.LatencyThreshold},
+		scriptTimeout: cfg.ScriptTimeout,
+		announce:      cfg.AnnounceAddr,
+		primary:       replication.NewPrimary(storage, cfg.ReplBacklogSize),
+		acl:           acl.New(),
+		aclFile:       cfg.ACLFile,
+		authUser:      cfg.AuthUser,
+		authPassword:  cfg.AuthPassword,
+		done:          make(chan struct{}),
+		started:       time.Now(),
+		log:           logger,
+		connLog:       connLogger,
+	}
+	if cfg.MetricsAddr != "" {
+		server.metrics = server.newMetrics(cfg.MetricsAddr)
+	}
+	storage.OnFsync(server.fsyncDone)
+	if err := server.registerCommands(); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("command registration failed: %w", err)
+	}
+	if err := server.loadUsers(cfg.RequirePass); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("acl initialization failed: %w", err)
+	}
+	if err := server.setupTLS(cfg); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("tls initialization failed: %w", err)
+	}
+	if cfg.ReplicaOf != "" {
+		server.replica = server.newReplica(cfg.ReplicaOf)
 	}
-	log.Printf("Server configured for address %s", addr)
+	if cfg.RaftID != "" {
+		if cfg.ReplicaOf != "" {
+			storage.Close()
+			return nil, fmt.Errorf("raft mode and replicaof cannot be combined")
+		}
+		if server.raft, err = newRaftNode(cfg, storage, keys); err != nil {
+			storage.Close()
+			return nil, fmt.Errorf("raft initialization failed: %w", err)
+		}
+	}
+	if cfg.ClusterEnabled {
+		announce := cfg.AnnounceAddr
+		if announce == "" {
+			announce = cfg.Addr
+		}
+		if server.cluster, err = cluster.Load(cfg.ClusterConfigFile, announce); err != nil {
+			storage.Close()
+			return nil, fmt.Errorf("cluster initialization failed: %w", err)
+		}
+	}
+	logger.Info("Server configured", "addr", cfg.Addr)
 
 	return server, nil
 }
 
+// loadKeyring returns the encryption keys of the configuration, or nil if persistence is not encrypted
+func loadKeyring(cfg Config) (*persistence.Keyring, error) {
+	switch {
+	case cfg.EncryptionKeyFile != "" && cfg.EncryptionKeys != "":
+		return nil, fmt.Errorf("an encryption key file and encryption keys cannot be combined")
+	case cfg.EncryptionKeyFile != "":
+		return persistence.LoadKeyring(cfg.EncryptionKeyFile)
+	case cfg.EncryptionKeys != "":
+		return persistence.ParseKeyring(cfg.EncryptionKeys)
+	}
+	return nil, nil
+}
+
+// Listen binds the server's listeners without accepting connections yet
+func (s *Server) Listen() error {
+	if s.addr == "" && s.unixSocket == "" {
+		return fmt.Errorf("no TCP address or Unix socket to listen on")
+	}
+	s.log.Debug("Starting server listener")
+	if s.addr != "" {
+		listener, err := net.Listen("tcp", s.addr)
+		if err != nil {
+			s.log.Error("Failed to start listener", "addr", s.addr, "err", err)
+			return fmt.Errorf("failed to start listener: %w", err)
+		}
+		if s.tlsConfig != nil {
+			listener = tls.NewListener(listener, s.tlsConfig)
+		}
+		s.listener = listener
+	}
+	if s.unixSocket != "" {
+		listener, err := listenUnix(s.unixSocket, s.unixSocketPerm)
+		if err != nil {
+			s.log.Error("Failed to start Unix socket listener", "path", s.unixSocket, "err", err)
+			if s.listener != nil {
+				s.listener.Close()
+			}
+			return fmt.Errorf("failed to start Unix socket listener: %w", err)
+		}
+		s.unixListener = listener
+	}
+	if s.metrics != nil {
+		if err := s.metrics.listen(); err != nil {
+			s.log.Error("Failed to start metrics listener", "addr", s.metrics.addr, "err", err)
+			if s.listener != nil {
+				s.listener.Close()
+			}
+			if s.unixListener != nil {
+				s.unixListener.Close()
+			}
+			return fmt.Errorf("failed to start metrics listener: %w", err)
+		}
+	}
+	return nil
+}
+
+// listenUnix listens on a Unix socket at path, replacing a socket file left
+// behind by a server that is no longer running
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+// Addr returns the address the server is listening on, or nil before Listen.
+// It is the TCP address, or the Unix socket if the server has no TCP listener.
+func (s *Server) Addr() net.Addr {
+	switch {
+	case s.listener != nil:
+		return s.listener.Addr()
+	case s.unixListener != nil:
+		return s.unixListener.Addr()
+	}
+	return nil
+}
+
 // Start starts the server and listens for incoming connections
 func (s *Server) Start() error {
-	log.Println("Starting server listener...")
-	listener, err := net.Listen("tcp", s.addr)
-	if err != nil {
-		log.Printf("Failed to start listener: %v", err)
-		return fmt.Errorf("failed to start listener: %w", err)
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Close stops accepting connections and stops replication. Serve returns
+// once the listener is closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	replica := s.replica
+	s.replica = nil
+	s.mu.Unlock()
+
+	if replica != nil {
+		replica.Stop()
+	}
+	if s.raft != nil {
+		s.raft.Stop()
+	}
+	s.metrics.close()
+	if s.unixListener != nil {
+		s.unixListener.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// Serve accepts connections on the listeners bound by Listen until Close is called
+func (s *Server) Serve() error {
+	listener := s.listener
+	if listener == nil {
+		listener = s.unixListener
+	} else if s.unixListener != nil {
+		s.log.Info("Server listening", "addr", s.unixListener.Addr())
+		go s.accept(s.unixListener)
 	}
 	defer listener.Close()
 	defer func() {
-		log.Println("Attempting to close store...")
+		s.log.Debug("Closing store")
 		if err := s.store.Close(); err != nil {
-			log.Printf("Error closing store: %v", err)
+			s.log.Error("Failed to close store", "err", err)
 		} else {
-			log.Println("Store closed successfully.")
+			s.log.Info("Store closed")
 		}
 	}()
 
-	log.Printf("Server listening on %s", s.addr)
+	s.log.Info("Server listening", "addr", listener.Addr())
+	if s.metrics != nil {
+		go s.metrics.serve()
+	}
+
+	s.mu.Lock()
+	if s.replica != nil {
+		s.log.Info("Replicating", "primary", s.replica.Status().PrimaryAddr)
+		s.replica.Announce(s.announceAddr())
+		s.replica.Start()
+	}
+	s.mu.Unlock()
+
+	if s.raft != nil {
+		if err := s.raft.Start(); err != nil {
+			return err
+		}
+	}
 
 	// Start a goroutine to periodically clean up expired items
 	go func() {
-		log.Println("Background cleanup routine started.")
+		s.log.Debug("Background cleanup routine started")
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
 		for {
-			s.store.DeleteExpired()
-			time.Sleep(1 * time.Minute)
+			// Keys must not change while writes are paused
+			if !s.pause.paused() {
+				start := time.Now()
+				s.store.DeleteExpired()
+				s.latency.add(latencyExpireCycle, time.Since(start))
+			}
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
 		}
 	}()
 
-	log.Println("Entering accept loop...")
+	s.accept(listener)
+	return nil
+}
+
+// accept hands the connections of a listener to handleConnection until the server is closed
+func (s *Server) accept(listener net.Listener) {
+	s.log.Debug("Entering accept loop", "addr", listener.Addr())
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("Error accepting connection: %v", err)
+			if s.isClosed() {
+				s.log.Debug("Listener closed, leaving accept loop", "addr", listener.Addr())
+				return
+			}
+			s.log.Error("Failed to accept connection", "err", err)
+			continue
+		}
+		if !s.admit(conn) {
 			continue
 		}
-		log.Printf("Accepted connection from %s", conn.RemoteAddr())
+		s.stats.connections.Add(1)
+		s.connLog.Info("Accepted connection", "client", conn.RemoteAddr())
 
 		// Handle each connection in a separate goroutine
 		go s.handleConnection(conn)
@@ -85,87 +552,371 @@ func (s *Server) Start() error {
 // handleConnection processes a single client connection
 func (s *Server) handleConnection(conn net.Conn) {
 	defer func() {
-		log.Printf("Closing connection from %s", conn.RemoteAddr())
+		s.connLog.Info("Closed connection", "client", conn.RemoteAddr())
 		conn.Close()
+		s.release()
 	}()
 
+	client := s.registry.register(conn)
+	defer s.registry.unregister(client)
+
+	sess := s.newSession()
+	sess.client = client
+	sess.addr = conn.RemoteAddr()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := s.handshake(tlsConn, sess); err != nil {
+			s.connLog.Warn("TLS handshake failed", "client", conn.RemoteAddr(), "err", err)
+			return
+		}
+	}
+	client.setUser(sess.user)
+
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
 	for {
 		// Read command from client
-		cmd, err := reader.ReadString('\n')
+		cmd, err := s.readRequest(conn, reader)
+		if errors.Is(err, errRequestTooLarge) {
+			// The rest of the line cannot be told apart from the next command
+			s.connLog.Warn("Closing connection: request too large", "client", conn.RemoteAddr(), "limit", s.limits.maxRequestSize)
+			s.writeReply(conn, writer, protocol.Errorf(protocol.CodeErr, "Protocol error: request exceeds %d bytes", s.limits.maxRequestSize))
+			return
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.connLog.Info("Closing idle connection", "client", conn.RemoteAddr())
+			return
+		}
 		if err != nil {
-			log.Printf("Error reading command from %s: %v", conn.RemoteAddr(), err)
+			if !errors.Is(err, io.EOF) {
+				s.connLog.Warn("Failed to read command", "client", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+
+		parts := strings.Fields(cmd)
+		client.qbuf.Store(int64(reader.Buffered()))
+		if len(parts) > 0 {
+			client.started(parts[0])
+		}
+		if reply := s.checkArgs(parts); reply != "" {
+			if err := s.writeReply(conn, writer, reply); err != nil {
+				s.connLog.Warn("Failed to write reply", "client", conn.RemoteAddr(), "err", err)
+				return
+			}
+			continue
+		}
+
+		// A replica asking to sync takes over the connection
+		if len(parts) > 0 && strings.ToUpper(parts[0]) == "PSYNC" {
+			if reply := s.authorize(sess, "PSYNC", nil); reply != "" {
+				if err := s.writeReply(conn, writer, reply); err != nil {
+					return
+				}
+				continue
+			}
+			// Replication streams are exempt from the idle and output timeouts
+			conn.SetDeadline(time.Time{})
+			client.setFlags("S")
+			if err := s.primary.Serve(conn, reader, parts[1:]); err != nil {
+				s.log.Warn("Replication stream stopped", "replica", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+
+		// A monitor takes over the connection to stream the commands of others
+		if len(parts) > 0 && strings.ToUpper(parts[0]) == "MONITOR" {
+			reply := s.authorize(sess, "MONITOR", parts)
+			if reply == "" && len(parts) != 1 {
+				reply = protocol.Error(protocol.CodeSyntax, "MONITOR takes no arguments")
+			}
+			if reply != "" {
+				if err := s.writeReply(conn, writer, reply); err != nil {
+					return
+				}
+				continue
+			}
+			client.setFlags("O")
+			s.serveMonitor(conn, reader, writer)
 			return
 		}
 
 		// Process command and send response
-		response := s.handleCommand(strings.TrimSpace(cmd))
-		if _, err := writer.WriteString(response + "\n"); err != nil {
-			log.Printf("Error writing response to %s: %v", conn.RemoteAddr(), err)
+		start := time.Now()
+		response := s.handleCommand(sess, strings.TrimSpace(cmd))
+		s.commandDone(conn.RemoteAddr(), parts, time.Since(start))
+		s.stats.commands.Add(1)
+		client.obuf.Store(int64(len(response)))
+		err = s.writeReply(conn, writer, response)
+		client.obuf.Store(0)
+		if err != nil {
+			s.connLog.Warn("Failed to write reply", "client", conn.RemoteAddr(), "err", err)
+			return
+		}
+		if client.killed.Load() {
+			s.connLog.Info("Closing connection killed by CLIENT KILL", "client", conn.RemoteAddr())
 			return
 		}
-		writer.Flush()
 	}
 }
 
+// session holds the state of a single client connection
+type session struct {
+	// asking is set by ASKING and lets the next command reach a slot being imported
+	asking bool
+	// user is the user the connection is logged in as; empty until AUTH succeeds
+	user string
+	// db is the database selected with SELECT
+	db int
+	// client is the entry of the connection in the client registry; nil for
+	// sessions that are not client connections
+	client *clientConn
+	// addr is the address of the client, which MONITOR reports commands
+	// with, including those its scripts run
+	addr net.Addr
+	// nested is set on the sessions of commands run by other commands, such
+	// as scripts, which hold the locks of the outer command
+	nested bool
+}
+
+// newSession starts the state of a new connection, logged in as the default
+// user if it needs no password
+func (s *Server) newSession() *session {
+	sess := &session{}
+	if s.acl.AutoLogin() {
+		sess.user = acl.DefaultUser
+	}
+	return sess
+}
+
 // handleCommand processes a single command and returns the response
-func (s *Server) handleCommand(cmd string) string {
+func (s *Server) handleCommand(sess *session, cmd string) string {
 	parts := strings.Fields(cmd)
 	if len(parts) == 0 {
-		return "ERROR: Empty command"
-	}
-
-	switch strings.ToUpper(parts[0]) {
-	case "SET":
-		if len(parts) < 3 {
-			return "ERROR: SET requires key and value"
-		}
-		key := parts[1]
-		var value string
-		var ttl time.Duration
-		if len(parts) > 3 {
-			parsedTTL, err := time.ParseDuration(parts[len(parts)-1])
-			if err == nil {
-				ttl = parsedTTL
-				value = strings.Join(parts[2:len(parts)-1], " ")
-			} else {
-				value = strings.Join(parts[2:], " ")
-			}
-		} else {
-			value = strings.Join(parts[2:], " ")
-		}
-		s.store.Set(key, value, ttl)
-		return "OK"
+		return protocol.Error(protocol.CodeSyntax, "Empty command")
+	}
+	asking := sess.asking
+	sess.asking = false
 
-	case "GET":
-		if len(parts) != 2 {
-			return "ERROR: GET requires key"
+	name := strings.ToUpper(parts[0])
+	command, known := s.commands.lookup(name)
+	if !known || !command.has(FlagNoAuth) {
+		if reply := s.authorize(sess, name, parts); reply != "" {
+			return reply
 		}
-		value, exists := s.store.Get(parts[1])
-		if !exists {
-			return "NIL"
+	}
+	if !known {
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown command")
+	}
+	if !command.checksArgs && !command.arityOK(len(parts)) {
+		return protocol.Errorf(protocol.CodeSyntax, "wrong number of arguments for '%s' command", strings.ToLower(name))
+	}
+	// A replica's dataset only changes through replication
+	if command.has(FlagWrite) && s.isReplica() {
+		return protocol.Error(protocol.CodeReadOnly, "You can't write against a read only replica")
+	}
+	if !command.has(FlagNoPause) && !sess.nested {
+		s.pause.wait(command.has(FlagWrite) || command.has(FlagExclusive), s.done)
+	}
+	release, redirect := s.acquire(sess, command, parts, asking)
+	req := &Request{Name: name, Args: parts[1:], Line: cmd, server: s, sess: sess,
+		command: command, parts: parts, asking: asking, release: release}
+	// Blocking commands release the locks while they wait and take them again
+	defer func() { req.release() }()
+	if redirect != "" {
+		return redirect
+	}
+	s.monitors.feed(sess.addr, parts)
+	return command.Handler(req)
+}
+
+// handleSet processes SET key value [ttl]; the value may contain spaces
+func (s *Server) handleSet(r *Request) string {
+	if len(r.Args) < 2 {
+		return protocol.Error(protocol.CodeSyntax, "SET requires key and value")
+	}
+	key := r.Args[0]
+	var value string
+	var ttl time.Duration
+	if len(r.Args) > 2 {
+		parsedTTL, err := time.ParseDuration(r.Args[len(r.Args)-1])
+		if err == nil {
+			ttl = parsedTTL
+			value = strings.Join(r.Args[1:len(r.Args)-1], " ")
+		} else {
+			value = strings.Join(r.Args[1:], " ")
 		}
-		return fmt.Sprintf("%v", value)
+	} else {
+		value = strings.Join(r.Args[1:], " ")
+	}
+	return s.setKey(r.sess.db, key, value, ttl)
+}
 
-	case "DELETE":
-		if len(parts) != 2 {
-			return "ERROR: DELETE requires key"
+// handleGet processes GET key
+func (s *Server) handleGet(r *Request) string {
+	if len(r.Args) != 1 {
+		return protocol.Error(protocol.CodeSyntax, "GET requires key")
+	}
+	value, exists := r.DB().Get(r.Args[0])
+	if !exists {
+		return "NIL"
+	}
+	if _, ok := value.(*store.Stream); ok {
+		return streamError(store.ErrWrongType)
+	}
+	return protocol.EscapeValue(fmt.Sprintf("%v", value))
+}
+
+// handleDelete processes DELETE key
+func (s *Server) handleDelete(r *Request) string {
+	if len(r.Args) != 1 {
+		return protocol.Error(protocol.CodeSyntax, "DELETE requires key")
+	}
+	if s.raft != nil {
+		return s.proposeWrite(r.sess.db, append([]string{r.Name}, r.Args...))
+	}
+	r.DB().Delete(r.Args[0])
+	return "OK"
+}
+
+// handleExists processes EXISTS key
+func (s *Server) handleExists(r *Request) string {
+	if len(r.Args) != 1 {
+		return protocol.Error(protocol.CodeSyntax, "EXISTS requires key")
+	}
+	if r.DB().Exists(r.Args[0]) {
+		return "1"
+	}
+	return "0"
+}
+
+// handlePing processes PING [message]
+func handlePing(r *Request) string {
+	if len(r.Args) > 0 {
+		return protocol.EscapeValue(strings.Join(r.Args, " "))
+	}
+	return "PONG"
+}
+
+// handleReplicaOf processes REPLICAOF host port and REPLICAOF NO ONE
+func (s *Server) handleReplicaOf(r *Request) string {
+	if len(r.Args) != 2 {
+		return protocol.Error(protocol.CodeSyntax, "REPLICAOF requires host and port, or NO ONE")
+	}
+	if s.raft != nil {
+		return protocol.Error(protocol.CodeErr, "REPLICAOF is not available in raft mode")
+	}
+	if strings.ToUpper(r.Args[0]) == "NO" && strings.ToUpper(r.Args[1]) == "ONE" {
+		s.setReplicaOf("")
+	} else {
+		s.setReplicaOf(net.JoinHostPort(r.Args[0], r.Args[1]))
+	}
+	return "OK"
+}
+
+// handleAsking processes ASKING, which lets the next command reach a slot being imported
+func (s *Server) handleAsking(r *Request) string {
+	if s.cluster == nil {
+		return protocol.Error(protocol.CodeErr, "cluster mode is not enabled")
+	}
+	r.sess.asking = true
+	return "OK"
+}
+
+// handleReplicas processes REPLICAS, listing the address and acknowledged offset of each replica
+func (s *Server) handleReplicas(r *Request) string {
+	if len(r.Args) != 0 {
+		return protocol.Error(protocol.CodeSyntax, "REPLICAS takes no arguments")
+	}
+	var entries []string
+	for _, replica := range s.primary.Replicas() {
+		addr := replica.ListenAddr
+		if addr == "" {
+			addr = replica.Addr
 		}
-		s.store.Delete(parts[1])
-		return "OK"
+		entries = append(entries, fmt.Sprintf("%s %d", addr, replica.AckOffset))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// isClosed reports whether Close has been called
+func (s *Server) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// isReplica reports whether the server currently replicates from a primary
+func (s *Server) isReplica() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replica != nil
+}
+
+// setReplicaOf switches the server to replicate from addr, or promotes it to
+// a primary when addr is empty. A promoted replica keeps its replication ID and
+// offset, so its former siblings can resume from it without a full resync.
+func (s *Server) setReplicaOf(addr string) {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
 
-	case "EXISTS":
-		if len(parts) != 2 {
-			return "ERROR: EXISTS requires key"
+	s.mu.Lock()
+	old := s.replica
+	s.replica = nil
+	s.mu.Unlock()
+
+	if old != nil {
+		if old.Status().PrimaryAddr == addr {
+			s.mu.Lock()
+			s.replica = old
+			s.mu.Unlock()
+			return
 		}
-		if s.store.Exists(parts[1]) {
-			return "1"
+		old.Stop()
+	}
+	if addr == "" {
+		if old != nil {
+			s.primary.Backlog().Promote()
 		}
-		return "0"
+		s.log.Info("Promoted to primary")
+		return
+	}
 
-	default:
-		return "ERROR: Unknown command"
+	replica := s.newReplica(addr)
+	s.mu.Lock()
+	s.replica = replica
+	s.mu.Unlock()
+	s.log.Info("Replicating", "primary", addr)
+	replica.Announce(s.announceAddr())
+	replica.Start()
+}
+
+// announceAddr returns the address this server tells other nodes to reach it
+// on. Without an AnnounceAddr it is the listener address, whose host the
+// primary replaces with the replica's source IP if it is unspecified.
+func (s *Server) announceAddr() string {
+	if s.announce != "" {
+		return s.announce
+	}
+	if s.listener != nil {
+		return s.listener.Addr().String()
+	}
+	return s.addr
+}
+
+// role describes the replication role of the server in a single line
+func (s *Server) role() string {
+	s.mu.Lock()
+	replica := s.replica
+	s.mu.Unlock()
+
+	if replica != nil {
+		status := replica.Status()
+		return fmt.Sprintf("replica %s %s %d", status.PrimaryAddr, status.State, status.Offset)
 	}
+	backlog := s.primary.Backlog()
+	return fmt.Sprintf("primary %s %d %d", backlog.ID(), backlog.Offset(), len(s.primary.Replicas()))
 }
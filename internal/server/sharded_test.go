@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+)
+
+// startPlainNode starts a standalone server on a random localhost port
+func startPlainNode(t *testing.T) *Server {
+	cfg := DefaultConfig("127.0.0.1:0")
+	cfg.AOFFilename = ""
+	srv, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve()
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestShardedClientEjectsFailedNode(t *testing.T) {
+	ctx := context.Background()
+	srv1 := startPlainNode(t)
+	srv2 := startPlainNode(t)
+
+	c, err := client.NewSharded([]client.ShardNode{{Addr: srv1.Addr().String()}, {Addr: srv2.Addr().String()}}, client.ShardOptions{
+		HealthCheckInterval: 50 * time.Millisecond,
+		FailureThreshold:    2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create sharded client: %v", err)
+	}
+	defer c.Close()
+
+	// Find a key owned by each node
+	keys := make(map[string]string)
+	for i := 0; len(keys) < 2 && i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		addr, err := c.NodeFor(key)
+		if err != nil {
+			t.Fatalf("Failed to route key: %v", err)
+		}
+		if _, ok := keys[addr]; !ok {
+			keys[addr] = key
+		}
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected keys on both nodes, got %d", len(keys))
+	}
+	for _, key := range keys {
+		if err := c.Set(ctx, key, "v", 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	srv2.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if nodes := c.Nodes(); !nodes[0].Healthy || !nodes[1].Healthy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the stopped node to be ejected")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Keys of the ejected node are now served by the remaining one
+	key := keys[srv2.Addr().String()]
+	if addr, _ := c.NodeFor(key); addr != srv1.Addr().String() {
+		t.Errorf("Expected key %s to move to %s, got %s", key, srv1.Addr().String(), addr)
+	}
+	if err := c.Set(ctx, key, "w", 0); err != nil {
+		t.Fatalf("Set after ejection failed: %v", err)
+	}
+	if value, err := c.Get(ctx, keys[srv1.Addr().String()]); err != nil || value != "v" {
+		t.Errorf("Expected value v on the healthy node, got %q (%v)", value, err)
+	}
+}
+
+func TestShardedClientNoHealthyNodes(t *testing.T) {
+	ctx := context.Background()
+	c, err := client.NewSharded([]client.ShardNode{{Addr: "127.0.0.1:1"}}, client.ShardOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create sharded client: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, client.ErrNoHealthyNodes) {
+		t.Errorf("Expected ErrNoHealthyNodes, got %v", err)
+	}
+}
+
+// startStallingNode starts a fake node that serves its first connection until
+// the second command, then drops it, and never answers later connections. It
+// reports each later connection on stalled.
+func startStallingNode(t *testing.T) (addr string, stalled <-chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan struct{}, 10)
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for first := true; ; first = false {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+			if !first {
+				accepted <- struct{}{}
+				continue
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				reader.ReadString('\n')
+				conn.Write([]byte("OK\n"))
+				reader.ReadString('\n')
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String(), accepted
+}
+
+func TestShardedClientDialsOutsideLock(t *testing.T) {
+	ctx := context.Background()
+	srv := startPlainNode(t)
+	stallingAddr, stalled := startStallingNode(t)
+
+	c, err := client.NewSharded([]client.ShardNode{{Addr: stallingAddr}, {Addr: srv.Addr().String()}}, client.ShardOptions{
+		HealthCheckInterval: time.Hour,
+		// Every new connection sends CLIENT SETNAME, which the stalling node never answers
+		Client: client.Options{ClientName: "sharded", MaxRetries: -1, ReadTimeout: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create sharded client: %v", err)
+	}
+	defer c.Close()
+
+	keys := make(map[string]string)
+	for i := 0; len(keys) < 2 && i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		addr, _ := c.NodeFor(key)
+		keys[addr] = key
+	}
+
+	// The dropped connection is closed, so the next command dials again and stalls
+	if _, err := c.Get(ctx, keys[stallingAddr]); err == nil {
+		t.Fatal("Expected the dropped connection to fail the command")
+	}
+	go c.Get(ctx, keys[stallingAddr])
+	<-stalled
+
+	start := time.Now()
+	if err := c.Set(ctx, keys[srv.Addr().String()], "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected a command to the healthy node not to wait for the stalled dial, took %s", elapsed)
+	}
+}
+
+func TestShardedClientIgnoresCanceledRequests(t *testing.T) {
+	srv := startPlainNode(t)
+	c, err := client.NewSharded([]client.ShardNode{{Addr: srv.Addr().String()}}, client.ShardOptions{
+		HealthCheckInterval: time.Hour,
+		FailureThreshold:    1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create sharded client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if nodes := c.Nodes(); !nodes[0].Healthy || nodes[0].Failures != 0 {
+		t.Errorf("Expected a canceled request not to count as a failure, got %+v", nodes[0])
+	}
+	if err := c.Set(context.Background(), "key", "v", 0); err != nil {
+		t.Errorf("Expected the node to keep serving, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Expected a second Close to be harmless, got %v", err)
+	}
+}
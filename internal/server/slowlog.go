@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"CacheFlow/internal/protocol"
+)
+
+const (
+	// slowLogMaxArgs is how many arguments of a command a slow log entry keeps
+	slowLogMaxArgs = 32
+	// slowLogMaxArgLen is how many bytes of each argument a slow log entry keeps
+	slowLogMaxArgLen = 128
+	// slowLogDefaultCount is how many entries SLOWLOG GET returns without a count
+	slowLogDefaultCount = 10
+)
+
+// SlowLogEntry records a command that took longer than the slow log threshold
+type SlowLogEntry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	// Client is the address of the connection that sent the command
+	Client string
+	// Args are the command and its arguments, truncated and with secrets redacted
+	Args []string
+}
+
+// slowLog keeps the most recent slow commands in a bounded ring
+type slowLog struct {
+	threshold time.Duration // negative disables the log
+	maxLen    int
+
+	mu      sync.Mutex
+	entries []SlowLogEntry // oldest first
+	nextID  int64
+}
+
+// add records a command if it took at least the threshold
+func (l *slowLog) add(client string, parts []string, d time.Duration) {
+	if l.threshold < 0 || d < l.threshold || l.maxLen <= 0 {
+		return
+	}
+	entry := SlowLogEntry{Time: time.Now(), Duration: d, Client: client, Args: slowLogArgs(parts)}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.ID = l.nextID
+	l.nextID++
+	if len(l.entries) >= l.maxLen {
+		l.entries = append(l.entries[:0], l.entries[len(l.entries)-l.maxLen+1:]...)
+	}
+	l.entries = append(l.entries, entry)
+}
+
+// get returns up to count entries, newest first; a negative count returns all of them
+func (l *slowLog) get(count int) []SlowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	result := make([]SlowLogEntry, 0, count)
+	for i := len(l.entries) - 1; i >= len(l.entries)-count; i-- {
+		result = append(result, l.entries[i])
+	}
+	return result
+}
+
+// len returns the number of entries
+func (l *slowLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// reset removes every entry
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
+
+// slowLogArgs copies the arguments of a command for the slow log, hiding
+// passwords and truncating long commands and arguments
+func slowLogArgs(parts []string) []string {
+	args := make([]string, 0, min(len(parts), slowLogMaxArgs))
+	for i, arg := range parts {
+		if i == slowLogMaxArgs-1 && len(parts) > slowLogMaxArgs {
+			args = append(args, fmt.Sprintf("...(%d more arguments)", len(parts)-i))
+			break
+		}
+		if redacted(parts, i) {
+			arg = "(redacted)"
+		} else if len(arg) > slowLogMaxArgLen {
+			arg = fmt.Sprintf("%s...(%d more bytes)", arg[:slowLogMaxArgLen], len(arg)-slowLogMaxArgLen)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// redacted reports whether the i-th part of a command may hold a password:
+// the arguments of AUTH and the rules of ACL SETUSER
+func redacted(parts []string, i int) bool {
+	switch strings.ToUpper(parts[0]) {
+	case "AUTH":
+		return i > 0
+	case "ACL":
+		return i > 2 && strings.ToUpper(parts[1]) == "SETUSER"
+	}
+	return false
+}
+
+// SlowLog returns up to count slow log entries, newest first; a negative count returns all of them
+func (s *Server) SlowLog(count int) []SlowLogEntry {
+	return s.slowLog.get(count)
+}
+
+// handleSlowLog processes SLOWLOG GET [count] | LEN | RESET
+func (s *Server) handleSlowLog(args []string) string {
+	if len(args) == 0 {
+		return protocol.Error(protocol.CodeSyntax, "SLOWLOG requires a subcommand")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		count := slowLogDefaultCount
+		if len(args) > 2 {
+			return protocol.Error(protocol.CodeSyntax, "SLOWLOG GET takes at most a count")
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < -1 {
+				return protocol.Error(protocol.CodeSyntax, "count must be a number, or -1 for every entry")
+			}
+			count = n
+		}
+		var entries []string
+		for _, e := range s.slowLog.get(count) {
+			entries = append(entries, fmt.Sprintf("%d %d %d %s %s",
+				e.ID, e.Time.Unix(), e.Duration.Microseconds(), e.Client, strings.Join(e.Args, " ")))
+		}
+		return strings.Join(entries, ", ")
+
+	case "LEN":
+		return strconv.Itoa(s.slowLog.len())
+
+	case "RESET":
+		s.slowLog.reset()
+		return "OK"
+
+	default:
+		return protocol.Error(protocol.CodeUnknownCommand, "Unknown SLOWLOG subcommand")
+	}
+}
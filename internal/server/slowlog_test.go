@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"CacheFlow/internal/client"
+)
+
+func TestSlowLog(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.SlowlogThreshold = 0
+		cfg.SlowlogMaxLen = 3
+	})
+
+	conn := dialRaw(t, srv)
+	conn.send("AUTH secret")
+	conn.send("SET key " + strings.Repeat("v", 200))
+	conn.send("GET key")
+	conn.send("EXISTS key")
+
+	// The log keeps the newest entries, up to its length
+	if reply := conn.send("SLOWLOG LEN"); reply != "3" {
+		t.Errorf("Expected 3 entries, got %s", reply)
+	}
+	entries := srv.SlowLog(-1)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if args := strings.Join(entries[0].Args, " "); args != "SLOWLOG LEN" {
+		t.Errorf("Expected the newest entry first, got %q", args)
+	}
+	if entries[0].ID != 4 || entries[0].Client != conn.LocalAddr().String() {
+		t.Errorf("Expected ID 4 from %s, got %d from %s", conn.LocalAddr(), entries[0].ID, entries[0].Client)
+	}
+
+	// A command is logged after it replies, so SLOWLOG GET does not see itself
+	fields := strings.Fields(conn.send("SLOWLOG GET 1"))
+	if len(fields) != 6 || fields[0] != "4" || fields[4] != "SLOWLOG" || fields[5] != "LEN" {
+		t.Errorf("Expected the SLOWLOG LEN entry, got %v", fields)
+	}
+	if _, err := strconv.ParseInt(fields[2], 10, 64); err != nil {
+		t.Errorf("Expected a duration in microseconds, got %q", fields[2])
+	}
+
+	// Long arguments are truncated and passwords hidden
+	conn.send("SLOWLOG RESET")
+	conn.send("SET key " + strings.Repeat("v", 200))
+	conn.send("AUTH secret")
+	entries = srv.SlowLog(-1)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if args := entries[0].Args; len(args) != 2 || args[1] != "(redacted)" {
+		t.Errorf("Expected the password to be redacted, got %v", args)
+	}
+	if arg := entries[1].Args[2]; arg != strings.Repeat("v", slowLogMaxArgLen)+"...(72 more bytes)" {
+		t.Errorf("Expected a truncated value, got %q", arg)
+	}
+	if reply := conn.send("SLOWLOG GET x"); !strings.HasPrefix(reply, "ERROR: SYNTAX") {
+		t.Errorf("Expected a syntax error, got %q", reply)
+	}
+}
+
+func TestSlowLogThreshold(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+
+	conn := dialRaw(t, srv)
+	conn.send("PING")
+	if reply := conn.send("SLOWLOG LEN"); reply != "0" {
+		t.Errorf("Expected fast commands to stay out of the slow log, got %s entries", reply)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	ctx := context.Background()
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.AOFFilename = filepath.Join(t.TempDir(), "aof.log")
+		cfg.LatencyThreshold = 1
+	})
+
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	c.Set(ctx, "a", "1", 0)
+	c.Get(ctx, "a")
+
+	latest, err := c.Do(ctx, "LATENCY LATEST")
+	if err != nil {
+		t.Fatalf("LATENCY LATEST failed: %v", err)
+	}
+	if !strings.HasPrefix(latest, "aof-fsync ") || !strings.Contains(latest, ", command ") {
+		t.Errorf("Expected aof-fsync and command events, got %q", latest)
+	}
+	if samples := srv.LatencyHistory(latencyAOFFsync); len(samples) != 1 {
+		t.Errorf("Expected one fsync sample, got %v", samples)
+	}
+	if history, _ := c.Do(ctx, "LATENCY HISTORY command"); len(strings.Fields(history)) != 2 {
+		t.Errorf("Expected commands within a second to share a sample, got %q", history)
+	}
+	if reply, _ := c.Do(ctx, "LATENCY RESET aof-fsync bogus"); reply != "1" {
+		t.Errorf("Expected one event to be reset, got %q", reply)
+	}
+	if samples := srv.LatencyHistory(latencyAOFFsync); len(samples) != 0 {
+		t.Errorf("Expected the fsync history to be reset, got %v", samples)
+	}
+
+	// Command statistics are reported by INFO
+	info, err := c.Info(ctx, "commandstats")
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if stat := info.Get("commandstats", "cmdstat_set"); !strings.HasPrefix(stat, "calls=1,usec=") {
+		t.Errorf("Expected one SET call, got %q", stat)
+	}
+}
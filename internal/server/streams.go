@@ -0,0 +1,596 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"CacheFlow/internal/protocol"
+	"CacheFlow/internal/store"
+)
+
+// defaultAutoClaimCount is how many entries XAUTOCLAIM claims without COUNT
+const defaultAutoClaimCount = 100
+
+// streamWriter returns how stream writes are committed and a function to
+// call once the write is done. In raft mode a write is resolved against the
+// dataset before the log applies it, so writes are serialized to keep two of
+// them from resolving against the same state.
+func (s *Server) streamWriter() (store.Commit, func()) {
+	if s.raft == nil {
+		return nil, func() {}
+	}
+	s.streamMu.Lock()
+	return func(commands []string) error {
+		for _, command := range commands {
+			if err := s.raft.Propose(command); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, s.streamMu.Unlock
+}
+
+// streamError converts an error of a stream operation into a reply
+func streamError(err error) string {
+	switch {
+	case errors.Is(err, store.ErrWrongType):
+		return protocol.Error(protocol.CodeWrongType, "Operation against a key holding the wrong kind of value")
+	case errors.Is(err, store.ErrNoGroup):
+		return protocol.Errorf(protocol.CodeNoGroup, "%v", err)
+	case errors.Is(err, store.ErrGroupExists):
+		return protocol.Errorf(protocol.CodeBusy, "%v", err)
+	case errors.Is(err, store.ErrQuotaExceeded):
+		return protocol.Errorf(protocol.CodeOOM, "%v", err)
+	case errors.Is(err, store.ErrInvalidStreamID):
+		return protocol.Errorf(protocol.CodeSyntax, "%v", err)
+	}
+	return raftError(err)
+}
+
+// formatEntry renders an entry as its ID followed by its fields and values
+func formatEntry(entry store.StreamEntry) string {
+	return strings.Join(append([]string{entry.ID.String()}, entry.Fields...), " ")
+}
+
+// formatEntries renders a list of entries, NIL if there are none
+func formatEntries(entries []store.StreamEntry) string {
+	if len(entries) == 0 {
+		return protocol.Nil
+	}
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = formatEntry(entry)
+	}
+	return strings.Join(lines, ", ")
+}
+
+// formatStreamResults renders the entries read from streams, each preceded
+// by its key, NIL if there are none
+func formatStreamResults(results []store.StreamResult) string {
+	var lines []string
+	for _, result := range results {
+		for _, entry := range result.Entries {
+			lines = append(lines, result.Key+" "+formatEntry(entry))
+		}
+	}
+	if len(lines) == 0 {
+		return protocol.Nil
+	}
+	return strings.Join(lines, ", ")
+}
+
+// parseCount parses a non-negative count argument of a command
+func parseCount(command, name, arg string) (int, string) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 {
+		return 0, protocol.Errorf(protocol.CodeSyntax, "%s %s must be a non-negative integer", command, name)
+	}
+	return n, ""
+}
+
+// handleXAdd processes XADD key [NOMKSTREAM] [MAXLEN n] id|* field value [field value ...]
+func (s *Server) handleXAdd(r *Request) string {
+	key, args := r.Args[0], r.Args[1:]
+	xadd := store.XAddArgs{MaxLen: -1}
+options:
+	for len(args) > 0 {
+		switch strings.ToUpper(args[0]) {
+		case "NOMKSTREAM":
+			xadd.NoMkStream = true
+			args = args[1:]
+		case "MAXLEN":
+			if len(args) < 2 {
+				return protocol.Error(protocol.CodeSyntax, "XADD MAXLEN requires a length")
+			}
+			n, reply := parseCount("XADD", "MAXLEN", args[1])
+			if reply != "" {
+				return reply
+			}
+			xadd.MaxLen = n
+			args = args[2:]
+		default:
+			break options
+		}
+	}
+	if len(args) < 3 || len(args)%2 == 0 {
+		return protocol.Error(protocol.CodeSyntax, "XADD requires an ID and field-value pairs")
+	}
+	xadd.ID, xadd.Fields = args[0], args[1:]
+
+	commit, done := s.streamWriter()
+	defer done()
+	id, added, err := r.DB().XAdd(key, xadd, commit)
+	if err != nil {
+		return streamError(err)
+	}
+	if !added {
+		return protocol.Nil
+	}
+	return id.String()
+}
+
+// handleXTrim processes XTRIM key MAXLEN n and XTRIM key MINID id
+func (s *Server) handleXTrim(r *Request) string {
+	key, strategy, threshold := r.Args[0], strings.ToUpper(r.Args[1]), r.Args[2]
+	commit, done := s.streamWriter()
+	defer done()
+
+	var removed int
+	var err error
+	switch strategy {
+	case "MAXLEN":
+		n, reply := parseCount("XTRIM", "MAXLEN", threshold)
+		if reply != "" {
+			return reply
+		}
+		removed, err = r.DB().XTrimMaxLen(key, n, commit)
+	case "MINID":
+		id, parseErr := store.ParseStreamID(threshold)
+		if parseErr != nil {
+			return streamError(parseErr)
+		}
+		removed, err = r.DB().XTrimMinID(key, id, commit)
+	default:
+		return protocol.Error(protocol.CodeSyntax, "XTRIM requires MAXLEN or MINID")
+	}
+	if err != nil {
+		return streamError(err)
+	}
+	return strconv.Itoa(removed)
+}
+
+// handleXSetID processes XSETID key id
+func (s *Server) handleXSetID(r *Request) string {
+	id, err := store.ParseStreamID(r.Args[1])
+	if err != nil {
+		return streamError(err)
+	}
+	commit, done := s.streamWriter()
+	defer done()
+	if err := r.DB().XSetID(r.Args[0], id, commit); err != nil {
+		return streamError(err)
+	}
+	return "OK"
+}
+
+// handleXLen processes XLEN key
+func (s *Server) handleXLen(r *Request) string {
+	n, err := r.DB().XLen(r.Args[0])
+	if err != nil {
+		return streamError(err)
+	}
+	return strconv.Itoa(n)
+}
+
+// handleXRange processes XRANGE key start end [COUNT n] and XREVRANGE key end start [COUNT n]
+func (s *Server) handleXRange(r *Request) string {
+	key, first, second := r.Args[0], r.Args[1], r.Args[2]
+	reverse := r.Name == "XREVRANGE"
+	if reverse {
+		first, second = second, first
+	}
+	count := 0
+	switch {
+	case len(r.Args) == 5 && strings.ToUpper(r.Args[3]) == "COUNT":
+		n, reply := parseCount(r.Name, "COUNT", r.Args[4])
+		if reply != "" {
+			return reply
+		}
+		count = n
+	case len(r.Args) != 3:
+		return protocol.Errorf(protocol.CodeSyntax, "%s takes only COUNT after the range", r.Name)
+	}
+
+	start, err := store.ParseStreamBound(first, false)
+	if err != nil {
+		return streamError(err)
+	}
+	end, err := store.ParseStreamBound(second, true)
+	if err != nil {
+		return streamError(err)
+	}
+	entries, err := r.DB().XRange(key, start, end, count, reverse)
+	if err != nil {
+		return streamError(err)
+	}
+	return formatEntries(entries)
+}
+
+// streamReadArgs are the arguments of XREAD and XREADGROUP
+type streamReadArgs struct {
+	group, consumer string
+	count           int
+	blocking        bool
+	block           time.Duration
+	noAck           bool
+	keys, ids       []string
+}
+
+// parseStreamRead parses the arguments of XREAD, or of XREADGROUP if group
+// is set, returning an error reply if they are invalid
+func parseStreamRead(command string, args []string, group bool) (streamReadArgs, string) {
+	var a streamReadArgs
+	if group {
+		if len(args) < 3 || strings.ToUpper(args[0]) != "GROUP" {
+			return a, protocol.Error(protocol.CodeSyntax, "XREADGROUP requires GROUP group consumer")
+		}
+		a.group, a.consumer, args = args[1], args[2], args[3:]
+	}
+	for len(args) > 0 {
+		option := strings.ToUpper(args[0])
+		switch {
+		case option == "STREAMS":
+			rest := args[1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return a, protocol.Errorf(protocol.CodeSyntax, "%s requires as many IDs as keys after STREAMS", command)
+			}
+			a.keys, a.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			return a, ""
+		case option == "NOACK" && group:
+			a.noAck = true
+			args = args[1:]
+		case option == "COUNT" || option == "BLOCK":
+			if len(args) < 2 {
+				return a, protocol.Errorf(protocol.CodeSyntax, "%s %s requires a value", command, option)
+			}
+			n, reply := parseCount(command, option, args[1])
+			if reply != "" {
+				return a, reply
+			}
+			if option == "COUNT" {
+				a.count = n
+			} else {
+				a.blocking, a.block = true, time.Duration(n)*time.Millisecond
+			}
+			args = args[2:]
+		default:
+			return a, protocol.Errorf(protocol.CodeSyntax, "unknown %s option %q", command, args[0])
+		}
+	}
+	return a, protocol.Errorf(protocol.CodeSyntax, "%s requires STREAMS", command)
+}
+
+// xreadKeys returns the keys of XREAD: the first half of the words after STREAMS
+func xreadKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.ToUpper(arg) == "STREAMS" {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// xreadGroupKeys returns the keys of XREADGROUP, which come after the group and consumer
+func xreadGroupKeys(args []string) []string {
+	if len(args) < 3 {
+		return nil
+	}
+	return xreadKeys(args[3:])
+}
+
+// blockStreams returns the reply of read, which is passed whether it should
+// wait. Unless it returns a StreamWait, which it does when it found no
+// entries and should wait, its reply is final. Otherwise blockStreams waits
+// for entries to be added, without holding the locks of the request, and
+// reads again: for up to timeout, or for ever if it is zero. Commands run by
+// scripts and other commands do not wait.
+func blockStreams(r *Request, blocking bool, timeout time.Duration, read func(wait bool) (string, *store.StreamWait)) string {
+	blocking = blocking && !r.sess.nested
+	var expired <-chan time.Time
+	if blocking && timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		reply, wait := read(blocking)
+		if wait == nil {
+			return reply
+		}
+		received, redirect := r.wait(wait.C, expired)
+		wait.Stop()
+		if redirect != "" {
+			return redirect
+		}
+		if !received {
+			return protocol.Nil
+		}
+	}
+}
+
+// handleXRead processes XREAD [COUNT n] [BLOCK ms] STREAMS key [key ...] id [id ...].
+// An ID of $ reads the entries added after the command.
+func (s *Server) handleXRead(r *Request) string {
+	a, reply := parseStreamRead(r.Name, r.Args, false)
+	if reply != "" {
+		return reply
+	}
+	db := r.DB()
+	after := make([]store.StreamID, len(a.keys))
+	for i, id := range a.ids {
+		var err error
+		if id == "$" {
+			after[i], err = db.LastStreamID(a.keys[i])
+		} else {
+			after[i], err = store.ParseStreamID(id)
+		}
+		if err != nil {
+			return streamError(err)
+		}
+	}
+	return blockStreams(r, a.blocking, a.block, func(wait bool) (string, *store.StreamWait) {
+		results, w, err := db.XRead(a.keys, after, a.count, wait)
+		if err != nil {
+			return streamError(err), nil
+		}
+		return formatStreamResults(results), w
+	})
+}
+
+// handleXReadGroup processes
+// XREADGROUP GROUP group consumer [COUNT n] [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...].
+// An ID of > reads entries never delivered to the group, other IDs reread
+// the pending entries of the consumer after them.
+func (s *Server) handleXReadGroup(r *Request) string {
+	a, reply := parseStreamRead(r.Name, r.Args, true)
+	if reply != "" {
+		return reply
+	}
+	args := store.XReadGroupArgs{
+		Group: a.group, Consumer: a.consumer, Keys: a.keys, IDs: a.ids, Count: a.count, NoAck: a.noAck,
+	}
+	db := r.DB()
+	return blockStreams(r, a.blocking, a.block, func(wait bool) (string, *store.StreamWait) {
+		commit, done := s.streamWriter()
+		defer done()
+		results, w, err := db.XReadGroup(args, wait, commit)
+		if err != nil {
+			return streamError(err), nil
+		}
+		return formatStreamResults(results), w
+	})
+}
+
+// handleXGroup processes the XGROUP subcommands:
+//
+//	XGROUP CREATE key group id|$ [MKSTREAM]   create a group delivering the entries after id
+//	XGROUP SETID key group id|$               set the ID the group delivers the entries after
+//	XGROUP DESTROY key group                  remove a group
+//	XGROUP CREATECONSUMER key group consumer  add a consumer to a group
+//	XGROUP DELCONSUMER key group consumer     remove a consumer and its pending entries
+func (s *Server) handleXGroup(r *Request) string {
+	sub, key, group, args := strings.ToUpper(r.Args[0]), r.Args[1], r.Args[2], r.Args[3:]
+	commit, done := s.streamWriter()
+	defer done()
+	db := r.DB()
+
+	switch sub {
+	case "CREATE":
+		mkStream := len(args) == 2 && strings.ToUpper(args[1]) == "MKSTREAM"
+		if len(args) != 1 && !mkStream {
+			return protocol.Error(protocol.CodeSyntax, "XGROUP CREATE requires key, group and ID, and takes MKSTREAM")
+		}
+		if err := db.XGroupCreate(key, group, args[0], mkStream, commit); err != nil {
+			return streamError(err)
+		}
+		return "OK"
+
+	case "SETID":
+		if len(args) != 1 {
+			return protocol.Error(protocol.CodeSyntax, "XGROUP SETID requires key, group and ID")
+		}
+		if err := db.XGroupSetID(key, group, args[0], commit); err != nil {
+			return streamError(err)
+		}
+		return "OK"
+
+	case "DESTROY":
+		if len(args) != 0 {
+			return protocol.Error(protocol.CodeSyntax, "XGROUP DESTROY requires key and group")
+		}
+		destroyed, err := db.XGroupDestroy(key, group, commit)
+		if err != nil {
+			return streamError(err)
+		}
+		return boolReply(destroyed)
+
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 1 {
+			return protocol.Errorf(protocol.CodeSyntax, "XGROUP %s requires key, group and consumer", sub)
+		}
+		if sub == "CREATECONSUMER" {
+			created, err := db.XGroupCreateConsumer(key, group, args[0], commit)
+			if err != nil {
+				return streamError(err)
+			}
+			return boolReply(created)
+		}
+		pending, err := db.XGroupDelConsumer(key, group, args[0], commit)
+		if err != nil {
+			return streamError(err)
+		}
+		return strconv.Itoa(pending)
+	}
+	return protocol.Error(protocol.CodeUnknownCommand, "Unknown XGROUP subcommand")
+}
+
+// boolReply renders a boolean as 1 or 0
+func boolReply(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// handleXAck processes XACK key group id [id ...]
+func (s *Server) handleXAck(r *Request) string {
+	ids := make([]store.StreamID, len(r.Args)-2)
+	for i, arg := range r.Args[2:] {
+		id, err := store.ParseStreamID(arg)
+		if err != nil {
+			return streamError(err)
+		}
+		ids[i] = id
+	}
+	commit, done := s.streamWriter()
+	defer done()
+	acked, err := r.DB().XAck(r.Args[0], r.Args[1], ids, commit)
+	if err != nil {
+		return streamError(err)
+	}
+	return strconv.Itoa(acked)
+}
+
+// handleXClaim processes
+// XCLAIM key group consumer min-idle-ms id [id ...] [IDLE ms] [TIME unix-ms] [RETRYCOUNT n] [FORCE] [JUSTID]
+func (s *Server) handleXClaim(r *Request) string {
+	claim, err := store.ParseClaim(r.Args[1:])
+	if err != nil {
+		return protocol.Errorf(protocol.CodeSyntax, "XCLAIM: %v", err)
+	}
+	commit, done := s.streamWriter()
+	defer done()
+	claimed, err := r.DB().XClaim(r.Args[0], claim, commit)
+	if err != nil {
+		return streamError(err)
+	}
+	return formatEntries(claimed)
+}
+
+// handleXAutoClaim processes XAUTOCLAIM key group consumer min-idle-ms start [COUNT n] [JUSTID].
+// The reply is the ID to continue from, 0-0 once every pending entry was
+// considered, followed by the claimed entries.
+func (s *Server) handleXAutoClaim(r *Request) string {
+	minIdle, reply := parseCount("XAUTOCLAIM", "min idle time", r.Args[3])
+	if reply != "" {
+		return reply
+	}
+	start, err := store.ParseStreamBound(r.Args[4], false)
+	if err != nil {
+		return streamError(err)
+	}
+	a := store.AutoClaim{
+		Group: r.Args[1], Consumer: r.Args[2], MinIdle: time.Duration(minIdle) * time.Millisecond,
+		Start: start, Count: defaultAutoClaimCount,
+	}
+	for args := r.Args[5:]; len(args) > 0; args = args[1:] {
+		switch strings.ToUpper(args[0]) {
+		case "JUSTID":
+			a.JustID = true
+		case "COUNT":
+			if len(args) < 2 {
+				return protocol.Error(protocol.CodeSyntax, "XAUTOCLAIM COUNT requires a value")
+			}
+			if a.Count, reply = parseCount("XAUTOCLAIM", "COUNT", args[1]); reply != "" {
+				return reply
+			}
+			args = args[1:]
+		default:
+			return protocol.Errorf(protocol.CodeSyntax, "unknown XAUTOCLAIM option %q", args[0])
+		}
+	}
+
+	commit, done := s.streamWriter()
+	defer done()
+	next, claimed, err := r.DB().XAutoClaim(r.Args[0], a, commit)
+	if err != nil {
+		return streamError(err)
+	}
+	lines := []string{next.String()}
+	for _, entry := range claimed {
+		lines = append(lines, formatEntry(entry))
+	}
+	return strings.Join(lines, ", ")
+}
+
+// handleXPending processes XPENDING key group, which summarizes the pending
+// entries of a group as their count, smallest and greatest ID and the count
+// of every consumer, and XPENDING key group [IDLE ms] start end count [consumer],
+// which lists them as ID, consumer, idle time in milliseconds and delivery count
+func (s *Server) handleXPending(r *Request) string {
+	key, group, args := r.Args[0], r.Args[1], r.Args[2:]
+	if len(args) == 0 {
+		summary, err := r.DB().XPending(key, group)
+		if err != nil {
+			return streamError(err)
+		}
+		if summary.Count == 0 {
+			return "0"
+		}
+		lines := []string{strconv.Itoa(summary.Count), summary.Lowest.String(), summary.Highest.String()}
+		consumers := make([]string, 0, len(summary.Consumers))
+		for consumer := range summary.Consumers {
+			consumers = append(consumers, consumer)
+		}
+		sort.Strings(consumers)
+		for _, consumer := range consumers {
+			lines = append(lines, fmt.Sprintf("%s %d", consumer, summary.Consumers[consumer]))
+		}
+		return strings.Join(lines, ", ")
+	}
+
+	var pr store.PendingRange
+	if strings.ToUpper(args[0]) == "IDLE" && len(args) > 1 {
+		ms, reply := parseCount("XPENDING", "IDLE", args[1])
+		if reply != "" {
+			return reply
+		}
+		pr.MinIdle = time.Duration(ms) * time.Millisecond
+		args = args[2:]
+	}
+	if len(args) != 3 && len(args) != 4 {
+		return protocol.Error(protocol.CodeSyntax, "XPENDING requires start, end and count, and takes a consumer")
+	}
+	var err error
+	if pr.Start, err = store.ParseStreamBound(args[0], false); err != nil {
+		return streamError(err)
+	}
+	if pr.End, err = store.ParseStreamBound(args[1], true); err != nil {
+		return streamError(err)
+	}
+	var reply string
+	if pr.Count, reply = parseCount("XPENDING", "count", args[2]); reply != "" {
+		return reply
+	}
+	if len(args) == 4 {
+		pr.Consumer = args[3]
+	}
+
+	entries, err := r.DB().XPendingRange(key, group, pr)
+	if err != nil {
+		return streamError(err)
+	}
+	if len(entries) == 0 {
+		return protocol.Nil
+	}
+	lines := make([]string, len(entries))
+	for i, p := range entries {
+		lines[i] = fmt.Sprintf("%s %s %d %d", p.ID, p.Consumer, time.Since(p.Delivered).Milliseconds(), p.Deliveries)
+	}
+	return strings.Join(lines, ", ")
+}
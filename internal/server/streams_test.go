@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+)
+
+func TestStreams(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	conn := dialRaw(t, srv)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"XADD events 1-1 type click page home", "1-1"},
+		{"XADD events 1-* type view", "1-2"},
+		{"XADD events MAXLEN 5 2 type click", "2-0"},
+		{"XADD events 1-5 type late", "ERROR: ERR the ID specified is equal or smaller than the last ID of the stream"},
+		{"XADD events 3-0 a b c", "ERROR: SYNTAX XADD requires an ID and field-value pairs"},
+		{"XADD missing NOMKSTREAM * a b", "NIL"},
+		{"XLEN events", "3"},
+		{"XRANGE events - +", "1-1 type click page home, 1-2 type view, 2-0 type click"},
+		{"XRANGE events 1 1 COUNT 1", "1-1 type click page home"},
+		{"XREVRANGE events + - COUNT 2", "2-0 type click, 1-2 type view"},
+		{"XRANGE events 5 +", "NIL"},
+		{"XREAD COUNT 1 STREAMS events other 1-1 0", "events 1-2 type view"},
+		{"XREAD STREAMS events $", "NIL"},
+		{"XREAD BLOCK 20 STREAMS events $", "NIL"},
+		{"XTRIM events MINID 1-2", "1"},
+		{"XTRIM events MAXLEN 5", "0"},
+		{"SET plain value", "OK"},
+		{"XADD plain * a b", "ERROR: WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"GET events", "ERROR: WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"XRANGE events x +", `ERROR: SYNTAX invalid stream ID "x"`},
+	}
+	for _, tt := range tests {
+		if reply := conn.send(tt.command); reply != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.want, reply)
+		}
+	}
+}
+
+func TestConsumerGroups(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	conn := dialRaw(t, srv)
+
+	for _, command := range []string{"XADD jobs 1 n 1", "XADD jobs 2 n 2", "XADD jobs 3 n 3"} {
+		conn.send(command)
+	}
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"XGROUP CREATE jobs workers 0", "OK"},
+		{"XGROUP CREATE jobs workers $", "ERROR: BUSY consumer group name already exists"},
+		{"XGROUP CREATE nope workers $", "ERROR: ERR no such stream nope, create it with MKSTREAM"},
+		{"XREADGROUP GROUP nobody alice STREAMS jobs >", "ERROR: NOGROUP no such consumer group nobody"},
+		{"XREADGROUP GROUP workers alice COUNT 2 STREAMS jobs >", "jobs 1-0 n 1, jobs 2-0 n 2"},
+		{"XREADGROUP GROUP workers bob STREAMS jobs >", "jobs 3-0 n 3"},
+		{"XREADGROUP GROUP workers bob STREAMS jobs >", "NIL"},
+		{"XPENDING jobs workers", "3, 1-0, 3-0, alice 2, bob 1"},
+		{"XACK jobs workers 1-0 1-0 9-0", "1"},
+		// Rereading its pending entries gives alice what it has not acknowledged
+		{"XREADGROUP GROUP workers alice STREAMS jobs 0", "jobs 2-0 n 2"},
+		{"XCLAIM jobs workers bob 3600000 2-0", "NIL"},
+		{"XCLAIM jobs workers bob 0 2-0 JUSTID", "2-0"},
+		{"XPENDING jobs workers - + 10 alice", "NIL"},
+		{"XAUTOCLAIM jobs workers carol 0 0 COUNT 1", "3-0, 2-0 n 2"},
+		{"XAUTOCLAIM jobs workers carol 0 3-0", "0-0, 3-0 n 3"},
+		{"XGROUP DELCONSUMER jobs workers carol", "2"},
+		{"XPENDING jobs workers", "0"},
+		{"XGROUP DESTROY jobs workers", "1"},
+		{"XGROUP DESTROY jobs workers", "0"},
+	}
+	for _, tt := range tests {
+		if reply := conn.send(tt.command); reply != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.want, reply)
+		}
+	}
+
+	conn.send("XGROUP CREATE jobs workers 0")
+	conn.send("XREADGROUP GROUP workers alice COUNT 1 STREAMS jobs >")
+	fields := strings.Fields(conn.send("XPENDING jobs workers IDLE 0 - + 10"))
+	if len(fields) != 4 || fields[0] != "1-0" || fields[1] != "alice" || fields[3] != "1" {
+		t.Errorf("Expected 1-0 pending for alice after one delivery, got %v", fields)
+	}
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	reader := dialRaw(t, srv)
+	writer := dialRaw(t, srv)
+
+	replies := make(chan string)
+	go func() { replies <- reader.send("XREAD BLOCK 0 STREAMS events $") }()
+
+	// Other commands, including scripts that run alone, go on while readers wait
+	time.Sleep(50 * time.Millisecond)
+	if reply := writer.send("EVAL 0 0 return 1"); reply != "1" {
+		t.Errorf("Expected a script to run while a reader waits, got %q", reply)
+	}
+	writer.send("XADD events 5-0 kind first")
+	select {
+	case reply := <-replies:
+		if reply != "events 5-0 kind first" {
+			t.Errorf("Expected the new entry, got %q", reply)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected XREAD to return once an entry was added")
+	}
+
+	writer.send("XGROUP CREATE events group $")
+	go func() { replies <- reader.send("XREADGROUP GROUP group alice BLOCK 2000 STREAMS events >") }()
+	time.Sleep(50 * time.Millisecond)
+	writer.send("XADD events 6-0 kind second")
+	if reply := <-replies; reply != "events 6-0 kind second" {
+		t.Errorf("Expected the group to get the new entry, got %q", reply)
+	}
+	if reply := writer.send("XPENDING jobs group"); !strings.HasPrefix(reply, "ERROR: NOGROUP") {
+		t.Errorf("Expected NOGROUP for a missing stream, got %q", reply)
+	}
+	if reply := writer.send("XPENDING events group"); reply != "1, 6-0, 6-0, alice 1" {
+		t.Errorf("Expected the entry to be pending for alice, got %q", reply)
+	}
+	if reply := reader.send("XREAD BLOCK 30 STREAMS events $"); reply != "NIL" {
+		t.Errorf("Expected NIL once the block timed out, got %q", reply)
+	}
+}
+
+func TestStreamClient(t *testing.T) {
+	ctx := context.Background()
+	srv := startConfiguredNode(t, func(cfg *Config) {})
+	c, err := client.New(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	id, err := c.XAdd(ctx, "events", "type", "click", "page", "home")
+	if err != nil {
+		t.Fatalf("XAdd failed: %v", err)
+	}
+	entries, err := c.XRange(ctx, "events", "-", "+", 0)
+	if err != nil || len(entries) != 1 || entries[0].ID != id || strings.Join(entries[0].Fields, " ") != "type click page home" {
+		t.Fatalf("Expected the added entry %s, got %v (%v)", id, entries, err)
+	}
+
+	if _, err := c.XReadGroup(ctx, "workers", "alice", "events", 10, 0); !errors.Is(err, client.ErrNoGroup) {
+		t.Errorf("Expected ErrNoGroup, got %v", err)
+	}
+	c.Do(ctx, "XGROUP CREATE events workers 0")
+	entries, err = c.XReadGroup(ctx, "workers", "alice", "events", 10, 10*time.Millisecond)
+	if err != nil || len(entries) != 1 || entries[0].ID != id {
+		t.Fatalf("Expected alice to read %s, got %v (%v)", id, entries, err)
+	}
+	if entries, err := c.XReadGroup(ctx, "workers", "alice", "events", 10, 10*time.Millisecond); err != nil || entries != nil {
+		t.Errorf("Expected no new entries, got %v (%v)", entries, err)
+	}
+	if n, err := c.XAck(ctx, "events", "workers", id); err != nil || n != 1 {
+		t.Errorf("Expected 1 acknowledged entry, got %d (%v)", n, err)
+	}
+}
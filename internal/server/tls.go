@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"CacheFlow/internal/tlsutil"
+)
+
+// handshakeTimeout bounds the TLS handshake of a new connection
+const handshakeTimeout = 10 * time.Second
+
+// setupTLS builds the listener and outgoing TLS configurations from cfg
+func (s *Server) setupTLS(cfg Config) error {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		return nil
+	}
+	files := tlsutil.Files{CertFile: cfg.TLSCertFile, KeyFile: cfg.TLSKeyFile, CAFile: cfg.TLSCAFile}
+	clientAuth, err := tlsutil.ParseClientAuth(cfg.TLSAuthClients)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig, err = tlsutil.ServerConfig(files, clientAuth); err != nil {
+		return err
+	}
+	if cfg.TLSReplication {
+		if s.peerTLS, err = tlsutil.ClientConfig(files); err != nil {
+			return err
+		}
+	}
+	s.tlsCertUsers = cfg.TLSCertUsers
+	return nil
+}
+
+// handshake completes the TLS handshake of a new connection and, with
+// TLSCertUsers, logs the session in as the user named by the client certificate
+func (s *Server) handshake(conn *tls.Conn, sess *session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	state := conn.ConnectionState()
+	if !s.tlsCertUsers || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	name := state.PeerCertificates[0].Subject.CommonName
+	if s.acl.Enabled(name) {
+		sess.user = name
+		s.connLog.Info("Client logged in by certificate", "client", conn.RemoteAddr(), "user", name)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"CacheFlow/internal/client"
+	"CacheFlow/internal/tlsutil"
+)
+
+// testCA is a self-signed CA issuing certificates for tests
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM file of the CA certificate
+}
+
+// newTestCA creates a CA and writes its certificate to a temporary directory
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CacheFlow test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	ca := &testCA{dir: t.TempDir(), cert: cert, key: key}
+	ca.file = filepath.Join(ca.dir, "ca.pem")
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate for name, valid for localhost and 127.0.0.1 as
+// both server and client, and returns the paths of its certificate and key
+func (ca *testCA) issue(t *testing.T, name string, serial int64) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+
+	certFile = filepath.Join(ca.dir, name+".pem")
+	keyFile = filepath.Join(ca.dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+// writePEM writes a single PEM block, replacing the file atomically as a rotation would
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// clientTLS returns a client configuration trusting ca and presenting the certificate of name, if any
+func clientTLS(t *testing.T, ca *testCA, name string) *tls.Config {
+	files := tlsutil.Files{CAFile: ca.file}
+	if name != "" {
+		files.CertFile, files.KeyFile = ca.issue(t, name, 100)
+	}
+	cfg, err := tlsutil.ClientConfig(files)
+	if err != nil {
+		t.Fatalf("Failed to create client TLS config: %v", err)
+	}
+	return cfg
+}
+
+func TestMutualTLS(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", 2)
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile = certFile, keyFile, ca.file
+		cfg.TLSCertUsers = true
+		cfg.RequirePass = "secret"
+	})
+	addr := srv.Addr().String()
+	srv.handleCommand(&session{user: "default"}, "ACL SETUSER alice on ~* +@all")
+
+	noRetry := client.Options{MaxRetries: -1, DialTimeout: time.Second}
+	if c, err := client.NewWithOptions(addr, noRetry); err == nil {
+		if err := c.Ping(ctx); err == nil {
+			t.Error("Expected a plain connection to a TLS server to fail")
+		}
+		c.Close()
+	}
+
+	// Without a client certificate the handshake fails
+	opts := noRetry
+	opts.TLSConfig = clientTLS(t, ca, "")
+	if c, err := client.NewWithOptions(addr, opts); err == nil {
+		if err := c.Ping(ctx); err == nil {
+			t.Error("Expected a client without a certificate to be rejected")
+		}
+		c.Close()
+	}
+
+	// A certificate naming an ACL user logs the connection in as that user
+	opts.TLSConfig = clientTLS(t, ca, "alice")
+	alice, err := client.NewWithOptions(addr, opts)
+	if err != nil {
+		t.Fatalf("Failed to connect with a client certificate: %v", err)
+	}
+	defer alice.Close()
+	if who, err := alice.Do(ctx, "ACL WHOAMI"); err != nil || who != "alice" {
+		t.Errorf("Expected to be logged in as alice, got %q (%v)", who, err)
+	}
+
+	// A certificate naming no user still requires AUTH
+	opts.TLSConfig = clientTLS(t, ca, "mallory")
+	mallory, err := client.NewWithOptions(addr, opts)
+	if err != nil {
+		t.Fatalf("Failed to connect with a client certificate: %v", err)
+	}
+	defer mallory.Close()
+	if _, err := mallory.Get(ctx, "key"); !errors.Is(err, client.ErrNoAuth) {
+		t.Errorf("Expected NOAUTH for a certificate without a user, got %v", err)
+	}
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", 2)
+	srv := startConfiguredNode(t, func(cfg *Config) {
+		cfg.TLSCertFile, cfg.TLSKeyFile = certFile, keyFile
+	})
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", srv.Addr().String(), &tls.Config{RootCAs: poolOf(ca.cert)})
+		if err != nil {
+			t.Fatalf("TLS dial failed: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("Expected certificate 2, got %d", got)
+	}
+
+	// Rotating the files is picked up by new connections without a restart
+	ca.issue(t, "server", 3)
+	deadline := time.Now().Add(5 * time.Second)
+	for serial() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the rotated certificate")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestReplicationOverTLS(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCA(t)
+	primaryCert, primaryKey := ca.issue(t, "primary", 2)
+	replicaCert, replicaKey := ca.issue(t, "replica", 3)
+
+	primary := startConfiguredNode(t, func(cfg *Config) {
+		cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile = primaryCert, primaryKey, ca.file
+	})
+	replica := startConfiguredNode(t, func(cfg *Config) {
+		cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile = replicaCert, replicaKey, ca.file
+		cfg.TLSReplication = true
+		cfg.ReplicaOf = primary.Addr().String()
+	})
+
+	c, err := client.NewWithOptions(primary.Addr().String(), client.Options{TLSConfig: clientTLS(t, ca, "app")})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.Set(ctx, "key", "value", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if value, ok := replica.store.Get("key"); ok && value == "value" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the replica to sync over TLS")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// poolOf returns a pool holding a single certificate
+func poolOf(cert *x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
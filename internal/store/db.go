@@ -0,0 +1,288 @@
+package store
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// DB is one of the numbered databases of a Store. Its writes are recorded
+// tagged with its number, so they are replayed into the same database.
+type DB struct {
+	store *Store
+	index int
+}
+
+// Index returns the number of the database
+func (d *DB) Index() int {
+	return d.index
+}
+
+// Set adds a value to the database and records the command if AOF is
+// enabled. A key of a namespace without a TTL gets the namespace's default
+// TTL, and keys of the namespace are evicted if needed to stay within its
+// quota; if they cannot be, Set returns ErrQuotaExceeded.
+func (d *DB) Set(key string, value any, ttl time.Duration) error {
+	return d.set(key, value, ttl, true, true)
+}
+
+// Restore adds a value moved from another node with exactly the given TTL,
+// zero meaning none. Unlike Set it does not apply the namespace's default
+// TTL, though it still keeps the namespace within its quota.
+func (d *DB) Restore(key string, value any, ttl time.Duration) error {
+	return d.set(key, value, ttl, true, false)
+}
+
+// set adds a value, keeping its namespace within its quota if enforce is set
+// and giving it the namespace's default TTL if defaultTTL is set
+func (d *DB) set(key string, value any, ttl time.Duration, enforce, defaultTTL bool) error {
+	s := d.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ns := s.namespaceOf(key); ns != nil && enforce {
+		if ttl == 0 && defaultTTL {
+			ttl = ns.DefaultTTL
+		}
+		if ns.MaxMemory > 0 {
+			if err := s.makeRoom(ns, d.index, key, itemSize(key, value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Create expiration time if TTL is provided
+	var expiration *time.Time
+	if ttl > 0 {
+		exp := time.Now().Add(ttl)
+		expiration = &exp
+	}
+
+	// Store the item
+	items := s.dbs[d.index]
+	if old, exists := items[key]; exists {
+		s.account(d.index, key, old, -1)
+	}
+	item := Item{
+		Value:      value,
+		Expiration: expiration,
+	}
+	items[key] = item
+	s.account(d.index, key, item, 1)
+
+	// Record the command
+	parts := []string{"SET", key, fmt.Sprintf("%v", value)}
+	if ttl > 0 {
+		parts = append(parts, ttl.String())
+	}
+	s.recordCommand(d.index, parts)
+	return nil
+}
+
+// CheckQuota returns ErrQuotaExceeded if setting key to value would take its
+// namespace past its quota, without evicting anything to make room
+func (d *DB) CheckQuota(key string, value any) error {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ns := s.namespaceOf(key)
+	if ns == nil || ns.MaxMemory == 0 {
+		return nil
+	}
+	size := itemSize(key, value)
+	if old, exists := s.dbs[d.index][key]; exists {
+		size -= itemSize(key, old.Value)
+	}
+	if ns.memory()+size > ns.MaxMemory {
+		return fmt.Errorf("%w: namespace %s holds %d of %d bytes", ErrQuotaExceeded, ns.Name, ns.memory(), ns.MaxMemory)
+	}
+	return nil
+}
+
+// Delete removes a value from the database and records the command if AOF is enabled
+func (d *DB) Delete(key string) {
+	s := d.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, exists := s.dbs[d.index][key]; exists {
+		s.account(d.index, key, old, -1)
+		delete(s.dbs[d.index], key)
+	}
+	s.recordCommand(d.index, []string{"DELETE", key})
+}
+
+// Get retrieves a value from the database
+func (d *DB) Get(key string) (interface{}, bool) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.dbs[d.index][key]
+	if !exists {
+		s.misses.Add(1)
+		return nil, false
+	}
+
+	// Check if item has expired
+	if item.Expiration != nil && time.Now().After(*item.Expiration) {
+		s.misses.Add(1)
+		return nil, false
+	}
+
+	s.hits.Add(1)
+	return item.Value, true
+}
+
+// Exists checks if a key exists in the database
+func (d *DB) Exists(key string) bool {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.dbs[d.index][key]
+	if !exists {
+		return false
+	}
+
+	// Check if item has expired
+	if item.Expiration != nil && time.Now().After(*item.Expiration) {
+		return false
+	}
+
+	return true
+}
+
+// Dump returns a key's value together with its remaining time to live, which
+// is zero for keys without expiration
+func (d *DB) Dump(key string) (any, time.Duration, bool) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.dbs[d.index][key]
+	if !exists {
+		return nil, 0, false
+	}
+
+	var ttl time.Duration
+	if item.Expiration != nil {
+		ttl = time.Until(*item.Expiration)
+		if ttl <= 0 {
+			return nil, 0, false
+		}
+	}
+	return item.Value, ttl, true
+}
+
+// Keys returns up to limit live keys for which match returns true. A limit of
+// zero or less returns all matching keys.
+func (d *DB) Keys(match func(key string) bool, limit int) []string {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var keys []string
+	for key, item := range s.dbs[d.index] {
+		if item.Expiration != nil && now.After(*item.Expiration) {
+			continue
+		}
+		if match != nil && !match(key) {
+			continue
+		}
+		keys = append(keys, key)
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+	return keys
+}
+
+// Flush removes every key of the database. The memory of the keys is
+// returned to the operating system before Flush returns, or in the
+// background if async is set.
+func (d *DB) Flush(async bool) {
+	s := d.store
+	s.mu.Lock()
+	old := s.dbs[d.index]
+	s.dbs[d.index] = make(map[string]Item)
+	for _, ns := range s.namespaces {
+		ns.usage[d.index] = namespaceUsage{}
+	}
+	s.recordCommand(d.index, flushCommand("FLUSHDB", async))
+	s.mu.Unlock()
+
+	s.free(async, old)
+}
+
+// FlushAll removes every key of every database, freeing their memory like Flush
+func (s *Store) FlushAll(async bool) {
+	s.mu.Lock()
+	old := s.dbs
+	s.dbs = make([]map[string]Item, len(old))
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]Item)
+	}
+	for _, ns := range s.namespaces {
+		clear(ns.usage)
+	}
+	s.recordCommand(0, flushCommand("FLUSHALL", async))
+	s.mu.Unlock()
+
+	s.free(async, old...)
+}
+
+// flushCommand returns the recorded form of a flush, which keeps the mode
+// so replicas free memory the same way
+func flushCommand(name string, async bool) []string {
+	if async {
+		return []string{name, "ASYNC"}
+	}
+	return []string{name}
+}
+
+// free returns the memory of flushed databases to the operating system,
+// in a background goroutine if async is set
+func (s *Store) free(async bool, dbs ...map[string]Item) {
+	var keys int64
+	for _, items := range dbs {
+		keys += int64(len(items))
+	}
+	if keys == 0 {
+		return
+	}
+	if !async {
+		debug.FreeOSMemory()
+		return
+	}
+
+	s.lazyFree.Add(keys)
+	go func() {
+		for _, items := range dbs {
+			clear(items)
+		}
+		debug.FreeOSMemory()
+		s.lazyFree.Add(-keys)
+	}()
+}
+
+// SwapDB exchanges the contents of databases a and b, so that clients of
+// either see the keys of the other at once
+func (s *Store) SwapDB(a, b int) error {
+	if a < 0 || a >= len(s.dbs) || b < 0 || b >= len(s.dbs) {
+		return fmt.Errorf("invalid database index, expected 0 to %d", len(s.dbs)-1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dbs[a], s.dbs[b] = s.dbs[b], s.dbs[a]
+	for _, ns := range s.namespaces {
+		ns.usage[a], ns.usage[b] = ns.usage[b], ns.usage[a]
+	}
+	s.recordCommand(0, []string{"SWAPDB", strconv.Itoa(a), strconv.Itoa(b)})
+	return nil
+}
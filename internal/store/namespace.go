@@ -0,0 +1,394 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrQuotaExceeded is returned by writes that would take a namespace past its
+// memory quota when its eviction policy cannot make room
+var ErrQuotaExceeded = errors.New("namespace memory quota exceeded")
+
+// EvictionPolicy selects the keys a namespace evicts to stay within its quota
+type EvictionPolicy string
+
+const (
+	// NoEviction refuses writes that would exceed the quota
+	NoEviction EvictionPolicy = "noeviction"
+	// AllKeysRandom evicts random keys of the namespace
+	AllKeysRandom EvictionPolicy = "allkeys-random"
+	// VolatileRandom evicts random keys of the namespace that have a TTL
+	VolatileRandom EvictionPolicy = "volatile-random"
+	// VolatileTTL evicts the keys of the namespace closest to expiring
+	VolatileTTL EvictionPolicy = "volatile-ttl"
+)
+
+// evictionSamples is how many keys VolatileTTL compares to pick one to evict
+const evictionSamples = 16
+
+// Namespace is a named group of keys sharing a prefix, with its own memory
+// quota, default TTL, eviction policy and users. It spans every database.
+type Namespace struct {
+	Name string
+	// Prefix selects the keys of the namespace; defaults to Name followed by
+	// a colon. A key belongs to the namespace with the longest matching prefix.
+	Prefix string
+	// MaxMemory is the quota in bytes of keys and values; zero is unlimited
+	MaxMemory int64
+	// DefaultTTL is given to keys written without a TTL; zero keeps them forever
+	DefaultTTL time.Duration
+	// Policy is how room is made when the quota is reached; defaults to NoEviction
+	Policy EvictionPolicy
+	// Users are the only users allowed to access the keys; empty allows every user
+	Users []string
+}
+
+// NamespaceInfo describes a namespace and the keys it holds
+type NamespaceInfo struct {
+	Namespace
+	// Keys is the number of keys, including expired ones not yet removed
+	Keys int
+	// Memory is the bytes counted against MaxMemory
+	Memory int64
+	// EvictedKeys counts keys removed to stay within MaxMemory
+	EvictedKeys int64
+}
+
+// namespace is a Namespace together with what it holds
+type namespace struct {
+	Namespace
+	usage   []namespaceUsage // indexed by database
+	evicted int64
+}
+
+// namespaceUsage is what a namespace holds in one database
+type namespaceUsage struct {
+	keys  int
+	bytes int64
+}
+
+// memory returns the bytes the namespace holds in every database
+func (ns *namespace) memory() int64 {
+	var total int64
+	for _, u := range ns.usage {
+		total += u.bytes
+	}
+	return total
+}
+
+// info describes the namespace
+func (ns *namespace) info() NamespaceInfo {
+	info := NamespaceInfo{Namespace: ns.Namespace, EvictedKeys: ns.evicted}
+	info.Users = slices.Clone(ns.Users)
+	for _, u := range ns.usage {
+		info.Keys += u.keys
+		info.Memory += u.bytes
+	}
+	return info
+}
+
+// Allows reports whether user may access the keys of the namespace
+func (ns Namespace) Allows(user string) bool {
+	return len(ns.Users) == 0 || slices.Contains(ns.Users, user)
+}
+
+// Args returns the namespace in the form ParseNamespace reads
+func (ns Namespace) Args() []string {
+	args := []string{ns.Name, "PREFIX", ns.Prefix,
+		"MAXMEMORY", strconv.FormatInt(ns.MaxMemory, 10),
+		"TTL", ns.DefaultTTL.String(),
+		"POLICY", string(ns.Policy)}
+	if len(ns.Users) > 0 {
+		args = append(args, "USERS", strings.Join(ns.Users, ","))
+	}
+	return args
+}
+
+// ParseNamespace parses "name [PREFIX prefix] [MAXMEMORY bytes] [TTL duration]
+// [POLICY policy] [USERS user,...]". MAXMEMORY takes a kb, mb or gb suffix.
+func ParseNamespace(args []string) (Namespace, error) {
+	if len(args) == 0 {
+		return Namespace{}, fmt.Errorf("namespace name required")
+	}
+	ns := Namespace{Name: args[0]}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return Namespace{}, fmt.Errorf("%s requires a value", args[i])
+		}
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "PREFIX":
+			ns.Prefix = value
+		case "MAXMEMORY":
+			bytes, err := parseBytes(value)
+			if err != nil {
+				return Namespace{}, err
+			}
+			ns.MaxMemory = bytes
+		case "TTL":
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < 0 {
+				return Namespace{}, fmt.Errorf("invalid TTL %q", value)
+			}
+			ns.DefaultTTL = ttl
+		case "POLICY":
+			ns.Policy = EvictionPolicy(strings.ToLower(value))
+		case "USERS":
+			ns.Users = strings.Split(value, ",")
+		default:
+			return Namespace{}, fmt.Errorf("unknown namespace option %s", args[i])
+		}
+	}
+	return ns.withDefaults()
+}
+
+// withDefaults fills in the zero fields of ns and validates it
+func (ns Namespace) withDefaults() (Namespace, error) {
+	if ns.Name == "" {
+		return Namespace{}, fmt.Errorf("namespace name required")
+	}
+	if ns.Prefix == "" {
+		ns.Prefix = ns.Name + ":"
+	}
+	if ns.Policy == "" {
+		ns.Policy = NoEviction
+	}
+	switch ns.Policy {
+	case NoEviction, AllKeysRandom, VolatileRandom, VolatileTTL:
+	default:
+		return Namespace{}, fmt.Errorf("unknown eviction policy %q, expected noeviction, allkeys-random, volatile-random or volatile-ttl", ns.Policy)
+	}
+	if ns.MaxMemory < 0 {
+		return Namespace{}, fmt.Errorf("invalid MAXMEMORY %d", ns.MaxMemory)
+	}
+	if slices.Contains(ns.Users, "") {
+		return Namespace{}, fmt.Errorf("invalid empty user name")
+	}
+	return ns, nil
+}
+
+// parseBytes parses a size in bytes with an optional kb, mb or gb suffix
+func parseBytes(s string) (int64, error) {
+	lower := strings.ToLower(s)
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"kb": 1 << 10, "mb": 1 << 20, "gb": 1 << 30} {
+		if trimmed, ok := strings.CutSuffix(lower, suffix); ok {
+			lower, multiplier = trimmed, m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// SetNamespace adds a namespace, or replaces the one with the same name.
+// Keys already stored are counted against it at once, but are not evicted
+// until a write needs room.
+func (s *Store) SetNamespace(ns Namespace) error {
+	ns, err := ns.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var evicted int64
+	namespaces := make([]*namespace, 0, len(s.namespaces)+1)
+	for _, other := range s.namespaces {
+		if other.Name == ns.Name {
+			evicted = other.evicted
+			continue
+		}
+		if other.Prefix == ns.Prefix {
+			return fmt.Errorf("prefix %q is already used by namespace %s", ns.Prefix, other.Name)
+		}
+		namespaces = append(namespaces, other)
+	}
+	namespaces = append(namespaces, &namespace{Namespace: ns, evicted: evicted})
+	s.setNamespaces(namespaces)
+	s.recordCommand(0, append([]string{"NAMESPACE", "SET"}, ns.Args()...))
+	return nil
+}
+
+// DeleteNamespace removes a namespace, leaving its keys in place, and
+// reports whether it existed
+func (s *Store) DeleteNamespace(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	namespaces := make([]*namespace, 0, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		if ns.Name != name {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == len(s.namespaces) {
+		return false
+	}
+	s.setNamespaces(namespaces)
+	s.recordCommand(0, []string{"NAMESPACE", "DEL", name})
+	return true
+}
+
+// Namespaces describes every namespace, ordered by name
+func (s *Store) Namespaces() []NamespaceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]NamespaceInfo, 0, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		infos = append(infos, ns.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// NamespaceOf returns the namespace a key belongs to, if any
+func (s *Store) NamespaceOf(key string) (Namespace, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if ns := s.namespaceOf(key); ns != nil {
+		return ns.Namespace, true
+	}
+	return Namespace{}, false
+}
+
+// CheckAccess returns an error if the namespace of key does not allow user
+func (s *Store) CheckAccess(user, key string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if ns := s.namespaceOf(key); ns != nil && !ns.Allows(user) {
+		return fmt.Errorf("user %s has no permissions to access namespace %s", user, ns.Name)
+	}
+	return nil
+}
+
+// setNamespaces installs namespaces, longest prefix first, and counts the
+// stored keys against them. The caller must hold s.mu.
+func (s *Store) setNamespaces(namespaces []*namespace) {
+	sort.Slice(namespaces, func(i, j int) bool {
+		return len(namespaces[i].Prefix) > len(namespaces[j].Prefix)
+	})
+	s.namespaces = namespaces
+	s.recountNamespaces()
+}
+
+// recountNamespaces recomputes what every namespace holds. The caller must hold s.mu.
+func (s *Store) recountNamespaces() {
+	for _, ns := range s.namespaces {
+		ns.usage = make([]namespaceUsage, len(s.dbs))
+	}
+	if len(s.namespaces) == 0 {
+		return
+	}
+	for db, items := range s.dbs {
+		for key, item := range items {
+			s.account(db, key, item, 1)
+		}
+	}
+}
+
+// namespaceOf returns the namespace of key, or nil. The caller must hold s.mu.
+func (s *Store) namespaceOf(key string) *namespace {
+	for _, ns := range s.namespaces {
+		if strings.HasPrefix(key, ns.Prefix) {
+			return ns
+		}
+	}
+	return nil
+}
+
+// account adds (sign 1) or removes (sign -1) an item from the usage of its
+// namespace. The caller must hold s.mu for writing.
+func (s *Store) account(db int, key string, item Item, sign int) {
+	ns := s.namespaceOf(key)
+	if ns == nil {
+		return
+	}
+	ns.usage[db].keys += sign
+	ns.usage[db].bytes += int64(sign) * itemSize(key, item.Value)
+}
+
+// itemSize estimates the bytes held by a key and its value
+func itemSize(key string, value any) int64 {
+	switch v := value.(type) {
+	case string:
+		return int64(len(key) + len(v))
+	case *Stream:
+		return int64(len(key)) + v.size
+	}
+	return int64(len(key) + len(fmt.Sprintf("%v", value)))
+}
+
+// makeRoom evicts keys of ns until key, with a value of size bytes, fits in
+// its quota in database db. The caller must hold s.mu for writing.
+func (s *Store) makeRoom(ns *namespace, db int, key string, size int64) error {
+	if size > ns.MaxMemory {
+		return fmt.Errorf("%w: %s needs %d bytes of the %d of namespace %s", ErrQuotaExceeded, key, size, ns.MaxMemory, ns.Name)
+	}
+	if old, exists := s.dbs[db][key]; exists {
+		size -= itemSize(key, old.Value)
+	}
+	for ns.memory()+size > ns.MaxMemory {
+		victimDB, victim, ok := s.evictionCandidate(ns, db, key)
+		if !ok {
+			return fmt.Errorf("%w: namespace %s holds %d of %d bytes", ErrQuotaExceeded, ns.Name, ns.memory(), ns.MaxMemory)
+		}
+		s.account(victimDB, victim, s.dbs[victimDB][victim], -1)
+		delete(s.dbs[victimDB], victim)
+		ns.evicted++
+		s.evicted.Add(1)
+		// Evictions are recorded so replicas and the AOF drop the same keys
+		s.recordCommand(victimDB, []string{"DELETE", victim})
+	}
+	return nil
+}
+
+// evictionCandidate picks a key of ns to evict under its policy, other than
+// key in database skipDB. The caller must hold s.mu.
+func (s *Store) evictionCandidate(ns *namespace, skipDB int, skip string) (int, string, bool) {
+	if ns.Policy == NoEviction {
+		return 0, "", false
+	}
+	var (
+		bestDB  int
+		best    string
+		bestExp time.Time
+		found   int
+	)
+	for db, items := range s.dbs {
+		if ns.usage[db].keys == 0 {
+			continue
+		}
+		// Map iteration order is random, so the first match is a random key
+		for key, item := range items {
+			if (db == skipDB && key == skip) || s.namespaceOf(key) != ns {
+				continue
+			}
+			if ns.Policy != AllKeysRandom && item.Expiration == nil {
+				continue
+			}
+			if ns.Policy != VolatileTTL {
+				return db, key, true
+			}
+			if found == 0 || item.Expiration.Before(bestExp) {
+				bestDB, best, bestExp = db, key, *item.Expiration
+			}
+			if found++; found >= evictionSamples {
+				return bestDB, best, true
+			}
+		}
+	}
+	return bestDB, best, found > 0
+}
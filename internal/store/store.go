@@ -2,89 +2,148 @@ package store
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"CacheFlow/internal/persistence"
 )
 
+// DefaultDatabases is the number of databases of a store created by New
+const DefaultDatabases = 16
+
 // Item represents a cache item with value and optional expiration time
 type Item struct {
 	Value      interface{}
 	Expiration *time.Time
 }
 
-// Store represents our key-value store with persistence support
+// Store represents our key-value store with persistence support. It holds a
+// fixed number of numbered databases, each its own keyspace; the methods of
+// Store itself operate on database 0.
 type Store struct {
-	mu    sync.RWMutex
-	items map[string]Item
-	aof   *persistence.AOF
+	mu        sync.RWMutex
+	dbs       []map[string]Item
+	views     []*DB
+	aof       *persistence.AOF
+	listeners []func(command string)
+
+	// namespaces are ordered longest prefix first, so the first match of a
+	// key is its namespace
+	namespaces []*namespace
+
+	// waiters are the readers blocked on streams, by database and key
+	waitMu  sync.Mutex
+	waiters map[streamKey][]chan struct{}
+
+	hits     atomic.Int64
+	misses   atomic.Int64
+	expired  atomic.Int64
+	evicted  atomic.Int64
+	lazyFree atomic.Int64 // keys of asynchronous flushes not yet freed
+}
+
+// Config holds the settings a Store is created with
+type Config struct {
+	// AOFFilename is the append-only file used for persistence; empty disables it
+	AOFFilename string
+	// Keys encrypts the AOF file; nil writes it in plaintext
+	Keys *persistence.Keyring
+	// Databases is the number of databases; zero uses DefaultDatabases
+	Databases int
+}
+
+// Stats describes the dataset and how it has been read
+type Stats struct {
+	// Keys is the number of keys, including expired ones not yet removed
+	Keys int
+	// Expires is the number of keys with a TTL
+	Expires int
+	// DataSize estimates the bytes held by keys and values
+	DataSize int64
+	// Hits and Misses count Get calls that found a key and that did not
+	Hits   int64
+	Misses int64
+	// ExpiredKeys counts keys removed because their TTL passed
+	ExpiredKeys int64
+	// EvictedKeys counts keys removed to keep namespaces within their quotas
+	EvictedKeys int64
+	// LazyFreePending counts the keys of asynchronous flushes still being freed
+	LazyFreePending int64
+	// Keyspace holds the counts of every database, indexed by number
+	Keyspace []KeyspaceStats
+}
+
+// KeyspaceStats describes the keys of one database
+type KeyspaceStats struct {
+	Keys    int
+	Expires int
 }
 
 // New creates a new Store instance and initializes AOF persistence
 func New(aofFilename string) (*Store, error) {
-	store := &Store{
-		items: make(map[string]Item),
+	return NewWithConfig(Config{AOFFilename: aofFilename})
+}
+
+// NewWithKeyring creates a new Store instance whose AOF file is encrypted
+// with keys. A file written with another key of the keyring, or in
+// plaintext, is rewritten with the current key once it is loaded.
+func NewWithKeyring(aofFilename string, keys *persistence.Keyring) (*Store, error) {
+	return NewWithConfig(Config{AOFFilename: aofFilename, Keys: keys})
+}
+
+// NewWithConfig creates a new Store instance from a Config
+func NewWithConfig(cfg Config) (*Store, error) {
+	if cfg.Databases < 0 {
+		return nil, fmt.Errorf("invalid number of databases: %d", cfg.Databases)
+	}
+	if cfg.Databases == 0 {
+		cfg.Databases = DefaultDatabases
 	}
+	store := newStore(cfg.Databases)
 
 	// Initialize AOF if filename is provided
-	if aofFilename != "" {
-		aof, err := persistence.New(aofFilename)
+	if cfg.AOFFilename != "" {
+		aof, err := persistence.NewWithKeyring(cfg.AOFFilename, cfg.Keys)
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize AOF: %w", err)
 		}
 		store.aof = aof
 
 		// Load data from AOF file
-		err = aof.Load(aofFilename, func(command string) error {
-			parts := strings.Fields(command)
-			if len(parts) == 0 {
-				return nil
-			}
-
-			cmd := strings.ToUpper(parts[0])
-			switch cmd {
-			case "SET":
-				if len(parts) < 3 {
-					return fmt.Errorf("invalid SET command: %s", command)
-				}
-				key := parts[1]
-				var value string
-				var ttl time.Duration
-				if len(parts) > 3 {
-					parsedTTL, err := time.ParseDuration(parts[len(parts)-1])
-					if err == nil {
-						ttl = parsedTTL
-						value = strings.Join(parts[2:len(parts)-1], " ")
-					} else {
-						value = strings.Join(parts[2:], " ")
-					}
-				} else {
-					value = strings.Join(parts[2:], " ")
-				}
-				store.Set(key, value, ttl)
-			case "DELETE":
-				if len(parts) != 2 {
-					return fmt.Errorf("invalid DELETE command: %s", command)
-				}
-				store.Delete(parts[1])
-			default:
-				return fmt.Errorf("unknown command: %s", cmd)
-			}
-			return nil
-		})
-
+		err = aof.Load(cfg.AOFFilename, store.Apply)
 		if err != nil {
 			aof.Close()
 			return nil, fmt.Errorf("failed to load data from AOF: %w", err)
 		}
+
+		if aof.NeedsRewrite() {
+			if err := aof.Rewrite(store.Snapshot(nil)); err != nil {
+				aof.Close()
+				return nil, fmt.Errorf("failed to re-encrypt AOF: %w", err)
+			}
+		}
 	}
 
 	return store, nil
 }
 
+// newStore creates an empty store with n databases and no AOF
+func newStore(n int) *Store {
+	store := &Store{
+		dbs:   make([]map[string]Item, n),
+		views: make([]*DB, n),
+	}
+	for i := range store.dbs {
+		store.dbs[i] = make(map[string]Item)
+		store.views[i] = &DB{store: store, index: i}
+	}
+	return store
+}
+
 // Close properly closes the AOF file if it was opened
 func (s *Store) Close() error {
 	if s.aof != nil {
@@ -93,98 +152,325 @@ func (s *Store) Close() error {
 	return nil
 }
 
-// recordCommand formats and persists a command to the AOF file
-func (s *Store) recordCommand(parts []string) {
-	if s.aof == nil {
-		return
-	}
+// Databases returns the number of databases
+func (s *Store) Databases() int {
+	return len(s.dbs)
+}
+
+// DB returns database i, which must be less than Databases
+func (s *Store) DB(i int) *DB {
+	return s.views[i]
+}
 
-	commandString := strings.Join(parts, " ")
-	if err := s.aof.Write(commandString); err != nil {
-		log.Printf("Failed to record command to AOF: %v", err)
+// TagCommand returns command as recorded for database db: commands on
+// database 0 are unchanged, others are prefixed with "DB <db>"
+func TagCommand(db int, command string) string {
+	if db == 0 {
+		return command
 	}
+	return "DB " + strconv.Itoa(db) + " " + command
 }
 
-// Set adds a value to the store and records the command if AOF is enabled
-func (s *Store) Set(key string, value any, ttl time.Duration) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Apply parses a single write command in AOF format and executes it against the store
+func (s *Store) Apply(command string) error {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return nil
+	}
 
-	// Create expiration time if TTL is provided
-	var expiration *time.Time
-	if ttl > 0 {
-		exp := time.Now().Add(ttl)
-		expiration = &exp
+	db := s.views[0]
+	if strings.ToUpper(parts[0]) == "DB" {
+		if len(parts) < 3 {
+			return fmt.Errorf("invalid DB command: %s", command)
+		}
+		i, err := strconv.Atoi(parts[1])
+		if err != nil || i < 0 || i >= len(s.dbs) {
+			return fmt.Errorf("invalid database %s in command: %s", parts[1], command)
+		}
+		db = s.views[i]
+		parts = parts[2:]
 	}
 
-	// Store the item
-	s.items[key] = Item{
-		Value:      value,
-		Expiration: expiration,
+	cmd := strings.ToUpper(parts[0])
+	switch cmd {
+	case "SET":
+		if len(parts) < 3 {
+			return fmt.Errorf("invalid SET command: %s", command)
+		}
+		key := parts[1]
+		var value string
+		var ttl time.Duration
+		if len(parts) > 3 {
+			parsedTTL, err := time.ParseDuration(parts[len(parts)-1])
+			if err == nil {
+				ttl = parsedTTL
+				value = strings.Join(parts[2:len(parts)-1], " ")
+			} else {
+				value = strings.Join(parts[2:], " ")
+			}
+		} else {
+			value = strings.Join(parts[2:], " ")
+		}
+		// The quota was enforced when the command was recorded
+		if err := db.set(key, value, ttl, false, false); err != nil {
+			return err
+		}
+	case "DELETE":
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid DELETE command: %s", command)
+		}
+		db.Delete(parts[1])
+	case "FLUSHDB":
+		async, err := parseFlushMode(parts[1:])
+		if err != nil {
+			return fmt.Errorf("invalid FLUSHDB command: %s", command)
+		}
+		db.Flush(async)
+	case "FLUSHALL":
+		async, err := parseFlushMode(parts[1:])
+		if err != nil {
+			return fmt.Errorf("invalid FLUSHALL command: %s", command)
+		}
+		s.FlushAll(async)
+	case "NAMESPACE":
+		if len(parts) < 3 {
+			return fmt.Errorf("invalid NAMESPACE command: %s", command)
+		}
+		switch strings.ToUpper(parts[1]) {
+		case "SET":
+			ns, err := ParseNamespace(parts[2:])
+			if err != nil {
+				return fmt.Errorf("invalid NAMESPACE command: %s: %w", command, err)
+			}
+			if err := s.SetNamespace(ns); err != nil {
+				return err
+			}
+		case "DEL":
+			s.DeleteNamespace(parts[2])
+		default:
+			return fmt.Errorf("invalid NAMESPACE command: %s", command)
+		}
+	case "XADD", "XTRIM", "XSETID", "XGROUP", "XACK", "XCLAIM":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := s.applyStream(db.index, parts); err != nil {
+			return fmt.Errorf("invalid %s command: %s: %w", cmd, command, err)
+		}
+		s.recordCommand(db.index, parts)
+	case "SWAPDB":
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid SWAPDB command: %s", command)
+		}
+		a, errA := strconv.Atoi(parts[1])
+		b, errB := strconv.Atoi(parts[2])
+		if errA != nil || errB != nil {
+			return fmt.Errorf("invalid SWAPDB command: %s", command)
+		}
+		if err := s.SwapDB(a, b); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown command: %s", cmd)
 	}
+	return nil
+}
 
-	// Record the command
-	parts := []string{"SET", key, fmt.Sprintf("%v", value)}
-	if ttl > 0 {
-		parts = append(parts, ttl.String())
+// parseFlushMode parses the optional ASYNC or SYNC argument of a flush
+func parseFlushMode(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
 	}
-	s.recordCommand(parts)
+	if len(args) == 1 {
+		switch strings.ToUpper(args[0]) {
+		case "ASYNC":
+			return true, nil
+		case "SYNC":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("expected ASYNC or SYNC")
 }
 
-// Delete removes a value from the store and records the command if AOF is enabled
-func (s *Store) Delete(key string) {
+// OnCommand registers a function that is called with every write command
+// recorded by the store. Listeners run while the store is locked, so they
+// observe commands in exactly the order they were applied and must not
+// call back into the store.
+func (s *Store) OnCommand(fn func(command string)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	delete(s.items, key)
-	s.recordCommand([]string{"DELETE", key})
+	s.listeners = append(s.listeners, fn)
 }
 
-// Get retrieves a value from the store
-func (s *Store) Get(key string) (interface{}, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// recordCommand formats and persists a command on database db to the AOF
+// file and passes it to listeners
+func (s *Store) recordCommand(db int, parts []string) {
+	commandString := TagCommand(db, strings.Join(parts, " "))
 
-	item, exists := s.items[key]
-	if !exists {
-		return nil, false
+	if s.aof != nil {
+		if err := s.aof.Write(commandString); err != nil {
+			slog.Error("Failed to record command to AOF", "command", parts[0], "err", err)
+		}
 	}
 
-	// Check if item has expired
-	if item.Expiration != nil && time.Now().After(*item.Expiration) {
-		return nil, false
+	for _, fn := range s.listeners {
+		fn(commandString)
 	}
-
-	return item.Value, true
 }
 
-// Exists checks if a key exists in the store
-func (s *Store) Exists(key string) bool {
+// Snapshot returns the live dataset as a list of commands in AOF format: the
+// namespaces followed by a SET for every key, or the stream commands that
+// recreate a stream with its consumer groups. Keys with a TTL carry their
+// remaining time to live. If mark is not nil it is called while the store is
+// still locked, so anything it observes (such as a replication offset) is
+// consistent with the returned commands.
+func (s *Store) Snapshot(mark func()) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	item, exists := s.items[key]
-	if !exists {
-		return false
+	now := time.Now()
+	var commands []string
+	for _, ns := range s.namespaces {
+		commands = append(commands, strings.Join(append([]string{"NAMESPACE", "SET"}, ns.Args()...), " "))
+	}
+	for db, items := range s.dbs {
+		for key, item := range items {
+			if stream, ok := item.Value.(*Stream); ok {
+				for _, parts := range stream.commands(key) {
+					commands = append(commands, TagCommand(db, strings.Join(parts, " ")))
+				}
+				continue
+			}
+			parts := []string{"SET", key, fmt.Sprintf("%v", item.Value)}
+			if item.Expiration != nil {
+				remaining := item.Expiration.Sub(now)
+				if remaining <= 0 {
+					continue
+				}
+				parts = append(parts, remaining.String())
+			}
+			commands = append(commands, TagCommand(db, strings.Join(parts, " ")))
+		}
+	}
+
+	if mark != nil {
+		mark()
 	}
+	return commands
+}
 
-	// Check if item has expired
-	if item.Expiration != nil && time.Now().After(*item.Expiration) {
-		return false
+// Replace discards the current dataset and loads the given snapshot commands in
+// its place. The AOF file is rewritten to match the new dataset. Listeners are
+// not notified, since the snapshot is not part of the command stream.
+func (s *Store) Replace(commands []string) error {
+	fresh := newStore(len(s.dbs))
+	for _, command := range commands {
+		if err := fresh.Apply(command); err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
 	}
 
-	return true
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dbs = fresh.dbs
+	s.namespaces = fresh.namespaces
+	if s.aof != nil {
+		if err := s.aof.Rewrite(commands); err != nil {
+			return fmt.Errorf("failed to rewrite AOF: %w", err)
+		}
+	}
+	return nil
+}
+
+// Set is DB(0).Set
+func (s *Store) Set(key string, value any, ttl time.Duration) error {
+	return s.views[0].Set(key, value, ttl)
+}
+
+// Delete is DB(0).Delete
+func (s *Store) Delete(key string) {
+	s.views[0].Delete(key)
+}
+
+// Get is DB(0).Get
+func (s *Store) Get(key string) (interface{}, bool) {
+	return s.views[0].Get(key)
+}
+
+// Exists is DB(0).Exists
+func (s *Store) Exists(key string) bool {
+	return s.views[0].Exists(key)
 }
 
-// DeleteExpired removes all expired items from the store
+// Dump is DB(0).Dump
+func (s *Store) Dump(key string) (any, time.Duration, bool) {
+	return s.views[0].Dump(key)
+}
+
+// Keys is DB(0).Keys
+func (s *Store) Keys(match func(key string) bool, limit int) []string {
+	return s.views[0].Keys(match, limit)
+}
+
+// DeleteExpired removes all expired items from every database
 func (s *Store) DeleteExpired() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	now := time.Now()
-	for key, item := range s.items {
-		if item.Expiration != nil && now.After(*item.Expiration) {
-			delete(s.items, key)
+	for db, items := range s.dbs {
+		for key, item := range items {
+			if item.Expiration != nil && now.After(*item.Expiration) {
+				s.account(db, key, item, -1)
+				delete(items, key)
+				s.expired.Add(1)
+			}
+		}
+	}
+}
+
+// Stats returns statistics about the dataset. It walks every key, so it
+// takes time proportional to the size of the store.
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := Stats{
+		Hits:            s.hits.Load(),
+		Misses:          s.misses.Load(),
+		ExpiredKeys:     s.expired.Load(),
+		EvictedKeys:     s.evicted.Load(),
+		LazyFreePending: s.lazyFree.Load(),
+		Keyspace:        make([]KeyspaceStats, len(s.dbs)),
+	}
+	for db, items := range s.dbs {
+		keyspace := &stats.Keyspace[db]
+		keyspace.Keys = len(items)
+		for key, item := range items {
+			if item.Expiration != nil {
+				keyspace.Expires++
+			}
+			stats.DataSize += itemSize(key, item.Value)
 		}
+		stats.Keys += keyspace.Keys
+		stats.Expires += keyspace.Expires
+	}
+	return stats
+}
+
+// OnFsync registers a function called with the duration of every fsync of
+// the AOF file; it is never called without one
+func (s *Store) OnFsync(fn func(time.Duration)) {
+	if s.aof != nil {
+		s.aof.OnFsync(fn)
+	}
+}
+
+// AOFStats returns statistics about the AOF file; Enabled is false without one
+func (s *Store) AOFStats() persistence.Stats {
+	if s.aof == nil {
+		return persistence.Stats{}
 	}
+	return s.aof.Stats()
 }
@@ -2,8 +2,10 @@ package store
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -286,4 +288,270 @@ func TestDeleteExpired(t *testing.T) {
 	}
 }
 
+// TestDatabases tests that databases are separate keyspaces that survive a reload
+func TestDatabases(t *testing.T) {
+	s, aofFilename := createTestStore(t)
+
+	s.Set("shared", "zero", 0)
+	s.DB(1).Set("shared", "one", 0)
+	s.DB(2).Set("blue", "warm", time.Hour)
+	if v, _ := s.Get("shared"); v != "zero" {
+		t.Errorf("Expected db 0 to hold zero, got %v", v)
+	}
+	if v, _ := s.DB(1).Get("shared"); v != "one" {
+		t.Errorf("Expected db 1 to hold one, got %v", v)
+	}
+
+	if err := s.SwapDB(1, 2); err != nil {
+		t.Fatalf("SwapDB failed: %v", err)
+	}
+	if !s.DB(1).Exists("blue") || s.DB(2).Exists("blue") {
+		t.Errorf("Expected blue to move to db 1")
+	}
+	if err := s.SwapDB(0, s.Databases()); err == nil {
+		t.Errorf("Expected an error swapping a database out of range")
+	}
+
+	s.DB(2).Flush(false)
+	if s.DB(2).Exists("shared") {
+		t.Errorf("Expected FLUSHDB to empty db 2")
+	}
+	if !s.Exists("shared") {
+		t.Errorf("Expected FLUSHDB to leave db 0 alone")
+	}
+
+	// Replaying the AOF puts every key back in its database
+	s.Close()
+	reloaded, err := New(aofFilename)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	defer reloaded.Close()
+	if v, _ := reloaded.Get("shared"); v != "zero" {
+		t.Errorf("Expected db 0 to hold zero after reload, got %v", v)
+	}
+	if v, _ := reloaded.DB(1).Get("blue"); v != "warm" {
+		t.Errorf("Expected db 1 to hold blue after reload, got %v", v)
+	}
+	if reloaded.DB(2).Exists("shared") {
+		t.Errorf("Expected db 2 to stay empty after reload")
+	}
+	if stats := reloaded.Stats(); stats.Keys != 2 || stats.Keyspace[1].Keys != 1 || stats.Keyspace[1].Expires != 1 {
+		t.Errorf("Expected 2 keys with 1 expiring in db 1, got %+v", stats)
+	}
+
+	// Snapshots carry the databases too
+	copied := newStore(reloaded.Databases())
+	for _, command := range reloaded.Snapshot(nil) {
+		if err := copied.Apply(command); err != nil {
+			t.Fatalf("Failed to apply snapshot command %q: %v", command, err)
+		}
+	}
+	if !copied.DB(1).Exists("blue") || copied.Exists("blue") {
+		t.Errorf("Expected the snapshot to keep blue in db 1")
+	}
+
+	reloaded.FlushAll(true)
+	if reloaded.Exists("shared") || reloaded.DB(1).Exists("blue") {
+		t.Errorf("Expected FLUSHALL to empty every database")
+	}
+}
+
+// TestNamespaces tests quotas, eviction and default TTLs of namespaces
+func TestNamespaces(t *testing.T) {
+	s, aofFilename := createTestStore(t)
+
+	// Every key and value below takes 9 bytes
+	if err := s.SetNamespace(Namespace{Name: "a", MaxMemory: 27}); err != nil {
+		t.Fatalf("SetNamespace failed: %v", err)
+	}
+	if err := s.SetNamespace(Namespace{Name: "b", MaxMemory: 27, Policy: AllKeysRandom, DefaultTTL: time.Hour}); err != nil {
+		t.Fatalf("SetNamespace failed: %v", err)
+	}
+	if err := s.SetNamespace(Namespace{Name: "c", Prefix: "a:"}); err == nil {
+		t.Errorf("Expected an error reusing the prefix of another namespace")
+	}
+
+	for _, key := range []string{"a:1", "a:2", "a:3"} {
+		if err := s.Set(key, "value0", 0); err != nil {
+			t.Fatalf("Expected %s to fit, got %v", key, err)
+		}
+	}
+	if err := s.Set("a:4", "value0", 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded from a noeviction namespace, got %v", err)
+	}
+	if err := s.Set("a:1", "value1", 0); err != nil {
+		t.Errorf("Expected overwriting a key of the same size to fit, got %v", err)
+	}
+	if err := s.Set("other", strings.Repeat("x", 100), 0); err != nil {
+		t.Errorf("Expected keys outside namespaces to be unlimited, got %v", err)
+	}
+
+	for _, key := range []string{"b:1", "b:2", "b:3", "b:4"} {
+		if err := s.DB(1).Set(key, "value0", 0); err != nil {
+			t.Fatalf("Expected %s to evict another key, got %v", key, err)
+		}
+	}
+	infos := s.Namespaces()
+	if len(infos) != 2 || infos[1].Name != "b" || infos[1].Keys != 3 || infos[1].Memory != 27 || infos[1].EvictedKeys != 1 {
+		t.Errorf("Expected b to hold 3 keys after one eviction, got %+v", infos)
+	}
+	if infos[0].Keys != 3 || !s.Exists("a:2") {
+		t.Errorf("Expected the keys of a to be left alone, got %+v", infos[0])
+	}
+	if _, ttl, _ := s.DB(1).Dump("b:4"); ttl <= 0 {
+		t.Errorf("Expected b:4 to get the default TTL, got %v", ttl)
+	}
+	if stats := s.Stats(); stats.EvictedKeys != 1 {
+		t.Errorf("Expected 1 evicted key, got %d", stats.EvictedKeys)
+	}
+
+	// The namespaces and the evictions are replayed from the AOF
+	s.Close()
+	reloaded, err := New(aofFilename)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	defer reloaded.Close()
+	infos = reloaded.Namespaces()
+	if len(infos) != 2 || infos[0].Memory != 27 || infos[1].Keys != 3 || infos[1].DefaultTTL != time.Hour {
+		t.Errorf("Expected the namespaces to be reloaded, got %+v", infos)
+	}
+	if err := reloaded.Set("a:4", "value0", 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the quota to apply after a reload, got %v", err)
+	}
+
+	if !reloaded.DeleteNamespace("a") || reloaded.DeleteNamespace("a") {
+		t.Errorf("Expected DeleteNamespace to remove a once")
+	}
+	if err := reloaded.Set("a:4", "value0", 0); err != nil {
+		t.Errorf("Expected no quota once the namespace is deleted, got %v", err)
+	}
+}
+
+// TestParseNamespace tests parsing namespace definitions
+func TestParseNamespace(t *testing.T) {
+	ns, err := ParseNamespace(strings.Fields("team MAXMEMORY 2mb TTL 1m POLICY volatile-ttl USERS alice,bob"))
+	if err != nil {
+		t.Fatalf("ParseNamespace failed: %v", err)
+	}
+	if ns.Prefix != "team:" || ns.MaxMemory != 2<<20 || ns.DefaultTTL != time.Minute || ns.Policy != VolatileTTL {
+		t.Errorf("Unexpected namespace %+v", ns)
+	}
+	if !ns.Allows("bob") || ns.Allows("carol") {
+		t.Errorf("Expected only alice and bob to be allowed, got %v", ns.Users)
+	}
+	again, err := ParseNamespace(ns.Args())
+	if err != nil || strings.Join(again.Args(), " ") != strings.Join(ns.Args(), " ") {
+		t.Errorf("Expected Args to round-trip, got %v and %v", again.Args(), err)
+	}
+
+	for _, args := range []string{"", "team POLICY lru", "team MAXMEMORY lots", "team TTL", "team COLOR red"} {
+		if _, err := ParseNamespace(strings.Fields(args)); err == nil {
+			t.Errorf("Expected an error parsing %q", args)
+		}
+	}
+}
+
 // Add other test functions here later, e.g., TestTTL, TestDelete, TestExists, TestDeleteExpired
+
+// TestStreams tests stream entries and consumer groups, and that both survive
+// a reload from the AOF and a snapshot
+func TestStreams(t *testing.T) {
+	s, aofFilename := createTestStore(t)
+	db := s.DB(1)
+
+	for _, id := range []string{"1-1", "1-*", "*"} {
+		if _, _, err := db.XAdd("events", XAddArgs{ID: id, Fields: []string{"type", "click"}, MaxLen: -1}, nil); err != nil {
+			t.Fatalf("XAdd %s failed: %v", id, err)
+		}
+	}
+	if _, _, err := db.XAdd("events", XAddArgs{ID: "1-1", Fields: []string{"a", "b"}, MaxLen: -1}, nil); !errors.Is(err, ErrStreamID) {
+		t.Errorf("Expected ErrStreamID for an old ID, got %v", err)
+	}
+	db.Set("plain", "value", 0)
+	if _, _, err := db.XAdd("plain", XAddArgs{ID: "*", Fields: []string{"a", "b"}, MaxLen: -1}, nil); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	entries, _ := db.XRange("events", StreamID{}, maxStreamID, 0, false)
+	if len(entries) != 3 || entries[1].ID != (StreamID{1, 2}) {
+		t.Fatalf("Expected 3 entries with 1-2 second, got %v", entries)
+	}
+
+	if err := db.XGroupCreate("events", "workers", "0", false, nil); err != nil {
+		t.Fatalf("XGroupCreate failed: %v", err)
+	}
+	read := XReadGroupArgs{Group: "workers", Consumer: "alice", Keys: []string{"events"}, IDs: []string{">"}, Count: 2}
+	results, _, err := db.XReadGroup(read, false, nil)
+	if err != nil || len(results) != 1 || len(results[0].Entries) != 2 {
+		t.Fatalf("Expected 2 entries for alice, got %v, %v", results, err)
+	}
+	if n, _ := db.XAck("events", "workers", []StreamID{{1, 1}}, nil); n != 1 {
+		t.Errorf("Expected 1 acknowledged entry, got %d", n)
+	}
+	claimed, err := db.XClaim("events", Claim{Group: "workers", Consumer: "bob", IDs: []StreamID{{1, 2}}}, nil)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Expected bob to claim 1-2, got %v, %v", claimed, err)
+	}
+
+	check := func(name string, st *Store) {
+		t.Helper()
+		d := st.DB(1)
+		if n, _ := d.XLen("events"); n != 3 {
+			t.Errorf("%s: expected 3 entries, got %d", name, n)
+		}
+		summary, err := d.XPending("events", "workers")
+		if err != nil || summary.Count != 1 || summary.Consumers["bob"] != 1 {
+			t.Errorf("%s: expected 1 entry pending for bob, got %+v, %v", name, summary, err)
+		}
+		pending, _ := d.XPendingRange("events", "workers", PendingRange{End: maxStreamID})
+		if len(pending) != 1 || pending[0].Deliveries != 2 {
+			t.Errorf("%s: expected 1-2 delivered twice, got %+v", name, pending)
+		}
+		// The next read of the group starts after the entries already delivered
+		results, _, _ := d.XReadGroup(XReadGroupArgs{Group: "workers", Consumer: "carol", Keys: []string{"events"}, IDs: []string{">"}}, false, nil)
+		if len(results) != 1 || len(results[0].Entries) != 1 {
+			t.Errorf("%s: expected carol to get the third entry, got %v", name, results)
+		}
+	}
+
+	copied := newStore(s.Databases())
+	for _, command := range s.Snapshot(nil) {
+		if err := copied.Apply(command); err != nil {
+			t.Fatalf("Failed to apply snapshot command %q: %v", command, err)
+		}
+	}
+	check("snapshot", copied)
+
+	s.Close()
+	reloaded, err := New(aofFilename)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	defer reloaded.Close()
+	check("reload", reloaded)
+
+	if n, _ := reloaded.DB(1).XTrimMaxLen("events", 1, nil); n != 2 {
+		t.Errorf("Expected 2 trimmed entries, got %d", n)
+	}
+}
+
+// TestStreamWait tests that a read waiting on a stream is woken by an entry added to it
+func TestStreamWait(t *testing.T) {
+	s := newStore(1)
+	_, w, err := s.DB(0).XRead([]string{"events"}, []StreamID{{}}, 0, true)
+	if err != nil || w == nil {
+		t.Fatalf("Expected a wait on an empty stream, got %v", err)
+	}
+	defer w.Stop()
+
+	go s.DB(0).XAdd("events", XAddArgs{ID: "*", Fields: []string{"k", "v"}, MaxLen: -1}, nil)
+	select {
+	case <-w.C:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the wait to end when an entry was added")
+	}
+	if results, _, _ := s.DB(0).XRead([]string{"events"}, []StreamID{{}}, 0, false); len(results) != 1 {
+		t.Errorf("Expected the new entry, got %v", results)
+	}
+}
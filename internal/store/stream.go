@@ -0,0 +1,617 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrWrongType is returned by stream operations on a key holding another kind of value
+	ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+	// ErrNoStream is returned by operations that need an existing stream
+	ErrNoStream = errors.New("no such stream")
+	// ErrNoGroup is returned for a consumer group that does not exist
+	ErrNoGroup = errors.New("no such consumer group")
+	// ErrGroupExists is returned when creating a consumer group under a taken name
+	ErrGroupExists = errors.New("consumer group name already exists")
+	// ErrStreamID is returned for an entry ID that is not greater than the
+	// last ID of the stream
+	ErrStreamID = errors.New("the ID specified is equal or smaller than the last ID of the stream")
+	// ErrInvalidStreamID is returned for an ID that does not parse
+	ErrInvalidStreamID = errors.New("invalid stream ID")
+)
+
+// StreamID identifies a stream entry: the millisecond time it was added at
+// and a sequence number telling apart the entries of the same millisecond
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+// maxStreamID is greater than or equal to every ID
+var maxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+// ParseStreamID parses an ID written as "<ms>-<seq>", or "<ms>" for sequence 0
+func ParseStreamID(s string) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("%w %q", ErrInvalidStreamID, s)
+	}
+	var seq uint64
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, fmt.Errorf("%w %q", ErrInvalidStreamID, s)
+		}
+	}
+	return StreamID{ms, seq}, nil
+}
+
+// ParseStreamBound parses a bound of an ID range: an ID, "-" for the smallest
+// ID or "+" for the greatest. A millisecond time without a sequence number
+// stands for its first ID as a start and its last as an end.
+func ParseStreamBound(s string, end bool) (StreamID, error) {
+	switch s {
+	case "-":
+		return StreamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	id, err := ParseStreamID(s)
+	if err == nil && end && !strings.Contains(s, "-") {
+		id.Seq = math.MaxUint64
+	}
+	return id, err
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1, 0 or 1 as id is less than, equal to or greater than other
+func (id StreamID) Compare(other StreamID) int {
+	if c := cmp.Compare(id.Ms, other.Ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.Seq, other.Seq)
+}
+
+// next returns the smallest ID greater than id, which must not be maxStreamID
+func (id StreamID) next() StreamID {
+	if id.Seq == math.MaxUint64 {
+		return StreamID{id.Ms + 1, 0}
+	}
+	return StreamID{id.Ms, id.Seq + 1}
+}
+
+// StreamEntry is an entry of a stream: field-value pairs under an ID
+type StreamEntry struct {
+	ID StreamID
+	// Fields alternate between field names and values
+	Fields []string
+}
+
+// size estimates the bytes held by the entry
+func (e StreamEntry) size() int64 {
+	n := int64(16)
+	for _, field := range e.Fields {
+		n += int64(len(field))
+	}
+	return n
+}
+
+// PendingEntry is an entry delivered to a consumer of a group that the
+// consumer has not acknowledged yet
+type PendingEntry struct {
+	ID       StreamID
+	Consumer string
+	// Delivered is when the entry was last delivered
+	Delivered time.Time
+	// Deliveries is how many times the entry was delivered
+	Deliveries int
+}
+
+// Stream is an append-only log of entries with increasing IDs, together with
+// the consumer groups reading it. It is the value of a stream key; the store
+// changes it only through the commands of applyStream.
+type Stream struct {
+	entries []StreamEntry
+	lastID  StreamID
+	groups  map[string]*consumerGroup
+	size    int64 // bytes held by the entries
+}
+
+// consumerGroup is a named reader of a stream. Every entry is delivered to
+// one of its consumers, and stays pending until the consumer acknowledges it.
+type consumerGroup struct {
+	lastDelivered StreamID
+	pending       map[StreamID]*PendingEntry
+	consumers     map[string]bool
+}
+
+func newStream() *Stream {
+	return &Stream{groups: make(map[string]*consumerGroup)}
+}
+
+func newConsumerGroup(lastDelivered StreamID) *consumerGroup {
+	return &consumerGroup{
+		lastDelivered: lastDelivered,
+		pending:       make(map[StreamID]*PendingEntry),
+		consumers:     make(map[string]bool),
+	}
+}
+
+// search returns the index of the first entry with an ID of at least id
+func (st *Stream) search(id StreamID) int {
+	return sort.Search(len(st.entries), func(i int) bool { return st.entries[i].ID.Compare(id) >= 0 })
+}
+
+// entry returns the entry with an ID
+func (st *Stream) entry(id StreamID) (StreamEntry, bool) {
+	i := st.search(id)
+	if i == len(st.entries) || st.entries[i].ID != id {
+		return StreamEntry{}, false
+	}
+	return st.entries[i], true
+}
+
+// rangeEntries returns up to count entries with IDs from start to end, the
+// newest first if reverse is set. A count of zero or less returns them all.
+func (st *Stream) rangeEntries(start, end StreamID, count int, reverse bool) []StreamEntry {
+	if start.Compare(end) > 0 {
+		return nil
+	}
+	lo := st.search(start)
+	hi := sort.Search(len(st.entries), func(i int) bool { return st.entries[i].ID.Compare(end) > 0 })
+	entries := st.entries[lo:hi]
+	if count > 0 && len(entries) > count {
+		if reverse {
+			entries = entries[len(entries)-count:]
+		} else {
+			entries = entries[:count]
+		}
+	}
+	// Copied, since trimming clears the entries it removes
+	result := slices.Clone(entries)
+	if reverse {
+		slices.Reverse(result)
+	}
+	return result
+}
+
+// after returns up to count entries with IDs greater than id
+func (st *Stream) after(id StreamID, count int) []StreamEntry {
+	if id == maxStreamID {
+		return nil
+	}
+	return st.rangeEntries(id.next(), maxStreamID, count, false)
+}
+
+// resolveID returns the ID an entry added at now gets under spec: "*" for
+// the next ID at the current time, "<ms>-*" for the next sequence number of
+// a millisecond time, or an explicit ID greater than the last one
+func (st *Stream) resolveID(spec string, now time.Time) (StreamID, error) {
+	if spec == "*" {
+		return st.nextID(max(uint64(now.UnixMilli()), st.lastID.Ms))
+	}
+	if ms, ok := strings.CutSuffix(spec, "-*"); ok {
+		n, err := strconv.ParseUint(ms, 10, 64)
+		if err != nil {
+			return StreamID{}, fmt.Errorf("%w %q", ErrInvalidStreamID, spec)
+		}
+		return st.nextID(n)
+	}
+	id, err := ParseStreamID(spec)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if id.Compare(st.lastID) <= 0 {
+		return StreamID{}, ErrStreamID
+	}
+	return id, nil
+}
+
+// nextID returns the smallest ID with millisecond time ms greater than the last ID
+func (st *Stream) nextID(ms uint64) (StreamID, error) {
+	switch {
+	case ms < st.lastID.Ms:
+		return StreamID{}, ErrStreamID
+	case ms > st.lastID.Ms:
+		return StreamID{ms, 0}, nil
+	case st.lastID.Seq == math.MaxUint64:
+		return StreamID{}, ErrStreamID
+	}
+	return StreamID{ms, st.lastID.Seq + 1}, nil
+}
+
+// add appends an entry, whose ID must be greater than the last one
+func (st *Stream) add(entry StreamEntry) {
+	st.entries = append(st.entries, entry)
+	st.lastID = entry.ID
+	st.size += entry.size()
+}
+
+// trim removes the n oldest entries
+func (st *Stream) trim(n int) {
+	for _, entry := range st.entries[:n] {
+		st.size -= entry.size()
+	}
+	// Cleared so the removed fields can be freed before the array is reallocated
+	clear(st.entries[:n])
+	st.entries = st.entries[n:]
+}
+
+// excess returns how many entries trimming the stream to its newest maxLen removes
+func (st *Stream) excess(maxLen int) int {
+	return max(len(st.entries)-maxLen, 0)
+}
+
+// group returns a consumer group
+func (st *Stream) group(name string) (*consumerGroup, error) {
+	g, ok := st.groups[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrNoGroup, name)
+	}
+	return g, nil
+}
+
+// pendingIDs returns the IDs of the pending entries of a group from start
+// on in order, only those of consumer unless it is empty
+func (g *consumerGroup) pendingIDs(start StreamID, consumer string) []StreamID {
+	var ids []StreamID
+	for id, p := range g.pending {
+		if id.Compare(start) >= 0 && (consumer == "" || p.Consumer == consumer) {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, StreamID.Compare)
+	return ids
+}
+
+// Claim is a transfer of pending entries of a consumer group to a consumer,
+// as XCLAIM requests it
+type Claim struct {
+	Group, Consumer string
+	// MinIdle leaves out entries delivered more recently
+	MinIdle time.Duration
+	IDs     []StreamID
+	// Time is when the entries count as delivered; the zero time means now
+	Time time.Time
+	// RetryCount sets the delivery count of the entries if positive;
+	// otherwise the count is incremented, unless JustID is set
+	RetryCount int
+	// Force creates pending entries for IDs not pending, as long as they
+	// are not greater than the last ID of the stream
+	Force  bool
+	JustID bool
+}
+
+// ParseClaim parses the arguments of XCLAIM after the key:
+//
+//	group consumer min-idle-ms id [id ...] [IDLE ms] [TIME unix-ms] [RETRYCOUNT n] [FORCE] [JUSTID]
+func ParseClaim(args []string) (Claim, error) {
+	if len(args) < 4 {
+		return Claim{}, fmt.Errorf("expected group, consumer, min idle time and IDs")
+	}
+	c := Claim{Group: args[0], Consumer: args[1]}
+	minIdle, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || minIdle < 0 {
+		return Claim{}, fmt.Errorf("invalid min idle time %q", args[2])
+	}
+	c.MinIdle = time.Duration(minIdle) * time.Millisecond
+
+	rest := args[3:]
+	for len(rest) > 0 {
+		id, err := ParseStreamID(rest[0])
+		if err != nil {
+			break
+		}
+		c.IDs = append(c.IDs, id)
+		rest = rest[1:]
+	}
+	if len(c.IDs) == 0 {
+		return Claim{}, fmt.Errorf("expected at least one ID")
+	}
+	for len(rest) > 0 {
+		option := strings.ToUpper(rest[0])
+		switch option {
+		case "FORCE":
+			c.Force = true
+		case "JUSTID":
+			c.JustID = true
+		case "IDLE", "TIME", "RETRYCOUNT":
+			if len(rest) < 2 {
+				return Claim{}, fmt.Errorf("%s requires a value", option)
+			}
+			n, err := strconv.ParseInt(rest[1], 10, 64)
+			if err != nil || n < 0 {
+				return Claim{}, fmt.Errorf("invalid %s %q", option, rest[1])
+			}
+			switch option {
+			case "IDLE":
+				c.Time = time.Now().Add(-time.Duration(n) * time.Millisecond)
+			case "TIME":
+				c.Time = time.UnixMilli(n)
+			default:
+				c.RetryCount = int(n)
+			}
+			rest = rest[1:]
+		default:
+			return Claim{}, fmt.Errorf("unknown XCLAIM option %q", rest[0])
+		}
+		rest = rest[1:]
+	}
+	return c, nil
+}
+
+// args returns the claim as XCLAIM arguments after the key, with the time
+// written out so it is the same wherever the claim is replayed
+func (c Claim) args() []string {
+	args := []string{c.Group, c.Consumer, strconv.FormatInt(c.MinIdle.Milliseconds(), 10)}
+	for _, id := range c.IDs {
+		args = append(args, id.String())
+	}
+	args = append(args, "TIME", strconv.FormatInt(c.Time.UnixMilli(), 10))
+	if c.RetryCount > 0 {
+		args = append(args, "RETRYCOUNT", strconv.Itoa(c.RetryCount))
+	}
+	if c.Force {
+		args = append(args, "FORCE")
+	}
+	if c.JustID {
+		args = append(args, "JUSTID")
+	}
+	return args
+}
+
+// claimable returns the IDs a claim on group g transfers at now: pending
+// entries idle for at least MinIdle, and with Force, IDs not pending yet
+func (st *Stream) claimable(g *consumerGroup, c Claim, now time.Time) []StreamID {
+	var ids []StreamID
+	for _, id := range c.IDs {
+		if slices.Contains(ids, id) {
+			continue
+		}
+		if p, ok := g.pending[id]; ok {
+			if now.Sub(p.Delivered) >= c.MinIdle {
+				ids = append(ids, id)
+			}
+		} else if c.Force && id.Compare(st.lastID) <= 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// claim carries out a claim on group g at now
+func (st *Stream) claim(g *consumerGroup, c Claim, now time.Time) {
+	delivered := c.Time
+	if delivered.IsZero() {
+		delivered = now
+	}
+	for _, id := range st.claimable(g, c, now) {
+		p, ok := g.pending[id]
+		if !ok {
+			p = &PendingEntry{ID: id}
+			g.pending[id] = p
+		}
+		p.Consumer = c.Consumer
+		p.Delivered = delivered
+		switch {
+		case c.RetryCount > 0:
+			p.Deliveries = c.RetryCount
+		case !c.JustID:
+			p.Deliveries++
+		}
+	}
+	g.consumers[c.Consumer] = true
+}
+
+// applyTo executes a stream command in AOF format on the stream; args are
+// the words after the key. It checks every argument before changing anything.
+func (st *Stream) applyTo(cmd string, args []string) error {
+	switch cmd {
+	case "XADD":
+		maxLen := -1
+		if len(args) >= 2 && strings.ToUpper(args[0]) == "MAXLEN" {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid MAXLEN %q", args[1])
+			}
+			maxLen, args = n, args[2:]
+		}
+		if len(args) < 3 || len(args)%2 == 0 {
+			return fmt.Errorf("expected an ID and field-value pairs")
+		}
+		id, err := ParseStreamID(args[0])
+		if err != nil {
+			return err
+		}
+		if id.Compare(st.lastID) <= 0 {
+			return ErrStreamID
+		}
+		st.add(StreamEntry{ID: id, Fields: slices.Clone(args[1:])})
+		if maxLen >= 0 {
+			st.trim(st.excess(maxLen))
+		}
+
+	case "XTRIM":
+		if len(args) != 2 {
+			return fmt.Errorf("expected MAXLEN or MINID and a threshold")
+		}
+		switch strings.ToUpper(args[0]) {
+		case "MAXLEN":
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid MAXLEN %q", args[1])
+			}
+			st.trim(st.excess(n))
+		case "MINID":
+			id, err := ParseStreamID(args[1])
+			if err != nil {
+				return err
+			}
+			st.trim(st.search(id))
+		default:
+			return fmt.Errorf("unknown trim strategy %q", args[0])
+		}
+
+	case "XSETID":
+		if len(args) != 1 {
+			return fmt.Errorf("expected an ID")
+		}
+		id, err := ParseStreamID(args[0])
+		if err != nil {
+			return err
+		}
+		if len(st.entries) > 0 && id.Compare(st.entries[len(st.entries)-1].ID) < 0 {
+			return ErrStreamID
+		}
+		st.lastID = id
+
+	case "XACK":
+		if len(args) < 2 {
+			return fmt.Errorf("expected a group and IDs")
+		}
+		g, err := st.group(args[0])
+		if err != nil {
+			return err
+		}
+		ids, err := parseStreamIDs(args[1:])
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			delete(g.pending, id)
+		}
+
+	case "XCLAIM":
+		c, err := ParseClaim(args)
+		if err != nil {
+			return err
+		}
+		g, err := st.group(c.Group)
+		if err != nil {
+			return err
+		}
+		st.claim(g, c, time.Now())
+
+	default:
+		return fmt.Errorf("unknown stream command %s", cmd)
+	}
+	return nil
+}
+
+// applyGroup executes XGROUP subcommand sub in AOF format on the stream;
+// args are the words after the key
+func (st *Stream) applyGroup(sub string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expected a group")
+	}
+	name := args[0]
+	switch sub {
+	case "CREATE", "SETID":
+		if len(args) < 2 {
+			return fmt.Errorf("expected a group and an ID")
+		}
+		id, err := ParseStreamID(args[1])
+		if err != nil {
+			return err
+		}
+		if sub == "SETID" {
+			g, err := st.group(name)
+			if err != nil {
+				return err
+			}
+			g.lastDelivered = id
+			return nil
+		}
+		if _, exists := st.groups[name]; exists {
+			return ErrGroupExists
+		}
+		st.groups[name] = newConsumerGroup(id)
+
+	case "DESTROY":
+		delete(st.groups, name)
+
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 2 {
+			return fmt.Errorf("expected a group and a consumer")
+		}
+		g, err := st.group(name)
+		if err != nil {
+			return err
+		}
+		if sub == "CREATECONSUMER" {
+			g.consumers[args[1]] = true
+			return nil
+		}
+		for id, p := range g.pending {
+			if p.Consumer == args[1] {
+				delete(g.pending, id)
+			}
+		}
+		delete(g.consumers, args[1])
+
+	default:
+		return fmt.Errorf("unknown XGROUP subcommand %q", sub)
+	}
+	return nil
+}
+
+// parseStreamIDs parses a list of IDs
+func parseStreamIDs(args []string) ([]StreamID, error) {
+	ids := make([]StreamID, len(args))
+	for i, arg := range args {
+		id, err := ParseStreamID(arg)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// commands returns the stream at key as commands in AOF format that
+// recreate it: its entries, its last ID if it is past them, and its consumer
+// groups with their consumers and pending entries
+func (st *Stream) commands(key string) [][]string {
+	var commands [][]string
+	for _, entry := range st.entries {
+		commands = append(commands, append([]string{"XADD", key, entry.ID.String()}, entry.Fields...))
+	}
+	if len(st.entries) == 0 || st.entries[len(st.entries)-1].ID != st.lastID {
+		commands = append(commands, []string{"XSETID", key, st.lastID.String()})
+	}
+
+	names := make([]string, 0, len(st.groups))
+	for name := range st.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g := st.groups[name]
+		commands = append(commands, []string{"XGROUP", "CREATE", key, name, g.lastDelivered.String()})
+		consumers := make([]string, 0, len(g.consumers))
+		for consumer := range g.consumers {
+			consumers = append(consumers, consumer)
+		}
+		sort.Strings(consumers)
+		for _, consumer := range consumers {
+			commands = append(commands, []string{"XGROUP", "CREATECONSUMER", key, name, consumer})
+		}
+		for _, id := range g.pendingIDs(StreamID{}, "") {
+			p := g.pending[id]
+			c := Claim{Group: name, Consumer: p.Consumer, IDs: []StreamID{id}, Time: p.Delivered,
+				RetryCount: p.Deliveries, Force: true, JustID: true}
+			commands = append(commands, append([]string{"XCLAIM", key}, c.args()...))
+		}
+	}
+	return commands
+}
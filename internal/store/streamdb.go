@@ -0,0 +1,772 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commit carries out the commands, in AOF format and tagged with their
+// database, that a stream write resolved to. Stream writes are resolved
+// against the dataset into commands that give the same result wherever they
+// are replayed, and only those commands change the dataset. A nil Commit
+// applies and records them at once, atomically with resolving them; in Raft
+// mode they go through the log instead.
+type Commit func(commands []string) error
+
+// StreamResult holds the entries a read returned from one stream
+type StreamResult struct {
+	Key     string
+	Entries []StreamEntry
+}
+
+// PendingSummary describes the pending entries of a consumer group
+type PendingSummary struct {
+	Count int
+	// Lowest and Highest are the smallest and greatest pending IDs
+	Lowest, Highest StreamID
+	// Consumers holds the number of pending entries of every consumer with any
+	Consumers map[string]int
+}
+
+// XAddArgs are the arguments of XAdd
+type XAddArgs struct {
+	// ID is "*" for the next ID at the current time, "<ms>-*" for the next
+	// sequence number of a millisecond time, or an explicit ID greater than
+	// the last ID of the stream
+	ID string
+	// Fields alternate between field names and values
+	Fields []string
+	// MaxLen trims the stream to its newest MaxLen entries unless it is negative
+	MaxLen int
+	// NoMkStream keeps XAdd from creating a missing stream
+	NoMkStream bool
+}
+
+// XReadGroupArgs are the arguments of XReadGroup
+type XReadGroupArgs struct {
+	Group, Consumer string
+	Keys            []string
+	// IDs hold, for every key, ">" for entries never delivered to the group,
+	// or an ID to read the pending entries of the consumer after it again
+	IDs []string
+	// Count limits the entries read from each stream if positive
+	Count int
+	// NoAck delivers the entries without adding them to the pending entries
+	NoAck bool
+}
+
+// AutoClaim is a claim of the pending entries of a consumer group idle for
+// long enough, as XAUTOCLAIM requests it
+type AutoClaim struct {
+	Group, Consumer string
+	MinIdle         time.Duration
+	// Start is the smallest pending ID considered
+	Start StreamID
+	// Count limits the entries claimed
+	Count  int
+	JustID bool
+}
+
+// PendingRange selects the pending entries XPendingRange returns
+type PendingRange struct {
+	Start, End StreamID
+	Count      int
+	// Consumer selects the entries of one consumer unless it is empty
+	Consumer string
+	// MinIdle leaves out entries delivered more recently
+	MinIdle time.Duration
+}
+
+// streamKey identifies a stream readers wait on
+type streamKey struct {
+	db  int
+	key string
+}
+
+// StreamWait is returned by reads that found no entries; C receives a value
+// once entries are added to one of the streams read
+type StreamWait struct {
+	C <-chan struct{}
+
+	store *Store
+	db    int
+	keys  []string
+	ch    chan struct{}
+}
+
+// Stop stops waiting; it must be called once the wait is no longer needed
+func (w *StreamWait) Stop() {
+	s := w.store
+	s.waitMu.Lock()
+	defer s.waitMu.Unlock()
+
+	for _, key := range w.keys {
+		k := streamKey{w.db, key}
+		s.waiters[k] = slices.DeleteFunc(s.waiters[k], func(ch chan struct{}) bool { return ch == w.ch })
+		if len(s.waiters[k]) == 0 {
+			delete(s.waiters, k)
+		}
+	}
+}
+
+// wait registers a wait for entries added to keys. The caller must hold
+// s.mu, so no entry is added between its read and the registration.
+func (s *Store) wait(db int, keys []string) *StreamWait {
+	s.waitMu.Lock()
+	defer s.waitMu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if s.waiters == nil {
+		s.waiters = make(map[streamKey][]chan struct{})
+	}
+	for _, key := range keys {
+		k := streamKey{db, key}
+		s.waiters[k] = append(s.waiters[k], ch)
+	}
+	return &StreamWait{C: ch, store: s, db: db, keys: keys, ch: ch}
+}
+
+// wake notifies the readers waiting on a stream
+func (s *Store) wake(db int, key string) {
+	s.waitMu.Lock()
+	defer s.waitMu.Unlock()
+
+	k := streamKey{db, key}
+	for _, ch := range s.waiters[k] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	delete(s.waiters, k)
+}
+
+// stream returns the stream at key in database db, or nil if there is none.
+// The caller must hold s.mu.
+func (s *Store) stream(db int, key string) (*Stream, error) {
+	item, exists := s.dbs[db][key]
+	if !exists || (item.Expiration != nil && time.Now().After(*item.Expiration)) {
+		return nil, nil
+	}
+	stream, ok := item.Value.(*Stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return stream, nil
+}
+
+// applyStream executes a stream command in AOF format on database db,
+// creating the stream if the command adds to it, and wakes the readers
+// waiting on it. The caller must hold s.mu for writing.
+func (s *Store) applyStream(db int, parts []string) error {
+	cmd := strings.ToUpper(parts[0])
+	keyIndex, sub := 1, ""
+	if cmd == "XGROUP" {
+		if len(parts) < 2 {
+			return fmt.Errorf("expected an XGROUP subcommand")
+		}
+		keyIndex, sub = 2, strings.ToUpper(parts[1])
+	}
+	if len(parts) <= keyIndex+1 {
+		return fmt.Errorf("expected a key and arguments")
+	}
+	key, args := parts[keyIndex], parts[keyIndex+1:]
+
+	stream, err := s.stream(db, key)
+	if err != nil {
+		return err
+	}
+	items := s.dbs[db]
+	created := false
+	if stream == nil {
+		if cmd != "XADD" && cmd != "XSETID" && sub != "CREATE" {
+			return fmt.Errorf("%w %s", ErrNoStream, key)
+		}
+		// An expired key of any kind gives way to the new stream
+		if old, exists := items[key]; exists {
+			s.account(db, key, old, -1)
+			delete(items, key)
+		}
+		stream, created = newStream(), true
+	} else {
+		s.account(db, key, items[key], -1)
+	}
+
+	if sub != "" {
+		err = stream.applyGroup(sub, args)
+	} else {
+		err = stream.applyTo(cmd, args)
+	}
+	if err != nil && created {
+		return err
+	}
+	items[key] = Item{Value: stream}
+	s.account(db, key, items[key], 1)
+	if err != nil {
+		return err
+	}
+	if cmd == "XADD" {
+		s.wake(db, key)
+	}
+	return nil
+}
+
+// streamWrite resolves a stream write with plan, which returns the commands
+// carrying it out, and has commit carry them out
+func (d *DB) streamWrite(commit Commit, plan func() ([][]string, error)) error {
+	s := d.store
+	if commit != nil {
+		s.mu.RLock()
+		commands, err := plan()
+		s.mu.RUnlock()
+		if err != nil || len(commands) == 0 {
+			return err
+		}
+		lines := make([]string, len(commands))
+		for i, parts := range commands {
+			lines[i] = TagCommand(d.index, strings.Join(parts, " "))
+		}
+		return commit(lines)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commands, err := plan()
+	if err != nil {
+		return err
+	}
+	for _, parts := range commands {
+		if err := s.applyStream(d.index, parts); err != nil {
+			return err
+		}
+		s.recordCommand(d.index, parts)
+	}
+	return nil
+}
+
+// groupOf returns a consumer group of the stream at key. The caller must hold s.mu.
+func (d *DB) groupOf(key, group string) (*Stream, *consumerGroup, error) {
+	stream, err := d.store.stream(d.index, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream == nil {
+		return nil, nil, fmt.Errorf("%w %s: no such stream %s", ErrNoGroup, group, key)
+	}
+	g, err := stream.group(group)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stream, g, nil
+}
+
+// XAdd appends an entry to the stream at key, creating the stream if needed,
+// and returns the ID of the entry. It reports false if the stream does not
+// exist and NoMkStream is set. The entry counts against the quota of the
+// namespace of the key, but other keys are not evicted to make room for it.
+func (d *DB) XAdd(key string, args XAddArgs, commit Commit) (StreamID, bool, error) {
+	if len(args.Fields) == 0 || len(args.Fields)%2 != 0 {
+		return StreamID{}, false, fmt.Errorf("expected field-value pairs")
+	}
+	var id StreamID
+	added := false
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		s := d.store
+		stream, err := s.stream(d.index, key)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			if args.NoMkStream {
+				return nil, nil
+			}
+			stream = newStream()
+		}
+		if id, err = stream.resolveID(args.ID, time.Now()); err != nil {
+			return nil, err
+		}
+		if ns := s.namespaceOf(key); ns != nil && ns.MaxMemory > 0 {
+			size := StreamEntry{ID: id, Fields: args.Fields}.size()
+			if ns.memory()+size > ns.MaxMemory {
+				return nil, fmt.Errorf("%w: namespace %s holds %d of %d bytes", ErrQuotaExceeded, ns.Name, ns.memory(), ns.MaxMemory)
+			}
+		}
+		added = true
+		parts := []string{"XADD", key}
+		if args.MaxLen >= 0 {
+			parts = append(parts, "MAXLEN", strconv.Itoa(args.MaxLen))
+		}
+		return [][]string{append(append(parts, id.String()), args.Fields...)}, nil
+	})
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	return id, added, nil
+}
+
+// XTrimMaxLen removes the oldest entries of the stream at key beyond its
+// newest maxLen and returns how many it removed
+func (d *DB) XTrimMaxLen(key string, maxLen int, commit Commit) (int, error) {
+	return d.xtrim(key, commit, []string{"MAXLEN", strconv.Itoa(maxLen)}, func(stream *Stream) int {
+		return stream.excess(maxLen)
+	})
+}
+
+// XTrimMinID removes the entries of the stream at key with IDs less than
+// minID and returns how many it removed
+func (d *DB) XTrimMinID(key string, minID StreamID, commit Commit) (int, error) {
+	return d.xtrim(key, commit, []string{"MINID", minID.String()}, func(stream *Stream) int {
+		return stream.search(minID)
+	})
+}
+
+// xtrim trims the stream at key with the XTRIM arguments strategy, where
+// removed returns how many entries they remove
+func (d *DB) xtrim(key string, commit Commit, strategy []string, removed func(*Stream) int) (int, error) {
+	n := 0
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		stream, err := d.store.stream(d.index, key)
+		if err != nil || stream == nil {
+			return nil, err
+		}
+		if n = removed(stream); n == 0 {
+			return nil, nil
+		}
+		return [][]string{append([]string{"XTRIM", key}, strategy...)}, nil
+	})
+	return n, err
+}
+
+// XSetID sets the last ID of the stream at key, which must not be less than
+// the ID of its last entry
+func (d *DB) XSetID(key string, id StreamID, commit Commit) error {
+	return d.streamWrite(commit, func() ([][]string, error) {
+		stream, err := d.store.stream(d.index, key)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			return nil, fmt.Errorf("%w %s", ErrNoStream, key)
+		}
+		if len(stream.entries) > 0 && id.Compare(stream.entries[len(stream.entries)-1].ID) < 0 {
+			return nil, ErrStreamID
+		}
+		return [][]string{{"XSETID", key, id.String()}}, nil
+	})
+}
+
+// XLen returns the number of entries of the stream at key
+func (d *DB) XLen(key string) (int, error) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stream, err := s.stream(d.index, key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return len(stream.entries), nil
+}
+
+// XRange returns up to count entries of the stream at key with IDs from
+// start to end, the newest first if reverse is set. A count of zero or less
+// returns them all.
+func (d *DB) XRange(key string, start, end StreamID, count int, reverse bool) ([]StreamEntry, error) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stream, err := s.stream(d.index, key)
+	if err != nil || stream == nil {
+		return nil, err
+	}
+	return stream.rangeEntries(start, end, count, reverse), nil
+}
+
+// LastStreamID returns the last ID of the stream at key, which is 0-0 for a
+// missing stream
+func (d *DB) LastStreamID(key string) (StreamID, error) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stream, err := s.stream(d.index, key)
+	if err != nil || stream == nil {
+		return StreamID{}, err
+	}
+	return stream.lastID, nil
+}
+
+// XRead returns up to count entries, all of them if count is zero or less, of
+// every stream in keys with IDs greater than the matching ID of after.
+// Streams without any are left out. If none has any and wait is set, it also
+// returns a StreamWait for entries added to them.
+func (d *DB) XRead(keys []string, after []StreamID, count int, wait bool) ([]StreamResult, *StreamWait, error) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []StreamResult
+	for i, key := range keys {
+		stream, err := s.stream(d.index, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stream == nil {
+			continue
+		}
+		if entries := stream.after(after[i], count); len(entries) > 0 {
+			results = append(results, StreamResult{Key: key, Entries: entries})
+		}
+	}
+	if len(results) == 0 && wait {
+		return nil, s.wait(d.index, keys), nil
+	}
+	return results, nil, nil
+}
+
+// XGroupCreate creates a consumer group of the stream at key that delivers
+// the entries after id, where "$" stands for the last ID of the stream. With
+// mkStream a missing stream is created empty.
+func (d *DB) XGroupCreate(key, group, id string, mkStream bool, commit Commit) error {
+	return d.streamWrite(commit, func() ([][]string, error) {
+		stream, err := d.store.stream(d.index, key)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			if !mkStream {
+				return nil, fmt.Errorf("%w %s, create it with MKSTREAM", ErrNoStream, key)
+			}
+			stream = newStream()
+		}
+		if _, exists := stream.groups[group]; exists {
+			return nil, ErrGroupExists
+		}
+		lastDelivered, err := groupID(stream, id)
+		if err != nil {
+			return nil, err
+		}
+		return [][]string{{"XGROUP", "CREATE", key, group, lastDelivered.String()}}, nil
+	})
+}
+
+// groupID parses the last delivered ID of a consumer group, where "$" stands
+// for the last ID of the stream
+func groupID(stream *Stream, id string) (StreamID, error) {
+	if id == "$" {
+		return stream.lastID, nil
+	}
+	return ParseStreamID(id)
+}
+
+// XGroupSetID sets the ID a consumer group delivers the entries after, where
+// "$" stands for the last ID of the stream
+func (d *DB) XGroupSetID(key, group, id string, commit Commit) error {
+	return d.streamWrite(commit, func() ([][]string, error) {
+		stream, _, err := d.groupOf(key, group)
+		if err != nil {
+			return nil, err
+		}
+		lastDelivered, err := groupID(stream, id)
+		if err != nil {
+			return nil, err
+		}
+		return [][]string{{"XGROUP", "SETID", key, group, lastDelivered.String()}}, nil
+	})
+}
+
+// XGroupDestroy removes a consumer group with its pending entries. It
+// reports false if there is no such group.
+func (d *DB) XGroupDestroy(key, group string, commit Commit) (bool, error) {
+	destroyed := false
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		stream, err := d.store.stream(d.index, key)
+		if err != nil || stream == nil {
+			return nil, err
+		}
+		if _, destroyed = stream.groups[group]; !destroyed {
+			return nil, nil
+		}
+		return [][]string{{"XGROUP", "DESTROY", key, group}}, nil
+	})
+	return destroyed, err
+}
+
+// XGroupCreateConsumer adds a consumer to a consumer group. It reports
+// false if the group already has it.
+func (d *DB) XGroupCreateConsumer(key, group, consumer string, commit Commit) (bool, error) {
+	created := false
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		_, g, err := d.groupOf(key, group)
+		if err != nil {
+			return nil, err
+		}
+		if created = !g.consumers[consumer]; !created {
+			return nil, nil
+		}
+		return [][]string{{"XGROUP", "CREATECONSUMER", key, group, consumer}}, nil
+	})
+	return created, err
+}
+
+// XGroupDelConsumer removes a consumer from a consumer group, dropping its
+// pending entries, and returns how many it had
+func (d *DB) XGroupDelConsumer(key, group, consumer string, commit Commit) (int, error) {
+	pending := 0
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		_, g, err := d.groupOf(key, group)
+		if err != nil {
+			return nil, err
+		}
+		if !g.consumers[consumer] {
+			return nil, nil
+		}
+		for _, p := range g.pending {
+			if p.Consumer == consumer {
+				pending++
+			}
+		}
+		return [][]string{{"XGROUP", "DELCONSUMER", key, group, consumer}}, nil
+	})
+	return pending, err
+}
+
+// XReadGroup reads entries of streams as a consumer of a group. New entries
+// are delivered to the consumer and, unless NoAck is set, stay pending until
+// it acknowledges them. Streams without entries to read are left out. If
+// none has any, only new entries were asked for and wait is set, it also
+// returns a StreamWait for entries added to the streams.
+func (d *DB) XReadGroup(args XReadGroupArgs, wait bool, commit Commit) ([]StreamResult, *StreamWait, error) {
+	var results []StreamResult
+	var w *StreamWait
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		results = nil
+		now := time.Now()
+		var commands [][]string
+		onlyNew := true
+		for i, key := range args.Keys {
+			stream, g, err := d.groupOf(key, args.Group)
+			if err != nil {
+				return nil, err
+			}
+			if args.IDs[i] != ">" {
+				onlyNew = false
+				after, err := ParseStreamID(args.IDs[i])
+				if err != nil {
+					return nil, err
+				}
+				if entries := stream.history(g, args.Consumer, after, args.Count); len(entries) > 0 {
+					results = append(results, StreamResult{Key: key, Entries: entries})
+				}
+				continue
+			}
+
+			entries := stream.after(g.lastDelivered, args.Count)
+			if len(entries) == 0 || args.NoAck {
+				if !g.consumers[args.Consumer] {
+					commands = append(commands, []string{"XGROUP", "CREATECONSUMER", key, args.Group, args.Consumer})
+				}
+				if len(entries) == 0 {
+					continue
+				}
+			} else {
+				c := Claim{Group: args.Group, Consumer: args.Consumer, Time: now, RetryCount: 1, Force: true, JustID: true}
+				for _, entry := range entries {
+					c.IDs = append(c.IDs, entry.ID)
+				}
+				commands = append(commands, append([]string{"XCLAIM", key}, c.args()...))
+			}
+			last := entries[len(entries)-1].ID
+			commands = append(commands, []string{"XGROUP", "SETID", key, args.Group, last.String()})
+			results = append(results, StreamResult{Key: key, Entries: entries})
+		}
+		if len(results) == 0 && onlyNew && wait {
+			w = d.store.wait(d.index, args.Keys)
+		}
+		return commands, nil
+	})
+	if err != nil {
+		if w != nil {
+			w.Stop()
+		}
+		return nil, nil, err
+	}
+	return results, w, nil
+}
+
+// history returns up to count pending entries of a consumer of group g with
+// IDs greater than after. Entries since removed from the stream have no fields.
+func (st *Stream) history(g *consumerGroup, consumer string, after StreamID, count int) []StreamEntry {
+	if after == maxStreamID {
+		return nil
+	}
+	ids := g.pendingIDs(after.next(), consumer)
+	if count > 0 && len(ids) > count {
+		ids = ids[:count]
+	}
+	entries := make([]StreamEntry, len(ids))
+	for i, id := range ids {
+		entry, ok := st.entry(id)
+		if !ok {
+			entry = StreamEntry{ID: id}
+		}
+		entries[i] = entry
+	}
+	return entries
+}
+
+// XAck acknowledges pending entries of a consumer group and returns how many
+// were pending. A missing stream or group has none.
+func (d *DB) XAck(key, group string, ids []StreamID, commit Commit) (int, error) {
+	acked := 0
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		stream, err := d.store.stream(d.index, key)
+		if err != nil || stream == nil {
+			return nil, err
+		}
+		g, ok := stream.groups[group]
+		if !ok {
+			return nil, nil
+		}
+		parts := []string{"XACK", key, group}
+		for _, id := range ids {
+			if _, pending := g.pending[id]; pending && !slices.Contains(parts[3:], id.String()) {
+				parts = append(parts, id.String())
+			}
+		}
+		if acked = len(parts) - 3; acked == 0 {
+			return nil, nil
+		}
+		return [][]string{parts}, nil
+	})
+	return acked, err
+}
+
+// XClaim transfers pending entries of a consumer group to a consumer and
+// returns them; with JustID they have no fields, otherwise entries since
+// removed from the stream are left out
+func (d *DB) XClaim(key string, c Claim, commit Commit) ([]StreamEntry, error) {
+	var claimed []StreamEntry
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		claimed = nil
+		stream, g, err := d.groupOf(key, c.Group)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		resolved := c
+		if resolved.Time.IsZero() {
+			resolved.Time = now
+		}
+		// Recorded without the idle time, which has been checked, so
+		// replaying it later claims the same entries
+		resolved.MinIdle = 0
+		if resolved.IDs = stream.claimable(g, c, now); len(resolved.IDs) == 0 {
+			return nil, nil
+		}
+		for _, id := range resolved.IDs {
+			entry, ok := stream.entry(id)
+			switch {
+			case c.JustID:
+				claimed = append(claimed, StreamEntry{ID: id})
+			case ok:
+				claimed = append(claimed, entry)
+			}
+		}
+		return [][]string{append([]string{"XCLAIM", key}, resolved.args()...)}, nil
+	})
+	return claimed, err
+}
+
+// XAutoClaim claims the pending entries of a consumer group from Start on
+// that have been idle for at least MinIdle, up to Count of them. Pending
+// entries since removed from the stream are acknowledged instead. It returns
+// the ID to continue from, or 0-0 once every pending entry was considered,
+// and the claimed entries, without fields with JustID.
+func (d *DB) XAutoClaim(key string, a AutoClaim, commit Commit) (StreamID, []StreamEntry, error) {
+	var next StreamID
+	var claimed []StreamEntry
+	err := d.streamWrite(commit, func() ([][]string, error) {
+		next, claimed = StreamID{}, nil
+		stream, g, err := d.groupOf(key, a.Group)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		c := Claim{Group: a.Group, Consumer: a.Consumer, Time: now, JustID: a.JustID}
+		var deleted []string
+		for _, id := range g.pendingIDs(a.Start, "") {
+			if a.Count > 0 && len(c.IDs) == a.Count {
+				next = id
+				break
+			}
+			entry, ok := stream.entry(id)
+			switch {
+			case !ok:
+				deleted = append(deleted, id.String())
+			case now.Sub(g.pending[id].Delivered) >= a.MinIdle:
+				c.IDs = append(c.IDs, id)
+				if a.JustID {
+					entry = StreamEntry{ID: id}
+				}
+				claimed = append(claimed, entry)
+			}
+		}
+		var commands [][]string
+		if len(c.IDs) > 0 {
+			commands = append(commands, append([]string{"XCLAIM", key}, c.args()...))
+		}
+		if len(deleted) > 0 {
+			commands = append(commands, append([]string{"XACK", key, a.Group}, deleted...))
+		}
+		return commands, nil
+	})
+	return next, claimed, err
+}
+
+// XPending summarizes the pending entries of a consumer group
+func (d *DB) XPending(key, group string) (PendingSummary, error) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, g, err := d.groupOf(key, group)
+	if err != nil {
+		return PendingSummary{}, err
+	}
+	summary := PendingSummary{Count: len(g.pending), Consumers: make(map[string]int)}
+	for _, p := range g.pending {
+		summary.Consumers[p.Consumer]++
+	}
+	if ids := g.pendingIDs(StreamID{}, ""); len(ids) > 0 {
+		summary.Lowest, summary.Highest = ids[0], ids[len(ids)-1]
+	}
+	return summary, nil
+}
+
+// XPendingRange returns the pending entries of a consumer group selected by r, in order
+func (d *DB) XPendingRange(key, group string, r PendingRange) ([]PendingEntry, error) {
+	s := d.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, g, err := d.groupOf(key, group)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var entries []PendingEntry
+	for _, id := range g.pendingIDs(r.Start, r.Consumer) {
+		if id.Compare(r.End) > 0 || (r.Count > 0 && len(entries) == r.Count) {
+			break
+		}
+		if p := g.pending[id]; now.Sub(p.Delivered) >= r.MinIdle {
+			entries = append(entries, *p)
+		}
+	}
+	return entries, nil
+}
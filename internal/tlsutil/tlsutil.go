@@ -0,0 +1,213 @@
+// Package tlsutil builds the TLS configurations of servers and clients from
+// PEM files. Certificates, and the CAs a server verifies clients against, are
+// reloaded when their files change, so they can be rotated without a restart.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadInterval is the minimum time between two checks of the files for changes
+const reloadInterval = time.Second
+
+// Files names the PEM files of a TLS configuration
+type Files struct {
+	// CertFile and KeyFile are the certificate chain presented to the peer and its private key
+	CertFile string
+	KeyFile  string
+	// CAFile holds the CAs trusted to sign the peer's certificate. Servers
+	// without one don't ask for client certificates; clients without one use
+	// the system pool.
+	CAFile string
+}
+
+// ParseClientAuth parses how a server treats client certificates: "yes"
+// requires a valid one, "optional" verifies one if presented, "no" ignores them
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(s) {
+	case "", "yes":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "no":
+		return tls.NoClientCert, nil
+	}
+	return 0, fmt.Errorf("invalid client authentication %q, expected yes, optional or no", s)
+}
+
+// ServerConfig returns a configuration for a listener presenting the
+// certificate in files, verifying client certificates against the CA file if
+// there is one
+func ServerConfig(files Files, clientAuth tls.ClientAuthType) (*tls.Config, error) {
+	if files.CertFile == "" || files.KeyFile == "" {
+		return nil, fmt.Errorf("a TLS server requires a certificate and a key")
+	}
+	r, err := newReloader(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Each handshake picks up the current files
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = clientAuth
+			}
+			return cfg, nil
+		},
+	}, nil
+}
+
+// ClientConfig returns a configuration for connecting to servers whose
+// certificates are signed by the CA file, presenting the certificate in files
+// if there is one
+func ClientConfig(files Files) (*tls.Config, error) {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, fmt.Errorf("a client certificate requires both a certificate and a key")
+	}
+	r, err := newReloader(files)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	_, cfg.RootCAs = r.current()
+	if files.CertFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	return cfg, nil
+}
+
+// reloader holds the certificate and CA pool loaded from files, reloading
+// them when a file's modification time changes
+type reloader struct {
+	files Files
+
+	mu       sync.Mutex
+	checked  time.Time
+	modTimes []time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+// newReloader loads files, failing if any of them is missing or invalid
+func newReloader(files Files) (*reloader, error) {
+	r := &reloader{files: files}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.modTimes = modTimes
+	r.checked = time.Now()
+	return r, nil
+}
+
+// current returns the certificate and CA pool, reloading them first if the
+// files changed since the last check. A failed reload is logged and the
+// previous ones are kept.
+func (r *reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= reloadInterval {
+		r.checked = time.Now()
+		modTimes, err := r.stat()
+		switch {
+		case err != nil:
+			log.Printf("Failed to check TLS files: %v", err)
+		case !equalTimes(modTimes, r.modTimes):
+			if err := r.load(); err != nil {
+				log.Printf("Failed to reload TLS files, keeping the previous ones: %v", err)
+			} else {
+				log.Printf("Reloaded TLS files")
+			}
+			// A broken file is not retried until it changes again
+			r.modTimes = modTimes
+		}
+	}
+	return r.cert, r.pool
+}
+
+// paths returns the files in use
+func (r *reloader) paths() []string {
+	var paths []string
+	for _, path := range []string{r.files.CertFile, r.files.KeyFile, r.files.CAFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// stat returns the modification time of every file
+func (r *reloader) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// load reads the certificate and CA pool. The caller must hold r.mu, except during construction.
+func (r *reloader) load() error {
+	var cert *tls.Certificate
+	if r.files.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if r.files.CAFile != "" {
+		pem, err := os.ReadFile(r.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.files.CAFile)
+		}
+	}
+
+	r.cert, r.pool = cert, pool
+	return nil
+}
+
+// equalTimes reports whether two lists of modification times are the same
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}